@@ -0,0 +1,401 @@
+package executors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skssmd/graft/internal/config"
+	"github.com/skssmd/graft/internal/server/ssh"
+)
+
+// accessLabelPrefix marks authorized_keys lines managed by `graft access`;
+// the person's name follows it
+const accessLabelPrefix = "graft-access:"
+
+var (
+	accessNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]*$`)
+	accessFromPattern = regexp.MustCompile(`^[A-Za-z0-9.:/*?!,-]+$`)
+)
+
+const accessUsage = `Usage:
+  graft access grant <registry> <name> <pubkey|file.pub> [--from <patterns>] [--command <cmd>]
+  graft access revoke [<registry>] <name>
+  graft access ls [<registry>]`
+
+// RunAccess dispatches `graft access grant|revoke|ls`
+func (e *Executor) RunAccess(args []string) error {
+	if len(args) == 0 {
+		fmt.Println(accessUsage)
+		return nil
+	}
+	switch args[0] {
+	case "grant":
+		return e.RunAccessGrant(args[1:])
+	case "revoke":
+		return e.RunAccessRevoke(args[1:])
+	case "ls":
+		return e.RunAccessLs(args[1:])
+	}
+	fmt.Println(accessUsage)
+	return nil
+}
+
+// RunAccessGrant adds a person's key to a server's authorized_keys, labelled
+// with their name, and records it in the server's access list
+func (e *Executor) RunAccessGrant(args []string) error {
+	var positional []string
+	entry := config.AccessEntry{}
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--from" && i+1 < len(args):
+			entry.From = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "--from="):
+			entry.From = strings.TrimPrefix(args[i], "--from=")
+		case args[i] == "--command" && i+1 < len(args):
+			entry.Command = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "--command="):
+			entry.Command = strings.TrimPrefix(args[i], "--command=")
+		case strings.HasPrefix(args[i], "--"):
+			return ConfigError("unknown flag '%s'\n%s", args[i], accessUsage)
+		default:
+			positional = append(positional, args[i])
+		}
+	}
+	if len(positional) < 3 {
+		return ConfigError("%s", accessUsage)
+	}
+	registry, name := positional[0], positional[1]
+
+	// 1. Validate everything that ends up in authorized_keys
+	if !accessNamePattern.MatchString(name) {
+		return ConfigError("invalid name '%s' (use letters, digits, '.', '_', '@' or '-')", name)
+	}
+	pubKey, err := readPublicKey(strings.Join(positional[2:], " "))
+	if err != nil {
+		return ConfigError("%v", err)
+	}
+	entry.Name = name
+	entry.PublicKey = pubKey
+	entry.Fingerprint = ssh.Fingerprint(pubKey)
+	entry.GrantedAt = time.Now().Format(time.RFC3339)
+
+	options, err := accessOptions(entry)
+	if err != nil {
+		return ConfigError("%v", err)
+	}
+
+	srv, err := e.registryServer(registry)
+	if err != nil {
+		return err
+	}
+
+	// 2. Install the key, then record it
+	fmt.Printf("🔑 Granting '%s' access to %s (%s)...\n", name, registry, srv.Host)
+	client, err := e.clientFor(srv)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	access, err := loadRemoteAccess(client)
+	if err != nil {
+		return remoteError("%v", err)
+	}
+	if err := client.SetLabeledAuthorizedKey(accessLabelPrefix+name, options, pubKey); err != nil {
+		return remoteError("failed to update authorized_keys: %v", err)
+	}
+	_, replaced := access[name]
+	access[name] = entry
+	if err := saveRemoteAccess(client, access); err != nil {
+		return remoteError("%v", err)
+	}
+
+	if replaced {
+		fmt.Printf("✅ Replaced the key of '%s' on %s (%s)\n", name, registry, entry.Fingerprint)
+	} else {
+		fmt.Printf("✅ Granted '%s' access to %s (%s)\n", name, registry, entry.Fingerprint)
+	}
+	return nil
+}
+
+// RunAccessRevoke removes a person's key from one server, or from every
+// server in the registry when no registry name is given
+func (e *Executor) RunAccessRevoke(args []string) error {
+	var registries []string
+	var name string
+	switch len(args) {
+	case 1:
+		name = args[0]
+		registries = e.registryNames()
+	case 2:
+		registries, name = []string{args[0]}, args[1]
+	default:
+		return ConfigError("%s", accessUsage)
+	}
+	if !accessNamePattern.MatchString(name) {
+		return ConfigError("invalid name '%s' (use letters, digits, '.', '_', '@' or '-')", name)
+	}
+	if len(registries) == 0 {
+		return ConfigError("no servers found in global registry")
+	}
+
+	// The error kind is a connection failure only if no server was reached
+	failed, unreachable := 0, 0
+	for _, registry := range registries {
+		if err := e.revokeAccess(registry, name); err != nil {
+			fmt.Printf("❌ %s: %v\n", registry, err)
+			failed++
+			var cmdErr *CommandError
+			if errors.As(err, &cmdErr) && cmdErr.Kind == KindConnection {
+				unreachable++
+			}
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	err := fmt.Errorf("could not revoke '%s' on %d of %d servers", name, failed, len(registries))
+	if unreachable == failed {
+		return connectionError(err)
+	}
+	return remoteError("%v", err)
+}
+
+func (e *Executor) revokeAccess(registry, name string) error {
+	srv, err := e.registryServer(registry)
+	if err != nil {
+		return err
+	}
+	client, err := e.clientFor(srv)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	access, err := loadRemoteAccess(client)
+	if err != nil {
+		return remoteError("%v", err)
+	}
+	// The key is removed even without a record, in case the list was edited
+	if err := client.RemoveLabeledAuthorizedKey(accessLabelPrefix + name); err != nil {
+		return remoteError("failed to update authorized_keys: %v", err)
+	}
+	if _, exists := access[name]; !exists {
+		fmt.Printf("ℹ️  %s: '%s' has no recorded access\n", registry, name)
+		return nil
+	}
+	delete(access, name)
+	if err := saveRemoteAccess(client, access); err != nil {
+		return remoteError("%v", err)
+	}
+	fmt.Printf("✅ Revoked '%s' on %s (%s)\n", name, registry, srv.Host)
+	return nil
+}
+
+// RunAccessLs lists the granted keys of one server or of every server
+func (e *Executor) RunAccessLs(args []string) error {
+	registries := e.registryNames()
+	if len(args) > 0 {
+		registries = args[:1]
+	}
+
+	doc := AccessOutput{Servers: []AccessServerOutput{}}
+	for _, registry := range registries {
+		out := AccessServerOutput{Server: registry, Entries: []AccessEntryOutput{}}
+		access, err := e.readAccess(registry, &out)
+		if err != nil {
+			if len(args) > 0 {
+				return err
+			}
+			out.Error = err.Error()
+		}
+		var names []string
+		for name := range access {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			a := access[name]
+			out.Entries = append(out.Entries, AccessEntryOutput{
+				Name:        name,
+				Fingerprint: a.Fingerprint,
+				PublicKey:   a.PublicKey,
+				From:        a.From,
+				Command:     a.Command,
+				GrantedAt:   a.GrantedAt,
+			})
+		}
+		doc.Servers = append(doc.Servers, out)
+	}
+
+	if e.machineOutput() {
+		return e.render(doc)
+	}
+
+	if len(doc.Servers) == 0 {
+		fmt.Println("No servers found in global registry.")
+		return nil
+	}
+	for _, s := range doc.Servers {
+		fmt.Printf("\n🔑 %s (%s):\n", s.Server, s.Host)
+		if s.Error != "" {
+			fmt.Printf("   ❌ %s\n", s.Error)
+			continue
+		}
+		if len(s.Entries) == 0 {
+			fmt.Println("   No access granted.")
+			continue
+		}
+		fmt.Printf("   %-15s %-50s %-20s %s\n", "Name", "Fingerprint", "Granted", "Restrictions")
+		fmt.Println("   " + strings.Repeat("-", 100))
+		for _, a := range s.Entries {
+			var restrictions []string
+			if a.From != "" {
+				restrictions = append(restrictions, "from="+a.From)
+			}
+			if a.Command != "" {
+				restrictions = append(restrictions, "command="+a.Command)
+			}
+			fmt.Printf("   %-15s %-50s %-20s %s\n", a.Name, a.Fingerprint, a.GrantedAt, strings.Join(restrictions, " "))
+		}
+	}
+	fmt.Println()
+	return nil
+}
+
+func (e *Executor) readAccess(registry string, out *AccessServerOutput) (map[string]config.AccessEntry, error) {
+	srv, err := e.registryServer(registry)
+	if err != nil {
+		return nil, err
+	}
+	out.Host = srv.Host
+	client, err := e.clientFor(srv)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	access, err := loadRemoteAccess(client)
+	if err != nil {
+		return nil, remoteError("%v", err)
+	}
+	return access, nil
+}
+
+// registryServer looks up a server in the global registry
+func (e *Executor) registryServer(name string) (*config.ServerConfig, error) {
+	if e.GlobalConfig == nil {
+		return nil, ConfigError("global config not loaded")
+	}
+	srv, ok := e.GlobalConfig.Servers[name]
+	if !ok {
+		return nil, ConfigError("server '%s' not found in registry", name)
+	}
+	if srv.RegistryName == "" {
+		srv.RegistryName = name
+	}
+	return &srv, nil
+}
+
+// registryNames returns every registered server name, sorted
+func (e *Executor) registryNames() []string {
+	var names []string
+	if e.GlobalConfig != nil {
+		for name := range e.GlobalConfig.Servers {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readPublicKey accepts a key in authorized_keys format or the path of a
+// .pub file and returns the key without its comment
+func readPublicKey(arg string) (string, error) {
+	if data, err := os.ReadFile(arg); err == nil {
+		arg = string(data)
+	}
+	key, err := ssh.NormalizePublicKey(arg)
+	if err != nil {
+		return "", fmt.Errorf("%v (use --from and --command for restrictions)", err)
+	}
+	return key, nil
+}
+
+// accessOptions builds the authorized_keys options for an entry
+func accessOptions(entry config.AccessEntry) (string, error) {
+	var options []string
+	if entry.From != "" {
+		if !accessFromPattern.MatchString(entry.From) {
+			return "", fmt.Errorf("invalid --from '%s' (use comma separated hosts, addresses or CIDRs)", entry.From)
+		}
+		options = append(options, `from="`+entry.From+`"`)
+	}
+	if entry.Command != "" {
+		if strings.ContainsAny(entry.Command, "\n\r") {
+			return "", fmt.Errorf("--command must be a single line")
+		}
+		// sshd only unescapes \" inside an option, so backslashes stay as written
+		escaped := strings.ReplaceAll(entry.Command, `"`, `\"`)
+		options = append(options, `command="`+escaped+`"`)
+	}
+	return strings.Join(options, ","), nil
+}
+
+// loadRemoteAccess reads the server's access list. A missing list is empty;
+// any other failure is returned so a grant never overwrites a list it could
+// not read.
+func loadRemoteAccess(client ssh.Remote) (map[string]config.AccessEntry, error) {
+	access := make(map[string]config.AccessEntry)
+	f, err := os.CreateTemp("", "graft-access-*.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %v", err)
+	}
+	f.Close()
+	tmpFile := f.Name()
+	defer os.Remove(tmpFile)
+	if err := client.DownloadFile(config.RemoteAccessPath, tmpFile); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return access, nil
+		}
+		return nil, fmt.Errorf("could not read %s: %v", config.RemoteAccessPath, err)
+	}
+	data, err := os.ReadFile(tmpFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &access); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", config.RemoteAccessPath, err)
+	}
+	return access, nil
+}
+
+// saveRemoteAccess uploads the access list to the server
+func saveRemoteAccess(client ssh.Remote, access map[string]config.AccessEntry) error {
+	client.RunCommand("sudo mkdir -p /opt/graft/config && sudo chown $USER:$USER /opt/graft/config", nil, nil)
+
+	data, _ := json.MarshalIndent(access, "", "  ")
+	f, err := os.CreateTemp("", "graft-access-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := client.UploadFile(f.Name(), config.RemoteAccessPath); err != nil {
+		return fmt.Errorf("failed to update remote access list: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package executors
+
+import (
+	"testing"
+
+	"github.com/skssmd/graft/internal/config"
+)
+
+func TestAccessOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   config.AccessEntry
+		want    string
+		wantErr bool
+	}{
+		{name: "no restrictions", want: ""},
+		{name: "from", entry: config.AccessEntry{From: "10.0.0.0/8,*.example.com"}, want: `from="10.0.0.0/8,*.example.com"`},
+		{name: "command", entry: config.AccessEntry{Command: "graft-deploy"}, want: `command="graft-deploy"`},
+		{name: "quotes are escaped", entry: config.AccessEntry{Command: `echo "hi"`}, want: `command="echo \"hi\""`},
+		{name: "backslashes are kept", entry: config.AccessEntry{Command: `grep 'a\.b' log`}, want: `command="grep 'a\.b' log"`},
+		{
+			name:  "from and command",
+			entry: config.AccessEntry{From: "192.168.1.5", Command: "uptime"},
+			want:  `from="192.168.1.5",command="uptime"`,
+		},
+		{name: "invalid from", entry: config.AccessEntry{From: `1.2.3.4" ,command="sh`}, wantErr: true},
+		{name: "multi-line command", entry: config.AccessEntry{Command: "uptime\nsh"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := accessOptions(tt.entry)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("accessOptions error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("accessOptions = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
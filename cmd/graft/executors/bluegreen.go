@@ -0,0 +1,41 @@
+package executors
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/skssmd/graft/internal/server/deploy"
+)
+
+// RunSwitch moves a blue-green service's traffic back to its warm color
+func (e *Executor) RunSwitch(args []string) error {
+	if len(args) < 1 {
+		fmt.Println("Usage: graft switch <service>")
+		return nil
+	}
+	serviceName := args[0]
+
+	meta, err := e.getProjectMeta()
+	if err != nil {
+		return err
+	}
+	compose, err := deploy.ParseComposeFile("graft-compose.yml", meta.Domain)
+	if err != nil {
+		return ConfigError("could not load graft-compose.yml: %v", err)
+	}
+	service, ok := compose.Services[serviceName]
+	if !ok {
+		return ConfigError("service '%s' not found in graft-compose.yml", serviceName)
+	}
+
+	client, err := e.getClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := deploy.SwitchBlueGreen(client, meta.RemotePath, serviceName, service, os.Stdout, os.Stderr); err != nil {
+		return remoteError("%v", err)
+	}
+	return nil
+}
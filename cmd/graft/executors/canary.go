@@ -0,0 +1,75 @@
+package executors
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/skssmd/graft/internal/server/deploy"
+)
+
+// RunCanary lists the running canaries of the current project or finishes
+// one: graft canary [status] | graft canary promote|abort <service>
+func (e *Executor) RunCanary(args []string) error {
+	action := "status"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch action {
+	case "status":
+	case "promote", "abort":
+		if len(args) < 2 {
+			fmt.Printf("Usage: graft canary %s <service>\n", action)
+			return nil
+		}
+	default:
+		return ConfigError("unknown canary command '%s' (usage: graft canary [status] | graft canary promote|abort <service>)", action)
+	}
+
+	meta, err := e.getProjectMeta()
+	if err != nil {
+		return err
+	}
+	client, err := e.getClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if action == "status" {
+		canaries, err := deploy.ListCanaries(client, meta.RemotePath)
+		if err != nil {
+			return remoteError("%v", err)
+		}
+		if e.machineOutput() {
+			return e.render(CanaryOutput{Project: meta.Name, Env: e.Env, Server: e.Server.RegistryName, Canaries: canaries})
+		}
+
+		if len(canaries) == 0 {
+			fmt.Printf("🐤 %s (%s) has no running canaries.\n", meta.Name, e.Env)
+			return nil
+		}
+		fmt.Printf("🐤 Running canaries of %s (%s):\n", meta.Name, e.Env)
+		for _, c := range canaries {
+			since := c.Started
+			if t, err := time.Parse(time.RFC3339, c.Started); err == nil {
+				since = t.Local().Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("   %-20s %3d%% of traffic  since %s by %s\n", c.Service, c.Weight, since, c.User)
+		}
+		fmt.Println("💡 Finish one with 'graft canary promote <service>' or 'graft canary abort <service>'")
+		return nil
+	}
+
+	p := &deploy.Project{Name: meta.Name, Env: e.Env}
+	serviceName := args[1]
+	if action == "promote" {
+		err = deploy.PromoteCanary(client, p, meta.RemotePath, serviceName, os.Stdout, os.Stderr)
+	} else {
+		err = deploy.AbortCanary(client, p, meta.RemotePath, serviceName, os.Stdout, os.Stderr)
+	}
+	if err != nil {
+		return remoteError("%v", err)
+	}
+	return nil
+}
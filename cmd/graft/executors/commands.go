@@ -1,11 +1,11 @@
 package executors
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/skssmd/graft/internal/config"
@@ -13,16 +13,18 @@ import (
 	"github.com/skssmd/graft/internal/server/project"
 	"github.com/skssmd/graft/internal/server/prompt"
 	"github.com/skssmd/graft/internal/server/ssh"
+	"golang.org/x/term"
 )
 
-func (e *Executor) RunMode() {
-	reader := bufio.NewReader(os.Stdin)
+func (e *Executor) RunMode(args []string) error {
+	if _, err := e.Answers.ParseFlags(args); err != nil {
+		return ConfigError("%w", err)
+	}
 
 	// Load project metadata
 	meta, err := e.getProjectMeta()
 	if err != nil {
-		fmt.Println("Error: Could not load project metadata. Run 'graft init' first.")
-		return
+		return err
 	}
 
 	// Display current mode
@@ -41,39 +43,34 @@ func (e *Executor) RunMode() {
 	fmt.Println("\n  Direct deployment modes:")
 	fmt.Println("    [4] direct-serverbuild (upload source → build on server)")
 	fmt.Println("    [5] direct-localbuild (build locally → upload image)")
-	fmt.Print("\nSelect deployment mode [1-5]: ")
 
-	modeInput, _ := reader.ReadString('\n')
-	modeInput = strings.TrimSpace(modeInput)
+	modeInput, err := e.Answers.Ask("mode", "\nSelect deployment mode [1-5]: ")
+	if err != nil {
+		return err
+	}
 
-	var newMode string
-	switch modeInput {
-	case "1":
-		newMode = "git-images"
+	newMode, ok := prompt.ParseDeploymentMode(modeInput, 5)
+	if !ok {
+		return ConfigError("invalid selection '%s'. Mode not changed", modeInput)
+	}
+	switch newMode {
+	case "git-images":
 		fmt.Println("\n✅ Git-based image deployment selected (GHCR)")
-	case "2":
-		newMode = "git-repo-serverbuild"
+	case "git-repo-serverbuild":
 		fmt.Println("\n✅ Git-based server build deployment selected")
-	case "3":
-		newMode = "git-manual"
+	case "git-manual":
 		fmt.Println("\n✅ Git manual deployment selected")
-	case "4":
-		newMode = "direct-serverbuild"
+	case "direct-serverbuild":
 		fmt.Println("\n✅ Direct server build mode selected")
-	case "5":
-		newMode = "direct-localbuild"
+	case "direct-localbuild":
 		fmt.Println("\n✅ Direct local build mode selected")
-	default:
-		fmt.Println("Invalid selection. Mode not changed.")
-		return
 	}
 
 	var gitBranch string
 	if strings.HasPrefix(newMode, "git") {
-		branch, err := prompt.PromptGitBranch(reader)
+		branch, err := prompt.PromptGitBranch(e.Answers)
 		if err != nil {
-			fmt.Printf("\n❌ %v\n", err)
-			return
+			return ConfigError("%w", err)
 		}
 		gitBranch = branch
 	}
@@ -83,8 +80,7 @@ func (e *Executor) RunMode() {
 	meta.GitBranch = gitBranch
 	meta.Initialized = false // Reset to false when mode changes
 	if err := e.saveProjectMeta(meta); err != nil {
-		fmt.Printf("Error: Could not save project metadata: %v\n", err)
-		return
+		return ConfigError("could not save project metadata: %v", err)
 	}
 
 	// Regenerate compose file with new mode
@@ -99,8 +95,7 @@ func (e *Executor) RunMode() {
 		// Update deployment mode and save
 		p.DeploymentMode = newMode
 		if err := p.Save("."); err != nil {
-			fmt.Printf("Error: Could not save compose file: %v\n", err)
-			return
+			return ConfigError("could not save compose file: %v", err)
 		}
 	}
 
@@ -113,10 +108,15 @@ func (e *Executor) RunMode() {
 		fmt.Println("\n💡 Don't forget to set up GitHub Actions workflow!")
 		fmt.Println("   See: examples/github-actions-workflow.yml")
 	}
+	return nil
 }
 
-func (e *Executor) RunInit(args []string) {
-	reader := bufio.NewReader(os.Stdin)
+func (e *Executor) RunInit(args []string) error {
+	// Answer flags (--answers, --yes, --domain, ...) are consumed first
+	args, err := e.Answers.ParseFlags(args)
+	if err != nil {
+		return ConfigError("%w", err)
+	}
 
 	// Parse flags
 	var force, cloud bool
@@ -130,10 +130,9 @@ func (e *Executor) RunInit(args []string) {
 	}
 
 	// Step 1: Project Setup (common for both modes)
-	projName, err := project.InitProjectWorkflow(reader, force, e.GlobalConfig)
+	projName, err := project.InitProjectWorkflow(e.Answers, force, e.GlobalConfig)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return abortError("%w", err)
 	}
 
 	var meta *config.ProjectMetadata
@@ -142,15 +141,13 @@ func (e *Executor) RunInit(args []string) {
 		// Cloud mode initialization
 		meta, err = project.InitCloudWorkflow(projName, e.Env)
 		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return
+			return ConfigError("%w", err)
 		}
 	} else {
 		// Server mode initialization (existing logic)
-		srv, err := project.SelectOrAddServer(reader, e.GlobalConfig)
+		srv, err := project.SelectOrAddServer(e.Answers, e.GlobalConfig)
 		if err != nil {
-			fmt.Println("Failed to select server:", err)
-			return
+			return ConfigError("failed to select server: %w", err)
 		}
 		e.Server = srv
 
@@ -171,25 +168,22 @@ func (e *Executor) RunInit(args []string) {
 		// Step 2: Remote Setup
 		client, err := e.getClient()
 		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return
+			return err
 		}
 		defer client.Close()
 
-		remoteProjects, domain, versionToKeep, err := project.InitRemoteWorkflow(reader, client, srv, projFull, force)
+		remoteProjects, domain, versionToKeep, err := project.InitRemoteWorkflow(e.Answers, client, srv, projFull, force)
 		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return
+			return remoteError("%w", err)
 		}
 
 		// Step 3: Update Remote Registry
 		project.UpdateRemoteRegistry(client, remoteProjects)
 
 		// Step 4: Deployment Setup
-		deploymentMode, gitBranch, updatedHookURL, err := project.InitDeploymentWorkflow(reader, client, e.GlobalConfig, srv, currentHookURL)
+		deploymentMode, gitBranch, updatedHookURL, err := project.InitDeploymentWorkflow(e.Answers, client, e.GlobalConfig, srv, currentHookURL)
 		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return
+			return remoteError("%w", err)
 		}
 
 		// Step 5: Remote Project Directory Setup
@@ -218,8 +212,9 @@ func (e *Executor) RunInit(args []string) {
 	fmt.Printf("\n✨ Project '%s' initialized!\n", projName)
 	fmt.Printf("Local config: .graft/project.json\n")
 	fmt.Printf("Boilerplate: graft-compose.yml\n")
+	return nil
 }
-func (e *Executor) GetSSHPub() {
+func (e *Executor) GetSSHPub() error {
 	gDir := config.GetGlobalConfigDir()
 	pemPath := filepath.Join(gDir, "graftpem")
 	pubPath := filepath.Join(gDir, "graftpub")
@@ -231,170 +226,77 @@ func (e *Executor) GetSSHPub() {
 	if os.IsNotExist(errPem) || os.IsNotExist(errPub) {
 		fmt.Fprintln(os.Stderr, "🔑 Generating new SSH key pair (graftpub/graftpem)...")
 		if err := ssh.GenerateSSHKey(pemPath, pubPath); err != nil {
-			fmt.Fprintf(os.Stderr, "❌ Error generating SSH key: %v\n", err)
-			return
+			return ConfigError("could not generate SSH key: %v", err)
 		}
 		fmt.Fprintln(os.Stderr, "✅ Keys generated successfully in", gDir)
 	}
 
 	pubKey, err := os.ReadFile(pubPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "❌ Error reading public key: %v\n", err)
-		return
+		return ConfigError("could not read public key: %v", err)
 	}
 
 	// Just print the key to stdout so it can be piped
 	fmt.Println(strings.TrimSpace(string(pubKey)))
+	return nil
 }
 
-func (e *Executor) RolloutSSHKeys() {
-	gDir := config.GetGlobalConfigDir()
-	pemPath := filepath.Join(gDir, "graftpem")
-	pubPath := filepath.Join(gDir, "graftpub")
-
-	// 1. Load old keys
-	_, err := os.ReadFile(pemPath)
-	if err != nil {
-		fmt.Printf("❌ Error: Could not read old private key: %v\n", err)
-		return
-	}
-	oldPubBytes, err := os.ReadFile(pubPath)
-	if err != nil {
-		fmt.Printf("❌ Error: Could not read old public key: %v\n", err)
-		return
-	}
-	oldPubKey := string(oldPubBytes)
-
-	// 2. Generate new keys in memory
-	fmt.Println("🔄 Generating new SSH key pair...")
-	newPem, newPub, err := ssh.GenerateSSHKeyPairStrings()
+func (e *Executor) RunSync(args []string) error {
+	// Parse command line arguments
+	sa, err := project.ParseSyncArgs(args)
 	if err != nil {
-		fmt.Printf("❌ Error generating new keys: %v\n", err)
-		return
-	}
-
-	// 3. Find servers using the old graft key
-	gCfg := e.GlobalConfig
-	if gCfg == nil {
-		fmt.Println("❌ Error: Global config not loaded.")
-		return
-	}
-
-	var targets []config.ServerConfig
-	for _, srv := range gCfg.Servers {
-		// Check if srv.KeyPath points to our graftpem
-		// We normalize paths for comparison
-		srvKeyAbs, _ := filepath.Abs(srv.KeyPath)
-		ourPemAbs, _ := filepath.Abs(pemPath)
-
-		if srvKeyAbs == ourPemAbs {
-			targets = append(targets, srv)
-		}
-	}
-
-	if len(targets) == 0 {
-		fmt.Println("ℹ️  No servers found using the Graft SSH key. Only local keys will be updated.")
-	} else {
-		fmt.Printf("🚀 Rolling out keys to %d servers...\n", len(targets))
-	}
-
-	// 4. Update each server
-	successCount := 0
-	for _, srv := range targets {
-		fmt.Printf("📡 Updating %s (%s)... ", srv.RegistryName, srv.Host)
-
-		// Connect using OLD key bytes (since we haven't overwritten the file yet, but we have them in memory)
-		// NewClient reads from file, so it's safe to call it now
-		client, err := ssh.NewClient(srv.Host, srv.Port, srv.User, srv.KeyPath)
-		if err != nil {
-			fmt.Printf("❌ Connection failed: %v\n", err)
-			continue
-		}
-
-		if err := client.UpdateAuthorizedKey(oldPubKey, newPub); err != nil {
-			fmt.Printf("❌ Update failed: %v\n", err)
-			client.Close()
-			continue
-		}
-
-		fmt.Println("✅ Success")
-		client.Close()
-		successCount++
-	}
-
-	// 5. Save new keys locally
-	fmt.Println("💾 Saving new keys locally...")
-	if err := os.WriteFile(pemPath, []byte(newPem), 0600); err != nil {
-		fmt.Printf("❌ Error saving private key: %v\n", err)
-		return
-	}
-	if err := os.WriteFile(pubPath, []byte(newPub), 0644); err != nil {
-		fmt.Printf("❌ Error saving public key: %v\n", err)
-		return
+		return ConfigError("%v", err)
 	}
 
-	fmt.Printf("\n✨ Successfully rolled out SSH keys! (%d servers updated)\n", successCount)
-	if len(targets) > successCount {
-		fmt.Printf("⚠️  Warning: %d servers failed to update. They still expect the OLD key.\n", len(targets)-successCount)
-		fmt.Printf("   You may need to manually add the new public key to them.\n")
-	}
-}
-
-func (e *Executor) RunSync(args []string) {
-	// Parse command line arguments
-	sa := project.ParseSyncArgs(args)
-
 	// Find and load project file
 	localFile := "graft-compose.yml"
 	if _, err := os.Stat(localFile); err != nil {
-		fmt.Println("Error: graft-compose.yml not found. Run 'graft init' first.")
-		return
+		return ConfigError("graft-compose.yml not found. Run 'graft init' first")
 	}
 
 	p, err := deploy.LoadProject(e.Env, localFile)
 	if err != nil {
-		fmt.Printf("Error loading project: %v\n", err)
-		return
+		return ConfigError("could not load project: %v", err)
+	}
+
+	// Preview the upload without touching the server
+	if sa.ListFiles {
+		return e.RunSyncListFiles(p, sa.ServiceName)
 	}
 
 	meta, err := e.getProjectMeta()
 	if err != nil {
-		fmt.Println("Warning: Could not load project metadata. Run 'graft init' first.")
-	} else {
-		p.DeploymentMode = meta.DeploymentMode
-		p.RollbackBackups = meta.RollbackBackups
+		return err
 	}
-
-	reader := bufio.NewReader(os.Stdin)
+	p.DeploymentMode = meta.DeploymentMode
+	p.RollbackBackups = meta.RollbackBackups
 
 	// Handle first-time git project initialization
 	if !meta.Initialized && strings.HasPrefix(meta.DeploymentMode, "git") {
 		client, err := e.getClient()
 		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return
+			return err
 		}
 		defer client.Close()
 
 		if err := project.SyncInitializeGitProject(e.Env, client, p, meta, e.GlobalConfig.Servers[meta.Registry].GraftHookURL); err != nil {
-			fmt.Printf("Error: %v\n", err)
+			return remoteError("%w", err)
 		}
-		return
+		return nil
 	}
 
 	// Handle already initialized projects
 	if meta.Initialized {
 		client, err := e.getClient()
 		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return
+			return err
 		}
 		defer client.Close()
 
-		if err := project.SyncHandleInitializedProject(e.Env, client, p, reader); err != nil {
-			fmt.Printf("Error: %v\n", err)
+		if err := project.SyncHandleInitializedProject(e.Env, client, p, e.Answers); err != nil {
+			return remoteError("%w", err)
 		}
-		return
+		return nil
 	}
 
 	// For automated git modes, don't allow manual sync
@@ -402,23 +304,57 @@ func (e *Executor) RunSync(args []string) {
 		fmt.Printf("\nℹ️  Project '%s' is in Git-automated mode (%s).\n", p.Name, meta.DeploymentMode)
 		fmt.Println("🚀 Please do 'git push' to trigger deployment via GitHub Actions and webhooks.")
 		fmt.Println("💡 To force a manual sync (upload source), use a different deployment mode with 'graft mode'.")
-		return
+		return nil
 	}
 
 	// Perform the actual sync/deploy
 	client, err := e.getClient()
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
 	if err := project.SyncPerformDeploy(e.Env, client, p, sa, os.Stdout, os.Stderr); err != nil {
-		fmt.Printf("Error: %v\n", err)
+		return remoteError("%w", err)
+	}
+	return nil
+}
+
+// RunSyncListFiles prints the files `graft sync` would upload for each
+// serverbuild service after applying the ignore rules
+func (e *Executor) RunSyncListFiles(p *deploy.Project, serviceName string) error {
+	contexts, err := deploy.ListUploadFiles(serviceName)
+	if err != nil {
+		return ConfigError("%v", err)
+	}
+
+	if e.machineOutput() {
+		doc := SyncFilesOutput{Project: p.Name, Services: []SyncContextOutput{}}
+		for _, c := range contexts {
+			doc.Services = append(doc.Services, SyncContextOutput{
+				Service: c.Service,
+				Context: c.Context,
+				Files:   c.Files,
+			})
+		}
+		return e.render(doc)
+	}
+
+	if len(contexts) == 0 {
+		fmt.Println("ℹ️  No serverbuild services: nothing is uploaded as source code.")
+		return nil
+	}
+	for _, c := range contexts {
+		fmt.Printf("\n📦 %s (context: %s) - %d file(s)\n", c.Service, c.Context, len(c.Files))
+		for _, f := range c.Files {
+			fmt.Printf("   %s\n", f)
+		}
 	}
+	fmt.Println("\nℹ️  Filtered by .gitignore, .dockerignore and .graftignore")
+	return nil
 }
 
-func (e *Executor) RunSyncCompose(args []string) {
+func (e *Executor) RunSyncCompose(args []string) error {
 	var heave bool
 	// Parse arguments: compose [-h|--heave]
 	for i := 0; i < len(args); i++ {
@@ -431,20 +367,17 @@ func (e *Executor) RunSyncCompose(args []string) {
 	// Find project file
 	localFile := "graft-compose.yml"
 	if _, err := os.Stat(localFile); err != nil {
-		fmt.Println("Error: graft-compose.yml not found. Run 'graft init' first.")
-		return
+		return ConfigError("graft-compose.yml not found. Run 'graft init' first")
 	}
 
 	p, err := deploy.LoadProject(e.Env, localFile)
 	if err != nil {
-		fmt.Printf("Error loading project: %v\n", err)
-		return
+		return ConfigError("could not load project: %v", err)
 	}
 
 	client, err := e.getClient()
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
@@ -454,27 +387,25 @@ func (e *Executor) RunSyncCompose(args []string) {
 
 	err = deploy.SyncComposeOnly(e.Env, client, p, heave, os.Stdout, os.Stderr, true, true)
 	if err != nil {
-		fmt.Printf("Error during sync: %v\n", err)
-		return
+		return remoteError("error during sync: %w", err)
 	}
 
 	if !heave {
 		fmt.Println("\n✅ Compose sync complete!")
 	}
+	return nil
 }
 
-func (e *Executor) RunLogs(serviceName string) {
+func (e *Executor) RunLogs(serviceName string) error {
 	// Load project metadata to get remote path
 	meta, err := e.getProjectMeta()
 	if err != nil {
-		fmt.Println("Error: Could not load project metadata. Run 'graft init' first.")
-		return
+		return err
 	}
 
 	client, err := e.getClient()
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
@@ -485,22 +416,21 @@ func (e *Executor) RunLogs(serviceName string) {
 	// Run docker compose logs with follow flag
 	logsCmd := fmt.Sprintf("cd %s && sudo docker compose logs -f --tail=100 %s", meta.RemotePath, serviceName)
 	if err := client.RunCommand(logsCmd, os.Stdout, os.Stderr); err != nil {
-		fmt.Printf("\nError: %v\n", err)
+		return passthroughError(err)
 	}
+	return nil
 }
 
-func (e *Executor) RunDockerCompose(args []string) {
+func (e *Executor) RunDockerCompose(args []string) error {
 
 	meta, err := e.getProjectMeta()
 	if err != nil {
-		fmt.Println("Error: Could not load project metadata. Run 'graft init' first.")
-		return
+		return err
 	}
 
 	client, err := e.getClient()
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
@@ -508,17 +438,83 @@ func (e *Executor) RunDockerCompose(args []string) {
 	cmdStr := strings.Join(args, " ")
 	composeCmd := fmt.Sprintf("cd %s && sudo docker compose %s", meta.RemotePath, cmdStr)
 
-	if err := client.RunCommand(composeCmd, os.Stdout, os.Stderr); err != nil {
-		fmt.Printf("\nError: %v\n", err)
+	return e.runPassthrough(client, composeCmd, e.needsTTY(args, true))
+}
+
+// runPassthrough runs a docker command for the user and returns its exit
+// status, on a pseudo-terminal when tty is set
+func (e *Executor) runPassthrough(client *ssh.Client, cmd string, tty bool) error {
+	var err error
+	if tty {
+		err = client.RunInteractive(cmd)
+	} else {
+		err = client.RunCommand(cmd, os.Stdout, os.Stderr)
+	}
+	if err != nil {
+		return passthroughError(err)
+	}
+	return nil
+}
+
+// ttyFlag matches docker flags that request a terminal: -t, -it, -dit, ...
+var ttyFlag = regexp.MustCompile(`^-[dit]*t[dit]*$`)
+
+// valueFlags are the docker and docker compose exec/run flags whose value is
+// the next argument
+var valueFlags = map[string]bool{
+	"-e": true, "--env": true, "--env-file": true,
+	"-u": true, "--user": true,
+	"-w": true, "--workdir": true,
+	"-l": true, "--label": true,
+	"-p": true, "--publish": true,
+	"-v": true, "--volume": true,
+	"--name": true, "--entrypoint": true, "--index": true,
+	"--network": true, "--platform": true, "--pull": true, "--detach-keys": true,
+}
+
+// needsTTY reports whether a docker command must run on a pseudo-terminal.
+// That is always the case with --tty. Otherwise graft itself has to run in
+// a terminal, and the command has to ask docker for one (-t, -it, --tty) or
+// be `compose exec` or `compose run`, which allocate one unless -T is given.
+func (e *Executor) needsTTY(args []string, compose bool) bool {
+	if e.TTY {
+		return true
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return false
+	}
+	return ttyRequested(args, compose)
+}
+
+// ttyRequested reports whether a docker command asks for a terminal. Only
+// the flags before the service or container name count; the rest is the
+// command run inside it.
+func ttyRequested(args []string, compose bool) bool {
+	if len(args) == 0 {
+		return false
+	}
+	tty := compose && (args[0] == "exec" || args[0] == "run")
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--" || !strings.HasPrefix(arg, "-"):
+			return tty
+		case arg == "-T" || arg == "--no-TTY" || arg == "--tty=false":
+			return false
+		case arg == "--tty" || ttyFlag.MatchString(arg):
+			tty = true
+		case valueFlags[arg]:
+			i++
+		}
 	}
+	return tty
 }
 
-func (e *Executor) RunHook(args []string) {
+func (e *Executor) RunHook(args []string) error {
 
 	client, err := e.getClient()
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
@@ -527,35 +523,32 @@ func (e *Executor) RunHook(args []string) {
 	composeCmd := fmt.Sprintf("cd %s && sudo docker compose %s", "/opt/graft/webhook/", cmdStr)
 
 	if err := client.RunCommand(composeCmd, os.Stdout, os.Stderr); err != nil {
-		fmt.Printf("\nError: %v\n", err)
+		return passthroughError(err)
 	}
+	return nil
 }
 
-func (e *Executor) RunPull(registryName, projectName string) {
+func (e *Executor) RunPull(registryName, projectName string) error {
 	gCfg := e.GlobalConfig
 	if gCfg == nil {
-		fmt.Println("Error loading global registry.")
-		return
+		return ConfigError("could not load global registry")
 	}
 
 	srv, exists := gCfg.Servers[registryName]
 	if !exists {
-		fmt.Printf("Error: Registry '%s' not found.\n", registryName)
-		return
+		return ConfigError("registry '%s' not found", registryName)
 	}
 
 	fmt.Printf("\n📥 Pulling project '%s' from '%s'...\n", projectName, registryName)
-	client, err := ssh.NewClient(srv.Host, srv.Port, srv.User, srv.KeyPath)
+	client, err := e.clientFor(&srv)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
 	tmpFile := filepath.Join(os.TempDir(), "remote_projects_pull.json")
 	if err := client.DownloadFile(config.RemoteProjectsPath, tmpFile); err != nil {
-		fmt.Println("Error: Could not retrieve remote project registry.")
-		return
+		return remoteError("could not retrieve remote project registry: %v", err)
 	}
 	defer os.Remove(tmpFile)
 
@@ -581,8 +574,7 @@ func (e *Executor) RunPull(registryName, projectName string) {
 	}
 
 	if remotePath == "" {
-		fmt.Printf("Error: Project '%s' not found on remote server.\n", projectName)
-		return
+		return remoteError("project '%s' not found on remote server", projectName)
 	}
 
 	projectName = fullProjectName // Use the full name for local directory too if found as full name
@@ -590,14 +582,12 @@ func (e *Executor) RunPull(registryName, projectName string) {
 	home, _ := os.UserHomeDir()
 	localBase := filepath.Join(home, "graft", projectName)
 	if err := os.MkdirAll(localBase, 0755); err != nil {
-		fmt.Printf("Error: Could not create local directory: %v\n", err)
-		return
+		return ConfigError("could not create local directory: %v", err)
 	}
 
 	fmt.Printf("🚀 Syncing files to %s...\n", localBase)
-	if err := client.PullRsync(remotePath, localBase, os.Stdout, os.Stderr); err != nil {
-		fmt.Printf("Error during pull: %v\n", err)
-		return
+	if err := client.PullDirectory(remotePath, localBase, os.Stdout, os.Stderr); err != nil {
+		return remoteError("error during pull: %v", err)
 	}
 
 	fmt.Println("🔧 Re-initializing local configuration...")
@@ -620,4 +610,5 @@ func (e *Executor) RunPull(registryName, projectName string) {
 
 	fmt.Printf("\n✨ Project '%s' pulled successfully to %s\n", projectName, localBase)
 	fmt.Printf("👉 Use 'graft -p %s <command>' to manage it.\n", projectName)
+	return nil
 }
@@ -0,0 +1,33 @@
+package executors
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTTYRequested(t *testing.T) {
+	tests := []struct {
+		args    string
+		compose bool
+		want    bool
+	}{
+		{"exec api sh", true, true},
+		{"run --rm api sh", true, true},
+		{"exec -T api sh", true, false},
+		{"exec api ls -t", true, true},
+		{"exec -T api ls -t", true, false},
+		{"ps -a", true, false},
+		{"logs api", true, false},
+		{"exec -it graft-postgres psql", false, true},
+		{"exec graft-postgres ls -t", false, false},
+		{"exec -u root -it app sh", false, true},
+		{"exec -e TERM=xterm app top -t", false, false},
+		{"run --rm -- app -t", false, false},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		if got := ttyRequested(strings.Fields(tt.args), tt.compose); got != tt.want {
+			t.Errorf("ttyRequested(%q, compose=%v) = %v, want %v", tt.args, tt.compose, got, tt.want)
+		}
+	}
+}
@@ -12,7 +12,10 @@ import (
 	"github.com/skssmd/graft/internal/server/dns"
 )
 
-func (e *Executor) RunMap(args []string) {
+func (e *Executor) RunMap(args []string) error {
+	if e.Recorder != nil {
+		return ConfigError("DNS mapping talks to Cloudflare directly and does not support --dry-run")
+	}
 	reader := bufio.NewReader(os.Stdin)
 
 	// Load metadata to get environment-specific domain
@@ -25,8 +28,7 @@ func (e *Executor) RunMap(args []string) {
 	// Parse graft-compose.yml with resolved domain
 	compose, err := deploy.ParseComposeFile("graft-compose.yml", domain)
 	if err != nil {
-		fmt.Printf("Error: Failed to parse graft-compose.yml: %v\n", err)
-		return
+		return ConfigError("failed to parse graft-compose.yml: %v", err)
 	}
 
 	// Extract all services with domains
@@ -48,8 +50,7 @@ func (e *Executor) RunMap(args []string) {
 	}
 
 	if len(serviceDomains) == 0 {
-		fmt.Println("❌ No services with Traefik Host labels found in graft-compose.yml")
-		return
+		return ConfigError("no services with Traefik Host labels found in graft-compose.yml")
 	}
 
 	// Display found services
@@ -62,8 +63,7 @@ func (e *Executor) RunMap(args []string) {
 	fmt.Println("\n🌐 Detecting server IP...")
 	client, err := e.getClient()
 	if err != nil {
-		fmt.Printf("Error: Could not connect to server: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
@@ -87,46 +87,51 @@ func (e *Executor) RunMap(args []string) {
 	// Get Cloudflare credentials
 	apiToken, zoneID := fetchCloudflareCredentials(cloudflare, reader)
 	if apiToken == "" || zoneID == "" {
-		fmt.Println("❌ Cloudflare API Token and Zone ID are required.")
-		return
+		return ConfigError("Cloudflare API Token and Zone ID are required")
 	}
 
 	// Verify DNS ownership
 	fmt.Println("\n🔐 Verifying DNS ownership...")
 	verified, err := dns.VerifyDNSOwnership("", apiToken, zoneID)
 	if err != nil || !verified {
-		fmt.Printf("❌ DNS ownership verification failed: %v\n", err)
-		return
+		return remoteError("DNS ownership verification failed: %v", err)
 	}
 	fmt.Println("✅ DNS ownership verified")
 
 	// Process each domain
 	fmt.Println("\n📍 Checking DNS records...")
 
-	stats := struct {
-		unchanged int
-		updated   int
-		created   int
-		skipped   int
-	}{}
+	summary := DNSMapOutput{
+		Env:      e.Env,
+		ServerIP: serverIP,
+		Records:  []DNSRecordOutput{},
+	}
+	if meta != nil {
+		summary.Project = meta.Name
+	}
+	if e.Server != nil {
+		summary.Server = e.Server.RegistryName
+	}
 
+	sort.Slice(serviceDomains, func(i, j int) bool { return serviceDomains[i].Service < serviceDomains[j].Service })
 	for _, sd := range serviceDomains {
 		for _, domain := range sd.Domains {
+			result := DNSRecordOutput{Service: sd.Service, Domain: domain}
+
 			// Get existing record
 			record, err := dns.GetDNSRecord(domain, "", apiToken, zoneID)
 
 			if err != nil {
 				fmt.Printf("  ❌ Error checking %s: %v\n", domain, err)
-				stats.skipped++
-				continue
-			}
-
-			if record != nil {
+				result.Status = "skipped"
+				result.Error = err.Error()
+			} else if record != nil {
 				// Record exists
 				if record.Content == serverIP {
 					fmt.Printf("  ✅ %s → %s (already correct)\n", domain, serverIP)
-					stats.unchanged++
+					result.Status = "unchanged"
 				} else {
+					result.Previous = record.Content
 					fmt.Printf("  ⚠️  %s → %s (exists, current: %s)\n", domain, serverIP, record.Content)
 					fmt.Printf("      Overwrite with %s? (y/n): ", serverIP)
 					confirm, _ := reader.ReadString('\n')
@@ -136,14 +141,15 @@ func (e *Executor) RunMap(args []string) {
 						err = dns.UpdateDNSRecord(record.ID, serverIP, apiToken, zoneID)
 						if err != nil {
 							fmt.Printf("      ❌ Failed to update: %v\n", err)
-							stats.skipped++
+							result.Status = "skipped"
+							result.Error = err.Error()
 						} else {
 							fmt.Printf("      ✅ Updated\n")
-							stats.updated++
+							result.Status = "updated"
 						}
 					} else {
 						fmt.Printf("      ⏭️  Skipped\n")
-						stats.skipped++
+						result.Status = "skipped"
 					}
 				}
 			} else {
@@ -152,32 +158,53 @@ func (e *Executor) RunMap(args []string) {
 				err = dns.CreateDNSRecord(domain, "", serverIP, apiToken, zoneID)
 				if err != nil {
 					fmt.Printf("      ❌ Failed to create: %v\n", err)
-					stats.skipped++
+					result.Status = "skipped"
+					result.Error = err.Error()
 				} else {
 					fmt.Printf("      ✅ Created\n")
-					stats.created++
+					result.Status = "created"
 				}
 			}
+
+			switch result.Status {
+			case "unchanged":
+				summary.Unchanged++
+			case "updated":
+				summary.Updated++
+			case "created":
+				summary.Created++
+			default:
+				summary.Skipped++
+			}
+			summary.Records = append(summary.Records, result)
 		}
 	}
 
+	if e.machineOutput() {
+		return e.render(summary)
+	}
+
 	// Display summary
 	fmt.Println("\n✅ DNS mapping complete!")
-	if stats.unchanged > 0 {
-		fmt.Printf("  - %d unchanged\n", stats.unchanged)
+	if summary.Unchanged > 0 {
+		fmt.Printf("  - %d unchanged\n", summary.Unchanged)
 	}
-	if stats.updated > 0 {
-		fmt.Printf("  - %d updated\n", stats.updated)
+	if summary.Updated > 0 {
+		fmt.Printf("  - %d updated\n", summary.Updated)
 	}
-	if stats.created > 0 {
-		fmt.Printf("  - %d created\n", stats.created)
+	if summary.Created > 0 {
+		fmt.Printf("  - %d created\n", summary.Created)
 	}
-	if stats.skipped > 0 {
-		fmt.Printf("  - %d skipped\n", stats.skipped)
+	if summary.Skipped > 0 {
+		fmt.Printf("  - %d skipped\n", summary.Skipped)
 	}
+	return nil
 }
 
-func (e *Executor) RunMapService(serviceName string) {
+func (e *Executor) RunMapService(serviceName string) error {
+	if e.Recorder != nil {
+		return ConfigError("DNS mapping talks to Cloudflare directly and does not support --dry-run")
+	}
 	reader := bufio.NewReader(os.Stdin)
 
 	// Load metadata to get environment-specific domain
@@ -190,22 +217,19 @@ func (e *Executor) RunMapService(serviceName string) {
 	// Parse graft-compose.yml with resolved domain
 	compose, err := deploy.ParseComposeFile("graft-compose.yml", domain)
 	if err != nil {
-		fmt.Printf("Error: Failed to parse graft-compose.yml: %v\n", err)
-		return
+		return ConfigError("failed to parse graft-compose.yml: %v", err)
 	}
 
 	// Find the service
 	service, exists := compose.Services[serviceName]
 	if !exists {
-		fmt.Printf("❌ Service '%s' not found in graft-compose.yml\n", serviceName)
-		return
+		return ConfigError("service '%s' not found in graft-compose.yml", serviceName)
 	}
 
 	// Extract domains
 	hosts := deploy.ExtractTraefikHosts(service.Labels)
 	if len(hosts) == 0 {
-		fmt.Printf("❌ Service '%s' has no Traefik Host labels\n", serviceName)
-		return
+		return ConfigError("service '%s' has no Traefik Host labels", serviceName)
 	}
 
 	fmt.Printf("🔍 Mapping service: %s\n", serviceName)
@@ -215,8 +239,7 @@ func (e *Executor) RunMapService(serviceName string) {
 	fmt.Println("\n🌐 Detecting server IP...")
 	client, err := e.getClient()
 	if err != nil {
-		fmt.Printf("Error: Could not connect to server: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
@@ -236,16 +259,14 @@ func (e *Executor) RunMapService(serviceName string) {
 	// Get Cloudflare credentials
 	apiToken, zoneID := fetchCloudflareCredentials(cloudflare, reader)
 	if apiToken == "" || zoneID == "" {
-		fmt.Println("❌ Cloudflare API Token and Zone ID are required.")
-		return
+		return ConfigError("Cloudflare API Token and Zone ID are required")
 	}
 
 	// Verify DNS ownership
 	fmt.Println("\n🔐 Verifying DNS ownership...")
 	verified, err := dns.VerifyDNSOwnership("", apiToken, zoneID)
 	if err != nil || !verified {
-		fmt.Printf("❌ DNS ownership verification failed: %v\n", err)
-		return
+		return remoteError("DNS ownership verification failed: %v", err)
 	}
 	fmt.Println("✅ DNS ownership verified")
 
@@ -295,8 +316,12 @@ func (e *Executor) RunMapService(serviceName string) {
 	}
 
 	fmt.Println("\n✅ DNS mapping complete for service:", serviceName)
+	return nil
 }
-func (e *Executor) RunHookMap() {
+func (e *Executor) RunHookMap() error {
+	if e.Recorder != nil {
+		return ConfigError("DNS mapping talks to Cloudflare directly and does not support --dry-run")
+	}
 	reader := bufio.NewReader(os.Stdin)
 
 	
@@ -314,9 +339,8 @@ func (e *Executor) RunHookMap() {
 	domain = strings.TrimPrefix(domain, "http://")
 	
 	if domain == "" {
-		fmt.Println("❌ No graft-hook URL found in project metadata or server registry.")
 		fmt.Println("💡 Run 'graft init' or 'graft hook' to set up graft-hook first.")
-		return
+		return ConfigError("no graft-hook URL found in project metadata or server registry")
 	}
 
 	// Display the domain to be mapped
@@ -326,8 +350,7 @@ func (e *Executor) RunHookMap() {
 	fmt.Println("\n🌐 Detecting server IP...")
 	client, err := e.getClient()
 	if err != nil {
-		fmt.Printf("Error: Could not connect to server: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
@@ -346,23 +369,20 @@ func (e *Executor) RunHookMap() {
 
 	cloudflare, err := config.LoadCloudFlareConfig()
 	if err != nil {
-		fmt.Println("❌ Failed to load cloudflare config")
-		return
+		return ConfigError("failed to load cloudflare config: %v", err)
 	}
 
 	// Get Cloudflare credentials
 	apiToken, zoneID := fetchCloudflareCredentials(cloudflare, reader)
 	if apiToken == "" || zoneID == "" {
-		fmt.Println("❌ Cloudflare API Token and Zone ID are required.")
-		return
+		return ConfigError("Cloudflare API Token and Zone ID are required")
 	}
 
 	// Verify DNS ownership
 	fmt.Println("\n🔐 Verifying DNS ownership...")
 	verified, err := dns.VerifyDNSOwnership("", apiToken, zoneID)
 	if err != nil || !verified {
-		fmt.Printf("❌ DNS ownership verification failed: %v\n", err)
-		return
+		return remoteError("DNS ownership verification failed: %v", err)
 	}
 	fmt.Println("✅ DNS ownership verified")
 
@@ -373,8 +393,7 @@ func (e *Executor) RunHookMap() {
 	record, err := dns.GetDNSRecord(domain, "", apiToken, zoneID)
 
 	if err != nil {
-		fmt.Printf("❌ Error checking %s: %v\n", domain, err)
-		return
+		return remoteError("error checking %s: %v", domain, err)
 	}
 
 	if record != nil {
@@ -390,8 +409,7 @@ func (e *Executor) RunHookMap() {
 			if confirm == "y" || confirm == "yes" {
 				err = dns.UpdateDNSRecord(record.ID, serverIP, apiToken, zoneID)
 				if err != nil {
-					fmt.Printf("❌ Failed to update: %v\n", err)
-					return
+					return remoteError("failed to update: %v", err)
 				}
 				fmt.Printf("✅ Updated %s → %s\n", domain, serverIP)
 			} else {
@@ -403,13 +421,13 @@ func (e *Executor) RunHookMap() {
 		fmt.Printf("➕ %s → Creating new record...\n", domain)
 		err = dns.CreateDNSRecord(domain, "", serverIP, apiToken, zoneID)
 		if err != nil {
-			fmt.Printf("❌ Failed to create: %v\n", err)
-			return
+			return remoteError("failed to create: %v", err)
 		}
 		fmt.Printf("✅ Created %s → %s\n", domain, serverIP)
 	}
 
 	fmt.Println("\n✅ DNS mapping complete!")
+	return nil
 }
 func fetchCloudflareCredentials(cfg *config.Cloudflare, reader *bufio.Reader) (string, string) {
 	// 1. Try environment variables
@@ -1,9 +1,7 @@
 package executors
 
 import (
-	"bufio"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/skssmd/graft/internal/config"
@@ -13,32 +11,31 @@ import (
 	"github.com/skssmd/graft/internal/server/prompt"
 )
 
-func (e *Executor) RunNewEnv(name string) {
-	reader := bufio.NewReader(os.Stdin)
+func (e *Executor) RunNewEnv(name string, args []string) error {
+	if _, err := e.Answers.ParseFlags(args); err != nil {
+		return ConfigError("%w", err)
+	}
 
 	// 1. Load existing project environment to inherit basics
 	pEnv, err := config.LoadProjectEnv()
 	if err != nil {
-		fmt.Printf("❌ Error: Could not load project configuration. Make sure you are in a Graft project directory: %v\n", err)
-		return
+		return ConfigError("could not load project configuration. Make sure you are in a Graft project directory: %v", err)
 	}
 
 	projName := pEnv.Name
 	deploymentMode := pEnv.DeploymentMode
 
 	if _, exists := pEnv.Env[name]; exists {
-		fmt.Printf("❌ Error: Environment '%s' already exists for this project.\n", name)
-		return
+		return ConfigError("environment '%s' already exists for this project", name)
 	}
 
 	fmt.Printf("🚀 Adding new environment '%s' to project '%s' (Mode: %s)\n", name, projName, deploymentMode)
 
 	// 2. Server Selection (Copy from RunInit)
 	gCfg := e.GlobalConfig
-	srv, err := project.SelectOrAddServer(reader,gCfg)
+	srv, err := project.SelectOrAddServer(e.Answers,gCfg)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return ConfigError("failed to select server: %w", err)
 	}
 	e.Server = srv
 
@@ -49,26 +46,30 @@ func (e *Executor) RunNewEnv(name string) {
 	// 3. Remote Conflict & Host Check
 	client,err:=e.getClient()
 	if err != nil {
-		fmt.Println("Error: Could not get client.")
-		return
+		return err
 	}
 	defer client.Close()
-	remoteProjects, err = project.RemoteConflictCheck(reader, srv, projFull, false, client)
+	remoteProjects, err = project.RemoteConflictCheck(e.Answers, srv, projFull, false, client)
 	if err != nil {
-		return
+		return remoteError("%w", err)
 	}
 
 
 	// 4. Prompts
-	domain := prompt.PromptDomain(reader, "")
-	versionToKeep = prompt.PromptRollback(reader)
+	domain, err := prompt.PromptDomain(e.Answers, "")
+	if err != nil {
+		return ConfigError("%w", err)
+	}
+	versionToKeep = prompt.PromptRollback(e.Answers)
 
 	var gitBranch string
 	if strings.HasPrefix(deploymentMode, "git") {
-		branch, err := prompt.PromptGitBranch(reader)
+		branch, err := prompt.PromptGitBranch(e.Answers)
 		if err == nil {
 			gitBranch = branch
 			fmt.Printf("✅ Selected branch: %s\n", gitBranch)
+		} else if e.Answers.NonInteractive {
+			return ConfigError("%w", err)
 		}
 	}
 
@@ -99,8 +100,7 @@ func (e *Executor) RunNewEnv(name string) {
 	}
 
 	if err := config.SaveProjectMetadata(name, meta); err != nil {
-		fmt.Printf("❌ Failed to save environment metadata: %v\n", err)
-		return
+		return ConfigError("failed to save environment metadata: %v", err)
 	}
 
 	fmt.Printf("\n✨ Environment '%s' successfully added to project '%s'!\n", name, projName)
@@ -130,5 +130,6 @@ func (e *Executor) RunNewEnv(name string) {
 
 	fmt.Printf("📍 Switch Context: graft env %s\n", name)
 	fmt.Printf("🚀 Deploy: graft env %s sync\n", name)
+	return nil
 }
 
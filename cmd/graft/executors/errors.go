@@ -0,0 +1,111 @@
+package executors
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/skssmd/graft/internal/server/prompt"
+	"github.com/skssmd/graft/internal/server/ssh"
+)
+
+// ErrorKind classifies why a command failed
+type ErrorKind int
+
+const (
+	// KindConfig covers missing or invalid local configuration and arguments
+	KindConfig ErrorKind = iota + 1
+	// KindConnection covers failures to reach or authenticate with a server
+	KindConnection
+	// KindRemote covers remote commands, transfers and deployments that failed
+	KindRemote
+	// KindAborted is returned when the user declines a confirmation
+	KindAborted
+)
+
+// Exit codes returned by the graft binary
+const (
+	ExitGeneric    = 1
+	ExitConfig     = 2
+	ExitConnection = 3
+	ExitRemote     = 4
+	ExitAborted    = 5
+)
+
+// CommandError is the error returned by every executor command
+type CommandError struct {
+	Kind ErrorKind
+	Err  error
+	// Status is the remote exit status for pass-through commands, 0 if unknown
+	Status int
+}
+
+func (e *CommandError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigError reports a problem with the command line or local configuration
+func ConfigError(format string, args ...interface{}) error {
+	return &CommandError{Kind: KindConfig, Err: fmt.Errorf(format, args...)}
+}
+
+func connectionError(err error) error {
+	return &CommandError{Kind: KindConnection, Err: err}
+}
+
+func remoteError(format string, args ...interface{}) error {
+	return &CommandError{Kind: KindRemote, Err: fmt.Errorf(format, args...)}
+}
+
+func abortError(format string, args ...interface{}) error {
+	return &CommandError{Kind: KindAborted, Err: fmt.Errorf(format, args...)}
+}
+
+// InterruptError marks err as caused by Ctrl+C
+func InterruptError(err error) error {
+	return &CommandError{Kind: KindAborted, Err: fmt.Errorf("interrupted: %w", err)}
+}
+
+// passthroughError keeps the remote exit status of a command the user ran directly
+func passthroughError(err error) error {
+	status, _ := ssh.ExitStatus(err)
+	return &CommandError{Kind: KindRemote, Err: err, Status: status}
+}
+
+// ExitCode maps an error returned by an executor command to a process exit code
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var missing *prompt.MissingAnswerError
+	if errors.As(err, &missing) {
+		return ExitConfig
+	}
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		return ExitGeneric
+	}
+	switch cmdErr.Kind {
+	case KindConfig:
+		return ExitConfig
+	case KindConnection:
+		return ExitConnection
+	case KindRemote:
+		if cmdErr.Status > 0 {
+			return cmdErr.Status
+		}
+		return ExitRemote
+	case KindAborted:
+		return ExitAborted
+	}
+	return ExitGeneric
+}
+
+// IsAborted reports whether err is a user abort
+func IsAborted(err error) bool {
+	var cmdErr *CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Kind == KindAborted
+}
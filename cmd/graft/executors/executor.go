@@ -1,9 +1,15 @@
 package executors
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"time"
 
 	"github.com/skssmd/graft/internal/config"
+	"github.com/skssmd/graft/internal/server/prompt"
 	"github.com/skssmd/graft/internal/server/ssh"
 )
 
@@ -14,6 +20,27 @@ type Executor struct {
 	Client       *ssh.Client
 	GlobalConfig *config.GlobalConfig
 	ProjectMeta  *config.ProjectMetadata
+
+	// Answers feeds every setup prompt; scripted via --answers, --set and --yes
+	Answers      *prompt.Answers
+
+	// Output is the --output format (table, json or yaml) and Stdout the
+	// writer machine-readable documents are rendered to
+	Output       string
+	Stdout       io.Writer
+	stdout       *os.File // os.Stdout while it is moved to stderr
+
+	// Recorder is set by --dry-run; remote changes are recorded instead of run
+	Recorder     *ssh.Recorder
+
+	// Ctx is cancelled on Ctrl+C, which interrupts the running remote
+	// command; Timeout (--timeout) bounds each remote command
+	Ctx          context.Context
+	Timeout      time.Duration
+
+	// TTY (--tty) runs passthrough docker commands on a pseudo-terminal
+	// even when they do not ask for one
+	TTY          bool
 	
 }
 
@@ -23,6 +50,10 @@ func GetExecutor() *Executor {
 	return &Executor{
 		Env:          "prod",
 		GlobalConfig: globalConfig,
+		Answers:      prompt.NewAnswers(),
+		Output:       OutputTable,
+		Stdout:       os.Stdout,
+		Ctx:          context.Background(),
 	}
 }
 func(e *Executor) getProjectMeta() (*config.ProjectMetadata, error) {
@@ -31,15 +62,14 @@ func(e *Executor) getProjectMeta() (*config.ProjectMetadata, error) {
 	}
 	meta, err := config.LoadProjectMetadata(e.Env)
 	if err != nil {
-		fmt.Println("Error loading project metadata.")
-		return nil, err
+		return nil, ConfigError("could not load project metadata, run 'graft init' first: %v", err)
 	}
 	e.ProjectMeta = meta
 	return meta, nil
 }
 func(e *Executor) saveProjectMeta(meta *config.ProjectMetadata) error {
 	if meta == nil {
-		return fmt.Errorf("project metadata is nil")
+		return ConfigError("project metadata is nil")
 	}
 	e.ProjectMeta = meta
 	return config.SaveProjectMetadata(e.Env, meta)
@@ -49,12 +79,101 @@ func(e *Executor) getClient() (*ssh.Client, error) {
 		return e.Client, nil
 	}
 	if e.Server == nil {
-		return nil, fmt.Errorf("server configuration is missing. please ensure the project is initialized or a server is selected")
+		return nil, ConfigError("server configuration is missing. please ensure the project is initialized or a server is selected")
 	}
-	client, err := ssh.NewClient(e.Server.Host, e.Server.Port, e.Server.User, e.Server.KeyPath)
+	client, err := e.clientFor(e.Server)
 	if err != nil {
 		return nil, err
 	}
 	e.Client = client
 	return client, nil
+}
+
+// clientFor connects to srv, or returns a recording client in dry-run mode.
+// The server's host key is pinned in the registry on first connect and
+// verified against that pin afterwards.
+func(e *Executor) clientFor(srv *config.ServerConfig) (*ssh.Client, error) {
+	if e.Recorder != nil {
+		client, err := ssh.NewDryRunClient(srv.Host, srv.Port, srv.User, srv.KeyPath, srv.HostKey, e.Recorder, srv.Auth...)
+		if err != nil {
+			var mismatch *ssh.HostKeyMismatchError
+			if errors.As(err, &mismatch) {
+				return nil, hostKeyError(srv, mismatch)
+			}
+			fmt.Printf("⚠️  Dry run: could not connect to %s (%v), remote state is assumed empty\n", srv.Host, err)
+		}
+		client.SetContext(e.Ctx)
+		client.SetCommandTimeout(e.Timeout)
+		return client, nil
+	}
+	client, err := ssh.NewPinnedClient(srv.Host, srv.Port, srv.User, srv.KeyPath, srv.HostKey, srv.Auth...)
+	if err != nil {
+		var mismatch *ssh.HostKeyMismatchError
+		if errors.As(err, &mismatch) {
+			return nil, hostKeyError(srv, mismatch)
+		}
+		return nil, connectionError(err)
+	}
+	if srv.HostKey == "" {
+		e.pinHostKey(srv, client.HostKey())
+	}
+	client.SetContext(e.Ctx)
+	client.SetCommandTimeout(e.Timeout)
+	return client, nil
+}
+
+// pinHostKey stores the host key seen on first connect in the registry
+func(e *Executor) pinHostKey(srv *config.ServerConfig, hostKey string) {
+	if hostKey == "" {
+		return
+	}
+	srv.HostKey = hostKey
+
+	gCfg := e.GlobalConfig
+	if gCfg == nil {
+		return
+	}
+	name := srv.RegistryName
+	if _, exists := gCfg.Servers[name]; !exists {
+		// Older registries do not store the name on the entry itself
+		name = ""
+		for n, s := range gCfg.Servers {
+			if s.Host == srv.Host && s.Port == srv.Port && s.User == srv.User {
+				name = n
+				break
+			}
+		}
+	}
+	if name == "" {
+		return
+	}
+
+	regSrv := gCfg.Servers[name]
+	if regSrv.HostKey != "" {
+		return
+	}
+	regSrv.HostKey = hostKey
+	gCfg.Servers[name] = regSrv
+	if err := config.SaveGlobalConfig(gCfg); err != nil {
+		fmt.Printf("⚠️  Could not pin host key for '%s': %v\n", name, err)
+		return
+	}
+	fmt.Printf("📌 Pinned host key for '%s': %s\n", name, ssh.Fingerprint(hostKey))
+}
+
+// hostKeyError explains how to recover from a changed host key
+func hostKeyError(srv *config.ServerConfig, mismatch *ssh.HostKeyMismatchError) error {
+	if mismatch.Pinned {
+		name := srv.RegistryName
+		if name == "" {
+			name = "<name>"
+		}
+		return connectionError(fmt.Errorf("%v\n👉 If the server was rebuilt or its keys were rotated, verify the new fingerprint and run: graft registry rekey %s", mismatch, name))
+	}
+	return connectionError(fmt.Errorf("%v\n👉 If the key changed legitimately, remove the old entry with: ssh-keygen -R %s", mismatch, mismatch.Host))
+}
+
+// EnableDryRun switches every remote operation to a recording transport
+func(e *Executor) EnableDryRun() {
+	e.Recorder = ssh.NewRecorder()
 }
\ No newline at end of file
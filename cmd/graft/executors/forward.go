@@ -0,0 +1,265 @@
+package executors
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/skssmd/graft/internal/config"
+	"github.com/skssmd/graft/internal/server/ssh"
+)
+
+// Shared infrastructure containers and their default ports
+var infraPorts = map[string]int{
+	"graft-postgres": 5432,
+	"graft-redis":    6379,
+}
+
+// RunForward tunnels a local port to a service or infrastructure container
+// on the server: graft forward <service|graft-postgres|graft-redis>:<port> [localport]
+func (e *Executor) RunForward(args []string) error {
+	if len(args) < 1 {
+		fmt.Println("Usage: graft forward <service|graft-postgres|graft-redis>:<port> [localport]")
+		return nil
+	}
+	if e.Recorder != nil {
+		return ConfigError("port forwarding does not change the server and does not support --dry-run")
+	}
+
+	target, port, err := parseForwardTarget(args[0])
+	if err != nil {
+		return err
+	}
+	localPort := port
+	if len(args) > 1 {
+		if localPort, err = strconv.Atoi(args[1]); err != nil || localPort < 0 || localPort > 65535 {
+			return ConfigError("invalid local port '%s'", args[1])
+		}
+	}
+
+	client, err := e.getClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	addr, err := e.containerAddress(client, target, port)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(localPort)))
+	if err != nil {
+		return ConfigError("could not listen on local port %d: %v", localPort, err)
+	}
+
+	fmt.Printf("🔌 Forwarding %s → %s:%d (%s) on %s\n", listener.Addr(), target, port, addr, e.Server.RegistryName)
+	fmt.Println("Press Ctrl+C to stop")
+	if err := client.Forward(e.Ctx, listener, addr); err != nil {
+		return connectionError(err)
+	}
+	fmt.Println("\n🔌 Tunnel closed")
+	return nil
+}
+
+// RunDBConnect opens a tunnel to the shared Postgres and starts psql on the
+// given database, or prints its URL with --print
+func (e *Executor) RunDBConnect(name string, args []string) error {
+	name = config.NormalizeProjectName(name)
+	if name == "" {
+		return ConfigError("invalid postgres name. Use only letters, numbers, and underscores")
+	}
+	if e.Recorder != nil {
+		return ConfigError("port forwarding does not change the server and does not support --dry-run")
+	}
+
+	localPort, printOnly := 0, false
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--print":
+			printOnly = true
+		case args[i] == "--port" && i+1 < len(args):
+			p, err := strconv.Atoi(args[i+1])
+			if err != nil || p < 0 || p > 65535 {
+				return ConfigError("invalid --port '%s'", args[i+1])
+			}
+			localPort = p
+			i++
+		default:
+			return ConfigError("unknown argument '%s' (usage: graft db <name> connect [--port <localport>] [--print])", args[i])
+		}
+	}
+
+	client, err := e.getClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// 1. Credentials from the server's infra config
+	infraCfg, err := fetchInfraConfig(client)
+	if err != nil {
+		return err
+	}
+	if infraCfg.PostgresUser == "" {
+		return remoteError("postgres is not set up on this server. Run 'graft host init' first")
+	}
+
+	// 2. Tunnel to graft-postgres
+	addr, err := e.containerAddress(client, "graft-postgres", 5432)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(localPort)))
+	if err != nil {
+		return ConfigError("could not listen on local port %d: %v", localPort, err)
+	}
+
+	dbURL := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(infraCfg.PostgresUser, infraCfg.PostgresPassword),
+		Host:   listener.Addr().String(),
+		Path:   "/" + name,
+	}
+
+	psql, lookErr := exec.LookPath("psql")
+	if printOnly || lookErr != nil {
+		if lookErr != nil && !printOnly {
+			fmt.Println("ℹ️  psql not found in PATH, connect with your own client instead.")
+		}
+		fmt.Printf("🔌 Tunnel to graft-postgres open on %s\n", listener.Addr())
+		fmt.Printf("🐘 %s\n", dbURL.String())
+		fmt.Println("Press Ctrl+C to stop")
+		if err := client.Forward(e.Ctx, listener, addr); err != nil {
+			return connectionError(err)
+		}
+		fmt.Println("\n🔌 Tunnel closed")
+		return nil
+	}
+
+	// 3. psql handles Ctrl+C itself to cancel queries, so graft ignores it
+	// and keeps the tunnel open while psql runs
+	signal.Ignore(os.Interrupt)
+	defer signal.Reset(os.Interrupt)
+
+	tunnelErr := make(chan error, 1)
+	ctx, cancel := context.WithCancel(e.Ctx)
+	defer cancel()
+	go func() { tunnelErr <- client.Forward(ctx, listener, addr) }()
+
+	// The password goes through the environment so it never shows up in
+	// the process list
+	fmt.Printf("🐘 Connecting to '%s' on %s through %s...\n", name, e.Server.RegistryName, listener.Addr())
+	dbURL.User = url.User(infraCfg.PostgresUser)
+	cmd := exec.Command(psql, dbURL.String())
+	cmd.Env = append(os.Environ(), "PGPASSWORD="+infraCfg.PostgresPassword)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	runErr := cmd.Run()
+
+	cancel()
+	if err := <-tunnelErr; err != nil {
+		return connectionError(err)
+	}
+	if runErr != nil {
+		return passthroughError(runErr)
+	}
+	return nil
+}
+
+// parseForwardTarget splits "<name>:<port>". The port may be left out for
+// the shared infrastructure containers.
+func parseForwardTarget(arg string) (string, int, error) {
+	name, portStr, hasPort := strings.Cut(arg, ":")
+	if name == "" {
+		return "", 0, ConfigError("invalid forward target '%s' (use <service>:<port>)", arg)
+	}
+	if !hasPort {
+		if port, ok := infraPorts[name]; ok {
+			return name, port, nil
+		}
+		return "", 0, ConfigError("missing port in '%s' (use %s:<port>)", arg, name)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, ConfigError("invalid port '%s'", portStr)
+	}
+	return name, port, nil
+}
+
+// containerAddress resolves target to the host:port of its container on the
+// server. graft-postgres and graft-redis are looked up by name, anything else
+// as a service of the current project.
+func (e *Executor) containerAddress(client *ssh.Client, target string, port int) (string, error) {
+	container := target
+	if _, ok := infraPorts[target]; !ok {
+		meta, err := e.getProjectMeta()
+		if err != nil {
+			return "", err
+		}
+		out, err := client.GetCommandOutput(fmt.Sprintf("cd %s && sudo docker compose ps -q %s", meta.RemotePath, target))
+		if err != nil {
+			return "", remoteError("could not find service '%s': %v", target, err)
+		}
+		fields := strings.Fields(out)
+		if len(fields) == 0 {
+			return "", remoteError("service '%s' is not running", target)
+		}
+		container = fields[0]
+	}
+
+	out, err := client.GetCommandOutput(fmt.Sprintf("sudo docker inspect -f '{{json .NetworkSettings.Networks}}' %s", container))
+	if err != nil {
+		return "", remoteError("could not inspect container '%s': %v", target, err)
+	}
+	var networks map[string]struct {
+		IPAddress string `json:"IPAddress"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &networks); err != nil {
+		return "", remoteError("could not read networks of '%s': %v", target, err)
+	}
+
+	// Prefer graft-public, which every graft container joins
+	ip := networks["graft-public"].IPAddress
+	if ip == "" {
+		for _, n := range networks {
+			if n.IPAddress != "" {
+				ip = n.IPAddress
+				break
+			}
+		}
+	}
+	if ip == "" {
+		return "", remoteError("container '%s' has no network address; is it running?", target)
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(port)), nil
+}
+
+// fetchInfraConfig downloads the server's infrastructure config
+func fetchInfraConfig(client *ssh.Client) (*config.InfraConfig, error) {
+	tmpFile := filepath.Join(os.TempDir(), "infra_config.json")
+	defer os.Remove(tmpFile)
+
+	if err := client.DownloadFile(config.RemoteInfraPath, tmpFile); err != nil {
+		fmt.Println("Make sure infrastructure has been initialized with 'graft host init'")
+		return nil, remoteError("could not fetch infra config from remote server: %v", err)
+	}
+	data, err := os.ReadFile(tmpFile)
+	if err != nil {
+		return nil, remoteError("error reading infra config: %v", err)
+	}
+	var infraCfg config.InfraConfig
+	if err := json.Unmarshal(data, &infraCfg); err != nil {
+		return nil, remoteError("error parsing infra config: %v", err)
+	}
+	return &infraCfg, nil
+}
@@ -0,0 +1,117 @@
+package executors
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/skssmd/graft/internal/server/deploy"
+)
+
+// RunHistory prints the deployment history of the current project and
+// environment: graft history [--service <name>] [--json]
+func (e *Executor) RunHistory(args []string) error {
+	var service string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--service" && i+1 < len(args):
+			service = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "--service="):
+			service = strings.TrimPrefix(args[i], "--service=")
+		case args[i] == "--json":
+			if err := e.SetOutput(OutputJSON); err != nil {
+				return err
+			}
+		default:
+			return ConfigError("unknown argument '%s' (usage: graft history [--service <name>] [--json])", args[i])
+		}
+	}
+
+	meta, err := e.getProjectMeta()
+	if err != nil {
+		return err
+	}
+	client, err := e.getClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	entries, err := deploy.LoadHistory(client, meta.Name)
+	if err != nil {
+		return remoteError("%v", err)
+	}
+
+	// Newest first, only this environment and, if given, this service
+	doc := HistoryOutput{
+		Project: meta.Name,
+		Env:     e.Env,
+		Server:  e.Server.RegistryName,
+		Service: service,
+		Entries: []deploy.HistoryEntry{},
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.Env != "" && entry.Env != e.Env {
+			continue
+		}
+		if service != "" && !touches(entry, service) {
+			continue
+		}
+		doc.Entries = append(doc.Entries, entry)
+	}
+
+	if e.machineOutput() {
+		return e.render(doc)
+	}
+
+	if len(doc.Entries) == 0 {
+		fmt.Printf("No deployments recorded for %s (%s) yet.\n", meta.Name, e.Env)
+		return nil
+	}
+	fmt.Printf("\n📜 Deployment history of %s (%s) on %s:\n", meta.Name, e.Env, e.Server.RegistryName)
+	fmt.Printf("   %-20s %-17s %-20s %-10s %-9s %-8s %s\n", "Time", "Action", "By", "Commit", "Duration", "Result", "Services")
+	fmt.Println("   " + strings.Repeat("-", 110))
+	for _, entry := range doc.Entries {
+		when := entry.Time
+		if t, err := time.Parse(time.RFC3339, entry.Time); err == nil {
+			when = t.Local().Format("2006-01-02 15:04:05")
+		}
+		by := entry.User
+		if entry.Host != "" {
+			by += "@" + entry.Host
+		}
+		commit := entry.GitCommit
+		if len(commit) > 7 {
+			commit = commit[:7]
+		}
+		if entry.GitDirty {
+			commit += "+"
+		}
+		result := "✅"
+		if entry.Result != deploy.ResultSuccess {
+			result = "❌"
+		}
+		duration := (time.Duration(entry.Duration*10) * time.Second / 10).String()
+		fmt.Printf("   %-20s %-17s %-20s %-10s %-9s %-8s %s\n", when, entry.Action, by, commit, duration, result, strings.Join(entry.Services, ", "))
+		for _, hook := range entry.Hooks {
+			fmt.Printf("   %20s 🪝 %s hook of %s exited with code %d\n", "", hook.Stage, hook.Service, hook.ExitCode)
+		}
+		if entry.Error != "" {
+			fmt.Printf("   %20s └─ %s\n", "", entry.Error)
+		}
+	}
+	fmt.Println()
+	return nil
+}
+
+// touches reports whether a history entry deployed the given service
+func touches(entry deploy.HistoryEntry, service string) bool {
+	for _, s := range entry.Services {
+		if s == service {
+			return true
+		}
+	}
+	return false
+}
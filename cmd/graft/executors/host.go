@@ -9,26 +9,30 @@ import (
 	"strings"
 
 	"github.com/skssmd/graft/internal/config"
+	"github.com/skssmd/graft/internal/server/deploy"
 	"github.com/skssmd/graft/internal/server/hostinit"
 )
 
-func (e *Executor) RunHostInit() {
+func (e *Executor) RunHostInit(args []string) error {
 	cfg:=e
 
+	if _, err := e.Answers.ParseFlags(args); err != nil {
+		return ConfigError("%w", err)
+	}
+
 	client, err := e.getClient()
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
-	reader := bufio.NewReader(os.Stdin)
-
 	// Save or update registry name
 	if cfg.Server.RegistryName == "" {
-		fmt.Print("Enter a Registry Name for this server (e.g. prod-us): ")
-		name, _ := reader.ReadString('\n')
-		cfg.Server.RegistryName = strings.TrimSpace(name)
+		name, err := e.Answers.Ask("server.name", "Enter a Registry Name for this server (e.g. prod-us): ")
+		if err != nil {
+			return ConfigError("%w", err)
+		}
+		cfg.Server.RegistryName = name
 
 	}
 
@@ -39,6 +43,10 @@ func (e *Executor) RunHostInit() {
 			gCfg.Servers = make(map[string]config.ServerConfig)
 		}
 		srv := gCfg.Servers[cfg.Server.RegistryName]
+		if srv.Host != cfg.Server.Host || srv.Port != cfg.Server.Port || cfg.Server.HostKey != "" {
+			// The key pinned when connecting above belongs to this address
+			srv.HostKey = cfg.Server.HostKey
+		}
 		srv.RegistryName = cfg.Server.RegistryName
 		srv.Host = cfg.Server.Host
 		srv.Port = cfg.Server.Port
@@ -54,30 +62,18 @@ func (e *Executor) RunHostInit() {
 	// Ask about shared infrastructure
 	fmt.Println("\n🗄️  Shared Infrastructure Setup")
 
-	fmt.Print("Setup shared Postgres instance? (y/n): ")
-	confirmPG, _ := reader.ReadString('\n')
-	confirmPG = strings.ToLower(strings.TrimSpace(confirmPG))
-	setupPostgres := confirmPG == "y" || confirmPG == "yes"
+	setupPostgres := e.Answers.Confirm("host.postgres", "Setup shared Postgres instance? (y/n): ", false)
 
 	var exposePostgres bool
 	if setupPostgres {
-		fmt.Print("  Expose Postgres port (5432) to the internet? (y/n): ")
-		input, _ := reader.ReadString('\n')
-		input = strings.ToLower(strings.TrimSpace(input))
-		exposePostgres = input == "y" || input == "yes"
+		exposePostgres = e.Answers.Confirm("host.expose_postgres", "  Expose Postgres port (5432) to the internet? (y/n): ", false)
 	}
 
-	fmt.Print("Setup shared Redis instance? (y/n): ")
-	confirmRedis, _ := reader.ReadString('\n')
-	confirmRedis = strings.ToLower(strings.TrimSpace(confirmRedis))
-	setupRedis := confirmRedis == "y" || confirmRedis == "yes"
+	setupRedis := e.Answers.Confirm("host.redis", "Setup shared Redis instance? (y/n): ", false)
 
 	var exposeRedis bool
 	if setupRedis {
-		fmt.Print("  Expose Redis port (6379) to the internet? (y/n): ")
-		input, _ := reader.ReadString('\n')
-		input = strings.ToLower(strings.TrimSpace(input))
-		exposeRedis = input == "y" || input == "yes"
+		exposeRedis = e.Answers.Confirm("host.expose_redis", "  Expose Redis port (6379) to the internet? (y/n): ", false)
 	}
 	var infraCfg config.InfraConfig
 	// Secure credentials for infrastructure
@@ -104,22 +100,29 @@ func (e *Executor) RunHostInit() {
 		infraCfg.PostgresUser, infraCfg.PostgresPassword, infraCfg.PostgresDB,
 		os.Stdout, os.Stderr)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return remoteError("host initialization failed: %v", err)
 	}
 
 	fmt.Println("\n✅ Host initialized successfully!")
+	return nil
 }
 
-func (e *Executor) RunHostClean() {
+func (e *Executor) RunHostClean() error {
 
 	client, err := e.getClient()
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
+	// The prunes are host-wide, so no project may deploy while they run or
+	// they would remove the images it builds
+	unlock, err := deploy.AcquireHostLock(client, deploy.ActionHostClean, os.Stdout)
+	if err != nil {
+		return remoteError("%v", err)
+	}
+	defer unlock()
+
 	fmt.Println("🧹 Cleaning Docker caches and unused resources...")
 
 	cleanupCmds := []struct {
@@ -141,8 +144,9 @@ func (e *Executor) RunHostClean() {
 	}
 
 	fmt.Println("\n✅ Cleanup complete!")
+	return nil
 }
-func (e *Executor) RunHostSelfDestruct() {
+func (e *Executor) RunHostSelfDestruct() error {
 
 	reader := bufio.NewReader(os.Stdin)
 
@@ -165,8 +169,7 @@ func (e *Executor) RunHostSelfDestruct() {
 	confirm = strings.TrimSpace(confirm)
 
 	if confirm != "DESTROY" {
-		fmt.Println("❌ Self-destruct aborted. No changes made.")
-		return
+		return abortError("self-destruct aborted. No changes made")
 	}
 
 	fmt.Print("\nAre you absolutely sure? Type 'YES' to proceed: ")
@@ -174,14 +177,12 @@ func (e *Executor) RunHostSelfDestruct() {
 	finalConfirm = strings.TrimSpace(finalConfirm)
 
 	if finalConfirm != "YES" {
-		fmt.Println("❌ Self-destruct aborted. No changes made.")
-		return
+		return abortError("self-destruct aborted. No changes made")
 	}
 
 	client, err := e.getClient()
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
@@ -256,15 +257,15 @@ func (e *Executor) RunHostSelfDestruct() {
 	fmt.Println("\nThe server has been cleaned of all Graft infrastructure.")
 	fmt.Println("Docker and Docker Compose remain installed.")
 	fmt.Println("\n💡 You can run 'graft host init' to set up a fresh environment.")
+	return nil
 }
 
-func (e *Executor) RunHostShell(commandArgs []string) {
+func (e *Executor) RunHostShell(commandArgs []string) error {
 	
 
 	client, err := e.getClient()
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
@@ -272,25 +273,25 @@ func (e *Executor) RunHostShell(commandArgs []string) {
 		// Interactive SSH
 		fmt.Printf("💻 Starting interactive SSH session on '%s' (%s)...\n", e.Server.RegistryName, e.Server.Host)
 		if err := client.InteractiveSession(); err != nil {
-			fmt.Printf("SSH session error: %v\n", err)
+			return passthroughError(err)
 		}
 	} else {
 		// Non-interactive command
 		cmdStr := strings.Join(commandArgs, " ")
 		fmt.Printf("🚀 Executing on '%s': %s\n", e.Server.RegistryName, cmdStr)
 		if err := client.RunCommand(cmdStr, os.Stdout, os.Stderr); err != nil {
-			fmt.Printf("Error: %v\n", err)
+			return passthroughError(err)
 		}
 	}
+	return nil
 }
 
-func (e *Executor) RunHostDocker(commandArgs []string) {
+func (e *Executor) RunHostDocker(commandArgs []string) error {
 	
 
 	client, err := e.getClient()
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
@@ -301,8 +302,9 @@ func (e *Executor) RunHostDocker(commandArgs []string) {
 		// Non-interactive command
 		cmdStr := "sudo docker " + strings.Join(commandArgs, " ")
 		fmt.Printf("🚀 Executing on '%s': %s\n", e.Server.RegistryName, cmdStr)
-		if err := client.RunCommand(cmdStr, os.Stdout, os.Stderr); err != nil {
-			fmt.Printf("Error: %v\n", err)
+		if err := e.runPassthrough(client, cmdStr, e.needsTTY(commandArgs, false)); err != nil {
+			return err
 		}
 	}
+	return nil
 }
@@ -12,17 +12,15 @@ import (
 	"github.com/skssmd/graft/internal/server/infra"
 )
 
-func (e *Executor) RunInfraInit(typ, name string) {
+func (e *Executor) RunInfraInit(typ, name string) error {
 	name = config.NormalizeProjectName(name)
 	if name == "" {
-		fmt.Printf("Error: Invalid %s name. Use only letters, numbers, and underscores.\n", typ)
-		return
+		return ConfigError("invalid %s name. Use only letters, numbers, and underscores", typ)
 	}
 
 	client, err := e.getClient()
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
@@ -34,8 +32,7 @@ func (e *Executor) RunInfraInit(typ, name string) {
 	}
 
 	if err != nil {
-		fmt.Printf("Error initializing %s: %v\n", typ, err)
-		return
+		return remoteError("error initializing %s: %v", typ, err)
 	}
 
 	secretKey := fmt.Sprintf("GRAFT_%s_%s_URL", strings.ToUpper(typ), strings.ToUpper(name))
@@ -46,40 +43,42 @@ func (e *Executor) RunInfraInit(typ, name string) {
 	fmt.Printf("\n✅ %s '%s' initialized!\n", typ, name)
 	fmt.Printf("Secret saved at ./graft/secrets.env")
 
+	return nil
 }
 
-func (e *Executor) RunInfra(args []string) {
+func (e *Executor) RunInfra(args []string) error {
 	if len(args) < 2 {
 		fmt.Println("Usage: graft infra [db|redis] ports:<value>")
 		fmt.Println("       graft infra db backup")
 		fmt.Println("       graft infra reload")
-		return
+		return nil
 	}
 
 	typ := args[0]
 	if typ != "db" && typ != "redis" {
-		fmt.Println("Error: First argument must be 'db' or 'redis'")
-		return
+		return ConfigError("first argument must be 'db' or 'redis'")
 	}
 
 	// Handle backup subcommand
 	if typ == "db" && len(args) > 1 && args[1] == "backup" {
-		if e.Server.Host == "" {
-			fmt.Println("Error: No server configuration found.")
-			return
+		if e.Server == nil || e.Server.Host == "" {
+			return ConfigError("no server configuration found")
+		}
+
+		if _, err := e.Answers.ParseFlags(args[2:]); err != nil {
+			return ConfigError("%w", err)
 		}
 
 		client, err := e.getClient()
 		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return
+			return err
 		}
 		defer client.Close()
 
-		if err := infra.SetupDBBackup(client, os.Stdout, os.Stderr); err != nil {
-			fmt.Printf("Error setting up database backup: %v\n", err)
+		if err := infra.SetupDBBackup(client, e.Answers, os.Stdout, os.Stderr); err != nil {
+			return remoteError("error setting up database backup: %w", err)
 		}
-		return
+		return nil
 	}
 
 	var portVal string
@@ -93,13 +92,12 @@ func (e *Executor) RunInfra(args []string) {
 	if portVal == "" {
 		fmt.Println("Usage: graft infra [db|redis] ports:<value> (use 'ports:null' to hide)")
 		fmt.Println("       graft infra db backup")
-		return
+		return nil
 	}
 
 	client, err := e.getClient()
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
@@ -108,21 +106,18 @@ func (e *Executor) RunInfra(args []string) {
 	defer os.Remove(tmpFile)
 	
 	if err := client.DownloadFile(config.RemoteInfraPath, tmpFile); err != nil {
-		fmt.Println("Error: Could not fetch infra config from remote server.")
 		fmt.Println("Make sure infrastructure has been initialized with 'graft host init'")
-		return
+		return remoteError("could not fetch infra config from remote server: %v", err)
 	}
 
 	data, err := os.ReadFile(tmpFile)
 	if err != nil {
-		fmt.Printf("Error reading infra config: %v\n", err)
-		return
+		return remoteError("error reading infra config: %v", err)
 	}
 
 	var infraCfg config.InfraConfig
 	if err := json.Unmarshal(data, &infraCfg); err != nil {
-		fmt.Printf("Error parsing infra config: %v\n", err)
-		return
+		return remoteError("error parsing infra config: %v", err)
 	}
 
 	// Update port in config
@@ -143,18 +138,17 @@ func (e *Executor) RunInfra(args []string) {
 
 	err = hostinit.SetupInfra(client, setupPG, setupRedis, infraCfg, os.Stdout, os.Stderr)
 	if err != nil {
-		fmt.Printf("Error updating infrastructure: %v\n", err)
-		return
+		return remoteError("error updating infrastructure: %v", err)
 	}
 
 	fmt.Println("\n✅ Infrastructure updated successfully!")
+	return nil
 }
 
-func (e *Executor) RunInfraReload() {
+func (e *Executor) RunInfraReload() error {
 	client, err := e.getClient()
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
@@ -163,9 +157,9 @@ func (e *Executor) RunInfraReload() {
 	// Use docker compose up -d --pull always to pull and reload
 	reloadCmd := "cd /opt/graft/infra && sudo docker compose up -d --pull always"
 	if err := client.RunCommand(reloadCmd, os.Stdout, os.Stderr); err != nil {
-		fmt.Printf("Error reloading infrastructure: %v\n", err)
-		return
+		return remoteError("error reloading infrastructure: %v", err)
 	}
 
 	fmt.Println("\n✅ Infrastructure reloaded successfully!")
+	return nil
 }
@@ -0,0 +1,429 @@
+package executors
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skssmd/graft/internal/config"
+	"github.com/skssmd/graft/internal/server/ssh"
+)
+
+// keyRollout is the progress of `graft pub rollout`. It is saved after every
+// step, so an interrupted rotation can be resumed or rolled back.
+type keyRollout struct {
+	StartedAt    string `json:"started_at"`
+	KeyType      string `json:"key_type"`
+	OldPublicKey string `json:"old_public_key"`
+	NewPublicKey string `json:"new_public_key"`
+	// Installed is set once the new key replaced graftpem locally
+	Installed bool                      `json:"installed"`
+	Servers   map[string]*rolloutServer `json:"servers"`
+}
+
+type rolloutServer struct {
+	Host   string `json:"host"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Per-server rollout states, in order
+const (
+	rolloutPending  = "pending"  // not touched yet
+	rolloutAdding   = "adding"   // the new key may have been added
+	rolloutAdded    = "added"    // the new key is in authorized_keys
+	rolloutVerified = "verified" // a login with the new key worked
+	rolloutDone     = "done"     // the old key was removed
+)
+
+// keyPaths are the local files involved in a rollout. The new key is staged
+// next to the current one until every server accepts it.
+type keyPaths struct {
+	pem, pub       string
+	newPem, newPub string
+	state          string
+}
+
+func graftKeyPaths() keyPaths {
+	gDir := config.GetGlobalConfigDir()
+	return keyPaths{
+		pem:    filepath.Join(gDir, "graftpem"),
+		pub:    filepath.Join(gDir, "graftpub"),
+		newPem: filepath.Join(gDir, "graftpem.new"),
+		newPub: filepath.Join(gDir, "graftpub.new"),
+		state:  filepath.Join(gDir, "rollout.json"),
+	}
+}
+
+// RolloutSSHKeys rotates the Graft SSH key on every server that uses it.
+// The new key is added everywhere and verified with a real login before the
+// old key is removed, so a failed server never locks us out.
+func (e *Executor) RolloutSSHKeys(args []string) error {
+	keyType := ssh.KeyTypeEd25519
+	abort := false
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--abort":
+			abort = true
+		case args[i] == "--type" && i+1 < len(args):
+			keyType = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "--type="):
+			keyType = strings.TrimPrefix(args[i], "--type=")
+		default:
+			return ConfigError("unknown argument '%s' (usage: graft pub rollout [--type ed25519|rsa] [--abort])", args[i])
+		}
+	}
+	if keyType != ssh.KeyTypeEd25519 && keyType != ssh.KeyTypeRSA {
+		return ConfigError("unknown key type '%s' (use %s or %s)", keyType, ssh.KeyTypeEd25519, ssh.KeyTypeRSA)
+	}
+	if e.GlobalConfig == nil {
+		return ConfigError("global config not loaded")
+	}
+
+	paths := graftKeyPaths()
+	state, err := loadKeyRollout(paths.state)
+	if err != nil {
+		return ConfigError("%v", err)
+	}
+
+	if e.Recorder != nil {
+		return e.dryRunKeyRollout(state, keyType, paths)
+	}
+	if abort {
+		if state == nil {
+			return ConfigError("no key rollout in progress")
+		}
+		return e.abortKeyRollout(state, paths)
+	}
+
+	if state == nil {
+		if state, err = startKeyRollout(keyType, paths); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("🔁 Resuming the key rollout started at %s\n", state.StartedAt)
+	}
+	return e.continueKeyRollout(state, paths)
+}
+
+// startKeyRollout stages a new key pair and records the rollout
+func startKeyRollout(keyType string, paths keyPaths) (*keyRollout, error) {
+	oldPub, err := os.ReadFile(paths.pub)
+	if err != nil {
+		return nil, ConfigError("could not read current public key: %v (run 'graft pub' to create one)", err)
+	}
+
+	fmt.Printf("🔄 Generating new %s SSH key pair...\n", keyType)
+	newPem, newPub, err := ssh.GenerateKeyPair(keyType)
+	if err != nil {
+		return nil, ConfigError("could not generate new keys: %v", err)
+	}
+	if err := os.WriteFile(paths.newPem, []byte(newPem), 0600); err != nil {
+		return nil, ConfigError("could not save new private key: %v", err)
+	}
+	if err := os.WriteFile(paths.newPub, []byte(newPub), 0644); err != nil {
+		return nil, ConfigError("could not save new public key: %v", err)
+	}
+
+	state := &keyRollout{
+		StartedAt:    time.Now().Format(time.RFC3339),
+		KeyType:      keyType,
+		OldPublicKey: strings.TrimSpace(string(oldPub)),
+		NewPublicKey: strings.TrimSpace(newPub),
+		Servers:      map[string]*rolloutServer{},
+	}
+	if err := state.save(paths.state); err != nil {
+		return nil, ConfigError("%v", err)
+	}
+	return state, nil
+}
+
+func (e *Executor) continueKeyRollout(state *keyRollout, paths keyPaths) error {
+	if !state.Installed {
+		// Servers registered since the rollout started need the new key too
+		for _, name := range e.graftKeyServers(paths.pem) {
+			if _, ok := state.Servers[name]; !ok {
+				srv := e.GlobalConfig.Servers[name]
+				state.Servers[name] = &rolloutServer{Host: srv.Host, Status: rolloutPending}
+			}
+		}
+		if len(state.Servers) == 0 {
+			fmt.Println("ℹ️  No servers found using the Graft SSH key. Only local keys will be updated.")
+		}
+
+		// 1. Add the new key next to the old one, logging in with the old key
+		if pending := state.count(rolloutPending, rolloutAdding); pending > 0 {
+			fmt.Printf("🚀 Adding the new key to %d server(s)...\n", pending)
+		}
+		for _, name := range state.names(rolloutPending, rolloutAdding) {
+			e.rolloutStep(state, paths, name, rolloutAdding, rolloutAdded, "adding new key", func(srv *config.ServerConfig) error {
+				client, err := e.clientFor(srv)
+				if err != nil {
+					return err
+				}
+				defer client.Close()
+				return client.AddAuthorizedKey(state.NewPublicKey)
+			})
+		}
+
+		// 2. Prove the new key works before anything is removed
+		for _, name := range state.names(rolloutAdded) {
+			e.rolloutStep(state, paths, name, rolloutAdded, rolloutVerified, "verifying login with new key", func(srv *config.ServerConfig) error {
+				client, err := e.keyClientFor(srv, paths.newPem)
+				if err != nil {
+					return err
+				}
+				defer client.Close()
+				return client.RunCommand("true", nil, nil)
+			})
+		}
+
+		if failed := len(state.Servers) - state.count(rolloutVerified); failed > 0 {
+			printRolloutFailures(state)
+			fmt.Println("   Nothing was removed: the old key still works on every server.")
+			fmt.Println("💡 Run 'graft pub rollout' to retry, or 'graft pub rollout --abort' to roll back.")
+			return connectionError(fmt.Errorf("%d of %d servers do not accept the new key yet", failed, len(state.Servers)))
+		}
+
+		// 3. Every server accepts the new key, switch to it locally
+		fmt.Println("💾 Installing new keys locally...")
+		if err := installStagedKey(paths.newPem, paths.pem); err != nil {
+			return ConfigError("could not install private key: %v", err)
+		}
+		if err := installStagedKey(paths.newPub, paths.pub); err != nil {
+			return ConfigError("could not install public key: %v", err)
+		}
+		state.Installed = true
+		if err := state.save(paths.state); err != nil {
+			return ConfigError("%v", err)
+		}
+	}
+
+	// 4. Remove the old key, logging in with the new one
+	for _, name := range state.names(rolloutVerified) {
+		e.rolloutStep(state, paths, name, rolloutVerified, rolloutDone, "removing old key", func(srv *config.ServerConfig) error {
+			client, err := e.keyClientFor(srv, paths.pem)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+			return client.RemoveAuthorizedKey(state.OldPublicKey)
+		})
+	}
+
+	if failed := len(state.Servers) - state.count(rolloutDone); failed > 0 {
+		printRolloutFailures(state)
+		fmt.Println("   The new key is active everywhere, but these servers still accept the old key.")
+		fmt.Println("💡 Run 'graft pub rollout' to finish removing it.")
+		return connectionError(fmt.Errorf("old key still present on %d of %d servers", failed, len(state.Servers)))
+	}
+
+	if err := os.Remove(paths.state); err != nil && !os.IsNotExist(err) {
+		return ConfigError("could not remove %s: %v", paths.state, err)
+	}
+	fmt.Printf("\n✨ Successfully rolled out SSH keys! (%d servers updated)\n", len(state.Servers))
+	return nil
+}
+
+// abortKeyRollout removes the staged key from every server it may have been
+// added to and discards it locally
+func (e *Executor) abortKeyRollout(state *keyRollout, paths keyPaths) error {
+	if state.Installed {
+		return ConfigError("the new key is already in use locally; run 'graft pub rollout' to finish the rotation")
+	}
+
+	fmt.Println("⏪ Rolling back the key rollout...")
+	for _, name := range state.names(rolloutAdding, rolloutAdded, rolloutVerified) {
+		e.rolloutStep(state, paths, name, "", rolloutPending, "removing new key", func(srv *config.ServerConfig) error {
+			client, err := e.clientFor(srv)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+			return client.RemoveAuthorizedKey(state.NewPublicKey)
+		})
+	}
+
+	if failed := len(state.Servers) - state.count(rolloutPending); failed > 0 {
+		printRolloutFailures(state)
+		fmt.Println("💡 Run 'graft pub rollout --abort' again once they are reachable.")
+		return connectionError(fmt.Errorf("could not roll back %d of %d servers", failed, len(state.Servers)))
+	}
+
+	for _, p := range []string{paths.newPem, paths.newPub, paths.state} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return ConfigError("could not remove %s: %v", p, err)
+		}
+	}
+	fmt.Println("✅ Key rollout rolled back. The current key is unchanged.")
+	return nil
+}
+
+// dryRunKeyRollout records the commands of a full rollout without touching
+// the servers or the local keys
+func (e *Executor) dryRunKeyRollout(state *keyRollout, keyType string, paths keyPaths) error {
+	var oldPub, newPub string
+	if state != nil {
+		oldPub, newPub = state.OldPublicKey, state.NewPublicKey
+	} else {
+		data, err := os.ReadFile(paths.pub)
+		if err != nil {
+			return ConfigError("could not read current public key: %v (run 'graft pub' to create one)", err)
+		}
+		oldPub = string(data)
+		if _, newPub, err = ssh.GenerateKeyPair(keyType); err != nil {
+			return ConfigError("could not generate new keys: %v", err)
+		}
+	}
+
+	for _, name := range e.graftKeyServers(paths.pem) {
+		srv := e.GlobalConfig.Servers[name]
+		client, err := e.clientFor(&srv)
+		if err != nil {
+			return err
+		}
+		client.AddAuthorizedKey(newPub)
+		client.RemoveAuthorizedKey(oldPub)
+		client.Close()
+	}
+	fmt.Println("💾 Dry run: keeping local keys unchanged. The new key would be verified on every server before the old key is removed.")
+	return nil
+}
+
+// rolloutStep runs one step for a server and records the outcome. from is
+// the status saved while the step runs; an empty from keeps the current one.
+func (e *Executor) rolloutStep(state *keyRollout, paths keyPaths, name, from, to, action string, step func(srv *config.ServerConfig) error) {
+	s := state.Servers[name]
+	srv, ok := e.GlobalConfig.Servers[name]
+	if !ok {
+		fmt.Printf("⚠️  %s is no longer in the registry, skipping\n", name)
+		delete(state.Servers, name)
+		state.save(paths.state)
+		return
+	}
+
+	fmt.Printf("📡 %s (%s): %s... ", name, srv.Host, action)
+	if from != "" {
+		s.Status = from
+	}
+	s.Error = ""
+	if err := state.save(paths.state); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+
+	if err := step(&srv); err != nil {
+		s.Error = err.Error()
+		fmt.Printf("❌ %v\n", err)
+	} else {
+		s.Status = to
+		fmt.Println("✅")
+	}
+	if err := state.save(paths.state); err != nil {
+		fmt.Printf("⚠️  %v\n", err)
+	}
+}
+
+// keyClientFor connects to srv with keyPath alone, so the login proves that
+// exact key is accepted
+func (e *Executor) keyClientFor(srv *config.ServerConfig, keyPath string) (*ssh.Client, error) {
+	client, err := ssh.NewPinnedClient(srv.Host, srv.Port, srv.User, keyPath, srv.HostKey, ssh.AuthKey)
+	if err != nil {
+		return nil, err
+	}
+	client.SetContext(e.Ctx)
+	client.SetCommandTimeout(e.Timeout)
+	return client, nil
+}
+
+// graftKeyServers returns the registry names of servers that log in with
+// the Graft key, sorted
+func (e *Executor) graftKeyServers(pemPath string) []string {
+	ourPemAbs, _ := filepath.Abs(pemPath)
+	var names []string
+	for name, srv := range e.GlobalConfig.Servers {
+		srvKeyAbs, _ := filepath.Abs(srv.KeyPath)
+		if srvKeyAbs == ourPemAbs {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func printRolloutFailures(state *keyRollout) {
+	fmt.Println("\n⚠️  Key rollout incomplete:")
+	for _, name := range state.names() {
+		s := state.Servers[name]
+		line := fmt.Sprintf("   %-20s %-9s", name, s.Status)
+		if s.Error != "" {
+			line += " " + s.Error
+		}
+		fmt.Println(line)
+	}
+}
+
+// installStagedKey moves a staged key over the current one. A missing staged
+// file means it was installed by an earlier, interrupted run.
+func installStagedKey(staged, current string) error {
+	if err := os.Rename(staged, current); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// loadKeyRollout returns the rollout in progress, or nil if there is none
+func loadKeyRollout(path string) (*keyRollout, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", path, err)
+	}
+	var state keyRollout
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", path, err)
+	}
+	if state.Servers == nil {
+		state.Servers = map[string]*rolloutServer{}
+	}
+	return &state, nil
+}
+
+func (r *keyRollout) save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("could not save %s: %v", path, err)
+	}
+	return nil
+}
+
+// names returns the sorted names of servers in one of statuses, or of all
+// servers when none are given
+func (r *keyRollout) names(statuses ...string) []string {
+	var names []string
+	for name, s := range r.Servers {
+		match := len(statuses) == 0
+		for _, status := range statuses {
+			match = match || s.Status == status
+		}
+		if match {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *keyRollout) count(statuses ...string) int {
+	return len(r.names(statuses...))
+}
@@ -0,0 +1,79 @@
+package executors
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/skssmd/graft/internal/server/deploy"
+)
+
+// RunLock shows or removes the deploy lock of the current project and
+// environment: graft lock [status|break]
+func (e *Executor) RunLock(args []string) error {
+	action := "status"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	if action != "status" && action != "break" {
+		return ConfigError("unknown lock command '%s' (usage: graft lock [status|break])", action)
+	}
+
+	meta, err := e.getProjectMeta()
+	if err != nil {
+		return err
+	}
+	client, err := e.getClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	lock, err := deploy.ReadLock(client, meta.Name, e.Env)
+	if err != nil {
+		return remoteError("%v", err)
+	}
+
+	if action == "status" {
+		doc := LockOutput{Project: meta.Name, Env: e.Env, Server: e.Server.RegistryName, Locked: lock != nil}
+		if lock != nil {
+			doc.Lock = lock
+			doc.IdleSeconds = int(lock.Idle.Seconds())
+			doc.Stale = lock.Stale()
+		}
+		if e.machineOutput() {
+			return e.render(doc)
+		}
+
+		if lock == nil {
+			fmt.Printf("🔓 %s (%s) is not locked, no deploy is running.\n", meta.Name, e.Env)
+			return nil
+		}
+		fmt.Printf("🔒 %s (%s) is locked by a running deploy:\n", meta.Name, e.Env)
+		fmt.Printf("   Owner:   %s\n", lock.Owner)
+		fmt.Printf("   Host:    %s (pid %d)\n", lock.Host, lock.PID)
+		fmt.Printf("   Action:  %s\n", lock.Action)
+		fmt.Printf("   Started: %s\n", lock.Started)
+		fmt.Printf("   Renewed: %s ago\n", lock.Idle.Round(time.Second))
+		if lock.Stale() {
+			fmt.Println("⚠️  The lock looks stale: its deploy is no longer running. The next deploy takes it over.")
+		}
+		return nil
+	}
+
+	if lock == nil {
+		fmt.Printf("🔓 %s (%s) is not locked, nothing to break.\n", meta.Name, e.Env)
+		return nil
+	}
+	fmt.Printf("🔒 %s (%s) is locked by %s\n", meta.Name, e.Env, lock)
+	if !lock.Stale() {
+		fmt.Println("⚠️  That deploy still looks alive. Breaking its lock lets another deploy run at the same time.")
+	}
+	if !e.Answers.Confirm("lock.break", "❓ Break the lock? (y/n): ", false) {
+		return abortError("lock left in place")
+	}
+	if _, err := deploy.BreakLock(client, meta.Name, e.Env); err != nil {
+		return remoteError("%v", err)
+	}
+	fmt.Println("✅ Lock removed.")
+	return nil
+}
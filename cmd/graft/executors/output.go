@@ -0,0 +1,236 @@
+package executors
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/skssmd/graft/internal/server/deploy"
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats accepted by --output
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+)
+
+// ServerOutput describes a registered server in `graft registry ls`
+type ServerOutput struct {
+	Name         string `json:"name" yaml:"name"`
+	Host         string `json:"host" yaml:"host"`
+	Port         int    `json:"port" yaml:"port"`
+	User         string `json:"user" yaml:"user"`
+	KeyPath      string `json:"key_path" yaml:"key_path"`
+	GraftHookURL string `json:"graft_hook_url,omitempty" yaml:"graft_hook_url,omitempty"`
+	HostKey      string `json:"host_key,omitempty" yaml:"host_key,omitempty"`
+}
+
+// RegistryOutput is the document printed by `graft registry ls`
+type RegistryOutput struct {
+	Servers []ServerOutput `json:"servers" yaml:"servers"`
+}
+
+// ProjectOutput describes one environment of a project in `graft projects ls`
+type ProjectOutput struct {
+	Name            string `json:"name" yaml:"name"`
+	Env             string `json:"env,omitempty" yaml:"env,omitempty"`
+	Server          string `json:"server,omitempty" yaml:"server,omitempty"`
+	LocalPath       string `json:"local_path,omitempty" yaml:"local_path,omitempty"`
+	RemotePath      string `json:"remote_path,omitempty" yaml:"remote_path,omitempty"`
+	Domain          string `json:"domain,omitempty" yaml:"domain,omitempty"`
+	DeploymentMode  string `json:"deployment_mode,omitempty" yaml:"deployment_mode,omitempty"`
+	RollbackBackups int    `json:"rollback_backups" yaml:"rollback_backups"`
+}
+
+// ProjectsOutput is the document printed by `graft projects ls`.
+// Source is "local" for the global registry or "remote" for `graft -r <name> projects ls`.
+type ProjectsOutput struct {
+	Source   string          `json:"source" yaml:"source"`
+	Server   string          `json:"server,omitempty" yaml:"server,omitempty"`
+	Projects []ProjectOutput `json:"projects" yaml:"projects"`
+}
+
+// BackupOutput describes one rollback backup on the server
+type BackupOutput struct {
+	Index     int    `json:"index" yaml:"index"`
+	Timestamp string `json:"timestamp" yaml:"timestamp"`
+	Time      string `json:"time" yaml:"time"`
+	Path      string `json:"path" yaml:"path"`
+}
+
+// BackupsOutput is the document printed by `graft rollback`
+type BackupsOutput struct {
+	Project         string         `json:"project" yaml:"project"`
+	Env             string         `json:"env" yaml:"env"`
+	Server          string         `json:"server" yaml:"server"`
+	RemotePath      string         `json:"remote_path" yaml:"remote_path"`
+	RollbackBackups int            `json:"rollback_backups" yaml:"rollback_backups"`
+	Backups         []BackupOutput `json:"backups" yaml:"backups"`
+}
+
+// DNSRecordOutput describes the result for one domain in `graft map`.
+// Status is one of unchanged, updated, created or skipped.
+type DNSRecordOutput struct {
+	Service  string `json:"service" yaml:"service"`
+	Domain   string `json:"domain" yaml:"domain"`
+	Status   string `json:"status" yaml:"status"`
+	Previous string `json:"previous,omitempty" yaml:"previous,omitempty"`
+	Error    string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// DNSMapOutput is the summary printed by `graft map`
+type DNSMapOutput struct {
+	Project   string            `json:"project" yaml:"project"`
+	Env       string            `json:"env" yaml:"env"`
+	Server    string            `json:"server" yaml:"server"`
+	ServerIP  string            `json:"server_ip" yaml:"server_ip"`
+	Unchanged int               `json:"unchanged" yaml:"unchanged"`
+	Updated   int               `json:"updated" yaml:"updated"`
+	Created   int               `json:"created" yaml:"created"`
+	Skipped   int               `json:"skipped" yaml:"skipped"`
+	Records   []DNSRecordOutput `json:"records" yaml:"records"`
+}
+
+// SyncContextOutput lists the files uploaded from one build context
+type SyncContextOutput struct {
+	Service string   `json:"service" yaml:"service"`
+	Context string   `json:"context" yaml:"context"`
+	Files   []string `json:"files" yaml:"files"`
+}
+
+// SyncFilesOutput is the document printed by `graft sync --list-files`
+type SyncFilesOutput struct {
+	Project  string              `json:"project" yaml:"project"`
+	Services []SyncContextOutput `json:"services" yaml:"services"`
+}
+
+// AccessEntryOutput describes one granted key in `graft access ls`
+type AccessEntryOutput struct {
+	Name        string `json:"name" yaml:"name"`
+	Fingerprint string `json:"fingerprint" yaml:"fingerprint"`
+	PublicKey   string `json:"public_key" yaml:"public_key"`
+	From        string `json:"from,omitempty" yaml:"from,omitempty"`
+	Command     string `json:"command,omitempty" yaml:"command,omitempty"`
+	GrantedAt   string `json:"granted_at" yaml:"granted_at"`
+}
+
+// AccessServerOutput lists the keys granted on one server. Error is set
+// when the server could not be read.
+type AccessServerOutput struct {
+	Server  string              `json:"server" yaml:"server"`
+	Host    string              `json:"host" yaml:"host"`
+	Error   string              `json:"error,omitempty" yaml:"error,omitempty"`
+	Entries []AccessEntryOutput `json:"entries" yaml:"entries"`
+}
+
+// AccessOutput is the document printed by `graft access ls`
+type AccessOutput struct {
+	Servers []AccessServerOutput `json:"servers" yaml:"servers"`
+}
+
+// HistoryOutput is the document printed by `graft history`, newest deploy
+// first
+type HistoryOutput struct {
+	Project string                `json:"project" yaml:"project"`
+	Env     string                `json:"env" yaml:"env"`
+	Server  string                `json:"server" yaml:"server"`
+	Service string                `json:"service,omitempty" yaml:"service,omitempty"`
+	Entries []deploy.HistoryEntry `json:"entries" yaml:"entries"`
+}
+
+// LockOutput is the document printed by `graft lock status`. IdleSeconds
+// is how long ago the running deploy last renewed the lock.
+type LockOutput struct {
+	Project     string             `json:"project" yaml:"project"`
+	Env         string             `json:"env" yaml:"env"`
+	Server      string             `json:"server" yaml:"server"`
+	Locked      bool               `json:"locked" yaml:"locked"`
+	Lock        *deploy.DeployLock `json:"lock,omitempty" yaml:"lock,omitempty"`
+	IdleSeconds int                `json:"idle_seconds,omitempty" yaml:"idle_seconds,omitempty"`
+	Stale       bool               `json:"stale,omitempty" yaml:"stale,omitempty"`
+}
+
+// CanaryOutput is the document printed by `graft canary status`
+type CanaryOutput struct {
+	Project  string               `json:"project" yaml:"project"`
+	Env      string               `json:"env" yaml:"env"`
+	Server   string               `json:"server" yaml:"server"`
+	Canaries []deploy.CanaryState `json:"canaries" yaml:"canaries"`
+}
+
+// PlanOutput is the document printed by `graft plan`. Mode is "sync" or
+// "compose", the kind of sync that was planned.
+type PlanOutput struct {
+	Project     string               `json:"project" yaml:"project"`
+	Env         string               `json:"env" yaml:"env"`
+	Server      string               `json:"server" yaml:"server"`
+	Mode        string               `json:"mode" yaml:"mode"`
+	RemotePath  string               `json:"remote_path" yaml:"remote_path"`
+	ComposeFile string               `json:"compose_file" yaml:"compose_file"`
+	Deployed    bool                 `json:"deployed" yaml:"deployed"`
+	Services    []deploy.ServicePlan `json:"services" yaml:"services"`
+}
+
+// SetOutput selects the output format for listing and status commands.
+// In json/yaml mode progress messages are moved to stderr until the command
+// has rendered its document or RestoreOutput is called, so stdout only
+// carries the machine-readable document.
+func (e *Executor) SetOutput(format string) error {
+	switch format {
+	case "", OutputTable:
+		e.Output = OutputTable
+	case OutputJSON, OutputYAML:
+		if !e.machineOutput() {
+			e.Stdout = os.Stdout
+			e.stdout = os.Stdout
+			os.Stdout = os.Stderr
+		}
+		e.Output = format
+	default:
+		return ConfigError("unknown output format '%s' (use table, json or yaml)", format)
+	}
+	return nil
+}
+
+// machineOutput reports whether a json or yaml document was requested
+func (e *Executor) machineOutput() bool {
+	return e.Output == OutputJSON || e.Output == OutputYAML
+}
+
+// RestoreOutput puts back the stdout that SetOutput moved to stderr
+func (e *Executor) RestoreOutput() {
+	if e.stdout != nil {
+		os.Stdout = e.stdout
+		e.stdout = nil
+	}
+}
+
+// render writes v to the real stdout in the selected format, then restores
+// os.Stdout
+func (e *Executor) render(v interface{}) error {
+	defer e.RestoreOutput()
+	var out io.Writer = e.Stdout
+	if out == nil {
+		out = os.Stdout
+	}
+
+	switch e.Output {
+	case OutputYAML:
+		enc := yaml.NewEncoder(out)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return fmt.Errorf("could not encode yaml: %v", err)
+		}
+		return enc.Close()
+	default:
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			return fmt.Errorf("could not encode json: %v", err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,165 @@
+package executors
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/skssmd/graft/internal/server/deploy"
+)
+
+// RunPlan shows what the next sync would change on the server without
+// changing anything there: graft plan [compose] [--json]
+func (e *Executor) RunPlan(args []string) error {
+	var compose bool
+	for _, arg := range args {
+		switch arg {
+		case "compose":
+			compose = true
+		case "--json":
+			if err := e.SetOutput(OutputJSON); err != nil {
+				return err
+			}
+		default:
+			return ConfigError("unknown argument '%s' (usage: graft plan [compose] [--json])", arg)
+		}
+	}
+
+	localFile := "graft-compose.yml"
+	if _, err := os.Stat(localFile); err != nil {
+		return ConfigError("graft-compose.yml not found. Run 'graft init' first")
+	}
+	p, err := deploy.LoadProject(e.Env, localFile)
+	if err != nil {
+		return ConfigError("could not load project: %v", err)
+	}
+	meta, err := e.getProjectMeta()
+	if err != nil {
+		return err
+	}
+
+	// Git-based projects only ever sync their compose and env files
+	if strings.HasPrefix(meta.DeploymentMode, "git") {
+		compose = true
+	}
+	mode, command := "sync", "graft sync"
+	if compose {
+		mode, command = "compose", "graft sync compose"
+	}
+
+	client, err := e.getClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	plan, err := deploy.PlanSync(e.Env, client, p, compose)
+	if err != nil {
+		return remoteError("could not plan sync: %v", err)
+	}
+
+	doc := PlanOutput{
+		Project:     p.Name,
+		Env:         e.Env,
+		Server:      e.Server.RegistryName,
+		Mode:        mode,
+		RemotePath:  plan.RemoteDir,
+		ComposeFile: plan.ComposeFile,
+		Deployed:    plan.Deployed,
+		Services:    plan.Services,
+	}
+	if e.machineOutput() {
+		return e.render(doc)
+	}
+
+	fmt.Printf("\n📋 What '%s' would change for %s (%s) on %s:\n", command, p.Name, e.Env, e.Server.RegistryName)
+	fmt.Printf("   Generated %s and the merged env files, nothing was uploaded.\n", plan.ComposeFile)
+	if !plan.Deployed {
+		fmt.Printf("   %s has no docker-compose.yml yet: every service is new.\n", plan.RemoteDir)
+	}
+
+	byAction := make(map[string][]string)
+	for _, s := range plan.Services {
+		byAction[s.Action] = append(byAction[s.Action], s.Service)
+		if !s.Changed() && s.Action != deploy.PlanCreate && s.Action != deploy.PlanRemove {
+			continue
+		}
+		printServicePlan(s)
+	}
+
+	fmt.Println("\n🔄 Containers:")
+	printPlanGroup("Recreated", byAction[deploy.PlanRecreate])
+	printPlanGroup("Created", byAction[deploy.PlanCreate])
+	printPlanGroup("Removed", byAction[deploy.PlanRemove])
+	printPlanGroup("Rebuilt, recreated if the image changes", byAction[deploy.PlanRebuild])
+	printPlanGroup("Pulled, recreated if the image changes", byAction[deploy.PlanPull])
+	printPlanGroup("Deployed to the idle blue-green color", byAction[deploy.PlanRedeploy])
+	printPlanGroup("Unchanged", byAction[deploy.PlanKeep])
+	fmt.Println()
+	return nil
+}
+
+// printServicePlan prints the differences of one service
+func printServicePlan(s deploy.ServicePlan) {
+	switch s.Action {
+	case deploy.PlanCreate:
+		fmt.Printf("\n  + %s (new)\n", s.Service)
+	case deploy.PlanRemove:
+		fmt.Printf("\n  - %s (removed)\n", s.Service)
+		return
+	default:
+		fmt.Printf("\n  ~ %s\n", s.Service)
+	}
+
+	if s.Image != nil {
+		fmt.Printf("      image: %s → %s\n", planValue(s.Image.Old), planValue(s.Image.New))
+	}
+	if s.Build != nil {
+		fmt.Printf("      build: %s → %s\n", planValue(s.Build.Old), planValue(s.Build.New))
+	}
+	if s.Labels != nil {
+		for _, l := range s.Labels.Added {
+			fmt.Printf("      + label %s\n", l)
+		}
+		for _, l := range s.Labels.Removed {
+			fmt.Printf("      - label %s\n", l)
+		}
+	}
+	for _, env := range s.Environment {
+		switch env.Change {
+		case deploy.EnvAdded:
+			fmt.Printf("      + env %s=%s\n", env.Key, env.New)
+		case deploy.EnvRemoved:
+			fmt.Printf("      - env %s\n", env.Key)
+		default:
+			fmt.Printf("      ~ env %s: %s → %s\n", env.Key, env.Old, env.New)
+		}
+	}
+	if s.Volumes != nil {
+		for _, v := range s.Volumes.Added {
+			fmt.Printf("      + volume %s\n", v)
+		}
+		for _, v := range s.Volumes.Removed {
+			fmt.Printf("      - volume %s\n", v)
+		}
+	}
+	if len(s.Other) > 0 {
+		fmt.Printf("      ~ also changed: %s\n", strings.Join(s.Other, ", "))
+	}
+}
+
+// printPlanGroup prints the services a sync treats the same way
+func printPlanGroup(title string, services []string) {
+	if len(services) == 0 {
+		return
+	}
+	fmt.Printf("   %s: %s\n", title, strings.Join(services, ", "))
+}
+
+// planValue shows an empty setting as (none)
+func planValue(v string) string {
+	if v == "" {
+		return "(none)"
+	}
+	return v
+}
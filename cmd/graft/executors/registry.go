@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/skssmd/graft/internal/config"
@@ -13,18 +14,24 @@ import (
 	"github.com/skssmd/graft/internal/server/ssh"
 )
 
-func (e *Executor) RunRegistryAdd() {
-	reader := bufio.NewReader(os.Stdin)
+func (e *Executor) RunRegistryAdd(args []string) error {
+	if _, err := e.Answers.ParseFlags(args); err != nil {
+		return ConfigError("%w", err)
+	}
+
 	fmt.Println("\n➕ Add New Server to Global Registry")
-	host, port, user, keyPath := prompt.PromptNewServer(reader)
+	host, port, user, keyPath, err := prompt.PromptNewServer(e.Answers)
+	if err != nil {
+		return ConfigError("%w", err)
+	}
 
-	fmt.Print("Registry Name (e.g. prod-us): ")
-	registryName, _ := reader.ReadString('\n')
-	registryName = strings.TrimSpace(registryName)
+	registryName, err := e.Answers.Ask("server.name", "Registry Name (e.g. prod-us): ")
+	if err != nil {
+		return ConfigError("%w", err)
+	}
 
 	if registryName == "" {
-		fmt.Println("Error: Registry name cannot be empty.")
-		return
+		return ConfigError("registry name cannot be empty")
 	}
 
 	gCfg := e.GlobalConfig
@@ -48,23 +55,21 @@ func (e *Executor) RunRegistryAdd() {
 	}
 
 	if err := config.SaveGlobalConfig(gCfg); err != nil {
-		fmt.Printf("Error saving registry: %v\n", err)
-		return
+		return ConfigError("error saving registry: %v", err)
 	}
 
 	fmt.Printf("✅ Server '%s' added to registry.\n", registryName)
+	return nil
 }
 
-func (e *Executor) RunRegistryDel(name string) {
+func (e *Executor) RunRegistryDel(name string) error {
 	gCfg := e.GlobalConfig
 	if gCfg == nil {
-		fmt.Println("Error: Could not load global registry.")
-		return
+		return ConfigError("could not load global registry")
 	}
 
 	if _, exists := gCfg.Servers[name]; !exists {
-		fmt.Printf("Error: Registry '%s' not found.\n", name)
-		return
+		return ConfigError("registry '%s' not found", name)
 	}
 
 	fmt.Printf("Are you sure you want to delete registry '%s'? (y/n): ", name)
@@ -73,35 +78,82 @@ func (e *Executor) RunRegistryDel(name string) {
 	confirm = strings.ToLower(strings.TrimSpace(confirm))
 
 	if confirm != "y" && confirm != "yes" {
-		fmt.Println("Delete aborted.")
-		return
+		return abortError("delete aborted")
 	}
 
 	delete(gCfg.Servers, name)
 	if err := config.SaveGlobalConfig(gCfg); err != nil {
-		fmt.Printf("Error saving registry: %v\n", err)
-		return
+		return ConfigError("error saving registry: %v", err)
 	}
 
 	fmt.Printf("✅ Registry '%s' deleted.\n", name)
+	return nil
+}
+
+// RunRegistryRekey replaces the pinned host key of a server after a
+// legitimate change, such as a rebuilt machine or rotated host keys
+func (e *Executor) RunRegistryRekey(name string) error {
+	gCfg := e.GlobalConfig
+	if gCfg == nil {
+		return ConfigError("could not load global registry")
+	}
+	srv, exists := gCfg.Servers[name]
+	if !exists {
+		return ConfigError("registry '%s' not found", name)
+	}
+
+	fmt.Printf("🔍 Fetching host key from '%s' (%s)...\n", name, srv.Host)
+	hostKey, err := ssh.ScanHostKey(srv.Host, srv.Port, srv.User, srv.KeyPath, srv.Auth...)
+	if err != nil {
+		return connectionError(err)
+	}
+
+	if hostKey == srv.HostKey {
+		fmt.Printf("✅ Host key for '%s' is unchanged: %s\n", name, ssh.Fingerprint(hostKey))
+		return nil
+	}
+	if srv.HostKey != "" {
+		fmt.Printf("   Pinned:   %s\n", ssh.Fingerprint(srv.HostKey))
+	} else {
+		fmt.Println("   Pinned:   (none)")
+	}
+	fmt.Printf("   Received: %s\n", ssh.Fingerprint(hostKey))
+	fmt.Println("⚠️  Only accept if you can confirm this fingerprint on the server (ssh-keygen -lf /etc/ssh/ssh_host_*_key.pub).")
+
+	confirm, err := e.Answers.ConfirmRequired("registry.rekey", "Pin the new host key? (y/n): ")
+	if err != nil {
+		return ConfigError("%w", err)
+	}
+	if !confirm {
+		return abortError("rekey aborted")
+	}
+	if e.Recorder != nil {
+		fmt.Println("📝 Dry run: the registry was not changed")
+		return nil
+	}
+
+	srv.HostKey = hostKey
+	gCfg.Servers[name] = srv
+	if err := config.SaveGlobalConfig(gCfg); err != nil {
+		return ConfigError("error saving registry: %v", err)
+	}
+	fmt.Printf("✅ Pinned new host key for '%s'.\n", name)
+	return nil
 }
 
-func (e *Executor) RunRegistryShell(registryName string, commandArgs []string) {
+func (e *Executor) RunRegistryShell(registryName string, commandArgs []string) error {
 	gCfg := e.GlobalConfig
 	if gCfg == nil {
-		fmt.Println("Error: Could not load global registry.")
-		return
+		return ConfigError("could not load global registry")
 	}
 	srv, exists := gCfg.Servers[registryName]
 	if !exists {
-		fmt.Printf("Error: Registry '%s' not found.\n", registryName)
-		return
+		return ConfigError("registry '%s' not found", registryName)
 	}
 
-	client, err := ssh.NewClient(srv.Host, srv.Port, srv.User, srv.KeyPath)
+	client, err := e.clientFor(&srv)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
@@ -109,123 +161,191 @@ func (e *Executor) RunRegistryShell(registryName string, commandArgs []string) {
 		// Interactive SSH
 		fmt.Printf("💻 Starting interactive SSH session on '%s' (%s)...\n", registryName, srv.Host)
 		if err := client.InteractiveSession(); err != nil {
-			fmt.Printf("SSH session error: %v\n", err)
+			return passthroughError(err)
 		}
 	} else {
 		// Non-interactive command
 		cmdStr := strings.Join(commandArgs, " ")
 		fmt.Printf("🚀 Executing on '%s': %s\n", registryName, cmdStr)
 		if err := client.RunCommand(cmdStr, os.Stdout, os.Stderr); err != nil {
-			fmt.Printf("Error: %v\n", err)
+			return passthroughError(err)
 		}
 	}
+	return nil
 }
 
-func (e *Executor) RunRegistryDocker(registry string, args []string){
+func (e *Executor) RunRegistryDocker(registry string, args []string) error {
 	gCfg := e.GlobalConfig
 	if gCfg == nil {
-		fmt.Println("Error: Could not load global registry.")
-		return
+		return ConfigError("could not load global registry")
 	}
 	srv, exists := gCfg.Servers[registry]
 	if !exists {
-		fmt.Printf("Error: Registry '%s' not found.\n", registry)
-		return
+		return ConfigError("registry '%s' not found", registry)
 	}
 
-	client, err := ssh.NewClient(srv.Host, srv.Port, srv.User, srv.KeyPath)
+	client, err := e.clientFor(&srv)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
 	if len(args) == 0 {
 		fmt.Printf("Usage: graft -r <registry name> <any docker command>\n")
 		fmt.Printf("Example: graft -r prod-us ps\n")
-		return
+		return nil
 	} else {
 		cmdStr := "sudo docker " + strings.Join(args, " ")
 		fmt.Printf("🚀 Executing on '%s': %s\n", registry, cmdStr)
-		if err := client.RunCommand(cmdStr, os.Stdout, os.Stderr); err != nil {
-			fmt.Printf("Error: %v\n", err)
+		if err := e.runPassthrough(client, cmdStr, e.needsTTY(args, false)); err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
-func (e *Executor) RunRegistryLs() {
+func (e *Executor) RunRegistryLs() error {
 	gCfg := e.GlobalConfig
-	if gCfg == nil || len(gCfg.Servers) == 0 {
+
+	var names []string
+	if gCfg != nil {
+		for name := range gCfg.Servers {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	if e.machineOutput() {
+		doc := RegistryOutput{Servers: []ServerOutput{}}
+		for _, name := range names {
+			srv := gCfg.Servers[name]
+			doc.Servers = append(doc.Servers, ServerOutput{
+				Name:         name,
+				Host:         srv.Host,
+				Port:         srv.Port,
+				User:         srv.User,
+				KeyPath:      srv.KeyPath,
+				GraftHookURL: srv.GraftHookURL,
+				HostKey:      srv.HostKey,
+			})
+		}
+		return e.render(doc)
+	}
+
+	if len(names) == 0 {
 		fmt.Println("No servers found in global registry.")
-		return
+		return nil
 	}
 
 	fmt.Println("\n📋 Registered Servers:")
 	fmt.Printf("%-15s %-20s %-10s %-10s\n", "Name", "Host", "User", "Port")
 	fmt.Println(strings.Repeat("-", 60))
-	for name, srv := range gCfg.Servers {
+	for _, name := range names {
+		srv := gCfg.Servers[name]
 		fmt.Printf("%-15s %-20s %-10s %-10d\n", name, srv.Host, srv.User, srv.Port)
 	}
 	fmt.Println()
+	return nil
 }
 
-func (e *Executor) RunProjectsLs(registryName string) {
+func (e *Executor) RunProjectsLs(registryName string) error {
 	gCfg := e.GlobalConfig
 	if gCfg == nil {
-		fmt.Println("Error loading global registry.")
-		return
+		return ConfigError("could not load global registry")
 	}
 
 	if registryName != "" {
 		// Remote listing
 		srv, exists := gCfg.Servers[registryName]
 		if !exists {
-			fmt.Printf("Error: Registry '%s' not found.\n", registryName)
-			return
+			return ConfigError("registry '%s' not found", registryName)
 		}
 
 		fmt.Printf("\n🔍 Fetching projects from remote server '%s' (%s)...\n", registryName, srv.Host)
-		client, err := ssh.NewClient(srv.Host, srv.Port, srv.User, srv.KeyPath)
+		client, err := e.clientFor(&srv)
 		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return
+			return err
 		}
 		defer client.Close()
 
+		doc := ProjectsOutput{Source: "remote", Server: registryName, Projects: []ProjectOutput{}}
+
 		tmpFile := filepath.Join(os.TempDir(), "remote_projects_ls.json")
 		if err := client.DownloadFile(config.RemoteProjectsPath, tmpFile); err != nil {
+			if e.machineOutput() {
+				return e.render(doc)
+			}
 			fmt.Println("No projects found on remote server or registry file missing.")
-			return
+			return nil
 		}
 		defer os.Remove(tmpFile)
 
 		data, _ := os.ReadFile(tmpFile)
-		var remoteProjects map[string]string // Name -> Path
+		var remoteProjects map[string]interface{} // Name -> Path or {path, rollback_backups}
 		json.Unmarshal(data, &remoteProjects)
 
-		if len(remoteProjects) == 0 {
+		var names []string
+		for name := range remoteProjects {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			entry := ProjectOutput{Name: name, Server: registryName}
+			switch v := remoteProjects[name].(type) {
+			case string:
+				entry.RemotePath = v
+			case map[string]interface{}:
+				entry.RemotePath, _ = v["path"].(string)
+				if n, ok := v["rollback_backups"].(float64); ok {
+					entry.RollbackBackups = int(n)
+				}
+			}
+			doc.Projects = append(doc.Projects, entry)
+		}
+
+		if e.machineOutput() {
+			return e.render(doc)
+		}
+
+		if len(doc.Projects) == 0 {
 			fmt.Println("No projects registered on this server.")
-			return
+			return nil
 		}
 
 		fmt.Printf("\n📂 Remote Projects on '%s':\n", registryName)
 		fmt.Printf("%-20s %-40s\n", "Name", "Remote Path")
 		fmt.Println(strings.Repeat("-", 65))
-		for name, path := range remoteProjects {
-			fmt.Printf("%-20s %-40s\n", name, path)
+		for _, p := range doc.Projects {
+			fmt.Printf("%-20s %-40s\n", p.Name, p.RemotePath)
 		}
 		fmt.Println()
 	} else {
 		// Local listing
-		if len(gCfg.Projects) == 0 {
+		var names []string
+		for name := range gCfg.Projects {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		if e.machineOutput() {
+			doc := ProjectsOutput{Source: "local", Projects: []ProjectOutput{}}
+			for _, name := range names {
+				doc.Projects = append(doc.Projects, localProjectEntries(name, gCfg.Projects[name])...)
+			}
+			return e.render(doc)
+		}
+
+		if len(names) == 0 {
 			fmt.Println("No local projects found in registry.")
-			return
+			return nil
 		}
 
 		fmt.Println("\n📂 Local Projects:")
 		fmt.Printf("%-20s %-15s %-40s\n", "Name", "Server", "Local Path")
 		fmt.Println(strings.Repeat("-", 80))
-		for name, path := range gCfg.Projects {
+		for _, name := range names {
+			path := gCfg.Projects[name]
 			serverName := "unknown"
 			localMetaPath := filepath.Join(path, ".graft", "project.json")
 			if data, err := os.ReadFile(localMetaPath); err == nil {
@@ -240,4 +360,40 @@ func (e *Executor) RunProjectsLs(registryName string) {
 		}
 		fmt.Println()
 	}
+	return nil
+}
+
+// localProjectEntries lists every environment of a locally registered project.
+// A project whose metadata can not be read is reported with its path only.
+func localProjectEntries(name, path string) []ProjectOutput {
+	var projectEnv config.ProjectEnv
+	data, err := os.ReadFile(filepath.Join(path, ".graft", "project.json"))
+	if err != nil || json.Unmarshal(data, &projectEnv) != nil || len(projectEnv.Env) == 0 {
+		return []ProjectOutput{{Name: name, LocalPath: path}}
+	}
+
+	var envs []string
+	for env := range projectEnv.Env {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+
+	var entries []ProjectOutput
+	for _, env := range envs {
+		meta := projectEnv.Env[env]
+		if meta == nil {
+			continue
+		}
+		entries = append(entries, ProjectOutput{
+			Name:            name,
+			Env:             env,
+			Server:          meta.Registry,
+			LocalPath:       path,
+			RemotePath:      meta.RemotePath,
+			Domain:          meta.Domain,
+			DeploymentMode:  meta.DeploymentMode,
+			RollbackBackups: meta.RollbackBackups,
+		})
+	}
+	return entries
 }
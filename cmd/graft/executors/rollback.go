@@ -14,32 +14,53 @@ import (
 	"github.com/skssmd/graft/internal/utils"
 )
 
-func (e *Executor) RunRollback() {
+func (e *Executor) RunRollback() error {
 	meta, err := config.LoadProjectMetadata(e.Env)
 	if err != nil {
-		fmt.Println("Error: Could not load project metadata. Run 'graft init' first.")
-		return
+		return ConfigError("could not load project metadata. Run 'graft init' first: %v", err)
 	}
 
 	if meta.RollbackBackups <= 0 {
-		fmt.Println("❌ Rollback is not configured for this project. Setup rollbacks during 'graft init' or update your project configuration with 'graft rollback config'.")
-		return
+		return ConfigError("rollback is not configured for this project. Setup rollbacks during 'graft init' or update your project configuration with 'graft rollback config'")
 	}
 
 	fmt.Printf("🔍 Connecting to %s (%s)...\n", e.Server.RegistryName, e.Server.Host)
 	client, err := e.getClient()
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
-	backupBase := fmt.Sprintf("/opt/graft/backup/%s", meta.Name)
+	backupBase := deploy.BackupBase(meta.Name, e.Env)
 	// List directories in backup path, newest first
 	out, err := client.GetCommandOutput(fmt.Sprintf("sudo ls -1dt %s/* 2>/dev/null", backupBase))
+
+	// Machine-readable output only lists the backups, it never prompts
+	if e.machineOutput() {
+		doc := BackupsOutput{
+			Project:         meta.Name,
+			Env:             e.Env,
+			Server:          e.Server.RegistryName,
+			RemotePath:      meta.RemotePath,
+			RollbackBackups: meta.RollbackBackups,
+			Backups:         []BackupOutput{},
+		}
+		if err == nil && strings.TrimSpace(out) != "" {
+			for i, p := range strings.Split(strings.TrimSpace(out), "\n") {
+				timestamp := filepath.Base(p)
+				doc.Backups = append(doc.Backups, BackupOutput{
+					Index:     i + 1,
+					Timestamp: timestamp,
+					Time:      utils.FormatTimestamp(timestamp),
+					Path:      p,
+				})
+			}
+		}
+		return e.render(doc)
+	}
+
 	if err != nil || strings.TrimSpace(out) == "" {
-		fmt.Println("❌ No backups found on server.")
-		return
+		return remoteError("no backups found on server")
 	}
 
 	backups := strings.Split(strings.TrimSpace(out), "\n")
@@ -57,14 +78,12 @@ func (e *Executor) RunRollback() {
 	input, _ := reader.ReadString('\n')
 	input = strings.TrimSpace(input)
 	if input == "" {
-		fmt.Println("❌ Rollback cancelled.")
-		return
+		return abortError("rollback cancelled")
 	}
 
 	choice, err := strconv.Atoi(input)
 	if err != nil || choice < 1 || choice > len(choices) {
-		fmt.Println("❌ Invalid selection.")
-		return
+		return ConfigError("invalid selection")
 	}
 
 	selected := choices[choice-1]
@@ -72,20 +91,20 @@ func (e *Executor) RunRollback() {
 	p := &deploy.Project{
 		Name:            meta.Name,
 		RollbackBackups: meta.RollbackBackups,
+		Env:             e.Env,
 	}
 
 	if err := deploy.RestoreRollback(client, p, selected, os.Stdout, os.Stderr); err != nil {
-		fmt.Printf("❌ Rollback failed: %v\n", err)
-	} else {
-		fmt.Println("\n✅ Rollback successful!")
+		return remoteError("rollback failed: %v", err)
 	}
+	fmt.Println("\n✅ Rollback successful!")
+	return nil
 }
 
-func (e *Executor) RunRollbackConfig() {
+func (e *Executor) RunRollbackConfig() error {
 	meta, err := config.LoadProjectMetadata(e.Env)
 	if err != nil {
-		fmt.Println("Error: Could not load project metadata. Run 'graft init' first.")
-		return
+		return ConfigError("could not load project metadata. Run 'graft init' first: %v", err)
 	}
 
 	fmt.Printf("🔄 Rollback Configuration for project: %s\n", meta.Name)
@@ -104,8 +123,7 @@ func (e *Executor) RunRollbackConfig() {
 		rollInput, _ := reader.ReadString('\n')
 		newVersionToKeep, err = strconv.Atoi(strings.TrimSpace(rollInput))
 		if err != nil || newVersionToKeep < 0 {
-			fmt.Println("❌ Invalid input. Number must be 0 or greater.")
-			return
+			return ConfigError("invalid input. Number must be 0 or greater")
 		}
 		action = "updated"
 	} else if input == "n" || input == "no" {
@@ -113,14 +131,13 @@ func (e *Executor) RunRollbackConfig() {
 		action = "removed"
 	} else {
 		fmt.Println("⏭️  Skipping configuration.")
-		return
+		return nil
 	}
 
 	fmt.Printf("🔍 Connecting to %s (%s) to update remote configuration...\n", e.Server.RegistryName, e.Server.Host)
 	client, err := e.getClient()
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
@@ -153,8 +170,7 @@ func (e *Executor) RunRollbackConfig() {
 	data, _ := json.MarshalIndent(remoteProjects, "", "  ")
 	os.WriteFile(tmpFile, data, 0644)
 	if err := client.UploadFile(tmpFile, config.RemoteProjectsPath); err != nil {
-		fmt.Printf("❌ Failed to update remote registry: %v\n", err)
-		return
+		return remoteError("failed to update remote registry: %v", err)
 	}
 	os.Remove(tmpFile)
 
@@ -173,34 +189,31 @@ func (e *Executor) RunRollbackConfig() {
 	} else {
 		fmt.Println("✅ graft-hook restarted successfully.")
 	}
+	return nil
 }
 
-func (e *Executor) RunServiceRollback(serviceName string) {
+func (e *Executor) RunServiceRollback(serviceName string) error {
 	meta, err := config.LoadProjectMetadata(e.Env)
 	if err != nil {
-		fmt.Println("Error: Could not load project metadata. Run 'graft init' first.")
-		return
+		return ConfigError("could not load project metadata. Run 'graft init' first: %v", err)
 	}
 
 	if meta.RollbackBackups <= 0 {
-		fmt.Println("❌ Rollback is not configured for this project. Setup rollbacks during 'graft init' or update your project configuration with 'graft rollback config'.")
-		return
+		return ConfigError("rollback is not configured for this project. Setup rollbacks during 'graft init' or update your project configuration with 'graft rollback config'")
 	}
 
 	fmt.Printf("🔍 Connecting to %s (%s)...\n", e.Server.RegistryName, e.Server.Host)
 	client, err := e.getClient()
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		return
+		return err
 	}
 	defer client.Close()
 
-	backupBase := fmt.Sprintf("/opt/graft/backup/%s", meta.Name)
+	backupBase := deploy.BackupBase(meta.Name, e.Env)
 	// List directories in backup path, newest first
 	out, err := client.GetCommandOutput(fmt.Sprintf("sudo ls -1dt %s/* 2>/dev/null", backupBase))
 	if err != nil || strings.TrimSpace(out) == "" {
-		fmt.Println("❌ No backups found on server.")
-		return
+		return remoteError("no backups found on server")
 	}
 
 	backups := strings.Split(strings.TrimSpace(out), "\n")
@@ -218,14 +231,12 @@ func (e *Executor) RunServiceRollback(serviceName string) {
 	input, _ := reader.ReadString('\n')
 	input = strings.TrimSpace(input)
 	if input == "" {
-		fmt.Println("❌ Rollback cancelled.")
-		return
+		return abortError("rollback cancelled")
 	}
 
 	choice, err := strconv.Atoi(input)
 	if err != nil || choice < 1 || choice > len(choices) {
-		fmt.Println("❌ Invalid selection.")
-		return
+		return ConfigError("invalid selection")
 	}
 
 	selected := choices[choice-1]
@@ -233,11 +244,12 @@ func (e *Executor) RunServiceRollback(serviceName string) {
 	p := &deploy.Project{
 		Name:            meta.Name,
 		RollbackBackups: meta.RollbackBackups,
+		Env:             e.Env,
 	}
 
 	if err := deploy.RestoreServiceRollback(client, p, selected, serviceName, os.Stdout, os.Stderr); err != nil {
-		fmt.Printf("❌ Rollback failed: %v\n", err)
-	} else {
-		fmt.Printf("\n✅ Service '%s' rollback successful!\n", serviceName)
+		return remoteError("rollback failed: %v", err)
 	}
+	fmt.Printf("\n✅ Service '%s' rollback successful!\n", serviceName)
+	return nil
 }
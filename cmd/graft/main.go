@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/skssmd/graft/cmd/graft/executors"
 	"github.com/skssmd/graft/internal/config"
@@ -15,26 +19,64 @@ func main() {
 		return
 	}
 	e := executors.GetExecutor()
-	args := os.Args[1:]
+
+	// The first Ctrl+C interrupts the running remote command, a second one
+	// quits immediately
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		fmt.Fprintln(os.Stderr, "\n⏹️  Interrupting... press Ctrl+C again to quit immediately")
+	}()
+	e.Ctx = ctx
+
+	err := run(e, os.Args[1:])
+	e.RestoreOutput()
+	if e.Recorder != nil {
+		// With -o json/yaml the dry run summary must not mix with the document
+		if e.Output == executors.OutputJSON || e.Output == executors.OutputYAML {
+			e.Recorder.Print(os.Stderr)
+		} else {
+			e.Recorder.Print(os.Stdout)
+		}
+	}
+	if err != nil && ctx.Err() != nil && !executors.IsAborted(err) {
+		err = executors.InterruptError(err)
+	}
+	if err != nil {
+		if executors.IsAborted(err) {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "❌ Error: %v\n", err)
+		}
+		os.Exit(executors.ExitCode(err))
+	}
+}
+
+// run dispatches the command line and returns the command's error
+func run(e *executors.Executor, args []string) error {
+	args, err := parseGlobalFlags(e, args)
+	if err != nil || args == nil {
+		return err
+	}
 
 	// Handle version and help flags
 	if len(args) > 0 {
 		arg := args[0]
 		if arg == "-v" || arg == "--version" {
 			fmt.Println("v2.4.9")
-			return
+			return nil
 		}
 		if arg == "--help" {
 			printUsage()
-			return
+			return nil
 		}
 		if arg == "pub" {
 			if len(args) > 1 && args[1] == "rollout" {
-				e.RolloutSSHKeys()
+				return e.RolloutSSHKeys(args[2:])
 			} else {
-				e.GetSSHPub()
+				return e.GetSSHPub()
 			}
-			return
 		}
 	}
 
@@ -43,21 +85,21 @@ func main() {
 	if args[0] == "-r" || args[0] == "--registry" {
 		if len(args) < 2 {
 			fmt.Println("Usage: graft -r <registryname> <command>")
-			return
+			return nil
 		}
 		registryContext = args[1]
-		args = args[2:]
+		if args, err = parseGlobalFlags(e, args[2:]); err != nil || args == nil {
+			return err
+		}
 
 		// Handle shell directly after -r: graft -r name -sh ...
 		if len(args) > 0 && (args[0] == "-sh" || args[0] == "--sh") {
-			e.RunRegistryShell(registryContext, args[1:])
-			return
+			return e.RunRegistryShell(registryContext, args[1:])
 		}
 		// Handle shell directly after -r: graft -r name -sh ...
 		if len(args) > 0 {
 
-			e.RunRegistryDocker(registryContext, args)
-			return
+			return e.RunRegistryDocker(registryContext, args)
 		}
 	}
 
@@ -65,22 +107,22 @@ func main() {
 	if args[0] == "-p" || args[0] == "--project" {
 		if len(args) < 3 {
 			fmt.Println("Usage: graft -p <projectname> <command>")
-			return
+			return nil
 		}
 		projectName := args[1]
-		args = args[2:]
+		if args, err = parseGlobalFlags(e, args[2:]); err != nil || args == nil {
+			return err
+		}
 
 		// Lookup project path
 		gCfg, _ := config.LoadGlobalConfig()
 		if gCfg == nil || gCfg.Projects == nil || gCfg.Projects[projectName] == "" {
-			fmt.Printf("Error: Project '%s' not found in global registry\n", projectName)
-			return
+			return executors.ConfigError("project '%s' not found in global registry", projectName)
 		}
 
 		projectPath := gCfg.Projects[projectName]
 		if err := os.Chdir(projectPath); err != nil {
-			fmt.Printf("Error: Could not enter project directory: %v\n", err)
-			return
+			return executors.ConfigError("could not enter project directory: %v", err)
 		}
 		fmt.Printf("📂 Context: %s (%s)\n", projectName, projectPath)
 	}
@@ -104,26 +146,28 @@ func main() {
 			fmt.Println("")
 			fmt.Println("Usage: graft env --new <envname>")
 			fmt.Println("Usage: graft -p <projectname> env --new <envname>")
-			return
+			return nil
 		}
 		//handle new env
 		if args[1] == "--new" {
+			if len(args) < 3 {
+				fmt.Println("Usage: graft env --new <envname>")
+				return nil
+			}
 			name := args[2]
 
 			if strings.HasSuffix(strings.ToLower(name), "prod") {
-				fmt.Println("Error: Cannot create env named prod")
-				return
+				return executors.ConfigError("cannot create env named prod")
 			}
-			e.RunNewEnv(name)
-			return
+			return e.RunNewEnv(name, args[3:])
 		}
 		env := args[1]
-		args = args[2:]
+		if args, err = parseGlobalFlags(e, args[2:]); err != nil || args == nil {
+			return err
+		}
 		//load project metadata
 		projectmeta, err := config.LoadProjectMetadata(env)
 		if err != nil {
-			fmt.Printf("❌ Error: Environment '%s' not found for this project.\n", env)
-
 			// Show available environments
 			projEnv, err := config.LoadProjectEnv()
 			if err == nil && projEnv != nil {
@@ -133,7 +177,7 @@ func main() {
 				}
 				fmt.Println("\n💡 Use 'graft env --new <name>' to create a new environment.")
 			}
-			return
+			return executors.ConfigError("environment '%s' not found for this project", env)
 		}
 
 		e.Env = env
@@ -149,127 +193,148 @@ func main() {
 
 	switch command {
 	case "init":
-		e.RunInit(args[1:])
+		return e.RunInit(args[1:])
 	case "hook":
 		if args[1] == "map" {
-			e.RunHookMap()
-			return
+			return e.RunHookMap()
 		} else {
-			e.RunHook(args[1:])
+			return e.RunHook(args[1:])
 		}
 
 	case "host":
 		if len(args) < 2 {
 			fmt.Println("Usage: graft host [init|clean|sh|self-destruct]")
-			return
+			return nil
 		}
 		switch args[1] {
 		case "init":
-			e.RunHostInit()
+			return e.RunHostInit(args[2:])
 		case "clean":
-			e.RunHostClean()
+			return e.RunHostClean()
 		case "sh", "-sh", "--sh":
-			e.RunHostShell(args[2:])
+			return e.RunHostShell(args[2:])
 		case "self-destruct":
-			e.RunHostSelfDestruct()
+			return e.RunHostSelfDestruct()
 		default:
-			e.RunHostDocker(args[1:])
+			return e.RunHostDocker(args[1:])
 		}
 	case "db":
+		if len(args) >= 3 && args[2] == "connect" {
+			return e.RunDBConnect(args[1], args[3:])
+		}
 		if len(args) < 3 || args[2] != "init" {
-			fmt.Println("Usage: graft db <name> init")
-			return
+			fmt.Println("Usage: graft db <name> [init|connect]")
+			return nil
 		}
-		e.RunInfraInit("postgres", args[1])
+		return e.RunInfraInit("postgres", args[1])
+	case "forward":
+		return e.RunForward(args[1:])
 	case "redis":
 		if len(args) < 3 || args[2] != "init" {
 			fmt.Println("Usage: graft redis <name> init")
-			return
+			return nil
 		}
-		e.RunInfraInit("redis", args[1])
+		return e.RunInfraInit("redis", args[1])
 	case "infra":
 		if len(args) < 2 {
 			fmt.Println("Usage: graft infra [db|redis] ports:<value> | graft infra reload")
-			return
+			return nil
 		}
 		if args[1] == "reload" {
-			e.RunInfraReload()
+			return e.RunInfraReload()
 		} else {
-			e.RunInfra(args[1:])
+			return e.RunInfra(args[1:])
 		}
 	case "logs":
 		if len(args) < 2 {
 			fmt.Println("Usage: graft logs <service>")
-			return
+			return nil
 		}
-		e.RunLogs(args[1])
+		return e.RunLogs(args[1])
+	case "switch":
+		return e.RunSwitch(args[1:])
+	case "history":
+		return e.RunHistory(args[1:])
+	case "plan":
+		return e.RunPlan(args[1:])
+	case "lock":
+		return e.RunLock(args[1:])
+	case "canary":
+		return e.RunCanary(args[1:])
 	case "sync":
 		// Check if "compose" subcommand is specified
 		if len(args) > 1 && args[1] == "compose" {
-			e.RunSyncCompose(args[1:])
+			return e.RunSyncCompose(args[1:])
 		} else {
-			e.RunSync(args[1:])
+			return e.RunSync(args[1:])
 		}
 	case "rollback":
 		if len(args) > 1 && args[1] == "config" {
-			e.RunRollbackConfig()
+			return e.RunRollbackConfig()
 		} else if len(args) > 1 && args[1] == "service" {
 			if len(args) < 3 {
 				fmt.Println("Usage: graft rollback service <service-name>")
-				return
+				return nil
 			}
-			e.RunServiceRollback(args[2])
+			return e.RunServiceRollback(args[2])
 		} else {
-			e.RunRollback()
+			return e.RunRollback()
 		}
+	case "access":
+		return e.RunAccess(args[1:])
 	case "registry":
 		if len(args) < 2 {
-			fmt.Println("Usage: graft registry [ls|add|del]")
-			return
+			fmt.Println("Usage: graft registry [ls|add|del|rekey]")
+			return nil
 		}
 		switch args[1] {
 		case "ls":
-			e.RunRegistryLs()
+			return e.RunRegistryLs()
 		case "add":
-			e.RunRegistryAdd()
+			return e.RunRegistryAdd(args[2:])
 		case "del":
 			if len(args) < 3 {
 				fmt.Println("Usage: graft registry del <name>")
-				return
+				return nil
 			}
-			e.RunRegistryDel(args[2])
+			return e.RunRegistryDel(args[2])
+		case "rekey":
+			if len(args) < 3 {
+				fmt.Println("Usage: graft registry rekey <name>")
+				return nil
+			}
+			return e.RunRegistryRekey(args[2])
 		default:
-			fmt.Println("Usage: graft registry [ls|add|del]")
+			fmt.Println("Usage: graft registry [ls|add|del|rekey]")
 		}
 	case "projects":
 		if len(args) > 1 && args[1] == "ls" {
-			e.RunProjectsLs(registryContext)
+			return e.RunProjectsLs(registryContext)
 		} else {
 			fmt.Println("Usage: graft projects ls")
 		}
 	case "pullfromhost":
 		if registryContext == "" {
-			fmt.Println("Error: Pulling requires a registry context. Use 'graft -r <registry> pull <project>'")
-			return
+			return executors.ConfigError("pulling requires a registry context. Use 'graft -r <registry> pullfromhost <project>'")
 		}
 		if len(args) < 2 {
 			fmt.Println("Usage: graft -r <registry> pullfromhost <project>")
-			return
+			return nil
 		}
-		e.RunPull(registryContext, args[1])
+		return e.RunPull(registryContext, args[1])
 	case "mode":
-		e.RunMode()
+		return e.RunMode(args[1:])
 	case "map":
 		if len(args) < 2 {
-			e.RunMap([]string{}) // Map all services
+			return e.RunMap([]string{}) // Map all services
 		} else if args[1] == "service" {
 			if len(args) < 3 {
 				fmt.Println("Usage: graft map service <service-name>")
-				return
+				return nil
 			}
-			e.RunMapService(args[2])
+			return e.RunMapService(args[2])
 		} else {
-			e.RunMap(args[1:])
+			return e.RunMap(args[1:])
 		}
 	default:
 		// Handle the --pull flag as requested in the specific format
@@ -288,8 +353,70 @@ func main() {
 		// if foundPull { return }
 
 		// Pass through to docker compose for any other command
-		e.RunDockerCompose(args)
+		return e.RunDockerCompose(args)
+	}
+	return nil
+}
+
+// parseGlobalFlags consumes leading global flags such as --answers, --yes and --output.
+// Only flags in leading position are taken so docker compose passthrough
+// arguments are left untouched. It returns no arguments when only flags were given.
+func parseGlobalFlags(e *executors.Executor, args []string) ([]string, error) {
+	var lead []string
+	for len(args) > 0 {
+		if args[0] == "--dry-run" {
+			e.EnableDryRun()
+			args = args[1:]
+		} else if args[0] == "--tty" {
+			e.TTY = true
+			args = args[1:]
+		} else if args[0] == "--yes" || args[0] == "--non-interactive" {
+			lead = append(lead, args[0])
+			args = args[1:]
+		} else if args[0] == "--answers" || args[0] == "--set" {
+			if len(args) < 2 {
+				return nil, executors.ConfigError("%s requires a value", args[0])
+			}
+			lead = append(lead, args[0], args[1])
+			args = args[2:]
+		} else if args[0] == "--timeout" {
+			if len(args) < 2 {
+				return nil, executors.ConfigError("%s requires a value", args[0])
+			}
+			timeout, err := time.ParseDuration(args[1])
+			if err != nil || timeout < 0 {
+				return nil, executors.ConfigError("invalid --timeout '%s' (use a duration such as 90s, 15m or 1h)", args[1])
+			}
+			e.Timeout = timeout
+			args = args[2:]
+		} else if args[0] == "-o" || args[0] == "--output" || strings.HasPrefix(args[0], "--output=") {
+			format := strings.TrimPrefix(args[0], "--output=")
+			if format == args[0] {
+				if len(args) < 2 {
+					return nil, executors.ConfigError("%s requires a value", args[0])
+				}
+				format = args[1]
+				args = args[1:]
+			}
+			args = args[1:]
+			if err := e.SetOutput(format); err != nil {
+				return nil, err
+			}
+		} else {
+			break
+		}
+	}
+
+	if len(lead) > 0 {
+		if _, err := e.Answers.ParseFlags(lead); err != nil {
+			return nil, executors.ConfigError("%v", err)
+		}
+	}
+	if len(args) == 0 {
+		printUsage()
+		return nil, nil
 	}
+	return args, nil
 }
 
 func printUsage() {
@@ -301,11 +428,21 @@ func printUsage() {
 	fmt.Println("  -r, --registry <name>     Target a specific server context")
 	fmt.Println("  -sh, --sh [cmd]           Execute shell command on target (or start SSH session)")
 	fmt.Println("  -v, --version             Show version information")
+	fmt.Println("  --answers <file>          Read setup answers from a YAML/JSON file")
+	fmt.Println("  --set <key=value>         Provide a single setup answer")
+	fmt.Println("  --yes, --non-interactive  Never prompt; fail on missing required answers")
+	fmt.Println("  -o, --output <format>     Output format for ls/status commands: table, json, yaml")
+	fmt.Println("  --dry-run                 Print the remote commands, uploads and syncs instead of running them")
+	fmt.Println("  --timeout <duration>      Stop any remote command that runs longer (e.g. 30m)")
+	fmt.Println("  --tty                     Run docker passthrough commands on a pseudo-terminal")
 	fmt.Println("  --help                    Show this help message")
 	fmt.Println("\nCommands:")
 	fmt.Println("  init [-f]                 Initialize a new project")
 	fmt.Println("  registry [ls|add|del]     Manage registered servers")
+	fmt.Println("  access [grant|revoke|ls]  Manage named SSH keys of people with access to servers")
+	fmt.Println("  registry rekey <name>     Re-pin a server's SSH host key after it changed")
 	fmt.Println("  pub [rollout]             Manage Graft SSH keys (show public key or rotate)")
+	fmt.Println("  pub rollout --abort       Roll back an unfinished key rotation")
 	fmt.Println("  projects ls               List local projects")
 	fmt.Println("  pull <project>            Pull/Clone project from remote")
 	fmt.Println("  host [init|clean|sh|self-destruct]  Manage current project's host context")
@@ -313,7 +450,19 @@ func printUsage() {
 	fmt.Println("  infra db backup           Setup automated database backups to S3")
 	fmt.Println("  infra reload              Pull and reload infrastructure services")
 	fmt.Println("  db/redis <name> init      Initialize shared infrastructure")
+	fmt.Println("  db <name> connect         Open psql on a database through an SSH tunnel")
+	fmt.Println("  forward <target>:<port> [localport]  Tunnel a local port to a service or graft-postgres/graft-redis")
 	fmt.Println("  sync [service] [-h]       Deploy project to server")
+	fmt.Println("  sync --list-files         Preview the files sync would upload")
+	fmt.Println("  sync [service] --strategy blue-green|rolling  Choose how services are swapped")
+	fmt.Println("  sync --parallel N         Upload and build up to N services at once (default 4)")
+	fmt.Println("  sync <service> --canary 10%  Release a new version to a share of the traffic")
+	fmt.Println("  canary [status]           List running canaries")
+	fmt.Println("  canary promote|abort <service>  Give a canary all traffic or remove it")
+	fmt.Println("  plan [compose] [--json]   Show what a sync would change on the server")
+	fmt.Println("  history [--service <name>] [--json]  Show who deployed what and when")
+	fmt.Println("  lock [status|break]       Show or remove the lock held by a running deploy")
+	fmt.Println("  switch <service>          Move a blue-green service back to its warm color")
 	fmt.Println("  rollback                  Restore project to a previous backup")
 	fmt.Println("  rollback service <name>   Restore specific service from a backup")
 	fmt.Println("  rollback config           Configure rollback versions to keep")
@@ -321,6 +470,10 @@ func printUsage() {
 	fmt.Println("  mode                      Change project deployment mode")
 	fmt.Println("  map                       Map all service domains to Cloudflare DNS")
 	fmt.Println("  map service <name>        Map specific service domain to Cloudflare DNS")
+	fmt.Println("\nExit Codes:")
+	fmt.Println("  0 success, 1 unexpected error, 2 configuration error, 3 connection error,")
+	fmt.Println("  4 remote command failure, 5 aborted by user. Pass-through commands")
+	fmt.Println("  (docker compose, host sh, -r ...) exit with the remote command's status.")
 	fmt.Println("\nFull Documentation:")
 	fmt.Println("  https://graftdocs.vercel.app")
 }
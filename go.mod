@@ -3,6 +3,7 @@ module github.com/skssmd/graft
 go 1.24.4
 
 require (
+	github.com/kevinburke/ssh_config v1.6.0
 	github.com/pkg/sftp v1.13.10
 	golang.org/x/crypto v0.46.0
 	golang.org/x/term v0.38.0
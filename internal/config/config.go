@@ -11,6 +11,18 @@ import (
 
 const RemoteInfraPath = "/opt/graft/infra/.config"
 const RemoteProjectsPath = "/opt/graft/config/projects.json"
+const RemoteAccessPath = "/opt/graft/config/access.json"
+
+// RemoteGatewayDynamicPath holds dynamic config for Traefik's file provider
+const RemoteGatewayDynamicPath = "/opt/graft/gateway/dynamic"
+
+// RemoteHistoryDir holds the deployment history of each project, one JSON
+// object per line in <project>.jsonl
+const RemoteHistoryDir = "/opt/graft/history"
+
+// RemoteLocksDir holds the deploy lock of each project and environment,
+// <project>-<env>.lock, while a deploy runs
+const RemoteLocksDir = "/opt/graft/locks"
 
 type ServerConfig struct {
 	RegistryName string `json:"registry_name,omitempty"`
@@ -18,9 +30,26 @@ type ServerConfig struct {
 	Port         int    `json:"port"`
 	User         string `json:"user"`
 	KeyPath      string `json:"key_path"`
+	// Auth lists authentication methods to try in order: "agent", "key"
+	// (KeyPath) or "key:<path>". Empty means KeyPath, then ssh-agent.
+	Auth         []string `json:"auth,omitempty"`
+	// HostKey is the server's SSH host key in authorized_keys format, pinned
+	// on first connect. Change it with `graft registry rekey <name>`.
+	HostKey      string `json:"host_key,omitempty"`
 	GraftHookURL string `json:"graft_hook_url,omitempty"`
 }
 
+// AccessEntry is a person's SSH key granted with `graft access grant`. The
+// server keeps one per name in RemoteAccessPath.
+type AccessEntry struct {
+	Name        string `json:"name"`
+	PublicKey   string `json:"public_key"`
+	Fingerprint string `json:"fingerprint"`
+	From        string `json:"from,omitempty"`
+	Command     string `json:"command,omitempty"`
+	GrantedAt   string `json:"granted_at"`
+}
+
 type InfraConfig struct {
 	PostgresUser     string    `json:"postgres_user"`
 	PostgresPassword string    `json:"postgres_password"`
@@ -228,6 +257,8 @@ type ProjectMetadata struct {
 	DeploymentMode  string `json:"deployment_mode,omitempty"` // "git-images", "git-repo-serverbuild", "git-manual", "direct-serverbuild", "direct-localbuild", "cloud-flyio", "cloud-vercel"
 	GitBranch       string `json:"git_branch,omitempty"`
 	RollbackBackups int    `json:"rollback_backups,omitempty"`
+	// BlueGreen lists the services deployed with `--strategy blue-green`
+	BlueGreen       []string `json:"blue_green,omitempty"`
 }
 type ProjectEnv struct {
 	Name            string `json:"name"`
@@ -0,0 +1,622 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skssmd/graft/internal/config"
+	"github.com/skssmd/graft/internal/server/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+// Deploy strategies accepted by `graft sync --strategy`
+const (
+	StrategyRolling   = "rolling"
+	StrategyBlueGreen = "blue-green"
+)
+
+// blueGreenProfile keeps blue-green services out of the project's own
+// `docker compose up`; each color runs them as a compose project of its own
+const blueGreenProfile = "graft-blue-green"
+
+// blueGreenCheckLabel sets the path requested from a new color before
+// traffic is switched to it
+const blueGreenCheckLabel = "graft.bluegreen.check"
+
+var composeProjectInvalid = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// BlueGreenState records which color of a service is live. It is stored in
+// the project directory on the server.
+type BlueGreenState struct {
+	Live       string `json:"live"`
+	Idle       string `json:"idle,omitempty"`
+	SwitchedAt string `json:"switched_at"`
+}
+
+// blueGreenRouter is an HTTP router taken from a service's Traefik labels
+type blueGreenRouter struct {
+	Rule         string
+	EntryPoints  []string
+	Priority     int
+	TLS          bool
+	CertResolver string
+	Middlewares  []string
+	Service      string
+	Port         string
+}
+
+// Traefik file provider config, as far as blue-green routing needs it
+type dynamicConfig struct {
+	HTTP dynamicHTTP `yaml:"http"`
+}
+
+type dynamicHTTP struct {
+	Routers  map[string]dynamicRouter  `yaml:"routers"`
+	Services map[string]dynamicService `yaml:"services"`
+}
+
+type dynamicRouter struct {
+	Rule        string      `yaml:"rule"`
+	EntryPoints []string    `yaml:"entryPoints,omitempty"`
+	Priority    int         `yaml:"priority,omitempty"`
+	Middlewares []string    `yaml:"middlewares,omitempty"`
+	Service     string      `yaml:"service"`
+	TLS         *dynamicTLS `yaml:"tls,omitempty"`
+}
+
+type dynamicTLS struct {
+	CertResolver string `yaml:"certResolver,omitempty"`
+}
+
+type dynamicService struct {
+	LoadBalancer *dynamicLoadBalancer `yaml:"loadBalancer,omitempty"`
+	Weighted     *dynamicWeighted     `yaml:"weighted,omitempty"`
+}
+
+type dynamicLoadBalancer struct {
+	Servers []dynamicServer `yaml:"servers"`
+}
+
+type dynamicWeighted struct {
+	Services []dynamicWeight `yaml:"services"`
+}
+
+type dynamicWeight struct {
+	Name   string `yaml:"name"`
+	Weight int    `yaml:"weight"`
+}
+
+type dynamicServer struct {
+	URL string `yaml:"url"`
+}
+
+// SetSyncStrategy records the deploy strategy of serviceName, or of every
+// service that supports it when serviceName is empty, in the project
+// metadata so later syncs keep using it
+func SetSyncStrategy(envname, serviceName, strategy string) error {
+	meta, err := config.LoadProjectMetadata(envname)
+	if err != nil {
+		return fmt.Errorf("could not load project metadata: %v", err)
+	}
+	compose, err := ParseComposeFile("graft-compose.yml", meta.Domain)
+	if err != nil {
+		return fmt.Errorf("failed to parse compose file: %v", err)
+	}
+
+	names := []string{serviceName}
+	if serviceName == "" {
+		names = nil
+		for name := range compose.Services {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	} else if _, ok := compose.Services[serviceName]; !ok {
+		return fmt.Errorf("service '%s' not found in compose file", serviceName)
+	}
+
+	blueGreen := make(map[string]bool)
+	for _, name := range meta.BlueGreen {
+		blueGreen[name] = true
+	}
+	switch strategy {
+	case StrategyBlueGreen:
+		for _, name := range names {
+			if reason := blueGreenUnsupported(compose.Services[name]); reason != "" {
+				if serviceName != "" {
+					return fmt.Errorf("'%s' cannot be deployed blue-green: %s", name, reason)
+				}
+				continue
+			}
+			blueGreen[name] = true
+		}
+	case StrategyRolling:
+		for _, name := range names {
+			delete(blueGreen, name)
+		}
+	default:
+		return fmt.Errorf("unknown strategy '%s' (use %s or %s)", strategy, StrategyRolling, StrategyBlueGreen)
+	}
+
+	meta.BlueGreen = nil
+	for name := range blueGreen {
+		meta.BlueGreen = append(meta.BlueGreen, name)
+	}
+	sort.Strings(meta.BlueGreen)
+	return config.SaveProjectMetadata(envname, meta)
+}
+
+// blueGreenUnsupported returns why a service cannot be deployed blue-green,
+// or "" if it can
+func blueGreenUnsupported(service ComposeService) string {
+	if !defaultZeroDowntime(service) {
+		return "only serverbuild, localbuild and image services are supported"
+	}
+	if reason := sideBySide(service); reason != "" {
+		return reason
+	}
+	if _, err := traefikRouters(service.Labels); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// isBlueGreen reports whether the metadata marks a service as blue-green
+func isBlueGreen(meta *config.ProjectMetadata, serviceName string) bool {
+	if meta == nil {
+		return false
+	}
+	for _, name := range meta.BlueGreen {
+		if name == serviceName {
+			return true
+		}
+	}
+	return false
+}
+
+// applyBlueGreen hides blue-green services from the project's own compose
+// commands and from Traefik's docker provider; their colors are routed
+// through the file provider instead
+func applyBlueGreen(compose *DockerComposeFile, services []string) {
+	for _, name := range services {
+		s, ok := compose.Services[name]
+		if !ok {
+			continue
+		}
+		s = hideFromDocker(s)
+
+		other := make(map[string]interface{}, len(s.OtherFields)+1)
+		for k, v := range s.OtherFields {
+			other[k] = v
+		}
+		other["profiles"] = []string{blueGreenProfile}
+		s.OtherFields = other
+		compose.Services[name] = s
+	}
+}
+
+// traefikRouters reads the HTTP routers of a service from its Traefik labels
+// for routing through the file provider alone
+func traefikRouters(labels []string) (map[string]*blueGreenRouter, error) {
+	routers, ownMiddlewares, err := serviceRouters(labels)
+	if err != nil {
+		return nil, err
+	}
+	// The colors are hidden from the docker provider, and with them any
+	// middleware defined on the service itself
+	for name, r := range routers {
+		for _, m := range r.Middlewares {
+			if own := strings.TrimSuffix(m, "@docker"); ownMiddlewares[own] {
+				return nil, fmt.Errorf("middleware '%s' of router '%s' is defined on the service itself; define it on another container or in %s", own, name, config.RemoteGatewayDynamicPath)
+			}
+		}
+	}
+	return routers, nil
+}
+
+// serviceRouters reads the HTTP routers of a service from its Traefik labels,
+// along with the middlewares the service defines itself
+func serviceRouters(labels []string) (map[string]*blueGreenRouter, map[string]bool, error) {
+	routers := make(map[string]*blueGreenRouter)
+	ports := make(map[string]string)
+	ownMiddlewares := make(map[string]bool)
+	for _, label := range labels {
+		key, value, _ := strings.Cut(label, "=")
+		if rest, ok := strings.CutPrefix(key, "traefik.http.middlewares."); ok {
+			name, _, _ := strings.Cut(rest, ".")
+			ownMiddlewares[name] = true
+			continue
+		}
+		if rest, ok := strings.CutPrefix(key, "traefik.http.services."); ok {
+			if name, ok := strings.CutSuffix(rest, ".loadbalancer.server.port"); ok {
+				ports[name] = value
+			}
+			continue
+		}
+		rest, ok := strings.CutPrefix(key, "traefik.http.routers.")
+		if !ok {
+			continue
+		}
+		name, field, _ := strings.Cut(rest, ".")
+		r := routers[name]
+		if r == nil {
+			r = &blueGreenRouter{}
+			routers[name] = r
+		}
+		switch field {
+		case "rule":
+			r.Rule = value
+		case "entrypoints":
+			r.EntryPoints = strings.Split(value, ",")
+		case "priority":
+			r.Priority, _ = strconv.Atoi(value)
+		case "tls":
+			r.TLS = value == "true"
+		case "tls.certresolver":
+			r.TLS = true
+			r.CertResolver = value
+		case "middlewares":
+			for _, m := range strings.Split(value, ",") {
+				// Middlewares from other containers' labels live in the
+				// docker provider
+				if !strings.Contains(m, "@") {
+					m += "@docker"
+				}
+				r.Middlewares = append(r.Middlewares, m)
+			}
+		case "service":
+			r.Service = value
+		}
+	}
+
+	if len(routers) == 0 {
+		return nil, nil, fmt.Errorf("it has no traefik.http.routers labels")
+	}
+	for name, r := range routers {
+		if r.Rule == "" {
+			return nil, nil, fmt.Errorf("router '%s' has no rule", name)
+		}
+		// A router without a service label uses the only one the container
+		// defines
+		if r.Service == "" && len(ports) == 1 {
+			for service := range ports {
+				r.Service = service
+			}
+		}
+		r.Port = ports[r.Service]
+		if r.Port == "" {
+			return nil, nil, fmt.Errorf("router '%s' needs a traefik.http.services.<name>.loadbalancer.server.port label", name)
+		}
+	}
+	return routers, ownMiddlewares, nil
+}
+
+// blueGreenDynamicConfig routes every router of a service to container
+func blueGreenDynamicConfig(routers map[string]*blueGreenRouter, container string) ([]byte, error) {
+	cfg := dynamicConfig{HTTP: dynamicHTTP{
+		Routers:  make(map[string]dynamicRouter),
+		Services: make(map[string]dynamicService),
+	}}
+	for name, r := range routers {
+		serviceName := name + "-bluegreen"
+		router := dynamicRouter{
+			Rule:        r.Rule,
+			EntryPoints: r.EntryPoints,
+			Priority:    r.Priority,
+			Middlewares: r.Middlewares,
+			Service:     serviceName,
+		}
+		if r.TLS {
+			router.TLS = &dynamicTLS{CertResolver: r.CertResolver}
+		}
+		cfg.HTTP.Routers[name] = router
+
+		cfg.HTTP.Services[serviceName] = dynamicService{LoadBalancer: &dynamicLoadBalancer{
+			Servers: []dynamicServer{{URL: fmt.Sprintf("http://%s:%s", container, r.Port)}},
+		}}
+	}
+	return yaml.Marshal(cfg)
+}
+
+// requireFileProvider fails if the server's Traefik gateway does not read
+// the dynamic config directory yet
+func requireFileProvider(client ssh.Remote) error {
+	if err := client.RunCommand("grep -q providers.file.directory /opt/graft/gateway/docker-compose.yml", nil, nil); err != nil {
+		return fmt.Errorf("the Traefik gateway on this server has no file provider; run 'graft host init' to update it")
+	}
+	return nil
+}
+
+// hideFromDocker turns Traefik's docker provider off for a service
+func hideFromDocker(s ComposeService) ComposeService {
+	labels := make([]string, 0, len(s.Labels)+1)
+	for _, label := range s.Labels {
+		if !strings.HasPrefix(label, "traefik.enable=") {
+			labels = append(labels, label)
+		}
+	}
+	s.Labels = append(labels, "traefik.enable=false")
+	return s
+}
+
+// colorProject is the compose project that runs one color of a service
+func colorProject(remoteDir, serviceName, color string) string {
+	name := strings.ToLower(path.Base(remoteDir) + "-" + serviceName + "-" + color)
+	return composeProjectInvalid.ReplaceAllString(name, "-")
+}
+
+// colorContainer is the container name of one color of a service, which
+// Traefik resolves on graft-public
+func colorContainer(remoteDir, serviceName, color string) string {
+	return colorProject(remoteDir, serviceName, color) + "-" + serviceName + "-1"
+}
+
+func otherColor(color string) string {
+	if color == "blue" {
+		return "green"
+	}
+	return "blue"
+}
+
+func blueGreenStatePath(remoteDir, serviceName string) string {
+	return path.Join(remoteDir, "bluegreen", serviceName+".json")
+}
+
+func blueGreenDynamicPath(remoteDir, serviceName string) string {
+	return path.Join(config.RemoteGatewayDynamicPath, path.Base(remoteDir)+"-"+serviceName+".yml")
+}
+
+// loadBlueGreenState reads the state of a service. A service that was never
+// deployed blue-green has an empty state.
+func loadBlueGreenState(client ssh.Remote, remoteDir, serviceName string) (*BlueGreenState, error) {
+	state := &BlueGreenState{}
+	tmpFile := filepath.Join(os.TempDir(), fmt.Sprintf("bluegreen-%s.json", serviceName))
+	if err := client.DownloadFile(blueGreenStatePath(remoteDir, serviceName), tmpFile); err != nil {
+		return state, nil
+	}
+	defer os.Remove(tmpFile)
+	data, err := os.ReadFile(tmpFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("could not parse blue-green state of %s: %v", serviceName, err)
+	}
+	return state, nil
+}
+
+func saveBlueGreenState(client ssh.Remote, remoteDir, serviceName string, state *BlueGreenState) error {
+	data, _ := json.MarshalIndent(state, "", "  ")
+	return uploadRemoteFile(client, data, blueGreenStatePath(remoteDir, serviceName), fmt.Sprintf("bluegreen-%s.json", serviceName))
+}
+
+// uploadRemoteFile writes data to remote through a temporary file that is
+// renamed into place, so readers never see it half written
+func uploadRemoteFile(client ssh.Remote, data []byte, remote, tmpName string) error {
+	tmpFile := filepath.Join(os.TempDir(), tmpName)
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile)
+
+	if err := client.RunCommand(fmt.Sprintf("mkdir -p %s", path.Dir(remote)), nil, nil); err != nil {
+		return fmt.Errorf("failed to create %s: %v", path.Dir(remote), err)
+	}
+	if err := client.UploadFile(tmpFile, remote+".tmp"); err != nil {
+		return fmt.Errorf("failed to upload %s: %v", remote, err)
+	}
+	return client.RunCommand(fmt.Sprintf("mv -f %s.tmp %s", remote, remote), nil, nil)
+}
+
+// checkColor waits for a color's container to become healthy and then
+// requests the check path on each of its ports from inside the Traefik
+// container, the way live traffic will reach it
+func checkColor(client ssh.Remote, container string, service ComposeService, routers map[string]*blueGreenRouter, stdout, stderr io.Writer) error {
+	if err := verifyContainers(client, []string{container}, service, false, stdout, stderr); err != nil {
+		return err
+	}
+	checkPath := blueGreenCheckPath(service.Labels)
+
+	checked := make(map[string]bool)
+	for _, r := range routers {
+		if checked[r.Port] {
+			continue
+		}
+		checked[r.Port] = true
+		url := fmt.Sprintf("http://%s:%s%s", container, r.Port, checkPath)
+		fmt.Fprintf(stdout, "🩺 Checking %s...\n", url)
+		checkCmd := fmt.Sprintf("for i in 1 2 3 4 5; do sudo docker exec graft-traefik wget -q -T 5 -O /dev/null %s && exit 0; sleep 2; done; exit 1", url)
+		if err := client.RunCommand(checkCmd, stdout, stderr); err != nil {
+			return fmt.Errorf("%s did not answer successfully", url)
+		}
+	}
+	return nil
+}
+
+// routeToColor points Traefik's routers for a service at one color
+func routeToColor(client ssh.Remote, remoteDir, serviceName, color string, routers map[string]*blueGreenRouter) error {
+	data, err := blueGreenDynamicConfig(routers, colorContainer(remoteDir, serviceName, color))
+	if err != nil {
+		return err
+	}
+	return uploadRemoteFile(client, data, blueGreenDynamicPath(remoteDir, serviceName), fmt.Sprintf("bluegreen-%s.yml", serviceName))
+}
+
+// blueGreenCheckPath returns the path requested from a new color: the
+// graft.bluegreen.check label, else the graft.healthcheck path, else "/"
+func blueGreenCheckPath(labels []string) string {
+	for _, label := range labels {
+		if value, ok := strings.CutPrefix(label, blueGreenCheckLabel+"="); ok && strings.HasPrefix(value, "/") {
+			return value
+		}
+	}
+	if probe, err := parseHealthProbe(labels); err == nil && probe != nil {
+		return probe.Path
+	}
+	return "/"
+}
+
+// deployBlueGreen builds or pulls a service into its idle color, checks the
+// new container and then switches Traefik to it. The previous color keeps
+// running so `graft switch` can move traffic back instantly.
+func deployBlueGreen(client ssh.Remote, remoteDir, serviceName string, service ComposeService, noCache bool, hist *historyRecord, stdout, stderr io.Writer) error {
+	// 1. Blue-green routing needs Traefik's file provider
+	if err := requireFileProvider(client); err != nil {
+		return err
+	}
+	routers, err := traefikRouters(service.Labels)
+	if err != nil {
+		return fmt.Errorf("'%s' cannot be deployed blue-green: %v", serviceName, err)
+	}
+
+	state, err := loadBlueGreenState(client, remoteDir, serviceName)
+	if err != nil {
+		return err
+	}
+	target := otherColor(state.Live)
+	project := colorProject(remoteDir, serviceName, target)
+	container := colorContainer(remoteDir, serviceName, target)
+	compose := fmt.Sprintf("cd %s && sudo docker compose -p %s --profile %s", remoteDir, project, blueGreenProfile)
+	fmt.Fprintf(stdout, "🔵🟢 Deploying %s to %s", serviceName, target)
+	if state.Live != "" {
+		fmt.Fprintf(stdout, " (%s is live)", state.Live)
+	}
+	fmt.Fprintln(stdout)
+
+	// 2. Build or pull the new version into the idle color
+	if service.Build != nil {
+		fmt.Fprintf(stdout, "🔨 Building %s (%s)...\n", serviceName, target)
+		buildCmd := fmt.Sprintf("%s build %s", compose, serviceName)
+		if noCache {
+			buildCmd = fmt.Sprintf("%s build --no-cache %s", compose, serviceName)
+		}
+		if err := client.RunIdempotent(buildCmd, stdout, stderr); err != nil {
+			return fmt.Errorf("build failed: %v", err)
+		}
+	} else if pullable(service) {
+		fmt.Fprintf(stdout, "📥 Pulling latest image...\n")
+		if err := client.RunIdempotent(fmt.Sprintf("%s pull %s", compose, serviceName), stdout, stderr); err != nil {
+			return fmt.Errorf("image pull failed: %v", err)
+		}
+	}
+
+	// Hooks such as migrations run before the new version starts
+	if err := runHook(client, compose, serviceName, service, PreDeployHook, hist, stdout, stderr); err != nil {
+		if state.Live != "" {
+			return fmt.Errorf("%v, %s keeps serving", err, state.Live)
+		}
+		return err
+	}
+
+	fmt.Fprintf(stdout, "🚀 Starting %s (%s)...\n", serviceName, target)
+	if err := client.RunIdempotent(fmt.Sprintf("%s up -d --no-deps --force-recreate %s", compose, serviceName), stdout, stderr); err != nil {
+		return fmt.Errorf("failed to start %s (%s): %v", serviceName, target, err)
+	}
+
+	// 3. Check it before it gets any traffic
+	fmt.Fprintf(stdout, "🩺 Waiting for %s (%s) to become healthy...\n", serviceName, target)
+	if err := checkColor(client, container, service, routers, stdout, stderr); err != nil {
+		fmt.Fprintf(stdout, "📜 Last logs of %s (%s):\n", serviceName, target)
+		client.RunCommand(fmt.Sprintf("sudo docker logs --tail 30 %s", container), stdout, stderr)
+		client.RunCommand(fmt.Sprintf("%s stop %s", compose, serviceName), stdout, stderr)
+		if state.Live != "" {
+			return fmt.Errorf("%s (%s) failed its checks, %s keeps serving: %v", serviceName, target, state.Live, err)
+		}
+		return fmt.Errorf("%s (%s) failed its checks: %v", serviceName, target, err)
+	}
+
+	// 4. Switch traffic
+	fmt.Fprintf(stdout, "🔀 Switching traffic to %s...\n", target)
+	if err := routeToColor(client, remoteDir, serviceName, target, routers); err != nil {
+		return fmt.Errorf("failed to switch traffic: %v", err)
+	}
+	if state.Live == "" {
+		// The service ran in the project itself until now
+		stopCmd := fmt.Sprintf("sleep %d && cd %s && sudo docker compose --profile %s rm -s -f %s", swapSettleDelay, remoteDir, blueGreenProfile, serviceName)
+		client.RunCommand(stopCmd, stdout, stderr)
+	}
+
+	next := &BlueGreenState{Live: target, Idle: state.Live, SwitchedAt: time.Now().Format(time.RFC3339)}
+	if err := saveBlueGreenState(client, remoteDir, serviceName, next); err != nil {
+		return fmt.Errorf("traffic switched to %s but the state could not be saved: %v", target, err)
+	}
+
+	fmt.Fprintf(stdout, "✅ %s is live on %s\n", serviceName, target)
+	if err := runHook(client, compose, serviceName, service, PostDeployHook, hist, stdout, stderr); err != nil {
+		fmt.Fprintf(stdout, "⚠️  %v\n", err)
+	}
+	if next.Idle != "" {
+		fmt.Fprintf(stdout, "💡 %s stays warm, switch back with: graft switch %s\n", next.Idle, serviceName)
+	}
+	return nil
+}
+
+// SwitchBlueGreen moves a blue-green service's traffic to its warm color
+func SwitchBlueGreen(client ssh.Remote, remoteDir, serviceName string, service ComposeService, stdout, stderr io.Writer) error {
+	routers, err := traefikRouters(service.Labels)
+	if err != nil {
+		return fmt.Errorf("'%s' cannot be routed blue-green: %v", serviceName, err)
+	}
+	state, err := loadBlueGreenState(client, remoteDir, serviceName)
+	if err != nil {
+		return err
+	}
+	if state.Live == "" {
+		return fmt.Errorf("'%s' is not deployed blue-green; use 'graft sync %s --strategy blue-green'", serviceName, serviceName)
+	}
+	if state.Idle == "" {
+		return fmt.Errorf("'%s' has no warm color to switch to yet", serviceName)
+	}
+
+	// The warm color may have been stopped since, so it is checked again
+	target := state.Idle
+	container := colorContainer(remoteDir, serviceName, target)
+	fmt.Fprintf(stdout, "🩺 Checking %s (%s)...\n", serviceName, target)
+	if err := checkColor(client, container, service, routers, stdout, stderr); err != nil {
+		return fmt.Errorf("%s (%s) failed its checks, %s keeps serving: %v", serviceName, target, state.Live, err)
+	}
+
+	fmt.Fprintf(stdout, "🔀 Switching traffic to %s...\n", target)
+	if err := routeToColor(client, remoteDir, serviceName, target, routers); err != nil {
+		return fmt.Errorf("failed to switch traffic: %v", err)
+	}
+	next := &BlueGreenState{Live: target, Idle: state.Live, SwitchedAt: time.Now().Format(time.RFC3339)}
+	if err := saveBlueGreenState(client, remoteDir, serviceName, next); err != nil {
+		return fmt.Errorf("traffic switched to %s but the state could not be saved: %v", target, err)
+	}
+	fmt.Fprintf(stdout, "✅ %s is live on %s, %s stays warm\n", serviceName, target, next.Idle)
+	return nil
+}
+
+// retireBlueGreen removes the colors and routing of services that are no
+// longer deployed blue-green, once they run in the project again
+func retireBlueGreen(client ssh.Remote, remoteDir string, meta *config.ProjectMetadata, stdout, stderr io.Writer) error {
+	out, err := client.GetCommandOutput(fmt.Sprintf("ls %s 2>/dev/null || true", path.Join(remoteDir, "bluegreen")))
+	if err != nil {
+		return err
+	}
+	for _, file := range strings.Fields(out) {
+		serviceName, ok := strings.CutSuffix(file, ".json")
+		if !ok || isBlueGreen(meta, serviceName) {
+			continue
+		}
+		fmt.Fprintf(stdout, "🧹 Retiring blue-green colors of %s...\n", serviceName)
+		cmd := fmt.Sprintf("rm -f %s && sleep %d", blueGreenDynamicPath(remoteDir, serviceName), swapSettleDelay)
+		for _, color := range []string{"blue", "green"} {
+			cmd += fmt.Sprintf(" && (cd %s && sudo docker compose -p %s --profile %s down)", remoteDir, colorProject(remoteDir, serviceName, color), blueGreenProfile)
+		}
+		cmd += " && rm -f " + blueGreenStatePath(remoteDir, serviceName)
+		if err := client.RunCommand(cmd, stdout, stderr); err != nil {
+			return fmt.Errorf("failed to retire blue-green colors of %s: %v", serviceName, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,450 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skssmd/graft/internal/config"
+	"github.com/skssmd/graft/internal/server/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+// canaryColor names the compose project a canary runs in, next to the
+// blue-green colors
+const canaryColor = "canary"
+
+// CanaryState records a running canary. It is stored in the project
+// directory on the server with the compose file the canary was deployed with.
+type CanaryState struct {
+	Service string `json:"service" yaml:"service"`
+	Weight  int    `json:"weight" yaml:"weight"`
+	Started string `json:"started" yaml:"started"`
+	User    string `json:"user" yaml:"user"`
+}
+
+// ParseCanaryWeight reads the share of traffic given to a canary, such as
+// "10%" or "10"
+func ParseCanaryWeight(value string) (int, error) {
+	weight, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), "%"))
+	if err != nil || weight < 1 || weight > 99 {
+		return 0, fmt.Errorf("invalid canary share '%s' (use 1%% to 99%%)", value)
+	}
+	return weight, nil
+}
+
+// canaryUnsupported returns why a service cannot be released as a canary,
+// or "" if it can
+func canaryUnsupported(meta *config.ProjectMetadata, serviceName string, service ComposeService) string {
+	if isBlueGreen(meta, serviceName) {
+		return "it is deployed blue-green; use 'graft switch' to move traffic back instead"
+	}
+	if !defaultZeroDowntime(service) {
+		return "only serverbuild, localbuild and image services are supported"
+	}
+	if reason := sideBySide(service); reason != "" {
+		return reason
+	}
+	if _, _, err := serviceRouters(service.Labels); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func canaryDir(remoteDir string) string {
+	return path.Join(remoteDir, "canary")
+}
+
+func canaryStatePath(remoteDir, serviceName string) string {
+	return path.Join(canaryDir(remoteDir), serviceName+".json")
+}
+
+// canaryComposePath is the docker-compose.yml the canary was deployed with.
+// The project switches to it when the canary is promoted.
+func canaryComposePath(remoteDir, serviceName string) string {
+	return path.Join(canaryDir(remoteDir), serviceName+".compose.yml")
+}
+
+// canaryRunPath is the same compose file with the canary hidden from
+// Traefik's docker provider, which the canary's own project runs
+func canaryRunPath(remoteDir, serviceName string) string {
+	return path.Join(canaryDir(remoteDir), serviceName+".run.yml")
+}
+
+func canaryDynamicPath(remoteDir, serviceName string) string {
+	return path.Join(config.RemoteGatewayDynamicPath, path.Base(remoteDir)+"-"+serviceName+"-canary.yml")
+}
+
+// canaryCompose is the docker compose command of a service's canary project.
+// Paths in its compose file resolve against the project directory, as they
+// do for the project itself.
+func canaryCompose(remoteDir, serviceName string) string {
+	return fmt.Sprintf("cd %s && sudo docker compose -f %s --project-directory . -p %s", remoteDir, canaryRunPath(remoteDir, serviceName), colorProject(remoteDir, serviceName, canaryColor))
+}
+
+// uploadCanaryCompose uploads the generated compose file for a canary of
+// serviceName, leaving the project's own docker-compose.yml untouched
+func uploadCanaryCompose(client ssh.Remote, remoteDir, serviceName string, compose *DockerComposeFile, localComposeFile string) error {
+	if err := client.RunCommand(fmt.Sprintf("mkdir -p %s", canaryDir(remoteDir)), nil, nil); err != nil {
+		return fmt.Errorf("failed to create %s: %v", canaryDir(remoteDir), err)
+	}
+	if err := client.UploadFile(localComposeFile, canaryComposePath(remoteDir, serviceName)); err != nil {
+		return err
+	}
+
+	run := *compose
+	run.Services = make(map[string]ComposeService, len(compose.Services))
+	for name, s := range compose.Services {
+		run.Services[name] = s
+	}
+	run.Services[serviceName] = hideFromDocker(compose.Services[serviceName])
+
+	// Named volumes keep pointing at the project's own, so the canary sees
+	// the same data as the stable version
+	project := composeProjectInvalid.ReplaceAllString(strings.ToLower(path.Base(remoteDir)), "-")
+	run.Volumes = make(map[string]interface{}, len(compose.Volumes))
+	for name, v := range compose.Volumes {
+		def := map[string]interface{}{}
+		if m, ok := v.(map[string]interface{}); ok {
+			for k, val := range m {
+				def[k] = val
+			}
+		}
+		if _, named := def["name"]; !named && def["external"] != true {
+			def["name"] = project + "_" + name
+		}
+		run.Volumes[name] = def
+	}
+
+	data, err := yaml.Marshal(&run)
+	if err != nil {
+		return fmt.Errorf("failed to marshal canary compose file: %v", err)
+	}
+	return uploadRemoteFile(client, data, canaryRunPath(remoteDir, serviceName), fmt.Sprintf("canary-%s.yml", serviceName))
+}
+
+// checkCanary fails if a service cannot be released as a canary now: it
+// must support it and already run in the project, to compare it with
+func checkCanary(client ssh.Remote, remoteDir string, meta *config.ProjectMetadata, serviceName string, service ComposeService) error {
+	if reason := canaryUnsupported(meta, serviceName, service); reason != "" {
+		return fmt.Errorf("'%s' cannot be released as a canary: %s", serviceName, reason)
+	}
+	if isDryRun(client) {
+		return nil
+	}
+	out, err := client.GetCommandOutput(fmt.Sprintf("cd %s 2>/dev/null && sudo docker compose ps -q %s || true", remoteDir, serviceName))
+	if err != nil {
+		return fmt.Errorf("could not list containers of %s: %v", serviceName, err)
+	}
+	if strings.TrimSpace(out) == "" {
+		return fmt.Errorf("'%s' is not running yet, so a canary has nothing to share traffic with\n👉 Deploy it first with 'graft sync %s'", serviceName, serviceName)
+	}
+	return nil
+}
+
+// canaryDynamicConfig sends weight percent of the traffic of every router of
+// a service to container and the rest to the stable version, which stays in
+// Traefik's docker provider
+func canaryDynamicConfig(routers map[string]*blueGreenRouter, container string, weight int) ([]byte, error) {
+	cfg := dynamicConfig{HTTP: dynamicHTTP{
+		Routers:  make(map[string]dynamicRouter),
+		Services: make(map[string]dynamicService),
+	}}
+	for name, r := range routers {
+		// The stable version's router stays in place, so this one needs a
+		// higher priority. Traefik ranks routers without one by the length
+		// of their rule.
+		priority := r.Priority
+		if priority == 0 {
+			priority = len(r.Rule)
+		}
+
+		serviceName := name + "-canary"
+		router := dynamicRouter{
+			Rule:        r.Rule,
+			EntryPoints: r.EntryPoints,
+			Priority:    priority + 1,
+			Middlewares: r.Middlewares,
+			Service:     serviceName,
+		}
+		if r.TLS {
+			router.TLS = &dynamicTLS{CertResolver: r.CertResolver}
+		}
+		cfg.HTTP.Routers[name] = router
+
+		cfg.HTTP.Services[serviceName] = dynamicService{Weighted: &dynamicWeighted{
+			Services: []dynamicWeight{
+				{Name: r.Service + "@docker", Weight: 100 - weight},
+				{Name: serviceName + "-next", Weight: weight},
+			},
+		}}
+		cfg.HTTP.Services[serviceName+"-next"] = dynamicService{LoadBalancer: &dynamicLoadBalancer{
+			Servers: []dynamicServer{{URL: fmt.Sprintf("http://%s:%s", container, r.Port)}},
+		}}
+	}
+	return yaml.Marshal(cfg)
+}
+
+// LoadCanary returns the running canary of a service, or nil if it has none
+func LoadCanary(client ssh.Remote, remoteDir, serviceName string) (*CanaryState, error) {
+	out, err := client.GetCommandOutput(fmt.Sprintf("cat %s 2>/dev/null || true", canaryStatePath(remoteDir, serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("could not read the canary of %s: %v", serviceName, err)
+	}
+	if strings.TrimSpace(out) == "" {
+		return nil, nil
+	}
+	state := &CanaryState{}
+	if err := json.Unmarshal([]byte(out), state); err != nil {
+		return nil, fmt.Errorf("could not parse the canary state of %s: %v", serviceName, err)
+	}
+	return state, nil
+}
+
+// ListCanaries returns the running canaries of a project, by service name
+func ListCanaries(client ssh.Remote, remoteDir string) ([]CanaryState, error) {
+	out, err := client.GetCommandOutput(fmt.Sprintf("ls %s 2>/dev/null || true", canaryDir(remoteDir)))
+	if err != nil {
+		return nil, fmt.Errorf("could not list canaries: %v", err)
+	}
+	canaries := []CanaryState{}
+	for _, file := range strings.Fields(out) {
+		serviceName, ok := strings.CutSuffix(file, ".json")
+		if !ok {
+			continue
+		}
+		state, err := LoadCanary(client, remoteDir, serviceName)
+		if err != nil {
+			return nil, err
+		}
+		if state != nil {
+			canaries = append(canaries, *state)
+		}
+	}
+	sort.Slice(canaries, func(i, j int) bool { return canaries[i].Service < canaries[j].Service })
+	return canaries, nil
+}
+
+// canaryRunning fails if serviceName, or any service when it is empty, has a
+// canary that was neither promoted nor aborted
+func canaryRunning(client ssh.Remote, remoteDir, serviceName string) error {
+	canaries, err := ListCanaries(client, remoteDir)
+	if err != nil {
+		return err
+	}
+	for _, c := range canaries {
+		if serviceName != "" && c.Service != serviceName {
+			continue
+		}
+		return fmt.Errorf("a canary of %s is receiving %d%% of its traffic\n👉 Finish it first with 'graft canary promote %s' or 'graft canary abort %s'", c.Service, c.Weight, c.Service, c.Service)
+	}
+	return nil
+}
+
+// deployCanary builds or pulls the new version of a service into its canary
+// project, checks it and then sends weight percent of the service's traffic
+// to it. The stable version keeps running in the project and serves the rest.
+func deployCanary(client ssh.Remote, remoteDir, serviceName string, service ComposeService, weight int, noCache bool, hist *historyRecord, stdout, stderr io.Writer) error {
+	// 1. Weighted routing needs Traefik's file provider
+	if err := requireFileProvider(client); err != nil {
+		return err
+	}
+	routers, _, err := serviceRouters(service.Labels)
+	if err != nil {
+		return fmt.Errorf("'%s' cannot be released as a canary: %v", serviceName, err)
+	}
+
+	compose := canaryCompose(remoteDir, serviceName)
+	container := colorContainer(remoteDir, serviceName, canaryColor)
+	fmt.Fprintf(stdout, "🐤 Deploying a canary of %s for %d%% of its traffic\n", serviceName, weight)
+
+	// 2. Build or pull the new version into the canary project
+	if service.Build != nil {
+		fmt.Fprintf(stdout, "🔨 Building %s (canary)...\n", serviceName)
+		buildCmd := fmt.Sprintf("%s build %s", compose, serviceName)
+		if noCache {
+			buildCmd = fmt.Sprintf("%s build --no-cache %s", compose, serviceName)
+		}
+		if err := client.RunIdempotent(buildCmd, stdout, stderr); err != nil {
+			return fmt.Errorf("build failed: %v", err)
+		}
+	} else if pullable(service) {
+		fmt.Fprintf(stdout, "📥 Pulling latest image...\n")
+		if err := client.RunIdempotent(fmt.Sprintf("%s pull %s", compose, serviceName), stdout, stderr); err != nil {
+			return fmt.Errorf("image pull failed: %v", err)
+		}
+	}
+
+	// Hooks such as migrations run before the new version gets any traffic
+	if err := runHook(client, compose, serviceName, service, PreDeployHook, hist, stdout, stderr); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "🚀 Starting %s (canary)...\n", serviceName)
+	if err := client.RunIdempotent(fmt.Sprintf("%s up -d --no-deps --force-recreate %s", compose, serviceName), stdout, stderr); err != nil {
+		return fmt.Errorf("failed to start %s (canary): %v", serviceName, err)
+	}
+
+	// 3. Check it before it gets any traffic. A failed canary is removed
+	// entirely, so no share of the traffic is left pointing at it.
+	fmt.Fprintf(stdout, "🩺 Waiting for %s (canary) to become healthy...\n", serviceName)
+	if err := checkColor(client, container, service, routers, stdout, stderr); err != nil {
+		fmt.Fprintf(stdout, "📜 Last logs of %s (canary):\n", serviceName)
+		client.RunCommand(fmt.Sprintf("sudo docker logs --tail 30 %s", container), stdout, stderr)
+		if rmErr := removeCanary(client, remoteDir, serviceName, stdout, stderr); rmErr != nil {
+			fmt.Fprintf(stdout, "⚠️  %v\n", rmErr)
+		}
+		return fmt.Errorf("%s (canary) failed its checks, the stable version keeps serving all traffic: %v", serviceName, err)
+	}
+
+	// 4. Split the traffic
+	fmt.Fprintf(stdout, "🔀 Sending %d%% of %s's traffic to the canary...\n", weight, serviceName)
+	data, err := canaryDynamicConfig(routers, container, weight)
+	if err != nil {
+		return err
+	}
+	if err := uploadRemoteFile(client, data, canaryDynamicPath(remoteDir, serviceName), fmt.Sprintf("canary-%s-routes.yml", serviceName)); err != nil {
+		return fmt.Errorf("failed to route traffic to the canary: %v", err)
+	}
+
+	state := &CanaryState{Service: serviceName, Weight: weight, Started: time.Now().Format(time.RFC3339), User: currentUser()}
+	stateData, _ := json.MarshalIndent(state, "", "  ")
+	if err := uploadRemoteFile(client, stateData, canaryStatePath(remoteDir, serviceName), fmt.Sprintf("canary-%s.json", serviceName)); err != nil {
+		return fmt.Errorf("the canary receives traffic but its state could not be saved: %v", err)
+	}
+
+	fmt.Fprintf(stdout, "✅ The canary of %s receives %d%% of its traffic\n", serviceName, weight)
+	fmt.Fprintf(stdout, "💡 Promote it with 'graft canary promote %s' or remove it with 'graft canary abort %s'\n", serviceName, serviceName)
+	return nil
+}
+
+// removeCanary stops routing traffic to a service's canary, then removes
+// its containers and files
+func removeCanary(client ssh.Remote, remoteDir, serviceName string, stdout, stderr io.Writer) error {
+	cmd := fmt.Sprintf("rm -f %s && sleep %d && (%s down) && rm -f %s %s %s",
+		canaryDynamicPath(remoteDir, serviceName), swapSettleDelay, canaryCompose(remoteDir, serviceName),
+		canaryRunPath(remoteDir, serviceName), canaryComposePath(remoteDir, serviceName), canaryStatePath(remoteDir, serviceName))
+	if err := client.RunCommand(cmd, stdout, stderr); err != nil {
+		return fmt.Errorf("failed to remove the canary of %s: %v", serviceName, err)
+	}
+	return nil
+}
+
+// PromoteCanary makes a service's canary its stable version: the project
+// switches to the compose file the canary was deployed with, the service is
+// swapped to the new version, and the canary is removed once that is
+// healthy
+func PromoteCanary(client ssh.Remote, p *Project, remoteDir, serviceName string, stdout, stderr io.Writer) (err error) {
+	// Only one deploy of a project and environment runs at a time
+	unlock, err := AcquireLock(client, p.Name, p.Env, ActionCanaryPromote, stdout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := LoadCanary(client, remoteDir, serviceName)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("%s has no canary to promote", serviceName)
+	}
+
+	hist := startHistory(client, p, p.Env, ActionCanaryPromote, remoteDir, serviceName)
+	defer func() { hist.finish(err, stdout) }()
+
+	// 1. Read the service as the canary was deployed, not as it is defined
+	// locally now
+	out, err := client.GetCommandOutput(fmt.Sprintf("cat %s", canaryComposePath(remoteDir, serviceName)))
+	if err != nil {
+		return fmt.Errorf("could not read the compose file of the canary: %v", err)
+	}
+	var compose DockerComposeFile
+	if err := yaml.Unmarshal([]byte(out), &compose); err != nil {
+		return fmt.Errorf("could not parse the compose file of the canary: %v", err)
+	}
+	service, ok := compose.Services[serviceName]
+	if !ok && !isDryRun(client) {
+		return fmt.Errorf("service '%s' not found in the compose file of the canary", serviceName)
+	}
+
+	// 2. Switch the project to it, keeping the current file in case the
+	// swap fails
+	fmt.Fprintf(stdout, "🐤 Promoting the canary of %s (%d%% of its traffic)...\n", serviceName, state.Weight)
+	previous := path.Join(canaryDir(remoteDir), serviceName+".previous.yml")
+	composeFile := path.Join(remoteDir, "docker-compose.yml")
+	switchCmd := fmt.Sprintf("cp %s %s && cp %s %s", composeFile, previous, canaryComposePath(remoteDir, serviceName), composeFile)
+	if err := client.RunCommand(switchCmd, stdout, stderr); err != nil {
+		return fmt.Errorf("failed to update docker-compose.yml: %v", err)
+	}
+	restore := func(cause error) error {
+		client.RunCommand(fmt.Sprintf("mv -f %s %s", previous, composeFile), stdout, stderr)
+		return fmt.Errorf("%v; the canary keeps receiving %d%% of the traffic", cause, state.Weight)
+	}
+
+	// 3. Swap the stable version to the new one. Images were pulled or
+	// loaded for the canary already, and builds reuse its cached layers.
+	if service.Build != nil {
+		fmt.Fprintf(stdout, "🔨 Building %s...\n", serviceName)
+		buildCmd := fmt.Sprintf("cd %s && sudo docker compose build %s", remoteDir, serviceName)
+		if err := client.RunIdempotent(buildCmd, stdout, stderr); err != nil {
+			return restore(fmt.Errorf("build failed: %v", err))
+		}
+	}
+	if err := swapService(client, remoteDir, serviceName, service, stdout, stderr); err != nil {
+		return restore(err)
+	}
+	if err := verifyServices(client, remoteDir, map[string]ComposeService{serviceName: service}, stdout, stderr); err != nil {
+		return restore(err)
+	}
+
+	// 4. Retire the canary
+	fmt.Fprintf(stdout, "🧹 Removing the canary of %s...\n", serviceName)
+	if err := removeCanary(client, remoteDir, serviceName, stdout, stderr); err != nil {
+		return err
+	}
+	client.RunCommand(fmt.Sprintf("rm -f %s", previous), nil, nil)
+
+	fmt.Fprintf(stdout, "✅ %s was promoted, the new version serves all traffic\n", serviceName)
+	runPostDeployHooks(client, remoteDir, map[string]ComposeService{serviceName: service}, hist, stdout, stderr)
+
+	fmt.Fprintln(stdout, "🧹 Cleaning up old images...")
+	if err := client.RunCommand("sudo docker image prune -f", stdout, stderr); err != nil {
+		fmt.Fprintf(stdout, "⚠️  Cleanup warning: %v\n", err)
+	}
+	return nil
+}
+
+// AbortCanary sends all of a service's traffic back to its stable version
+// and removes the canary
+func AbortCanary(client ssh.Remote, p *Project, remoteDir, serviceName string, stdout, stderr io.Writer) (err error) {
+	// Only one deploy of a project and environment runs at a time
+	unlock, err := AcquireLock(client, p.Name, p.Env, ActionCanaryAbort, stdout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := LoadCanary(client, remoteDir, serviceName)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return fmt.Errorf("%s has no canary to abort", serviceName)
+	}
+
+	hist := startHistory(client, p, p.Env, ActionCanaryAbort, remoteDir, serviceName)
+	defer func() { hist.finish(err, stdout) }()
+
+	fmt.Fprintf(stdout, "🔀 Sending all of %s's traffic back to the stable version...\n", serviceName)
+	if err := removeCanary(client, remoteDir, serviceName, stdout, stderr); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "✅ The canary of %s was removed\n", serviceName)
+	return nil
+}
@@ -0,0 +1,221 @@
+package deploy
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skssmd/graft/internal/server/ssh"
+)
+
+// Post-deploy health verification labels
+const (
+	// healthcheckLabel declares an HTTP probe: graft.healthcheck=/healthz
+	// uses the service's Traefik port, graft.healthcheck=:8080/healthz names
+	// the port
+	healthcheckLabel = "graft.healthcheck"
+
+	// healthTimeoutLabel overrides how long a service gets to become healthy,
+	// for example graft.healthcheck.timeout=5m
+	healthTimeoutLabel = "graft.healthcheck.timeout"
+)
+
+// defaultHealthTimeout is how long a service gets to become healthy, or to
+// keep running when it has no healthcheck
+const defaultHealthTimeout = 2 * time.Minute
+
+// waitHealthyScript polls each container until Docker reports it healthy,
+// or, without a healthcheck, until it has kept running without a restart
+// across two checks. Containers that exited with code 0 pass when exits are
+// allowed, as one-off jobs do.
+const waitHealthyScript = `deadline=$(( $(date +%%s) + %d )); ` +
+	`for id in %s; do seen=; ` +
+	`while :; do ` +
+	`set -- $(sudo docker inspect -f '{{.State.Status}} {{.State.ExitCode}} {{.RestartCount}} {{if .State.Health}}{{.State.Health.Status}}{{else}}none{{end}}' $id); ` +
+	`case "$1/$4" in ` +
+	`exited/*) if [ %t = true ] && [ "$2" = 0 ]; then break; fi; echo "container $id exited with code $2"; exit 1 ;; ` +
+	`running/healthy) break ;; ` +
+	`*/unhealthy) echo "container $id is unhealthy"; exit 1 ;; ` +
+	`running/none) [ "$seen" = "$3" ] && break; seen=$3 ;; ` +
+	`running/starting|created/*) ;; ` +
+	`*) echo "container $id is $1"; exit 1 ;; ` +
+	`esac; ` +
+	`if [ $(date +%%s) -ge $deadline ]; then echo "timed out waiting for container $id ($1/$4)"; exit 1; fi; ` +
+	`sleep 2; ` +
+	`done; done`
+
+// probeScript requests a path on each container from inside the Traefik
+// container, which reaches them over graft-public like live traffic does
+const probeScript = `deadline=$(( $(date +%%s) + %d )); ` +
+	`for id in %s; do ` +
+	`name=$(sudo docker inspect -f '{{.Name}}' $id); name=${name#/}; url="http://$name:%s%s"; ` +
+	`until sudo docker exec graft-traefik wget -q -T 5 -O /dev/null "$url"; do ` +
+	`if [ $(date +%%s) -ge $deadline ]; then echo "$url did not answer successfully"; exit 1; fi; ` +
+	`sleep 2; ` +
+	`done; done`
+
+// healthProbe is the HTTP probe declared by the graft.healthcheck label
+type healthProbe struct {
+	Port string
+	Path string
+}
+
+// parseHealthProbe reads the graft.healthcheck label. It returns nil when
+// the service declares no probe.
+func parseHealthProbe(labels []string) (*healthProbe, error) {
+	var value string
+	for _, label := range labels {
+		if v, ok := strings.CutPrefix(label, healthcheckLabel+"="); ok {
+			value = v
+		}
+	}
+	if value == "" {
+		return nil, nil
+	}
+
+	probe := &healthProbe{Path: value}
+	if rest, ok := strings.CutPrefix(value, ":"); ok {
+		port, path, _ := strings.Cut(rest, "/")
+		if _, err := strconv.Atoi(port); err != nil {
+			return nil, fmt.Errorf("invalid port in %s=%s", healthcheckLabel, value)
+		}
+		probe.Port, probe.Path = port, "/"+path
+	}
+	if !strings.HasPrefix(probe.Path, "/") {
+		return nil, fmt.Errorf("%s=%s must be a path such as /healthz or :8080/healthz", healthcheckLabel, value)
+	}
+	if strings.ContainsAny(probe.Path, " '\"`$\\;&|<>") {
+		return nil, fmt.Errorf("invalid characters in %s=%s", healthcheckLabel, value)
+	}
+
+	if probe.Port == "" {
+		for _, label := range labels {
+			key, port, _ := strings.Cut(label, "=")
+			if strings.HasPrefix(key, "traefik.http.services.") && strings.HasSuffix(key, ".loadbalancer.server.port") {
+				probe.Port = port
+				break
+			}
+		}
+		if probe.Port == "" {
+			return nil, fmt.Errorf("%s=%s needs a port (:8080%s) when the service has no traefik loadbalancer port label", healthcheckLabel, value, value)
+		}
+	}
+	return probe, nil
+}
+
+// healthTimeout returns how long a service gets to become healthy
+func healthTimeout(labels []string) (time.Duration, error) {
+	for _, label := range labels {
+		if v, ok := strings.CutPrefix(label, healthTimeoutLabel+"="); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return 0, fmt.Errorf("invalid %s=%s (use a duration such as 90s or 5m)", healthTimeoutLabel, v)
+			}
+			return d, nil
+		}
+	}
+	return defaultHealthTimeout, nil
+}
+
+// validateHealthLabels reports malformed health labels before a deploy starts
+func validateHealthLabels(services map[string]ComposeService) error {
+	for name, s := range services {
+		if _, err := parseHealthProbe(s.Labels); err != nil {
+			return fmt.Errorf("service '%s': %v", name, err)
+		}
+		if _, err := healthTimeout(s.Labels); err != nil {
+			return fmt.Errorf("service '%s': %v", name, err)
+		}
+	}
+	return nil
+}
+
+// verifyContainers waits for the containers of a service to become healthy
+// and then runs its HTTP probe, if it declares one, all within the service's
+// health timeout
+func verifyContainers(client ssh.Remote, ids []string, service ComposeService, allowExited bool, stdout, stderr io.Writer) error {
+	timeout, err := healthTimeout(service.Labels)
+	if err != nil {
+		return err
+	}
+	probe, err := parseHealthProbe(service.Labels)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	waitCmd := fmt.Sprintf(waitHealthyScript, int(timeout.Seconds()), strings.Join(ids, " "), allowExited)
+	if err := client.RunCommand(waitCmd, stdout, stderr); err != nil {
+		return err
+	}
+	if probe == nil {
+		return nil
+	}
+
+	remaining := int((timeout - time.Since(start)).Seconds())
+	if remaining < 10 {
+		remaining = 10
+	}
+	probeCmd := fmt.Sprintf(probeScript, remaining, strings.Join(ids, " "), probe.Port, probe.Path)
+	return client.RunCommand(probeCmd, stdout, stderr)
+}
+
+// verifyServices is the post-deploy check: every container of the given
+// services must become healthy, or keep running, within its timeout
+func verifyServices(client ssh.Remote, remoteDir string, services map[string]ComposeService, stdout, stderr io.Writer) error {
+	var names []string
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(stdout, "🩺 Verifying deployment...")
+	var failed []string
+	for _, name := range names {
+		out, err := client.GetCommandOutput(fmt.Sprintf("cd %s && sudo docker compose ps -a -q %s", remoteDir, name))
+		if err != nil {
+			return fmt.Errorf("could not list containers of %s: %v", name, err)
+		}
+		ids := strings.Fields(out)
+		if len(ids) == 0 {
+			continue
+		}
+		if err := verifyContainers(client, ids, services[name], true, stdout, stderr); err != nil {
+			fmt.Fprintf(stdout, "  ❌ %s: %v\n", name, err)
+			fmt.Fprintf(stdout, "📜 Last logs of %s:\n", name)
+			client.RunCommand(fmt.Sprintf("cd %s && sudo docker compose logs --tail 30 %s", remoteDir, name), stdout, stderr)
+			failed = append(failed, name)
+			continue
+		}
+		fmt.Fprintf(stdout, "  ✅ %s is healthy\n", name)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("unhealthy after deploy: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// rollbackFailedDeploy restores the backup taken at the start of a deploy
+// after it failed, for one service or, when serviceName is empty, the whole
+// project. The deploy's error is returned either way.
+func rollbackFailedDeploy(client ssh.Remote, p *Project, backup, serviceName string, cause error, stdout, stderr io.Writer) error {
+	if backup == "" {
+		fmt.Fprintln(stdout, "⚠️  No rollback backup was taken for this deploy, so nothing is restored. Enable backups with 'graft rollback config'.")
+		return cause
+	}
+
+	fmt.Fprintf(stdout, "\n❌ Deploy failed: %v\n", cause)
+	var err error
+	if serviceName != "" {
+		err = RestoreServiceRollback(client, p, backup, serviceName, stdout, stderr)
+	} else {
+		err = RestoreRollback(client, p, backup, stdout, stderr)
+	}
+	if err != nil {
+		return fmt.Errorf("%v; restoring backup %s also failed: %v", cause, backup, err)
+	}
+	return fmt.Errorf("%v; rolled back to backup %s", cause, backup)
+}
@@ -0,0 +1,213 @@
+package deploy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skssmd/graft/internal/config"
+	"github.com/skssmd/graft/internal/server/git"
+	"github.com/skssmd/graft/internal/server/ssh"
+)
+
+// History actions
+const (
+	ActionSync            = "sync"
+	ActionSyncService     = "sync-service"
+	ActionSyncCompose     = "sync-compose"
+	ActionRollback        = "rollback"
+	ActionRollbackService = "rollback-service"
+	ActionWebhook         = "webhook"
+	ActionCanary          = "canary"
+	ActionCanaryPromote   = "canary-promote"
+	ActionCanaryAbort     = "canary-abort"
+	ActionHostClean       = "host-clean"
+)
+
+// History results
+const (
+	ResultSuccess = "success"
+	ResultFailed  = "failed"
+)
+
+// HistoryEntry is one deploy in a project's history. The deploy workflows
+// generated for git-based modes append webhook entries in the same format.
+type HistoryEntry struct {
+	Time      string            `json:"time" yaml:"time"`
+	Action    string            `json:"action" yaml:"action"`
+	User      string            `json:"user" yaml:"user"`
+	Host      string            `json:"host" yaml:"host"`
+	Env       string            `json:"env,omitempty" yaml:"env,omitempty"`
+	GitBranch string            `json:"git_branch,omitempty" yaml:"git_branch,omitempty"`
+	GitCommit string            `json:"git_commit,omitempty" yaml:"git_commit,omitempty"`
+	GitDirty  bool              `json:"git_dirty,omitempty" yaml:"git_dirty,omitempty"`
+	Services  []string          `json:"services" yaml:"services"`
+	Images    map[string]string `json:"images,omitempty" yaml:"images,omitempty"`
+	Backup    string            `json:"backup,omitempty" yaml:"backup,omitempty"`
+	Hooks     []HookResult      `json:"hooks,omitempty" yaml:"hooks,omitempty"`
+	Duration  float64           `json:"duration_seconds" yaml:"duration_seconds"`
+	Result    string            `json:"result" yaml:"result"`
+	Error     string            `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// historyPath is the history file of a project on the server
+func historyPath(project string) string {
+	return path.Join(config.RemoteHistoryDir, project+".jsonl")
+}
+
+// historyRecord tracks a deploy until it is appended to the history
+type historyRecord struct {
+	client    ssh.Remote
+	project   string
+	remoteDir string
+	start     time.Time
+	entry     HistoryEntry
+}
+
+// startHistory begins the history entry of a deploy. Who deployed is taken
+// from this machine, the commit from the working directory's git repository;
+// deploys from a git export overwrite it.
+func startHistory(client ssh.Remote, p *Project, env, action, remoteDir string, services ...string) *historyRecord {
+	h := &historyRecord{
+		client:    client,
+		project:   p.Name,
+		remoteDir: remoteDir,
+		start:     time.Now(),
+		entry: HistoryEntry{
+			Action:   action,
+			User:     currentUser(),
+			Env:      env,
+			Services: services,
+		},
+	}
+	h.entry.Time = h.start.UTC().Format(time.RFC3339)
+	h.entry.Host, _ = os.Hostname()
+
+	if git.HasGitRepo(".") {
+		h.entry.GitBranch, _ = git.GetCurrentBranch(".")
+		h.entry.GitCommit, _ = git.GetLatestCommit(".", "HEAD")
+		h.entry.GitDirty, _ = git.IsDirty(".")
+	}
+	return h
+}
+
+// setGit records the commit a deploy was exported from
+func (h *historyRecord) setGit(branch, commit string) {
+	h.entry.GitBranch, h.entry.GitCommit, h.entry.GitDirty = branch, commit, false
+}
+
+// addHook records a hook run. hist may be nil when nothing is recorded.
+func (h *historyRecord) addHook(result HookResult) {
+	if h != nil {
+		h.entry.Hooks = append(h.entry.Hooks, result)
+	}
+}
+
+// finish records the outcome and the images the services now run, then
+// appends the entry. A history that cannot be written only prints a warning.
+func (h *historyRecord) finish(err error, stdout io.Writer) {
+	h.entry.Duration = time.Since(h.start).Round(100 * time.Millisecond).Seconds()
+	h.entry.Result = ResultSuccess
+	if err != nil {
+		h.entry.Result = ResultFailed
+		h.entry.Error = err.Error()
+	}
+	h.entry.Images = runningImages(h.client, h.remoteDir, h.entry.Services)
+	if len(h.entry.Services) == 0 {
+		// Whole-project deploys touch every service that is running now
+		h.entry.Services = []string{}
+		for service := range h.entry.Images {
+			h.entry.Services = append(h.entry.Services, service)
+		}
+		sort.Strings(h.entry.Services)
+	}
+
+	if err := appendHistory(h.client, h.project, h.entry); err != nil {
+		fmt.Fprintf(stdout, "⚠️  Could not record deploy in history: %v\n", err)
+	}
+}
+
+// runningImages returns the image each of the given services runs, or of
+// every service when services is empty. Images pulled from a registry are
+// recorded by repo digest, which stays valid after the image is removed;
+// locally built ones by image ID.
+func runningImages(client ssh.Remote, remoteDir string, services []string) map[string]string {
+	cmd := fmt.Sprintf("cd %s && sudo docker compose ps -a -q | xargs -r sudo docker inspect -f '{{index .Config.Labels \"com.docker.compose.service\"}} {{.Image}}' | "+
+		"while read service image; do "+
+		"digest=$(sudo docker image inspect -f '{{if .RepoDigests}}{{index .RepoDigests 0}}{{end}}' \"$image\" 2>/dev/null); "+
+		"echo \"$service ${digest:-$image}\"; "+
+		"done", remoteDir)
+	out, err := client.GetCommandOutput(cmd)
+	if err != nil {
+		return nil
+	}
+	wanted := make(map[string]bool)
+	for _, s := range services {
+		wanted[s] = true
+	}
+	images := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		service, image, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok || len(wanted) > 0 && !wanted[service] {
+			continue
+		}
+		images[service] = image
+	}
+	if len(images) == 0 {
+		return nil
+	}
+	return images
+}
+
+// appendHistory adds an entry to the end of a project's history
+func appendHistory(client ssh.Remote, project string, entry HistoryEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	dir := config.RemoteHistoryDir
+	if err := client.RunCommand(fmt.Sprintf("sudo mkdir -p %s && sudo chown $USER:$USER %s", dir, dir), nil, nil); err != nil {
+		return err
+	}
+	return client.StreamCommand(fmt.Sprintf("cat >> %s", historyPath(project)), bytes.NewReader(append(line, '\n')), nil, nil)
+}
+
+// LoadHistory reads a project's history, oldest entry first. Lines that are
+// not valid entries are skipped.
+func LoadHistory(client ssh.Remote, project string) ([]HistoryEntry, error) {
+	out, err := client.GetCommandOutput(fmt.Sprintf("cat %s 2>/dev/null || true", historyPath(project)))
+	if err != nil {
+		return nil, fmt.Errorf("could not read history: %v", err)
+	}
+	var entries []HistoryEntry
+	for _, line := range strings.Split(out, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var entry HistoryEntry
+		if json.Unmarshal([]byte(line), &entry) != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time < entries[j].Time })
+	return entries, nil
+}
+
+// currentUser names the person deploying from this machine
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
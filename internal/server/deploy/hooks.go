@@ -0,0 +1,150 @@
+package deploy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skssmd/graft/internal/server/ssh"
+)
+
+// Deploy hook labels. Each runs its command in a one-off container of the
+// service: pre-deploy once the new image is built, before it gets traffic,
+// and post-deploy once it is live and healthy.
+const (
+	PreDeployHook  = "pre-deploy"
+	PostDeployHook = "post-deploy"
+
+	hookLabelPrefix = "graft.hooks."
+)
+
+// hookOutputLines is how much of a hook's output is kept in the history
+const hookOutputLines = 20
+
+// HookResult is one hook run recorded in the deploy's history
+type HookResult struct {
+	Service  string  `json:"service" yaml:"service"`
+	Stage    string  `json:"stage" yaml:"stage"`
+	Command  string  `json:"command" yaml:"command"`
+	ExitCode int     `json:"exit_code" yaml:"exit_code"`
+	Duration float64 `json:"duration_seconds" yaml:"duration_seconds"`
+	Output   string  `json:"output,omitempty" yaml:"output,omitempty"`
+}
+
+// hookCommand returns the command a service declares for a hook stage, or ""
+func hookCommand(labels []string, stage string) string {
+	for _, label := range labels {
+		if cmd, ok := strings.CutPrefix(label, hookLabelPrefix+stage+"="); ok {
+			return strings.TrimSpace(cmd)
+		}
+	}
+	return ""
+}
+
+// runHook runs a service's hook for stage with `docker compose run --rm`.
+// compose is the docker compose command of the project the service runs in.
+// The run is added to the deploy's history; a failing hook returns an error.
+func runHook(client ssh.Remote, compose, serviceName string, service ComposeService, stage string, hist *historyRecord, stdout, stderr io.Writer) error {
+	command := hookCommand(service.Labels, stage)
+	if command == "" {
+		return nil
+	}
+
+	fmt.Fprintf(stdout, "🪝 Running %s hook of %s: %s\n", stage, serviceName, command)
+	output := &hookOutput{}
+	start := time.Now()
+	runCmd := fmt.Sprintf("%s run --rm --no-deps -T %s sh -c %s", compose, serviceName, shellQuote(command))
+	err := client.RunCommand(runCmd, io.MultiWriter(stdout, output), io.MultiWriter(stderr, output))
+
+	result := HookResult{
+		Service:  serviceName,
+		Stage:    stage,
+		Command:  command,
+		Duration: time.Since(start).Round(100 * time.Millisecond).Seconds(),
+		Output:   lastLines(output.String(), hookOutputLines),
+	}
+	if err != nil {
+		result.ExitCode = -1
+		if status, ok := ssh.ExitStatus(err); ok {
+			result.ExitCode = status
+		}
+	}
+	hist.addHook(result)
+
+	if err != nil {
+		fmt.Fprintf(stdout, "  ❌ %s hook of %s exited with code %d\n", stage, serviceName, result.ExitCode)
+		return fmt.Errorf("%s hook of %s failed: %v", stage, serviceName, err)
+	}
+	fmt.Fprintf(stdout, "  ✅ %s hook of %s finished\n", stage, serviceName)
+	return nil
+}
+
+// runPreDeployHooks runs the pre-deploy hooks of the given services in name
+// order and stops at the first that fails
+func runPreDeployHooks(client ssh.Remote, remoteDir string, services map[string]ComposeService, hist *historyRecord, stdout, stderr io.Writer) error {
+	compose := fmt.Sprintf("cd %s && sudo docker compose", remoteDir)
+	for _, name := range sortedServices(services) {
+		if err := runHook(client, compose, name, services[name], PreDeployHook, hist, stdout, stderr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runPostDeployHooks runs the post-deploy hooks of the given services. The
+// deploy is already live, so failures are only reported.
+func runPostDeployHooks(client ssh.Remote, remoteDir string, services map[string]ComposeService, hist *historyRecord, stdout, stderr io.Writer) {
+	compose := fmt.Sprintf("cd %s && sudo docker compose", remoteDir)
+	for _, name := range sortedServices(services) {
+		if err := runHook(client, compose, name, services[name], PostDeployHook, hist, stdout, stderr); err != nil {
+			fmt.Fprintf(stdout, "⚠️  %v\n", err)
+		}
+	}
+}
+
+// sortedServices returns the names of services in order
+func sortedServices(services map[string]ComposeService) []string {
+	var names []string
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hookOutput collects a hook's stdout and stderr, which are written
+// concurrently
+type hookOutput struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (o *hookOutput) Write(b []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.Write(b)
+}
+
+func (o *hookOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.String()
+}
+
+// lastLines returns at most n trailing lines of s
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// shellQuote quotes s as a single shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,297 @@
+package deploy
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/skssmd/graft/internal/server/ssh"
+)
+
+// remoteLayersScript prints the layers of every image on the server, one
+// JSON array per image
+const remoteLayersScript = `ids=$(sudo docker image ls -q | sort -u); ` +
+	`[ -z "$ids" ] || sudo docker image inspect -f '{{json .RootFS.Layers}}' $ids`
+
+// isLocalBuild reports whether a service is built on this machine and
+// streamed to the server instead of being built there
+func isLocalBuild(service ComposeService) bool {
+	return service.Build != nil && getGraftMode(service.Labels) == "localbuild"
+}
+
+// localImageTag is the tag a localbuild service's image is loaded under on
+// the server
+func localImageTag(remoteProjName, serviceName string) string {
+	return strings.ToLower(fmt.Sprintf("graft-local/%s-%s:latest", remoteProjName, serviceName))
+}
+
+// useLocalImage points a localbuild service at its loaded image. The server
+// never pulls or builds it, so a missing image fails instead of reaching out
+// to a registry.
+func useLocalImage(remoteProjName, serviceName string, s *ComposeService) {
+	other := make(map[string]interface{}, len(s.OtherFields)+1)
+	for k, v := range s.OtherFields {
+		other[k] = v
+	}
+	other["pull_policy"] = "never"
+	s.OtherFields = other
+	s.Image = localImageTag(remoteProjName, serviceName)
+	s.Build = nil
+}
+
+// pullable reports whether the server should pull a service's image
+func pullable(service ComposeService) bool {
+	policy, _ := service.OtherFields["pull_policy"].(string)
+	return service.Image != "" && service.Build == nil && policy != "never"
+}
+
+// buildLocalImage builds a localbuild service with the local Docker daemon
+// for the server's platform and loads it on the server, sending only the
+// layers the server does not have yet. contextPath is the local build
+// context, which differs from the compose file's when deploying from git.
+func buildLocalImage(client ssh.Remote, remoteProjName, serviceName string, service ComposeService, contextPath string, noCache bool, stdout, stderr io.Writer) error {
+	tag := localImageTag(remoteProjName, serviceName)
+
+	// 1. Build for the server's platform
+	if _, err := exec.LookPath("docker"); err != nil {
+		return fmt.Errorf("localbuild needs Docker on this machine: %v", err)
+	}
+	if _, err := os.Stat(contextPath); os.IsNotExist(err) {
+		return fmt.Errorf("build context directory not found: %s\n👉 Please ensure the directory exists or update 'context' in your graft.yml file.", contextPath)
+	}
+	platform, err := serverPlatform(client)
+	if err != nil {
+		return err
+	}
+	if isDryRun(client) {
+		fmt.Fprintf(stdout, "🔨 Would build %s locally for %s\n", serviceName, platform)
+		return client.StreamCommand("sudo docker load", nil, stdout, stderr)
+	}
+
+	fmt.Fprintf(stdout, "🔨 Building %s locally for %s...\n", serviceName, platform)
+	args := []string{"build", "--platform", platform, "-t", tag}
+	if service.Build.Dockerfile != "" {
+		args = append(args, "-f", filepath.Join(contextPath, service.Build.Dockerfile))
+	}
+	if noCache {
+		args = append(args, "--no-cache")
+	}
+	build := exec.Command("docker", append(args, contextPath)...)
+	build.Stdout = stdout
+	build.Stderr = stderr
+	if err := build.Run(); err != nil {
+		return fmt.Errorf("local build failed: %v", err)
+	}
+
+	// 2. Find the layers the server is missing
+	layers, err := localLayers(tag)
+	if err != nil {
+		return err
+	}
+	present, err := remoteChainIDs(client)
+	if err != nil {
+		return err
+	}
+	skip := make(map[int]bool)
+	for i, chainID := range chainIDs(layers) {
+		if present[chainID] {
+			skip[i] = true
+		}
+	}
+
+	// 3. Export the image and stream the missing layers
+	tmpFile, err := os.CreateTemp("", "graft-image-*.tar")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	save := exec.Command("docker", "save", "-o", tmpFile.Name(), tag)
+	save.Stderr = stderr
+	if err := save.Run(); err != nil {
+		return fmt.Errorf("failed to export image %s: %v", tag, err)
+	}
+
+	fmt.Fprintf(stdout, "📤 Sending %s to the server (%d of %d layers are new)...\n", serviceName, len(layers)-len(skip), len(layers))
+	err = loadImage(client, tmpFile.Name(), skip, stdout, stderr)
+	if err != nil && len(skip) > 0 {
+		// Servers using the containerd image store need every layer
+		fmt.Fprintf(stdout, "⚠️  The server could not load the partial image (%v), sending all layers...\n", err)
+		err = loadImage(client, tmpFile.Name(), nil, stdout, stderr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load image on the server: %v", err)
+	}
+	return nil
+}
+
+// serverPlatform returns the platform of the server's Docker daemon, for
+// example linux/arm64
+func serverPlatform(client ssh.Remote) (string, error) {
+	out, err := client.GetCommandOutput("sudo docker version -f '{{.Server.Os}}/{{.Server.Arch}}'")
+	if err != nil {
+		return "", fmt.Errorf("could not determine the server's platform: %v", err)
+	}
+	platform := strings.TrimSpace(out)
+	if platform == "" {
+		if isDryRun(client) {
+			return "linux/amd64", nil
+		}
+		return "", fmt.Errorf("could not determine the server's platform")
+	}
+	return platform, nil
+}
+
+// localLayers returns the layer diff IDs of a local image, base layer first
+func localLayers(tag string) ([]string, error) {
+	out, err := exec.Command("docker", "image", "inspect", "-f", "{{json .RootFS.Layers}}", tag).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image %s: %v", tag, err)
+	}
+	var layers []string
+	if err := json.Unmarshal(out, &layers); err != nil {
+		return nil, fmt.Errorf("failed to read layers of %s: %v", tag, err)
+	}
+	return layers, nil
+}
+
+// remoteChainIDs returns the chain IDs of every layer stored on the server
+func remoteChainIDs(client ssh.Remote) (map[string]bool, error) {
+	out, err := client.GetCommandOutput(remoteLayersScript)
+	if err != nil {
+		return nil, fmt.Errorf("could not list the server's image layers: %v", err)
+	}
+	present := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var layers []string
+		if json.Unmarshal([]byte(line), &layers) != nil {
+			continue
+		}
+		for _, chainID := range chainIDs(layers) {
+			present[chainID] = true
+		}
+	}
+	return present, nil
+}
+
+// chainIDs turns layer diff IDs into chain IDs, which identify a layer
+// together with everything below it as Docker's layer store does
+func chainIDs(diffIDs []string) []string {
+	ids := make([]string, len(diffIDs))
+	for i, diffID := range diffIDs {
+		if i == 0 {
+			ids[i] = diffID
+			continue
+		}
+		sum := sha256.Sum256([]byte(ids[i-1] + " " + diffID))
+		ids[i] = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return ids
+}
+
+// loadImage streams a `docker save` archive into `docker load` on the
+// server, leaving out the layers at the indexes in skip. docker load only
+// reads a layer's file when the server does not have the layer yet.
+func loadImage(client ssh.Remote, archive string, skip map[int]bool, stdout, stderr io.Writer) error {
+	omit, err := skippedLayerFiles(archive, skip)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeFilteredArchive(pw, archive, omit))
+	}()
+	err = client.StreamCommand("sudo docker load", pr, stdout, stderr)
+	pr.CloseWithError(io.ErrClosedPipe)
+	return err
+}
+
+// skippedLayerFiles maps the layer indexes in skip to their file names in
+// the archive's manifest.json
+func skippedLayerFiles(archive string, skip map[int]bool) (map[string]bool, error) {
+	omit := make(map[string]bool)
+	if len(skip) == 0 {
+		return omit, nil
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("image archive has no manifest.json")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image archive: %v", err)
+		}
+		if hdr.Name != "manifest.json" {
+			continue
+		}
+		var manifest []struct {
+			Layers []string `json:"Layers"`
+		}
+		if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+			return nil, fmt.Errorf("failed to read manifest.json: %v", err)
+		}
+		if len(manifest) != 1 {
+			return nil, fmt.Errorf("expected one image in the archive, found %d", len(manifest))
+		}
+		for i, layer := range manifest[0].Layers {
+			if skip[i] {
+				omit[layer] = true
+			}
+		}
+		return omit, nil
+	}
+}
+
+// writeFilteredArchive copies the archive to w, gzipped, without the files
+// in omit
+func writeFilteredArchive(w io.Writer, archive string, omit map[string]bool) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if omit[hdr.Name] {
+			continue
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,319 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/skssmd/graft/internal/config"
+	"github.com/skssmd/graft/internal/server/ssh"
+)
+
+// lockHeartbeat is how often a running deploy renews its lock
+const lockHeartbeat = 30 * time.Second
+
+// staleLockAfter is how long a lock may go without being renewed before
+// another deploy may take it over
+const staleLockAfter = 3 * time.Minute
+
+// DeployLock is the lock file that keeps two deploys of the same project and
+// environment from running at once
+type DeployLock struct {
+	ID      string `json:"id" yaml:"id"`
+	Owner   string `json:"owner" yaml:"owner"`
+	Host    string `json:"host" yaml:"host"`
+	PID     int    `json:"pid" yaml:"pid"`
+	Action  string `json:"action" yaml:"action"`
+	Started string `json:"started" yaml:"started"`
+
+	// Idle is how long ago the holder last renewed the lock
+	Idle time.Duration `json:"-" yaml:"-"`
+}
+
+// Stale reports whether the holder of the lock is gone: it stopped renewing
+// the lock, or it ran on this machine and its process has exited
+func (l *DeployLock) Stale() bool {
+	if l.Idle > staleLockAfter {
+		return true
+	}
+	hostname, _ := os.Hostname()
+	return l.Host == hostname && l.PID > 0 && !processRunning(l.PID)
+}
+
+// String describes who holds the lock
+func (l *DeployLock) String() string {
+	since := l.Started
+	if t, err := time.Parse(time.RFC3339, l.Started); err == nil {
+		since = t.Local().Format("2006-01-02 15:04:05")
+	}
+	return fmt.Sprintf("%s@%s (pid %d, %s) since %s", l.Owner, l.Host, l.PID, l.Action, since)
+}
+
+// heldLocks counts the locks this process holds, so a rollback inside a
+// failed deploy reuses the deploy's lock
+var (
+	heldLocks   = make(map[string]int)
+	heldLocksMu sync.Mutex
+)
+
+// lockPath is the lock file of a project and environment on the server
+func lockPath(project, env string) string {
+	name := project
+	if env != "" && !strings.HasSuffix(name, "-"+env) {
+		name = fmt.Sprintf("%s-%s", name, env)
+	}
+	return path.Join(config.RemoteLocksDir, name+".lock")
+}
+
+// AcquireLock takes the deploy lock of a project and environment, taking
+// over a stale one, and renews it until the returned release is called. It
+// fails when another deploy holds the lock.
+func AcquireLock(client ssh.Remote, project, env, action string, stdout io.Writer) (func(), error) {
+	lockFile := lockPath(project, env)
+
+	heldLocksMu.Lock()
+	if heldLocks[lockFile] > 0 {
+		heldLocks[lockFile]++
+		heldLocksMu.Unlock()
+		return func() { releaseHeld(lockFile) }, nil
+	}
+	heldLocksMu.Unlock()
+
+	idBytes := make([]byte, 8)
+	rand.Read(idBytes)
+	lock := DeployLock{
+		ID:      hex.EncodeToString(idBytes),
+		Owner:   currentUser(),
+		PID:     os.Getpid(),
+		Action:  action,
+		Started: time.Now().UTC().Format(time.RFC3339),
+	}
+	lock.Host, _ = os.Hostname()
+	data, err := json.Marshal(lock)
+	if err != nil {
+		return nil, err
+	}
+
+	// The shell's noclobber option creates the file only if it does not exist
+	dir := config.RemoteLocksDir
+	createCmd := fmt.Sprintf("sudo mkdir -p %s && sudo chown $USER:$USER %s && (set -C; cat > %s) 2>/dev/null", dir, dir, lockFile)
+	for attempt := 0; ; attempt++ {
+		err := client.StreamCommand(createCmd, bytes.NewReader(append(data, '\n')), nil, nil)
+		if err == nil {
+			break
+		}
+		held, readErr := ReadLock(client, project, env)
+		if readErr != nil {
+			return nil, readErr
+		}
+		if attempt > 0 {
+			if held == nil {
+				return nil, fmt.Errorf("could not take the deploy lock %s: %v", lockFile, err)
+			}
+			return nil, lockedError(held)
+		}
+		if held == nil {
+			continue // released in the meantime
+		}
+		if !held.Stale() {
+			return nil, lockedError(held)
+		}
+		fmt.Fprintf(stdout, "⚠️  Taking over the stale deploy lock of %s\n", held)
+		removeLock(client, lockFile, held.ID)
+	}
+
+	heldLocksMu.Lock()
+	heldLocks[lockFile] = 1
+	heldLocksMu.Unlock()
+
+	// Renew the lock so other deploys can tell it is still in use
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if isDryRun(client) {
+			return
+		}
+		ticker := time.NewTicker(lockHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				client.RunCommand(fmt.Sprintf("touch -c %s", lockFile), nil, nil)
+			}
+		}
+	}()
+
+	return func() {
+		if !releaseHeld(lockFile) {
+			return
+		}
+		close(stop)
+		<-done
+		removeLock(client, lockFile, lock.ID)
+	}, nil
+}
+
+// AcquireHostLock takes the deploy lock of every project on the server, for
+// host-wide work that no deploy may overlap. The projects are those in the
+// server's project registry and any that hold a lock now. It fails, and
+// releases what it took, when a deploy of any project is running.
+func AcquireHostLock(client ssh.Remote, action string, stdout io.Writer) (func(), error) {
+	names, err := lockedProjects(client)
+	if err != nil {
+		return nil, err
+	}
+	var releases []func()
+	release := func() {
+		for i := len(releases) - 1; i >= 0; i-- {
+			releases[i]()
+		}
+	}
+	for _, name := range names {
+		unlock, err := AcquireLock(client, name, "", action, stdout)
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		releases = append(releases, unlock)
+	}
+	return release, nil
+}
+
+// lockedProjects lists the project-environment names on the server that
+// deploy locks are taken for
+func lockedProjects(client ssh.Remote) ([]string, error) {
+	out, err := client.GetCommandOutput(fmt.Sprintf("cat %s 2>/dev/null || true", config.RemoteProjectsPath))
+	if err != nil {
+		return nil, fmt.Errorf("could not read the project registry: %v", err)
+	}
+	seen := make(map[string]bool)
+	if strings.TrimSpace(out) != "" {
+		var projects map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(out), &projects); err != nil {
+			return nil, fmt.Errorf("could not parse %s: %v", config.RemoteProjectsPath, err)
+		}
+		for name := range projects {
+			seen[name] = true
+		}
+	}
+
+	out, err = client.GetCommandOutput(fmt.Sprintf("ls -1 %s 2>/dev/null || true", config.RemoteLocksDir))
+	if err != nil {
+		return nil, fmt.Errorf("could not list the deploy locks: %v", err)
+	}
+	for _, file := range strings.Fields(out) {
+		if name, ok := strings.CutSuffix(file, ".lock"); ok {
+			seen[name] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// releaseHeld drops one hold on a lock and reports whether it was the last
+func releaseHeld(lockFile string) bool {
+	heldLocksMu.Lock()
+	defer heldLocksMu.Unlock()
+	heldLocks[lockFile]--
+	if heldLocks[lockFile] > 0 {
+		return false
+	}
+	delete(heldLocks, lockFile)
+	return true
+}
+
+// removeLock deletes the lock file if it still holds the lock with the
+// given ID. It also runs after Ctrl+C, so a cancelled deploy frees its lock.
+func removeLock(client ssh.Remote, lockFile, id string) {
+	cmd := fmt.Sprintf("grep -q '\"id\":\"%s\"' %s 2>/dev/null && rm -f %s; true", id, lockFile, lockFile)
+	if c, ok := client.(interface {
+		RunCommandContext(ctx context.Context, cmd string, stdout, stderr io.Writer) error
+	}); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		c.RunCommandContext(ctx, cmd, nil, nil)
+		return
+	}
+	client.RunCommand(cmd, nil, nil)
+}
+
+// ReadLock returns the deploy lock of a project and environment, or nil when
+// no deploy holds it
+func ReadLock(client ssh.Remote, project, env string) (*DeployLock, error) {
+	lockFile := lockPath(project, env)
+	cmd := fmt.Sprintf(`f=%s; [ -f "$f" ] || exit 0; cat "$f"; echo; echo $(( $(date +%%s) - $(stat -c %%Y "$f") ))`, lockFile)
+	out, err := client.GetCommandOutput(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the deploy lock: %v", err)
+	}
+	// The lock is one line of JSON, followed by its age in seconds. A lock
+	// whose holder cannot be read still counts as held.
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if lines[0] == "" {
+		return nil, nil
+	}
+	var lock DeployLock
+	if len(lines) < 2 || json.Unmarshal([]byte(lines[0]), &lock) != nil {
+		lock = DeployLock{Owner: "unknown", Host: "unknown", Action: "unknown"}
+	}
+	if idle, err := strconv.Atoi(strings.TrimSpace(lines[len(lines)-1])); err == nil {
+		lock.Idle = time.Duration(idle) * time.Second
+	}
+	return &lock, nil
+}
+
+// BreakLock removes the deploy lock of a project and environment whoever
+// holds it, and returns the lock it removed
+func BreakLock(client ssh.Remote, project, env string) (*DeployLock, error) {
+	lock, err := ReadLock(client, project, env)
+	if err != nil || lock == nil {
+		return lock, err
+	}
+	if err := client.RunCommand(fmt.Sprintf("rm -f %s", lockPath(project, env)), nil, nil); err != nil {
+		return nil, fmt.Errorf("could not remove the deploy lock: %v", err)
+	}
+	return lock, nil
+}
+
+// lockedError explains that another deploy holds the lock
+func lockedError(held *DeployLock) error {
+	return fmt.Errorf("another deploy is running: locked by %s\n👉 Wait for it to finish, check it with 'graft lock status' or remove the lock with 'graft lock break'", held)
+}
+
+// processRunning reports whether a process on this machine is still running
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		// FindProcess only succeeds for running processes on Windows
+		p.Release()
+		return true
+	}
+	// Processes of other users cannot be signalled but still run
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
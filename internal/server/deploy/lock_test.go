@@ -0,0 +1,78 @@
+package deploy_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/skssmd/graft/internal/server/deploy"
+)
+
+func TestAcquireHostLock(t *testing.T) {
+	tests := []struct {
+		name     string
+		projects string
+		locks    string
+		held     string
+		want     []string
+		wantErr  string
+	}{
+		{
+			name:     "locks every registered project",
+			projects: `{"api-prod": {"path": "/opt/graft/projects/api-prod"}, "web-dev": "/opt/graft/projects/web-dev"}`,
+			want:     []string{"api-prod", "web-dev"},
+		},
+		{
+			name:     "includes unregistered locks",
+			projects: `{"api-prod": {"path": "/opt/graft/projects/api-prod"}}`,
+			locks:    "old-prod.lock\n",
+			want:     []string{"api-prod", "old-prod"},
+		},
+		{
+			name:     "fails while a project deploys",
+			projects: `{"api-prod": {"path": "/opt/graft/projects/api-prod"}, "web-dev": "/opt/graft/projects/web-dev"}`,
+			held:     "web-dev",
+			wantErr:  "web-dev: another deploy is running",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, client := newTestProject(t)
+			srv.Respond("cat /opt/graft/config/projects.json", tt.projects, 0)
+			srv.Respond("ls -1 /opt/graft/locks", tt.locks, 0)
+			if tt.held != "" {
+				lockFile := "/opt/graft/locks/" + tt.held + ".lock"
+				srv.Respond("cat > "+lockFile, "", 1)
+				srv.Respond("f="+lockFile, `{"id":"1","owner":"alice","host":"laptop","pid":1,"action":"sync"}`+"\n5\n", 0)
+			}
+
+			unlock, err := deploy.AcquireHostLock(client, deploy.ActionHostClean, io.Discard)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("AcquireHostLock error = %v, want %q", err, tt.wantErr)
+				}
+				// Locks taken before the failure are released again
+				if !ran(srv.Commands(), "rm -f /opt/graft/locks/api-prod.lock") {
+					t.Errorf("api-prod lock was not released: %q", srv.Commands())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AcquireHostLock: %v", err)
+			}
+			unlock()
+
+			cmds := srv.Commands()
+			for _, name := range tt.want {
+				lockFile := "/opt/graft/locks/" + name + ".lock"
+				if !ran(cmds, "(set -C; cat > "+lockFile+")") {
+					t.Errorf("%s was not locked: %q", name, cmds)
+				}
+				if !ran(cmds, "rm -f "+lockFile) {
+					t.Errorf("%s was not released: %q", name, cmds)
+				}
+			}
+		})
+	}
+}
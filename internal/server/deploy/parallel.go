@@ -0,0 +1,149 @@
+package deploy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// DefaultParallel is how many services are uploaded and built at once when
+// --parallel is not given
+const DefaultParallel = 4
+
+// prefixColors cycles through the ANSI colors used for service prefixes
+var prefixColors = []string{"36", "33", "35", "32", "34", "31", "96", "93", "95", "92"}
+
+// runParallel runs task for every service in names with at most limit
+// running at once. With more than one worker each task writes through its
+// own writers, which prefix every line with the service name so interleaved
+// output stays readable. Once a task fails no new ones are started; the
+// failures of the tasks that ran are returned together.
+func runParallel(names []string, limit int, stdout, stderr io.Writer, task func(name string, stdout, stderr io.Writer) error) error {
+	if limit > len(names) {
+		limit = len(names)
+	}
+	if limit <= 1 {
+		for _, name := range names {
+			if err := task(name, stdout, stderr); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		return nil
+	}
+
+	width := 0
+	for _, name := range names {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	color := useColor(stdout)
+	var mu sync.Mutex // one line at a time across all services
+
+	errs := make([]error, len(names))
+	var failed bool
+	var failedMu sync.Mutex
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < limit; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				name := names[i]
+				prefix := fmt.Sprintf("%-*s | ", width, name)
+				if color {
+					prefix = fmt.Sprintf("\033[%sm%s\033[0m", prefixColors[i%len(prefixColors)], prefix)
+				}
+				out := &prefixWriter{w: stdout, prefix: prefix, mu: &mu}
+				errOut := &prefixWriter{w: stderr, prefix: prefix, mu: &mu}
+				err := task(name, out, errOut)
+				out.Flush()
+				errOut.Flush()
+				if err != nil {
+					errs[i] = err
+					failedMu.Lock()
+					failed = true
+					failedMu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range names {
+		failedMu.Lock()
+		stop := failed
+		failedMu.Unlock()
+		if stop {
+			break
+		}
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	var messages []string
+	for i, err := range errs {
+		if err != nil {
+			messages = append(messages, fmt.Sprintf("%s: %v", names[i], err))
+		}
+	}
+	if len(messages) > 0 {
+		return fmt.Errorf("%s", strings.Join(messages, "; "))
+	}
+	return nil
+}
+
+// useColor reports whether w is a terminal that should get colored output
+func useColor(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && os.Getenv("NO_COLOR") == "" && term.IsTerminal(int(f.Fd()))
+}
+
+// prefixWriter writes complete lines to w, each starting with prefix. mu is
+// shared by every writer of a parallel run.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	mu     *sync.Mutex
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	if p.w == nil {
+		return len(b), nil
+	}
+	p.buf = append(p.buf, b...)
+	for {
+		// Progress output redraws a line with \r; treat it as a line end
+		i := bytes.IndexAny(p.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		line := p.buf[:i]
+		p.buf = p.buf[i+1:]
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		p.writeLine(line)
+	}
+	return len(b), nil
+}
+
+// Flush writes a trailing line that has no newline yet
+func (p *prefixWriter) Flush() {
+	if p.w != nil && len(bytes.TrimSpace(p.buf)) > 0 {
+		p.writeLine(p.buf)
+	}
+	p.buf = nil
+}
+
+func (p *prefixWriter) writeLine(line []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(p.w, "%s%s\n", p.prefix, line)
+}
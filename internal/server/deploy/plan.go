@@ -0,0 +1,412 @@
+package deploy
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/skssmd/graft/internal/config"
+	"github.com/skssmd/graft/internal/server/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+// Plan actions: what the next sync does with a service's containers
+const (
+	PlanCreate   = "create"
+	PlanRecreate = "recreate"
+	PlanRemove   = "remove"
+	PlanRebuild  = "rebuild"  // built again, recreated only if the image changes
+	PlanPull     = "pull"     // pulled again, recreated only if the image changes
+	PlanRedeploy = "redeploy" // blue-green, deployed to the idle color
+	PlanKeep     = "keep"
+)
+
+// Environment changes
+const (
+	EnvAdded   = "added"
+	EnvRemoved = "removed"
+	EnvChanged = "changed"
+)
+
+// maskedValue replaces secret environment values in a plan
+const maskedValue = "********"
+
+// secretKeyWords mark environment keys whose values are never shown
+var secretKeyWords = []string{"SECRET", "PASSWORD", "PASSWD", "TOKEN", "KEY", "CREDENTIAL", "PRIVATE", "AUTH", "DSN"}
+
+// ValueChange is a setting that differs between the server and the next sync
+type ValueChange struct {
+	Old string `json:"old" yaml:"old"`
+	New string `json:"new" yaml:"new"`
+}
+
+// ListChange lists the entries the next sync adds and removes
+type ListChange struct {
+	Added   []string `json:"added,omitempty" yaml:"added,omitempty"`
+	Removed []string `json:"removed,omitempty" yaml:"removed,omitempty"`
+}
+
+// EnvChange is one environment variable that differs. Secret values are
+// masked.
+type EnvChange struct {
+	Key    string `json:"key" yaml:"key"`
+	Change string `json:"change" yaml:"change"`
+	Old    string `json:"old,omitempty" yaml:"old,omitempty"`
+	New    string `json:"new,omitempty" yaml:"new,omitempty"`
+}
+
+// ServicePlan is what the next sync changes about one service
+type ServicePlan struct {
+	Service     string       `json:"service" yaml:"service"`
+	Action      string       `json:"action" yaml:"action"`
+	Image       *ValueChange `json:"image,omitempty" yaml:"image,omitempty"`
+	Build       *ValueChange `json:"build,omitempty" yaml:"build,omitempty"`
+	Labels      *ListChange  `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Environment []EnvChange  `json:"environment,omitempty" yaml:"environment,omitempty"`
+	Volumes     *ListChange  `json:"volumes,omitempty" yaml:"volumes,omitempty"`
+	Other       []string     `json:"other,omitempty" yaml:"other,omitempty"`
+}
+
+// Changed reports whether the service's definition differs from the server's
+func (s ServicePlan) Changed() bool {
+	return s.Image != nil || s.Build != nil || s.Labels != nil || len(s.Environment) > 0 || s.Volumes != nil || len(s.Other) > 0
+}
+
+// SyncPlan compares what a sync would deploy with what the server runs
+type SyncPlan struct {
+	RemoteDir   string        `json:"remote_dir" yaml:"remote_dir"`
+	ComposeFile string        `json:"compose_file" yaml:"compose_file"`
+	Deployed    bool          `json:"deployed" yaml:"deployed"`
+	Services    []ServicePlan `json:"services" yaml:"services"`
+}
+
+// PlanSync generates compose/<env>.yml and the merged env files as a sync
+// would, without uploading them, and compares them with the live
+// docker-compose.yml and env files on the server. composeOnly plans a
+// compose sync, which neither builds nor pulls.
+func PlanSync(envname string, client ssh.Remote, p *Project, composeOnly bool) (*SyncPlan, error) {
+	remoteProjName := p.Name
+	if !strings.HasSuffix(remoteProjName, "-"+envname) {
+		remoteProjName = fmt.Sprintf("%s-%s", remoteProjName, envname)
+	}
+	remoteDir := fmt.Sprintf("/opt/graft/projects/%s", remoteProjName)
+
+	meta, _ := config.LoadProjectMetadata(envname)
+	if meta == nil {
+		meta = &config.ProjectMetadata{Name: p.Name}
+	}
+
+	// 1. Generate the compose and env files exactly as the sync does
+	localFile := "graft-compose.yml"
+	if _, err := os.Stat(localFile); err != nil {
+		return nil, fmt.Errorf("project file not found: %s", localFile)
+	}
+	compose, err := ParseComposeFile(localFile, meta.Domain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse compose file: %v", err)
+	}
+	if !composeOnly {
+		if err := validateHealthLabels(compose.Services); err != nil {
+			return nil, err
+		}
+	}
+	prepareCompose(compose, remoteProjName, envname, composeOnly)
+	applyBlueGreen(compose, meta.BlueGreen)
+	data, err := saveCompose(envname, compose)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compare both sides as parsed from YAML so equal values have equal types
+	var local DockerComposeFile
+	if err := yaml.Unmarshal(data, &local); err != nil {
+		return nil, fmt.Errorf("failed to parse generated compose file: %v", err)
+	}
+
+	// 2. Read the live files from the server
+	plan := &SyncPlan{
+		RemoteDir:   remoteDir,
+		ComposeFile: filepath.Join("compose", fmt.Sprintf("%s.yml", envname)),
+		Services:    []ServicePlan{},
+	}
+	var remote DockerComposeFile
+	out, err := client.GetCommandOutput(fmt.Sprintf("cat %s 2>/dev/null || true", path.Join(remoteDir, "docker-compose.yml")))
+	if err != nil {
+		return nil, fmt.Errorf("could not read the live docker-compose.yml: %v", err)
+	}
+	if strings.TrimSpace(out) != "" {
+		if err := yaml.Unmarshal([]byte(out), &remote); err != nil {
+			return nil, fmt.Errorf("could not parse the live docker-compose.yml: %v", err)
+		}
+		plan.Deployed = true
+	}
+
+	// 3. Diff every service on either side
+	secrets, _ := config.LoadSecrets()
+	names := make(map[string]bool)
+	for name := range local.Services {
+		names[name] = true
+	}
+	for name := range remote.Services {
+		names[name] = true
+	}
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		newService, inLocal := local.Services[name]
+		oldService, inRemote := remote.Services[name]
+		if !inLocal {
+			plan.Services = append(plan.Services, ServicePlan{Service: name, Action: PlanRemove})
+			continue
+		}
+
+		var oldEnv map[string]string
+		if inRemote {
+			oldEnv, err = remoteServiceEnv(client, remoteDir, oldService)
+			if err != nil {
+				return nil, err
+			}
+		}
+		sp := diffService(name, oldService, newService, oldEnv, localServiceEnv(name, envname, newService), secrets)
+
+		switch {
+		case !composeOnly && isBlueGreen(meta, name):
+			sp.Action = PlanRedeploy
+		case !inRemote:
+			sp.Action = PlanCreate
+		case sp.Changed():
+			sp.Action = PlanRecreate
+		case !composeOnly && newService.Build != nil:
+			sp.Action = PlanRebuild
+		case !composeOnly && pullable(newService):
+			sp.Action = PlanPull
+		default:
+			sp.Action = PlanKeep
+		}
+		plan.Services = append(plan.Services, sp)
+	}
+	return plan, nil
+}
+
+// diffService compares the live definition of a service with the generated one
+func diffService(name string, oldService, newService ComposeService, oldEnv, newEnv map[string]string, secrets map[string]string) ServicePlan {
+	sp := ServicePlan{Service: name}
+
+	if oldService.Image != newService.Image {
+		sp.Image = &ValueChange{Old: oldService.Image, New: newService.Image}
+	}
+	if oldBuild, newBuild := buildString(oldService.Build), buildString(newService.Build); oldBuild != newBuild {
+		sp.Build = &ValueChange{Old: oldBuild, New: newBuild}
+	}
+	sp.Labels = diffList(oldService.Labels, newService.Labels)
+	sp.Volumes = diffList(volumeStrings(oldService.OtherFields["volumes"]), volumeStrings(newService.OtherFields["volumes"]))
+
+	// Environment keys, values masked where they may be secret
+	keys := make(map[string]bool)
+	for k := range oldEnv {
+		keys[k] = true
+	}
+	for k := range newEnv {
+		keys[k] = true
+	}
+	var sortedKeys []string
+	for k := range keys {
+		sortedKeys = append(sortedKeys, k)
+	}
+	sort.Strings(sortedKeys)
+	for _, k := range sortedKeys {
+		oldValue, inOld := oldEnv[k]
+		newValue, inNew := newEnv[k]
+		switch {
+		case !inOld:
+			sp.Environment = append(sp.Environment, EnvChange{Key: k, Change: EnvAdded, New: maskEnv(k, newValue, secrets)})
+		case !inNew:
+			sp.Environment = append(sp.Environment, EnvChange{Key: k, Change: EnvRemoved})
+		case oldValue != newValue:
+			sp.Environment = append(sp.Environment, EnvChange{Key: k, Change: EnvChanged, Old: maskEnv(k, oldValue, secrets), New: maskEnv(k, newValue, secrets)})
+		}
+	}
+
+	// Any other setting that differs also recreates the containers
+	if !equalYAML(oldService.GetEnvFiles(), newService.GetEnvFiles()) {
+		sp.Other = append(sp.Other, "env_file")
+	}
+	fields := make(map[string]bool)
+	for k := range oldService.OtherFields {
+		fields[k] = true
+	}
+	for k := range newService.OtherFields {
+		fields[k] = true
+	}
+	delete(fields, "volumes")
+	for k := range fields {
+		if !equalYAML(oldService.OtherFields[k], newService.OtherFields[k]) {
+			sp.Other = append(sp.Other, k)
+		}
+	}
+	sort.Strings(sp.Other)
+	return sp
+}
+
+// localServiceEnv returns the environment the sync uploads for a service:
+// its inline variables and the merged env/<service>.env.<env> file
+func localServiceEnv(name, envname string, s ComposeService) map[string]string {
+	env := inlineEnv(s)
+	if len(s.GetEnvFiles()) == 0 {
+		return env
+	}
+	content, err := os.ReadFile(filepath.Join("env", fmt.Sprintf("%s.env.%s", name, envname)))
+	if err == nil {
+		for k, v := range parseEnvFile(string(content)) {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// remoteServiceEnv returns the environment a service runs with on the server:
+// its inline variables and its env file
+func remoteServiceEnv(client ssh.Remote, remoteDir string, s ComposeService) (map[string]string, error) {
+	env := inlineEnv(s)
+	files := s.GetEnvFiles()
+	if len(files) == 0 {
+		return env, nil
+	}
+	envPath := files[0]
+	if !path.IsAbs(envPath) {
+		envPath = path.Join(remoteDir, envPath)
+	}
+	out, err := client.GetCommandOutput(fmt.Sprintf("cat %s 2>/dev/null || true", envPath))
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", envPath, err)
+	}
+	for k, v := range parseEnvFile(out) {
+		env[k] = v
+	}
+	return env, nil
+}
+
+// inlineEnv reads the environment section of a service
+func inlineEnv(s ComposeService) map[string]string {
+	env := make(map[string]string)
+	switch e := s.Environment.(type) {
+	case map[string]interface{}:
+		for k, v := range e {
+			env[k] = fmt.Sprintf("%v", v)
+		}
+	case []interface{}:
+		for _, v := range e {
+			k, value, _ := strings.Cut(fmt.Sprintf("%v", v), "=")
+			env[k] = value
+		}
+	}
+	return env
+}
+
+// parseEnvFile reads KEY=value lines, skipping blanks and comments
+func parseEnvFile(content string) map[string]string {
+	env := make(map[string]string)
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, _ := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		env[strings.TrimSpace(k)] = v
+	}
+	return env
+}
+
+// maskEnv hides values that may be secret: those of keys named like
+// credentials, those containing a graft secret and URLs with a password
+func maskEnv(key, value string, secrets map[string]string) string {
+	upper := strings.ToUpper(key)
+	for _, word := range secretKeyWords {
+		if strings.Contains(upper, word) {
+			return maskedValue
+		}
+	}
+	for _, secret := range secrets {
+		if secret != "" && strings.Contains(value, secret) {
+			return maskedValue
+		}
+	}
+	if strings.Contains(value, "://") && strings.Contains(value, "@") {
+		return maskedValue
+	}
+	return value
+}
+
+// buildString describes a build section for display
+func buildString(b *BuildConfig) string {
+	if b == nil {
+		return ""
+	}
+	if b.Dockerfile != "" {
+		return fmt.Sprintf("%s (dockerfile: %s)", b.Context, b.Dockerfile)
+	}
+	return b.Context
+}
+
+// volumeStrings renders the volumes of a service in short syntax
+func volumeStrings(v interface{}) []string {
+	list, _ := v.([]interface{})
+	var volumes []string
+	for _, item := range list {
+		switch vol := item.(type) {
+		case string:
+			volumes = append(volumes, vol)
+		case map[string]interface{}:
+			s := fmt.Sprintf("%v:%v", vol["source"], vol["target"])
+			if ro, _ := vol["read_only"].(bool); ro {
+				s += ":ro"
+			}
+			volumes = append(volumes, s)
+		default:
+			volumes = append(volumes, fmt.Sprintf("%v", vol))
+		}
+	}
+	return volumes
+}
+
+// diffList returns the entries only in new as added and only in old as
+// removed, or nil when both hold the same entries
+func diffList(old, new []string) *ListChange {
+	inOld := make(map[string]bool)
+	for _, s := range old {
+		inOld[s] = true
+	}
+	inNew := make(map[string]bool)
+	for _, s := range new {
+		inNew[s] = true
+	}
+	change := &ListChange{}
+	for _, s := range new {
+		if !inOld[s] {
+			change.Added = append(change.Added, s)
+		}
+	}
+	for _, s := range old {
+		if !inNew[s] {
+			change.Removed = append(change.Removed, s)
+		}
+	}
+	if len(change.Added) == 0 && len(change.Removed) == 0 {
+		return nil
+	}
+	return change
+}
+
+// equalYAML reports whether two compose values serialize the same
+func equalYAML(a, b interface{}) bool {
+	x, errA := yaml.Marshal(a)
+	y, errB := yaml.Marshal(b)
+	return errA == nil && errB == nil && string(x) == string(y)
+}
@@ -26,6 +26,7 @@ type Project struct {
 	DeploymentMode  string             `yaml:"-"` // Not exported to YAML, used for generation logic
 	Services        map[string]Service `yaml:"services"`
 	RollbackBackups int                `yaml:"-"` // Not exported to YAML
+	Env             string             `yaml:"-"` // Environment being deployed, recorded in the history
 }
 
 func LoadProject(env string, path string) (*Project, error) {
@@ -45,6 +46,7 @@ func LoadProject(env string, path string) (*Project, error) {
 			p.Name = meta.Name
 		}
 	}
+	p.Env = env
 
 	return &p, nil
 }
@@ -623,29 +625,20 @@ jobs:
     environment: %s
     
     steps:
-      - name: Send Webhook Request
-        run: |
-          curl -X POST %s \
-            -H "Content-Type: application/json" \
-            -d '{
-              "project": "%s",
-              "repository": "${{ github.event.repository.name }}",
-              "token": "${{ secrets.GITHUB_TOKEN }}",
-              "user": "${{ github.actor }}",
-              "type": "%s",
-              "registry": "ghcr.io"
-            }'
-`
+%s`
 		deployPath := filepath.Join(workflowsDir, fmt.Sprintf("deploy-%s.yml", env))
 		projFull := p.Name
 		if !strings.HasSuffix(projFull, "-"+env) {
 			projFull = fmt.Sprintf("%s-%s", projFull, env)
 		}
-		deployContent := fmt.Sprintf(deployTemplate, triggers, env, condition, env, hookURL, projFull, deployType)
+		steps := webhookDeploySteps(p.Name, env, projFull, hookURL, deployType)
+		deployContent := fmt.Sprintf(deployTemplate, triggers, env, condition, env, steps)
 
 		if err := os.WriteFile(deployPath, []byte(deployContent), 0644); err != nil {
 			return fmt.Errorf("failed to write deploy workflow: %v", err)
 		}
+		fmt.Printf("🔐 Add the secrets %s (and GRAFT_SSH_PORT if not 22) to the '%s' environment on GitHub, so webhook deploys are recorded in graft history\n",
+			strings.Join(webhookSecrets, ", "), env)
 	}
 
 	if mode == "git-images" {
@@ -12,26 +12,44 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-func PerformBackup(client *ssh.Client, p *Project, stdout, stderr io.Writer) error {
+// remoteProjectDir is the directory Sync deploys a project and environment
+// to on the server
+func remoteProjectDir(project, env string) string {
+	name := project
+	if env != "" && !strings.HasSuffix(name, "-"+env) {
+		name = fmt.Sprintf("%s-%s", name, env)
+	}
+	return path.Join("/opt/graft/projects", name)
+}
+
+// BackupBase is the directory the rollback backups of a project and
+// environment are kept in, one directory per timestamp
+func BackupBase(project, env string) string {
+	return path.Join("/opt/graft/backup", path.Base(remoteProjectDir(project, env)))
+}
+
+// PerformBackup snapshots the project's compose file, env and images and
+// returns the backup's timestamp, or "" when no backup was taken
+func PerformBackup(client ssh.Remote, p *Project, stdout, stderr io.Writer) (string, error) {
 	if p.RollbackBackups <= 0 {
-		return nil
+		return "", nil
 	}
 
-	remoteDir := fmt.Sprintf("/opt/graft/projects/%s", p.Name)
+	remoteDir := remoteProjectDir(p.Name, p.Env)
 
 	// Check if project exists on remote
 	if err := client.RunCommand(fmt.Sprintf("ls %s/docker-compose.yml", remoteDir), nil, nil); err != nil {
-		return nil
+		return "", nil
 	}
 
 	// 1. Get timestamp
 	out, err := client.GetCommandOutput("date +%Y%m%d%H%M%S")
 	if err != nil {
-		return fmt.Errorf("failed to get timestamp: %v", err)
+		return "", fmt.Errorf("failed to get timestamp: %v", err)
 	}
 	timestamp := strings.TrimSpace(out)
 
-	backupBase := fmt.Sprintf("/opt/graft/backup/%s", p.Name)
+	backupBase := BackupBase(p.Name, p.Env)
 	backupDir := fmt.Sprintf("%s/%s", backupBase, timestamp)
 
 	fmt.Fprintf(stdout, "\n📦 Creating rollback backup: %s\n", timestamp)
@@ -59,12 +77,23 @@ func PerformBackup(client *ssh.Client, p *Project, stdout, stderr io.Writer) err
 	cleanCmd := fmt.Sprintf("cd %s && ls -1dt * | tail -n +%d | xargs -I {} sudo rm -rf {}", backupBase, p.RollbackBackups+1)
 	client.RunCommand(cleanCmd, stdout, stderr)
 
-	return nil
+	return timestamp, nil
 }
 
-func RestoreRollback(client *ssh.Client, p *Project, backupTimestamp string, stdout, stderr io.Writer) error {
-	remoteDir := fmt.Sprintf("/opt/graft/projects/%s", p.Name)
-	backupDir := fmt.Sprintf("/opt/graft/backup/%s/%s", p.Name, backupTimestamp)
+func RestoreRollback(client ssh.Remote, p *Project, backupTimestamp string, stdout, stderr io.Writer) (err error) {
+	remoteDir := remoteProjectDir(p.Name, p.Env)
+	backupDir := path.Join(BackupBase(p.Name, p.Env), backupTimestamp)
+
+	// Only one deploy of a project and environment runs at a time
+	unlock, err := AcquireLock(client, p.Name, p.Env, ActionRollback, stdout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	hist := startHistory(client, p, p.Env, ActionRollback, remoteDir)
+	hist.entry.Backup = backupTimestamp
+	defer func() { hist.finish(err, stdout) }()
 
 	fmt.Fprintf(stdout, "⏪ Rolling back to version %s...\n", backupTimestamp)
 
@@ -121,9 +150,20 @@ func RestoreRollback(client *ssh.Client, p *Project, backupTimestamp string, std
 	return client.RunCommand(restartCmd, stdout, stderr)
 }
 
-func RestoreServiceRollback(client *ssh.Client, p *Project, backupTimestamp string, serviceName string, stdout, stderr io.Writer) error {
-	remoteDir := fmt.Sprintf("/opt/graft/projects/%s", p.Name)
-	backupDir := fmt.Sprintf("/opt/graft/backup/%s/%s", p.Name, backupTimestamp)
+func RestoreServiceRollback(client ssh.Remote, p *Project, backupTimestamp string, serviceName string, stdout, stderr io.Writer) (err error) {
+	remoteDir := remoteProjectDir(p.Name, p.Env)
+	backupDir := path.Join(BackupBase(p.Name, p.Env), backupTimestamp)
+
+	// Only one deploy of a project and environment runs at a time
+	unlock, err := AcquireLock(client, p.Name, p.Env, ActionRollbackService, stdout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	hist := startHistory(client, p, p.Env, ActionRollbackService, remoteDir, serviceName)
+	hist.entry.Backup = backupTimestamp
+	defer func() { hist.finish(err, stdout) }()
 
 	fmt.Fprintf(stdout, "⏪ Rolling back service '%s' to version %s...\n", serviceName, backupTimestamp)
 
@@ -0,0 +1,154 @@
+package deploy_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/skssmd/graft/internal/server/deploy"
+)
+
+const backupDir = "/opt/graft/backup/demo-prod/20250101120000"
+
+func TestRestoreRollback(t *testing.T) {
+	tests := []struct {
+		name      string
+		images    string
+		upStatus  int
+		want      []string
+		notWant   []string
+		wantError bool
+	}{
+		{
+			name: "restores the backup",
+			want: []string{
+				"sudo cp " + backupDir + "/compose/docker-compose.yml " + remoteDir + "/",
+				"sudo cp -r " + backupDir + "/compose/env " + remoteDir + "/",
+				"cd " + remoteDir + " && sudo docker compose down",
+				"for img_tar in " + backupDir + "/images/*.tar*",
+				"cd " + remoteDir + " && sudo docker compose up -d --remove-orphans --pull never",
+			},
+			notWant: []string{"docker rmi -f demo"},
+		},
+		{
+			name:   "clears the restored tags",
+			images: "demo-prod-api:latest\nnginx:1.27\n",
+			want: []string{
+				"sudo docker rmi -f demo-prod-api:latest",
+				"sudo docker rmi -f nginx:1.27",
+			},
+		},
+		{
+			name:      "failed start",
+			upStatus:  1,
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, client := newTestProject(t)
+			srv.Respond("sudo docker compose config --images", tt.images, 0)
+			srv.Respond("--pull never", "", tt.upStatus)
+			p := &deploy.Project{Name: "demo", Env: "prod"}
+
+			err := deploy.RestoreRollback(client, p, "20250101120000", io.Discard, io.Discard)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("RestoreRollback error = %v, want error %v", err, tt.wantError)
+			}
+
+			cmds := srv.Commands()
+			for _, s := range tt.want {
+				if !ran(cmds, s) {
+					t.Errorf("no command containing %q in %q", s, cmds)
+				}
+			}
+			for _, s := range tt.notWant {
+				if ran(cmds, s) {
+					t.Errorf("unexpected command containing %q in %q", s, cmds)
+				}
+			}
+			if !ran(cmds, "cat >> /opt/graft/history/demo.jsonl") {
+				t.Errorf("rollback was not recorded in the history: %q", cmds)
+			}
+		})
+	}
+}
+
+func TestRestoreServiceRollback(t *testing.T) {
+	tests := []struct {
+		name    string
+		service string
+		want    string
+		keep    string
+		wantErr string
+	}{
+		{name: "image service", service: "web", want: "nginx:1.26", keep: "demo-prod-api:v2"},
+		{name: "build service", service: "api", want: "demo-prod-api:v1", keep: "nginx:1.27"},
+		{name: "service missing from backup", service: "worker", wantErr: "not found in backup"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, client := newTestProject(t)
+			t.Setenv("TMPDIR", t.TempDir())
+			files := map[string]string{
+				backupDir + "/compose/docker-compose.yml": "services:\n  api:\n    image: demo-prod-api:v1\n  web:\n    image: nginx:1.26\n",
+				remoteDir + "/docker-compose.yml":         "services:\n  api:\n    image: demo-prod-api:v2\n  web:\n    image: nginx:1.27\n",
+			}
+			for name, data := range files {
+				if err := srv.WriteFile(name, []byte(data)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			p := &deploy.Project{Name: "demo", Env: "prod"}
+
+			err := deploy.RestoreServiceRollback(client, p, "20250101120000", tt.service, io.Discard, io.Discard)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("RestoreServiceRollback error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RestoreServiceRollback: %v", err)
+			}
+
+			// Only the rolled back service changes in the live compose file
+			compose, err := srv.ReadFile(remoteDir + "/docker-compose.yml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range []string{tt.want, tt.keep} {
+				if !strings.Contains(string(compose), s) {
+					t.Errorf("docker-compose.yml lacks %q:\n%s", s, compose)
+				}
+			}
+
+			cmds := srv.Commands()
+			stop := "cd " + remoteDir + " && sudo docker compose stop " + tt.service
+			if !ran(cmds, stop) {
+				t.Errorf("no command containing %q in %q", stop, cmds)
+			}
+			if ran(cmds, "docker compose down") {
+				t.Errorf("a service rollback stopped the whole project: %q", cmds)
+			}
+		})
+	}
+}
+
+func TestBackupBase(t *testing.T) {
+	tests := []struct {
+		project, env string
+		want         string
+	}{
+		{"demo", "prod", "/opt/graft/backup/demo-prod"},
+		{"demo", "dev", "/opt/graft/backup/demo-dev"},
+		{"demo-prod", "prod", "/opt/graft/backup/demo-prod"},
+	}
+	for _, tt := range tests {
+		if got := deploy.BackupBase(tt.project, tt.env); got != tt.want {
+			t.Errorf("BackupBase(%q, %q) = %q, want %q", tt.project, tt.env, got, tt.want)
+		}
+	}
+}
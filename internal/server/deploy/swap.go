@@ -0,0 +1,187 @@
+package deploy
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/skssmd/graft/internal/server/ssh"
+)
+
+// Zero-downtime swap tuning
+const (
+	// swapSettleDelay is how many seconds Traefik gets to pick up the new
+	// container before the old one is stopped
+	swapSettleDelay = 5
+
+	// swapStopGrace is how many seconds the old container gets to finish
+	// in-flight requests after SIGTERM
+	swapStopGrace = 30
+)
+
+// swapLabel turns the zero-downtime swap on or off for one service
+const swapLabel = "graft.zero-downtime"
+
+// swapEnabled reports whether a service is replaced with a zero-downtime
+// swap and, if not, why. The swap is on by default for serverbuild, localbuild
+// and image services; the graft.zero-downtime label overrides that. Services
+// that cannot run twice side by side are always recreated.
+func swapEnabled(service ComposeService) (bool, string) {
+	enabled := defaultZeroDowntime(service)
+	for _, label := range service.Labels {
+		if strings.HasPrefix(label, swapLabel+"=") {
+			enabled = strings.TrimPrefix(label, swapLabel+"=") == "true"
+			if !enabled {
+				return false, swapLabel + "=false"
+			}
+		}
+	}
+	if !enabled {
+		return false, "mode " + getGraftMode(service.Labels)
+	}
+	if reason := sideBySide(service); reason != "" {
+		return false, reason
+	}
+	return true, ""
+}
+
+// defaultZeroDowntime reports whether a service is deployed without downtime
+// unless its labels say otherwise: serverbuild, localbuild and image services
+// are
+func defaultZeroDowntime(service ComposeService) bool {
+	return getGraftMode(service.Labels) == "serverbuild" || isLocalBuild(service) || service.Build == nil && service.Image != ""
+}
+
+// sideBySide returns why two containers of a service cannot run at the same
+// time, or "" if they can. A pinned container_name, published host ports and
+// host networking all clash.
+func sideBySide(service ComposeService) string {
+	if _, ok := service.OtherFields["container_name"]; ok {
+		return "container_name is set"
+	}
+	if mode, ok := service.OtherFields["network_mode"].(string); ok && mode == "host" {
+		return "network_mode is host"
+	}
+	if ports, ok := service.OtherFields["ports"].([]interface{}); ok {
+		for _, port := range ports {
+			switch p := port.(type) {
+			case string:
+				if strings.Contains(p, ":") {
+					return "it publishes host port " + p
+				}
+			case map[string]interface{}:
+				if published, ok := p["published"]; ok {
+					return fmt.Sprintf("it publishes host port %v", published)
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// swapService replaces the running containers of a service with ones from
+// its freshly built or pulled image. The new containers start next to the old
+// ones on graft-public, and the old ones are only drained and removed once
+// the new ones are healthy, so Traefik always has a backend to route to. If a
+// new container fails, it is removed and the old ones keep serving.
+func swapService(client ssh.Remote, remoteDir, serviceName string, service ComposeService, stdout, stderr io.Writer) error {
+	compose := fmt.Sprintf("cd %s && sudo docker compose", remoteDir)
+
+	if ok, reason := swapEnabled(service); !ok {
+		fmt.Fprintf(stdout, "🚀 Recreating %s (no zero-downtime swap: %s)...\n", serviceName, reason)
+		return client.RunIdempotent(fmt.Sprintf("%s up -d %s", compose, serviceName), stdout, stderr)
+	}
+
+	// 1. Find the containers serving now
+	out, err := client.GetCommandOutput(fmt.Sprintf("%s ps -q %s", compose, serviceName))
+	if err != nil {
+		return fmt.Errorf("could not list containers of %s: %v", serviceName, err)
+	}
+	old := strings.Fields(out)
+	if len(old) == 0 {
+		fmt.Fprintf(stdout, "🚀 Starting %s...\n", serviceName)
+		return client.RunIdempotent(fmt.Sprintf("%s up -d %s", compose, serviceName), stdout, stderr)
+	}
+
+	// 2. Start the new containers next to the old ones
+	fmt.Fprintf(stdout, "🚀 Starting new %s container next to the running one...\n", serviceName)
+	scaleCmd := fmt.Sprintf("%s up -d --no-deps --no-recreate --scale %s=%d %s", compose, serviceName, 2*len(old), serviceName)
+	if err := client.RunIdempotent(scaleCmd, stdout, stderr); err != nil {
+		return fmt.Errorf("failed to start new %s container: %v", serviceName, err)
+	}
+	if isDryRun(client) {
+		fmt.Fprintf(stdout, "ℹ️  The old %s container is stopped once the new one is healthy\n", serviceName)
+		return nil
+	}
+
+	out, err = client.GetCommandOutput(fmt.Sprintf("%s ps -q %s", compose, serviceName))
+	if err != nil {
+		return fmt.Errorf("could not list containers of %s: %v", serviceName, err)
+	}
+	fresh := newContainers(strings.Fields(out), old)
+	if len(fresh) == 0 {
+		return fmt.Errorf("docker compose did not start a new %s container", serviceName)
+	}
+	freshIDs := strings.Join(fresh, " ")
+
+	// 3. Wait for them to become healthy
+	fmt.Fprintf(stdout, "🩺 Waiting for the new %s container to become healthy...\n", serviceName)
+	if err := verifyContainers(client, fresh, service, false, stdout, stderr); err != nil {
+		fmt.Fprintf(stdout, "📜 Last logs of the new %s container:\n", serviceName)
+		client.RunCommand(fmt.Sprintf("sudo docker logs --tail 30 %s", fresh[0]), stdout, stderr)
+		client.RunCommand(fmt.Sprintf("sudo docker rm -f %s", freshIDs), stdout, stderr)
+		return fmt.Errorf("new %s container is not healthy, the old one keeps serving: %v", serviceName, err)
+	}
+
+	// 4. Drain and remove the old ones
+	fmt.Fprintf(stdout, "🔁 Draining the old %s container...\n", serviceName)
+	oldIDs := strings.Join(old, " ")
+	drainCmd := fmt.Sprintf("sleep %d && sudo docker stop -t %d %s && sudo docker rm %s", swapSettleDelay, swapStopGrace, oldIDs, oldIDs)
+	if err := client.RunIdempotent(drainCmd, stdout, stderr); err != nil {
+		return fmt.Errorf("failed to remove the old %s container: %v", serviceName, err)
+	}
+
+	fmt.Fprintf(stdout, "✅ %s swapped without downtime\n", serviceName)
+	return nil
+}
+
+// swapServices swaps every service of a project, in name order
+func swapServices(client ssh.Remote, remoteDir string, services map[string]ComposeService, stdout, stderr io.Writer) error {
+	var names []string
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if ok, _ := swapEnabled(services[name]); !ok {
+			continue
+		}
+		if err := swapService(client, remoteDir, name, services[name], stdout, stderr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newContainers returns the IDs in current that are not in old
+func newContainers(current, old []string) []string {
+	seen := make(map[string]bool, len(old))
+	for _, id := range old {
+		seen[id] = true
+	}
+	var fresh []string
+	for _, id := range current {
+		if !seen[id] {
+			fresh = append(fresh, id)
+		}
+	}
+	return fresh
+}
+
+// isDryRun reports whether client only records commands
+func isDryRun(client ssh.Remote) bool {
+	d, ok := client.(interface{ DryRun() bool })
+	return ok && d.DryRun()
+}
@@ -1,35 +1,65 @@
 package deploy
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
-
+	"sort"
 	"strings"
 
 	"github.com/skssmd/graft/internal/config"
 	"github.com/skssmd/graft/internal/server/git"
+	"github.com/skssmd/graft/internal/server/ignore"
 	"github.com/skssmd/graft/internal/server/ssh"
 	"gopkg.in/yaml.v3"
 )
 
-// SyncService syncs only a specific service
-func SyncService(envname string, client *ssh.Client, p *Project, serviceName string, noCache, heave, useGit bool, gitBranch, gitCommit string, stdout, stderr io.Writer) error {
+// SyncService syncs only a specific service. A canary share above zero
+// deploys the new version as a canary that receives that percentage of the
+// service's traffic, next to the running version.
+func SyncService(envname string, client ssh.Remote, p *Project, serviceName string, noCache, heave, useGit bool, gitBranch, gitCommit string, canary int, stdout, stderr io.Writer) (err error) {
 	fmt.Fprintf(stdout, "🎯 Syncing service: %s\n", serviceName)
 
-	// Perform backup before sync if configured
-	if err := PerformBackup(client, p, stdout, stderr); err != nil {
-		fmt.Fprintf(stdout, "⚠️  Backup warning: %v\n", err)
-	}
-
 	remoteProjName := p.Name
 	if !strings.HasSuffix(remoteProjName, "-"+envname) {
 		remoteProjName = fmt.Sprintf("%s-%s", remoteProjName, envname)
 	}
 	remoteDir := fmt.Sprintf("/opt/graft/projects/%s", remoteProjName)
 
+	action := ActionSyncService
+	if canary > 0 {
+		action = ActionCanary
+	}
+
+	// Only one deploy of a project and environment runs at a time
+	unlock, err := AcquireLock(client, p.Name, envname, action, stdout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// A running canary is promoted or aborted before the service is synced
+	// again; a new canary replaces it
+	if canary == 0 {
+		if err := canaryRunning(client, remoteDir, serviceName); err != nil {
+			return err
+		}
+	}
+
+	// Record the deploy in the project's history, however it ends
+	hist := startHistory(client, p, envname, action, remoteDir, serviceName)
+	defer func() { hist.finish(err, stdout) }()
+
+	// Perform backup before sync if configured
+	backup, err := PerformBackup(client, p, stdout, stderr)
+	if err != nil {
+		fmt.Fprintf(stdout, "⚠️  Backup warning: %v\n", err)
+	}
+	hist.entry.Backup = backup
+
 	// Update project metadata with current remote path
 	meta, _ := config.LoadProjectMetadata(envname)
 	if meta == nil {
@@ -63,13 +93,31 @@ func SyncService(envname string, client *ssh.Client, p *Project, serviceName str
 	if !exists {
 		return fmt.Errorf("service '%s' not found in compose file", serviceName)
 	}
+	if err := validateHealthLabels(map[string]ComposeService{serviceName: service}); err != nil {
+		return err
+	}
+	if canary > 0 {
+		if err := checkCanary(client, remoteDir, meta, serviceName, service); err != nil {
+			return err
+		}
+	}
 
 	mode := getGraftMode(service.Labels)
 	fmt.Fprintf(stdout, "📦 Mode: %s\n", mode)
+	blueGreen := isBlueGreen(meta, serviceName)
+	if blueGreen {
+		fmt.Fprintln(stdout, "🔵🟢 Strategy: blue-green")
+	} else if canary > 0 {
+		fmt.Fprintf(stdout, "🐤 Strategy: canary (%d%%)\n", canary)
+	}
 
 	// Check if this is an image-based service (no build context)
 	isImageBased := service.Image != "" && service.Build == nil
 
+	// localbuild services are built on this machine and streamed to the server
+	localBuild := isLocalBuild(service)
+	var localContext string
+
 	// Load secrets
 	secrets, _ := config.LoadSecrets()
 
@@ -78,29 +126,20 @@ func SyncService(envname string, client *ssh.Client, p *Project, serviceName str
 		// Use a pointer to update the service in the map
 		sPtr := compose.Services[sName]
 		ProcessServiceEnvironment(sName, &sPtr, secrets, envname)
+		if isLocalBuild(sPtr) {
+			useLocalImage(remoteProjName, sName, &sPtr)
+		}
 		compose.Services[sName] = sPtr
 	}
+	applyBlueGreen(compose, meta.BlueGreen)
 
-	// Generate the actual docker-compose.yml content
-	updatedComposeData, err := yaml.Marshal(compose)
-	if err != nil {
-		return fmt.Errorf("failed to marshal updated compose file: %v", err)
-	}
-
-	// Save the actual docker-compose.yml locally
-	localComposeFile := filepath.Join("compose", fmt.Sprintf("%s.yml", envname))
-	if err := os.MkdirAll("compose", 0755); err != nil {
-		return fmt.Errorf("failed to create compose directory: %v", err)
-	}
-	if err := os.WriteFile(localComposeFile, updatedComposeData, 0644); err != nil {
-		return fmt.Errorf("failed to save %s: %v", localComposeFile, err)
+	// Generate the actual docker-compose.yml and save it locally
+	if _, err := saveCompose(envname, compose); err != nil {
+		return err
 	}
 
-	// Ensure .gitignore is up to date
-	EnsureGitignore(".")
-
 	// Ensure remote projects directory exists
-	if err := client.RunCommand(fmt.Sprintf("sudo mkdir -p %s && sudo chown $USER:$USER %s", remoteDir, remoteDir), stdout, stderr); err != nil {
+	if err := client.RunIdempotent(fmt.Sprintf("sudo mkdir -p %s && sudo chown $USER:$USER %s", remoteDir, remoteDir), stdout, stderr); err != nil {
 		return err
 	}
 
@@ -117,10 +156,19 @@ func SyncService(envname string, client *ssh.Client, p *Project, serviceName str
 
 	// Upload the generated docker-compose.yml from compose/ directory
 	remoteCompose := path.Join(remoteDir, "docker-compose.yml")
-	fmt.Fprintf(stdout, "📤 Uploading generated docker-compose.yml...\n")
-	localComposeFile = filepath.Join("compose", fmt.Sprintf("%s.yml", envname))
-	if err := client.UploadFile(localComposeFile, remoteCompose); err != nil {
-		return err
+	localComposeFile := filepath.Join("compose", fmt.Sprintf("%s.yml", envname))
+	if canary > 0 {
+		// The project keeps running its docker-compose.yml until the canary
+		// is promoted
+		fmt.Fprintf(stdout, "📤 Uploading generated docker-compose.yml for the canary...\n")
+		if err := uploadCanaryCompose(client, remoteDir, serviceName, compose, localComposeFile); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(stdout, "📤 Uploading generated docker-compose.yml...\n")
+		if err := client.UploadFile(localComposeFile, remoteCompose); err != nil {
+			return err
+		}
 	}
 
 	if isImageBased {
@@ -131,23 +179,38 @@ func SyncService(envname string, client *ssh.Client, p *Project, serviceName str
 			return nil // Heave sync ends here
 		}
 
-		// Stop the old container
-		fmt.Fprintf(stdout, "🛑 Stopping old container...\n")
-		stopCmd := fmt.Sprintf("cd %s && sudo docker compose stop %s && sudo docker compose rm -f %s", remoteDir, serviceName, serviceName)
-		client.RunCommand(stopCmd, stdout, stderr) // Ignore errors if container doesn't exist
+		if blueGreen {
+			if err := deployBlueGreen(client, remoteDir, serviceName, service, false, hist, stdout, stderr); err != nil {
+				return err
+			}
+		} else if canary > 0 {
+			if err := deployCanary(client, remoteDir, serviceName, service, canary, false, hist, stdout, stderr); err != nil {
+				return err
+			}
+		} else {
+			// Pull the latest image while the old container keeps serving
+			fmt.Fprintf(stdout, "📥 Pulling latest image...\n")
+			pullCmd := fmt.Sprintf("cd %s && sudo docker compose pull %s", remoteDir, serviceName)
+			if err := client.RunIdempotent(pullCmd, stdout, stderr); err != nil {
+				return fmt.Errorf("image pull failed: %v", err)
+			}
 
-		// Pull the latest image
-		fmt.Fprintf(stdout, "📥 Pulling latest image...\n")
-		pullCmd := fmt.Sprintf("cd %s && sudo docker compose pull %s", remoteDir, serviceName)
-		if err := client.RunCommand(pullCmd, stdout, stderr); err != nil {
-			return fmt.Errorf("image pull failed: %v", err)
-		}
+			// Run pre-deploy hooks such as migrations before traffic moves
+			if err := runPreDeployHooks(client, remoteDir, map[string]ComposeService{serviceName: service}, hist, stdout, stderr); err != nil {
+				return rollbackFailedDeploy(client, p, backup, serviceName, err, stdout, stderr)
+			}
 
-		// Start the service with the new image
-		fmt.Fprintf(stdout, "🚀 Starting %s...\n", serviceName)
-		upCmd := fmt.Sprintf("cd %s && sudo docker compose up -d %s", remoteDir, serviceName)
-		if err := client.RunCommand(upCmd, stdout, stderr); err != nil {
-			return err
+			// Swap the running container for one with the new image, then verify it
+			if err := swapService(client, remoteDir, serviceName, service, stdout, stderr); err != nil {
+				return rollbackFailedDeploy(client, p, backup, serviceName, err, stdout, stderr)
+			}
+			if err := verifyServices(client, remoteDir, map[string]ComposeService{serviceName: service}, stdout, stderr); err != nil {
+				return rollbackFailedDeploy(client, p, backup, serviceName, err, stdout, stderr)
+			}
+			runPostDeployHooks(client, remoteDir, map[string]ComposeService{serviceName: service}, hist, stdout, stderr)
+			if err := retireBlueGreen(client, remoteDir, meta, stdout, stderr); err != nil {
+				fmt.Fprintf(stdout, "⚠️  %v\n", err)
+			}
 		}
 
 		// Cleanup old images
@@ -160,7 +223,7 @@ func SyncService(envname string, client *ssh.Client, p *Project, serviceName str
 		return nil
 	}
 
-	if mode == "serverbuild" && service.Build != nil {
+	if (mode == "serverbuild" || localBuild) && service.Build != nil {
 
 		// Upload source code for this service only
 		contextPath := service.Build.Context
@@ -186,6 +249,7 @@ func SyncService(envname string, client *ssh.Client, p *Project, serviceName str
 		// Handle git-based sync if enabled
 		var actualContextPath string
 		var cleanupFunc func()
+		projectRoot := "."
 
 		if useGit {
 			// Check if git repo exists
@@ -218,6 +282,7 @@ func SyncService(envname string, client *ssh.Client, p *Project, serviceName str
 			}
 
 			fmt.Fprintf(stdout, "📦 Git mode: branch=%s, commit=%s\n", branch, commit[:7])
+			hist.setGit(branch, commit)
 
 			// Create temp directory for git export
 			tempDir, err := os.MkdirTemp("", "graft-git-*")
@@ -252,57 +317,68 @@ func SyncService(envname string, client *ssh.Client, p *Project, serviceName str
 
 			// Update context path to extracted directory
 			actualContextPath = filepath.Join(extractDir, contextRelPath)
+			projectRoot = extractDir
 			fmt.Fprintf(stdout, "📦 Exported git commit to temp directory\n")
 		} else {
 			actualContextPath = contextPath
 		}
 
-		fmt.Fprintf(stdout, "📦 Syncing source code with rsync (incremental)...\n")
-		contextName := filepath.Base(contextPath)
-		if contextName == "." || contextName == "/" {
-			contextName = serviceName
-		}
+		if localBuild {
+			localContext = actualContextPath
+		} else {
+			fmt.Fprintf(stdout, "📦 Syncing source code (incremental)...\n")
+			contextName := filepath.Base(contextPath)
+			if contextName == "." || contextName == "/" {
+				contextName = serviceName
+			}
 
-		// Use rsync to sync the directory
-		serviceDir := path.Join(remoteDir, contextName)
+			// Sync the directory, sending only changed files
+			serviceDir := path.Join(remoteDir, contextName)
 
-		// Ensure remote directory exists
-		if err := client.RunCommand(fmt.Sprintf("mkdir -p %s", serviceDir), stdout, stderr); err != nil {
-			return fmt.Errorf("failed to create remote directory: %v", err)
-		}
+			// Ensure remote directory exists
+			if err := client.RunIdempotent(fmt.Sprintf("mkdir -p %s", serviceDir), stdout, stderr); err != nil {
+				return fmt.Errorf("failed to create remote directory: %v", err)
+			}
 
-		// Try rsync first, fall back to tarball if rsync is not available
-		fmt.Fprintf(stdout, "📤 Uploading changes from %s...\n", actualContextPath)
-		rsyncErr := client.RsyncDirectory(actualContextPath, serviceDir, stdout, stderr)
+			// Honour .gitignore, .dockerignore and .graftignore on both upload paths
+			ignored, err := ignore.New(projectRoot, actualContextPath)
+			if err != nil {
+				return fmt.Errorf("failed to load ignore files: %v", err)
+			}
 
-		if rsyncErr != nil {
-			// Check if error is due to rsync not being found
-			if strings.Contains(rsyncErr.Error(), "rsync not found") {
-				fmt.Fprintf(stdout, "⚠️  Rsync not available, falling back to tarball method...\n")
+			// Try the delta sync first, fall back to tarball if the server cannot hash files
+			fmt.Fprintf(stdout, "📤 Uploading changes from %s...\n", actualContextPath)
+			syncErr := client.SyncDirectory(actualContextPath, serviceDir, ignored, stdout, stderr)
 
-				// Fall back to tarball method
-				tarballPath := filepath.Join(os.TempDir(), fmt.Sprintf("%s-%s.tar.gz", p.Name, contextName))
-				if err := createTarball(actualContextPath, tarballPath); err != nil {
-					return fmt.Errorf("failed to create tarball: %v", err)
-				}
-				defer os.Remove(tarballPath)
+			if syncErr != nil {
+				// Check if error is due to missing tools on the server
+				if errors.Is(syncErr, ssh.ErrNoRemoteManifest) {
+					fmt.Fprintf(stdout, "⚠️  Server cannot compare files, falling back to tarball method...\n")
 
-				// Upload tarball to server
-				remoteTarball := path.Join(remoteDir, fmt.Sprintf("%s.tar.gz", contextName))
-				fmt.Fprintf(stdout, "📤 Uploading tarball...\n")
-				if err := client.UploadFile(tarballPath, remoteTarball); err != nil {
-					return fmt.Errorf("failed to upload tarball: %v", err)
-				}
+					// Fall back to tarball method
+					tarballPath := filepath.Join(os.TempDir(), fmt.Sprintf("%s-%s.tar.gz", p.Name, contextName))
+					if err := createTarball(actualContextPath, tarballPath, ignored); err != nil {
+						return fmt.Errorf("failed to create tarball: %v", err)
+					}
+					defer os.Remove(tarballPath)
 
-				// Extract on server
-				extractCmd := fmt.Sprintf("rm -rf %s && mkdir -p %s && tar -xzf %s -C %s && rm %s",
-					serviceDir, serviceDir, remoteTarball, serviceDir, remoteTarball)
-				fmt.Fprintf(stdout, "📂 Extracting on server...\n")
-				if err := client.RunCommand(extractCmd, stdout, stderr); err != nil {
-					return fmt.Errorf("failed to extract tarball: %v", err)
+					// Upload tarball to server
+					remoteTarball := path.Join(remoteDir, fmt.Sprintf("%s.tar.gz", contextName))
+					fmt.Fprintf(stdout, "📤 Uploading tarball...\n")
+					if err := client.UploadFile(tarballPath, remoteTarball); err != nil {
+						return fmt.Errorf("failed to upload tarball: %v", err)
+					}
+
+					// Extract on server
+					extractCmd := fmt.Sprintf("rm -rf %s && mkdir -p %s && tar -xzf %s -C %s && rm %s",
+						serviceDir, serviceDir, remoteTarball, serviceDir, remoteTarball)
+					fmt.Fprintf(stdout, "📂 Extracting on server...\n")
+					if err := client.RunCommand(extractCmd, stdout, stderr); err != nil {
+						return fmt.Errorf("failed to extract tarball: %v", err)
+					}
+				} else {
+					return fmt.Errorf("failed to sync directory: %v", syncErr)
 				}
-			} else {
-				return fmt.Errorf("failed to sync directory: %v", rsyncErr)
 			}
 		}
 
@@ -311,36 +387,66 @@ func SyncService(envname string, client *ssh.Client, p *Project, serviceName str
 		}
 	}
 
-	// Stop and remove the old container
-	fmt.Fprintf(stdout, "🛑 Stopping old container...\n")
-	stopCmd := fmt.Sprintf("cd %s && sudo docker compose stop %s && sudo docker compose rm -f %s", remoteDir, serviceName, serviceName)
-	client.RunCommand(stopCmd, stdout, stderr) // Ignore errors if container doesn't exist
+	// Build localbuild services here and load them on the server
+	if localBuild {
+		if err := buildLocalImage(client, remoteProjName, serviceName, service, localContext, noCache, stdout, stderr); err != nil {
+			return err
+		}
+		useLocalImage(remoteProjName, serviceName, &service)
+	}
 
 	// Conditionally clear build cache
-	if noCache {
+	if noCache && !localBuild {
 		fmt.Fprintf(stdout, "🧹 Clearing build cache for fresh build...\n")
 		pruneCmd := "sudo docker builder prune -f"
 		client.RunCommand(pruneCmd, stdout, stderr) // Ignore errors
 	}
 
-	// Build the service (separate command to show build logs)
-	fmt.Fprintf(stdout, "🔨 Building %s...\n", serviceName)
-	var buildCmd string
-	if noCache {
-		buildCmd = fmt.Sprintf("cd %s && sudo docker compose build --no-cache %s", remoteDir, serviceName)
-	} else {
-		buildCmd = fmt.Sprintf("cd %s && sudo docker compose build %s", remoteDir, serviceName)
+	if blueGreen {
+		if err := deployBlueGreen(client, remoteDir, serviceName, service, noCache, hist, stdout, stderr); err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, "🧹 Cleaning up old images...")
+		if err := client.RunCommand("sudo docker image prune -f", stdout, stderr); err != nil {
+			fmt.Fprintf(stdout, "⚠️  Cleanup warning: %v\n", err)
+		}
+		return nil
+	}
+	if canary > 0 {
+		return deployCanary(client, remoteDir, serviceName, service, canary, noCache, hist, stdout, stderr)
 	}
 
-	if err := client.RunCommand(buildCmd, stdout, stderr); err != nil {
-		return fmt.Errorf("build failed: %v", err)
+	// Build the service while the old container keeps serving (separate command to show build logs)
+	if !localBuild {
+		fmt.Fprintf(stdout, "🔨 Building %s...\n", serviceName)
+		var buildCmd string
+		if noCache {
+			buildCmd = fmt.Sprintf("cd %s && sudo docker compose build --no-cache %s", remoteDir, serviceName)
+		} else {
+			buildCmd = fmt.Sprintf("cd %s && sudo docker compose build %s", remoteDir, serviceName)
+		}
+
+		// Builds are safe to repeat, so they are re-run if the connection drops
+		if err := client.RunIdempotent(buildCmd, stdout, stderr); err != nil {
+			return fmt.Errorf("build failed: %v", err)
+		}
 	}
 
-	// Start the service
-	fmt.Fprintf(stdout, "� Starting %s...\n", serviceName)
-	upCmd := fmt.Sprintf("cd %s && sudo docker compose up -d %s", remoteDir, serviceName)
-	if err := client.RunCommand(upCmd, stdout, stderr); err != nil {
-		return err
+	// Run pre-deploy hooks such as migrations before traffic moves
+	if err := runPreDeployHooks(client, remoteDir, map[string]ComposeService{serviceName: service}, hist, stdout, stderr); err != nil {
+		return rollbackFailedDeploy(client, p, backup, serviceName, err, stdout, stderr)
+	}
+
+	// Swap the running container for the new build, then verify it
+	if err := swapService(client, remoteDir, serviceName, service, stdout, stderr); err != nil {
+		return rollbackFailedDeploy(client, p, backup, serviceName, err, stdout, stderr)
+	}
+	if err := verifyServices(client, remoteDir, map[string]ComposeService{serviceName: service}, stdout, stderr); err != nil {
+		return rollbackFailedDeploy(client, p, backup, serviceName, err, stdout, stderr)
+	}
+	runPostDeployHooks(client, remoteDir, map[string]ComposeService{serviceName: service}, hist, stdout, stderr)
+	if err := retireBlueGreen(client, remoteDir, meta, stdout, stderr); err != nil {
+		fmt.Fprintf(stdout, "⚠️  %v\n", err)
 	}
 
 	// Cleanup old images
@@ -353,12 +459,14 @@ func SyncService(envname string, client *ssh.Client, p *Project, serviceName str
 	return nil
 }
 
-func Sync(envname string, client *ssh.Client, p *Project, noCache, heave, useGit bool, gitBranch, gitCommit string, stdout, stderr io.Writer) error {
+// Sync deploys every service of the project. Uploads and builds run for up
+// to parallel services at once.
+func Sync(envname string, client ssh.Remote, p *Project, noCache, heave, useGit bool, gitBranch, gitCommit string, parallel int, stdout, stderr io.Writer) (err error) {
 	fmt.Fprintf(stdout, "🚀 Syncing project: %s\n", p.Name)
 
-	// Perform backup before sync if configured
-	if err := PerformBackup(client, p, stdout, stderr); err != nil {
-		fmt.Fprintf(stdout, "⚠️  Backup warning: %v\n", err)
+	// A dry run records its plan in order, one service at a time
+	if isDryRun(client) {
+		parallel = 1
 	}
 
 	remoteProjName := p.Name
@@ -367,6 +475,29 @@ func Sync(envname string, client *ssh.Client, p *Project, noCache, heave, useGit
 	}
 	remoteDir := fmt.Sprintf("/opt/graft/projects/%s", remoteProjName)
 
+	// Only one deploy of a project and environment runs at a time
+	unlock, err := AcquireLock(client, p.Name, envname, ActionSync, stdout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Running canaries are promoted or aborted before the project is synced
+	if err := canaryRunning(client, remoteDir, ""); err != nil {
+		return err
+	}
+
+	// Record the deploy in the project's history, however it ends
+	hist := startHistory(client, p, envname, ActionSync, remoteDir)
+	defer func() { hist.finish(err, stdout) }()
+
+	// Perform backup before sync if configured
+	backup, err := PerformBackup(client, p, stdout, stderr)
+	if err != nil {
+		fmt.Fprintf(stdout, "⚠️  Backup warning: %v\n", err)
+	}
+	hist.entry.Backup = backup
+
 	// Update project metadata with current remote path
 	meta, _ := config.LoadProjectMetadata(envname)
 	if meta == nil {
@@ -377,7 +508,7 @@ func Sync(envname string, client *ssh.Client, p *Project, noCache, heave, useGit
 		fmt.Fprintf(stdout, "Warning: Could not save project metadata: %v\n", err)
 	}
 
-	if err := client.RunCommand(fmt.Sprintf("sudo mkdir -p %s && sudo chown $USER:$USER %s", remoteDir, remoteDir), stdout, stderr); err != nil {
+	if err := client.RunIdempotent(fmt.Sprintf("sudo mkdir -p %s && sudo chown $USER:$USER %s", remoteDir, remoteDir), stdout, stderr); err != nil {
 		return err
 	}
 
@@ -392,6 +523,13 @@ func Sync(envname string, client *ssh.Client, p *Project, noCache, heave, useGit
 	if err != nil {
 		return fmt.Errorf("failed to parse compose file: %v", err)
 	}
+	if err := validateHealthLabels(compose.Services); err != nil {
+		return err
+	}
+	for sName := range compose.Services {
+		hist.entry.Services = append(hist.entry.Services, sName)
+	}
+	sort.Strings(hist.entry.Services)
 
 	// Handle git-based sync if enabled
 	var workingDir string
@@ -428,6 +566,7 @@ func Sync(envname string, client *ssh.Client, p *Project, noCache, heave, useGit
 		}
 
 		fmt.Fprintf(stdout, "📦 Git mode: branch=%s, commit=%s\n", branch, commit[:7])
+		hist.setGit(branch, commit)
 
 		// Create temp directory for git export
 		tempDir, err := os.MkdirTemp("", "graft-git-*")
@@ -461,126 +600,51 @@ func Sync(envname string, client *ssh.Client, p *Project, noCache, heave, useGit
 		workingDir = "."
 	}
 
-	// Process each service based on graft.mode
+	// Upload the source of serverbuild services, several at a time
+	var uploads []string
 	for serviceName, service := range compose.Services {
-		mode := getGraftMode(service.Labels)
-		fmt.Fprintf(stdout, "\n📦 Processing service '%s' (mode: %s)\n", serviceName, mode)
-
-		if mode == "serverbuild" && service.Build != nil {
-			// Upload source code and build on server
-			contextPath := service.Build.Context
-			if !filepath.IsAbs(contextPath) {
-				contextPath = filepath.Clean(contextPath)
-			}
-
-			// If using git, resolve context path relative to workingDir
-			if useGit {
-				contextPath = filepath.Join(workingDir, contextPath)
-			}
-
-			// Verify build context exists
-			if _, err := os.Stat(contextPath); os.IsNotExist(err) {
-				return fmt.Errorf("build context directory not found: %s\n👉 Please ensure the directory exists or update 'context' in your graft.yml file.", contextPath)
-			}
-
-			// Verify Dockerfile exists within context
-			dockerfileName := service.Build.Dockerfile
-			if dockerfileName == "" {
-				dockerfileName = "Dockerfile"
-			}
-			dockerfilePath := filepath.Join(contextPath, dockerfileName)
-			if _, err := os.Stat(dockerfilePath); os.IsNotExist(err) {
-				return fmt.Errorf("Dockerfile not found: %s\n👉 Checked path: %s\n👉 Please check the 'dockerfile' field in your graft.yml and ensure the file exists and casing matches EXACTLY (Linux is case-sensitive!).", dockerfileName, dockerfilePath)
-			}
-
-			fmt.Fprintf(stdout, "  📦 Syncing source code with rsync (incremental)...\n")
-			contextName := filepath.Base(contextPath)
-			if contextName == "." || contextName == "/" {
-				contextName = serviceName
-			}
-
-			// Use rsync to sync the directory
-			serviceDir := path.Join(remoteDir, contextName)
-
-			// Ensure remote directory exists
-			if err := client.RunCommand(fmt.Sprintf("mkdir -p %s", serviceDir), stdout, stderr); err != nil {
-				return fmt.Errorf("failed to create remote directory: %v", err)
-			}
-
-			// Try rsync first, fall back to tarball if rsync is not available
-			fmt.Fprintf(stdout, "  📤 Uploading changes from %s...\n", contextPath)
-			rsyncErr := client.RsyncDirectory(contextPath, serviceDir, stdout, stderr)
-
-			if rsyncErr != nil {
-				// Check if error is due to rsync not being found
-				if strings.Contains(rsyncErr.Error(), "rsync not found") {
-					fmt.Fprintf(stdout, "  ⚠️  Rsync not available, falling back to tarball method...\n")
-
-					// Fall back to tarball method
-					tarballPath := filepath.Join(os.TempDir(), fmt.Sprintf("%s-%s.tar.gz", p.Name, contextName))
-					if err := createTarball(contextPath, tarballPath); err != nil {
-						return fmt.Errorf("failed to create tarball: %v", err)
-					}
-					defer os.Remove(tarballPath)
-
-					// Upload tarball to server
-					remoteTarball := path.Join(remoteDir, fmt.Sprintf("%s.tar.gz", contextName))
-					fmt.Fprintf(stdout, "  📤 Uploading tarball...\n")
-					if err := client.UploadFile(tarballPath, remoteTarball); err != nil {
-						return fmt.Errorf("failed to upload tarball: %v", err)
-					}
-
-					// Extract on server
-					extractCmd := fmt.Sprintf("mkdir -p %s && tar -xzf %s -C %s && rm %s",
-						serviceDir, remoteTarball, serviceDir, remoteTarball)
-					fmt.Fprintf(stdout, "  📂 Extracting on server...\n")
-					if err := client.RunCommand(extractCmd, stdout, stderr); err != nil {
-						return fmt.Errorf("failed to extract tarball: %v", err)
-					}
-				} else {
-					return fmt.Errorf("failed to sync directory: %v", rsyncErr)
-				}
-			}
+		if getGraftMode(service.Labels) == "serverbuild" && service.Build != nil {
+			uploads = append(uploads, serviceName)
 		}
 	}
-
-	// Load secrets
-	secrets, _ := config.LoadSecrets()
-
-	// Process environments for ALL services
-	for sName := range compose.Services {
-		sPtr := compose.Services[sName]
-		ProcessServiceEnvironment(sName, &sPtr, secrets, envname)
-
-		// For serverbuild services, update build context to point to uploaded code
-		mode := getGraftMode(sPtr.Labels)
-		if mode == "serverbuild" && sPtr.Build != nil {
-			contextName := filepath.Base(sPtr.Build.Context)
-			if contextName == "." || contextName == "/" {
-				contextName = sName
-			}
-			sPtr.Build.Context = "./" + contextName
-		}
-		compose.Services[sName] = sPtr
+	sort.Strings(uploads)
+	if len(uploads) > 0 {
+		fmt.Fprintf(stdout, "\n📦 Uploading %d service(s)...\n", len(uploads))
 	}
-
-	// Generate the actual docker-compose.yml content
-	updatedComposeData, err := yaml.Marshal(compose)
+	err = runParallel(uploads, parallel, stdout, stderr, func(serviceName string, stdout, stderr io.Writer) error {
+		return uploadServiceSource(client, p, remoteDir, workingDir, useGit, serviceName, compose.Services[serviceName], stdout, stderr)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to marshal updated compose file: %v", err)
+		return err
 	}
 
-	// Save the actual docker-compose.yml locally
-	localComposeFile := filepath.Join("compose", fmt.Sprintf("%s.yml", envname))
-	if err := os.MkdirAll("compose", 0755); err != nil {
-		return fmt.Errorf("failed to create compose directory: %v", err)
+	// localbuild services run the image streamed from this machine
+	localBuilds := make(map[string]ComposeService)
+	for sName, s := range compose.Services {
+		if isLocalBuild(s) {
+			localBuilds[sName] = s
+		}
 	}
-	if err := os.WriteFile(localComposeFile, updatedComposeData, 0644); err != nil {
-		return fmt.Errorf("failed to save %s: %v", localComposeFile, err)
+
+	// Process environments for ALL services
+	prepareCompose(compose, remoteProjName, envname, false)
+
+	// Blue-green services run as compose projects of their own
+	rolling := make(map[string]ComposeService)
+	blueGreen := make(map[string]ComposeService)
+	for sName, s := range compose.Services {
+		if isBlueGreen(meta, sName) {
+			blueGreen[sName] = s
+		} else {
+			rolling[sName] = s
+		}
 	}
+	applyBlueGreen(compose, meta.BlueGreen)
 
-	// Ensure .gitignore is up to date
-	EnsureGitignore(".")
+	// Generate the actual docker-compose.yml and save it locally
+	if _, err := saveCompose(envname, compose); err != nil {
+		return err
+	}
 
 	// Upload env directory if it exists
 	if _, err := os.Stat("env"); err == nil {
@@ -592,7 +656,7 @@ func Sync(envname string, client *ssh.Client, p *Project, noCache, heave, useGit
 	// Upload docker-compose.yml
 	remoteCompose := path.Join(remoteDir, "docker-compose.yml")
 	fmt.Fprintln(stdout, "\n📤 Uploading generated docker-compose.yml...")
-	localComposeFile = filepath.Join("compose", fmt.Sprintf("%s.yml", envname))
+	localComposeFile := filepath.Join("compose", fmt.Sprintf("%s.yml", envname))
 	if err := client.UploadFile(localComposeFile, remoteCompose); err != nil {
 		return err
 	}
@@ -602,26 +666,106 @@ func Sync(envname string, client *ssh.Client, p *Project, noCache, heave, useGit
 		return nil
 	}
 
-	// Build and start services
+	// Build localbuild services on this machine and load them on the server
+	var localNames []string
+	for sName := range localBuilds {
+		localNames = append(localNames, sName)
+	}
+	sort.Strings(localNames)
+	err = runParallel(localNames, parallel, stdout, stderr, func(sName string, stdout, stderr io.Writer) error {
+		s := localBuilds[sName]
+		contextPath := filepath.Clean(s.Build.Context)
+		if useGit && !filepath.IsAbs(contextPath) {
+			contextPath = filepath.Join(workingDir, contextPath)
+		}
+		return buildLocalImage(client, remoteProjName, sName, s, contextPath, noCache, stdout, stderr)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Build the remaining services on the server, one compose build each
+	var builds []string
+	for sName, s := range rolling {
+		if s.Build != nil {
+			builds = append(builds, sName)
+		}
+	}
+	sort.Strings(builds)
+	buildFlags := ""
 	if noCache {
 		fmt.Fprintln(stdout, "🧹 Clearing build cache for fresh build...")
 		pruneCmd := "sudo docker builder prune -f"
 		client.RunCommand(pruneCmd, stdout, stderr) // Ignore errors
-
-		fmt.Fprintln(stdout, "🔨 Building services (no cache)...")
-		if err := client.RunCommand(fmt.Sprintf("cd %s && sudo docker compose build --no-cache", remoteDir), stdout, stderr); err != nil {
+		buildFlags = " --no-cache"
+	}
+	if len(builds) > 0 && noCache {
+		fmt.Fprintf(stdout, "🔨 Building %d service(s) (no cache)...\n", len(builds))
+	} else if len(builds) > 0 {
+		fmt.Fprintf(stdout, "🔨 Building %d service(s)...\n", len(builds))
+	}
+	err = runParallel(builds, parallel, stdout, stderr, func(sName string, stdout, stderr io.Writer) error {
+		// Builds are safe to repeat, so they are re-run if the connection drops
+		buildCmd := fmt.Sprintf("cd %s && sudo docker compose build%s %s", remoteDir, buildFlags, sName)
+		if err := client.RunIdempotent(buildCmd, stdout, stderr); err != nil {
 			return fmt.Errorf("build failed: %v", err)
 		}
-	} else {
-		fmt.Fprintln(stdout, "🔨 Building services...")
-		if err := client.RunCommand(fmt.Sprintf("cd %s && sudo docker compose build", remoteDir), stdout, stderr); err != nil {
-			return fmt.Errorf("build failed: %v", err)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Pull images while the old containers keep serving
+	var images []string
+	for sName, s := range rolling {
+		if pullable(s) {
+			images = append(images, sName)
+		}
+	}
+	if len(images) > 0 {
+		sort.Strings(images)
+		fmt.Fprintln(stdout, "📥 Pulling latest images...")
+		if err := client.RunIdempotent(fmt.Sprintf("cd %s && sudo docker compose pull %s", remoteDir, strings.Join(images, " ")), stdout, stderr); err != nil {
+			return fmt.Errorf("image pull failed: %v", err)
 		}
 	}
 
+	// Run pre-deploy hooks such as migrations before traffic moves
+	if err := runPreDeployHooks(client, remoteDir, rolling, hist, stdout, stderr); err != nil {
+		return rollbackFailedDeploy(client, p, backup, "", err, stdout, stderr)
+	}
+
+	// Swap serverbuild and image services one at a time, then start the rest
+	if err := swapServices(client, remoteDir, rolling, stdout, stderr); err != nil {
+		return rollbackFailedDeploy(client, p, backup, "", err, stdout, stderr)
+	}
+
 	fmt.Fprintln(stdout, "🚀 Starting services...")
-	if err := client.RunCommand(fmt.Sprintf("cd %s && sudo docker compose up -d --pull always --remove-orphans", remoteDir), stdout, stderr); err != nil {
-		return err
+	if err := client.RunIdempotent(fmt.Sprintf("cd %s && sudo docker compose up -d --remove-orphans", remoteDir), stdout, stderr); err != nil {
+		return rollbackFailedDeploy(client, p, backup, "", err, stdout, stderr)
+	}
+
+	// Nothing counts as deployed until it is healthy
+	if err := verifyServices(client, remoteDir, rolling, stdout, stderr); err != nil {
+		return rollbackFailedDeploy(client, p, backup, "", err, stdout, stderr)
+	}
+	runPostDeployHooks(client, remoteDir, rolling, hist, stdout, stderr)
+
+	// Deploy blue-green services to their idle color and switch traffic
+	var blueGreenNames []string
+	for sName := range blueGreen {
+		blueGreenNames = append(blueGreenNames, sName)
+	}
+	sort.Strings(blueGreenNames)
+	for _, sName := range blueGreenNames {
+		fmt.Fprintln(stdout)
+		if err := deployBlueGreen(client, remoteDir, sName, blueGreen[sName], noCache, hist, stdout, stderr); err != nil {
+			return err
+		}
+	}
+	if err := retireBlueGreen(client, remoteDir, meta, stdout, stderr); err != nil {
+		fmt.Fprintf(stdout, "⚠️  %v\n", err)
 	}
 
 	// Cleanup: Remove only dangling images
@@ -633,8 +777,93 @@ func Sync(envname string, client *ssh.Client, p *Project, noCache, heave, useGit
 	return nil
 }
 
+// uploadServiceSource sends the build context of a serverbuild service to
+// the server, falling back to a tarball if the server cannot hash files
+func uploadServiceSource(client ssh.Remote, p *Project, remoteDir, workingDir string, useGit bool, serviceName string, service ComposeService, stdout, stderr io.Writer) error {
+	contextPath := service.Build.Context
+	if !filepath.IsAbs(contextPath) {
+		contextPath = filepath.Clean(contextPath)
+	}
+
+	// If using git, resolve context path relative to workingDir
+	if useGit {
+		contextPath = filepath.Join(workingDir, contextPath)
+	}
+
+	// Verify build context exists
+	if _, err := os.Stat(contextPath); os.IsNotExist(err) {
+		return fmt.Errorf("build context directory not found: %s\n👉 Please ensure the directory exists or update 'context' in your graft.yml file.", contextPath)
+	}
+
+	// Verify Dockerfile exists within context
+	dockerfileName := service.Build.Dockerfile
+	if dockerfileName == "" {
+		dockerfileName = "Dockerfile"
+	}
+	dockerfilePath := filepath.Join(contextPath, dockerfileName)
+	if _, err := os.Stat(dockerfilePath); os.IsNotExist(err) {
+		return fmt.Errorf("Dockerfile not found: %s\n👉 Checked path: %s\n👉 Please check the 'dockerfile' field in your graft.yml and ensure the file exists and casing matches EXACTLY (Linux is case-sensitive!).", dockerfileName, dockerfilePath)
+	}
+
+	fmt.Fprintf(stdout, "  📦 Syncing source code (incremental)...\n")
+	contextName := filepath.Base(contextPath)
+	if contextName == "." || contextName == "/" {
+		contextName = serviceName
+	}
+
+	// Sync the directory, sending only changed files
+	serviceDir := path.Join(remoteDir, contextName)
+
+	// Ensure remote directory exists
+	if err := client.RunIdempotent(fmt.Sprintf("mkdir -p %s", serviceDir), stdout, stderr); err != nil {
+		return fmt.Errorf("failed to create remote directory: %v", err)
+	}
+
+	// Honour .gitignore, .dockerignore and .graftignore on both upload paths
+	ignored, err := ignore.New(workingDir, contextPath)
+	if err != nil {
+		return fmt.Errorf("failed to load ignore files: %v", err)
+	}
+
+	// Try the delta sync first, fall back to tarball if the server cannot hash files
+	fmt.Fprintf(stdout, "  📤 Uploading changes from %s...\n", contextPath)
+	syncErr := client.SyncDirectory(contextPath, serviceDir, ignored, stdout, stderr)
+
+	if syncErr != nil {
+		// Check if error is due to missing tools on the server
+		if errors.Is(syncErr, ssh.ErrNoRemoteManifest) {
+			fmt.Fprintf(stdout, "  ⚠️  Server cannot compare files, falling back to tarball method...\n")
+
+			// Fall back to tarball method
+			tarballPath := filepath.Join(os.TempDir(), fmt.Sprintf("%s-%s.tar.gz", p.Name, contextName))
+			if err := createTarball(contextPath, tarballPath, ignored); err != nil {
+				return fmt.Errorf("failed to create tarball: %v", err)
+			}
+			defer os.Remove(tarballPath)
+
+			// Upload tarball to server
+			remoteTarball := path.Join(remoteDir, fmt.Sprintf("%s.tar.gz", contextName))
+			fmt.Fprintf(stdout, "  📤 Uploading tarball...\n")
+			if err := client.UploadFile(tarballPath, remoteTarball); err != nil {
+				return fmt.Errorf("failed to upload tarball: %v", err)
+			}
+
+			// Extract on server
+			extractCmd := fmt.Sprintf("mkdir -p %s && tar -xzf %s -C %s && rm %s",
+				serviceDir, remoteTarball, serviceDir, remoteTarball)
+			fmt.Fprintf(stdout, "  📂 Extracting on server...\n")
+			if err := client.RunCommand(extractCmd, stdout, stderr); err != nil {
+				return fmt.Errorf("failed to extract tarball: %v", err)
+			}
+		} else {
+			return fmt.Errorf("failed to sync directory: %v", syncErr)
+		}
+	}
+	return nil
+}
+
 // SyncComposeOnly uploads only the docker-compose.yml and restarts services
-func SyncComposeOnly(envname string, client *ssh.Client, p *Project, heave bool, stdout, stderr io.Writer, doCompose bool, doEnv bool) error {
+func SyncComposeOnly(envname string, client ssh.Remote, p *Project, heave bool, stdout, stderr io.Writer, doCompose bool, doEnv bool) (err error) {
 	if !doCompose && !doEnv {
 		return fmt.Errorf("at least one of doCompose or doEnv must be true")
 	}
@@ -656,18 +885,31 @@ func SyncComposeOnly(envname string, client *ssh.Client, p *Project, heave bool,
 	}
 	remoteDir := fmt.Sprintf("/opt/graft/projects/%s", remoteProjName)
 
+	// Only one deploy of a project and environment runs at a time
+	unlock, err := AcquireLock(client, p.Name, envname, ActionSyncCompose, stdout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Record the compose sync in the project's history, however it ends
+	hist := startHistory(client, p, envname, ActionSyncCompose, remoteDir)
+	defer func() { hist.finish(err, stdout) }()
+
 	// Perform backup before sync if configured
 
 	if !strings.HasPrefix(p.DeploymentMode, "git") {
-		if err := PerformBackup(client, p, stdout, stderr); err != nil {
+		backup, err := PerformBackup(client, p, stdout, stderr)
+		if err != nil {
 			fmt.Fprintf(stdout, "⚠️  Backup warning: %v\n", err)
 		}
+		hist.entry.Backup = backup
 	}
 
 	// Ensure remote projects directory exists and is owned by the user
 	// We do this once at the beginning to handle both compose and env sync cases
 	// Use -R to ensure existing files (like docker-compose.yml) are also owned by the user
-	if err := client.RunCommand(fmt.Sprintf("sudo mkdir -p %s && sudo chown -R $USER:$USER %s", remoteDir, remoteDir), stdout, stderr); err != nil {
+	if err := client.RunIdempotent(fmt.Sprintf("sudo mkdir -p %s && sudo chown -R $USER:$USER %s", remoteDir, remoteDir), stdout, stderr); err != nil {
 		return fmt.Errorf("failed to prepare remote directory: %v", err)
 	}
 
@@ -686,61 +928,21 @@ func SyncComposeOnly(envname string, client *ssh.Client, p *Project, heave bool,
 		if _, err := os.Stat(localFile); err != nil {
 			return fmt.Errorf("project file not found: %s", localFile)
 		}
-
-		// Load secrets
-		secrets, _ := config.LoadSecrets()
-
-		// Process environments and handle git-images mode transformation
 		for sName := range compose.Services {
-
-			sPtr := compose.Services[sName]
-			ProcessServiceEnvironment(sName, &sPtr, secrets, envname)
-
-			// If in git-images mode and has build, replace with GHCR image
-			mode := getGraftMode(sPtr.Labels)
-			if mode == "git-images" && sPtr.Build != nil {
-				remoteURL, err := git.GetRemoteURL(".", "origin")
-				if err == nil {
-					ownerRepo := ""
-					if strings.HasPrefix(remoteURL, "https://") {
-						parts := strings.Split(strings.TrimSuffix(remoteURL, ".git"), "/")
-						if len(parts) >= 2 {
-							ownerRepo = parts[len(parts)-2] + "/" + parts[len(parts)-1]
-						}
-					} else if strings.HasPrefix(remoteURL, "git@") {
-						parts := strings.Split(strings.TrimSuffix(remoteURL, ".git"), ":")
-						if len(parts) >= 2 {
-							ownerRepo = parts[1]
-						}
-					}
-
-					if ownerRepo != "" {
-						sPtr.Image = fmt.Sprintf("ghcr.io/%s/%s:latest", strings.ToLower(ownerRepo), sName)
-						sPtr.Build = nil // Remove build context
-					}
-				}
-			}
-
-			compose.Services[sName] = sPtr
+			hist.entry.Services = append(hist.entry.Services, sName)
 		}
+		sort.Strings(hist.entry.Services)
 
-		// Generate the actual docker-compose.yml content
-		updatedComposeData, err := yaml.Marshal(compose)
-		if err != nil {
-			return fmt.Errorf("failed to marshal updated compose file: %v", err)
+		// Process environments and handle git-images mode transformation
+		prepareCompose(compose, remoteProjName, envname, true)
+		if meta != nil {
+			applyBlueGreen(compose, meta.BlueGreen)
 		}
 
-		// Save the actual docker-compose.yml locally
-		localComposeFile := filepath.Join("compose", fmt.Sprintf("%s.yml", envname))
-		if err := os.MkdirAll("compose", 0755); err != nil {
-			return fmt.Errorf("failed to create compose directory: %v", err)
-		}
-		if err := os.WriteFile(localComposeFile, updatedComposeData, 0644); err != nil {
-			return fmt.Errorf("failed to save %s: %v", localComposeFile, err)
+		// Generate the actual docker-compose.yml and save it locally
+		if _, err := saveCompose(envname, compose); err != nil {
+			return err
 		}
-
-		// Ensure .gitignore is up to date
-		EnsureGitignore(".")
 	}
 
 	// Upload env directory if it exists
@@ -766,7 +968,7 @@ func SyncComposeOnly(envname string, client *ssh.Client, p *Project, heave bool,
 	if !heave {
 		// Restart services without rebuilding
 		fmt.Fprintln(stdout, "🔄 Restarting services...")
-		if err := client.RunCommand(fmt.Sprintf("cd %s && sudo docker compose up -d --remove-orphans", remoteDir), stdout, stderr); err != nil {
+		if err := client.RunIdempotent(fmt.Sprintf("cd %s && sudo docker compose up -d --remove-orphans", remoteDir), stdout, stderr); err != nil {
 			return err
 		}
 	}
@@ -777,7 +979,7 @@ func SyncComposeOnly(envname string, client *ssh.Client, p *Project, heave bool,
 }
 
 // UploadEnvironmentFiles handles environment-specific file uploads according to the universal path pattern
-func UploadEnvironmentFiles(envname string, client *ssh.Client, p *Project, remoteDir string, stdout, stderr io.Writer) error {
+func UploadEnvironmentFiles(envname string, client ssh.Remote, p *Project, remoteDir string, stdout, stderr io.Writer) error {
 	localFile := filepath.Join("compose", fmt.Sprintf("%s.yml", envname))
 	if _, err := os.Stat(localFile); err != nil {
 		return nil // Nothing to do if no compose file
@@ -810,7 +1012,7 @@ func UploadEnvironmentFiles(envname string, client *ssh.Client, p *Project, remo
 				remoteEnvDir := path.Dir(remoteEnvPath)
 
 				// Create parent directory on remote server
-				if err := client.RunCommand(fmt.Sprintf("mkdir -p %s", remoteEnvDir), stdout, stderr); err != nil {
+				if err := client.RunIdempotent(fmt.Sprintf("mkdir -p %s", remoteEnvDir), stdout, stderr); err != nil {
 					return fmt.Errorf("failed to create remote directory %s: %v", remoteEnvDir, err)
 				}
 
@@ -824,3 +1026,77 @@ func UploadEnvironmentFiles(envname string, client *ssh.Client, p *Project, remo
 	}
 	return nil
 }
+
+// prepareCompose turns the services of graft-compose.yml into the ones the
+// server runs: environments are merged into env/<service>.env.<env> and
+// services point at what is built or pulled for them. composeOnly prepares
+// them for a compose sync, which uploads no source code.
+func prepareCompose(compose *DockerComposeFile, remoteProjName, envname string, composeOnly bool) {
+	// Load secrets
+	secrets, _ := config.LoadSecrets()
+
+	for sName := range compose.Services {
+		sPtr := compose.Services[sName]
+		ProcessServiceEnvironment(sName, &sPtr, secrets, envname)
+
+		// localbuild services run the image streamed from this machine
+		if isLocalBuild(sPtr) {
+			useLocalImage(remoteProjName, sName, &sPtr)
+		}
+
+		mode := getGraftMode(sPtr.Labels)
+		if !composeOnly && mode == "serverbuild" && sPtr.Build != nil {
+			// For serverbuild services, update build context to point to uploaded code
+			contextName := filepath.Base(sPtr.Build.Context)
+			if contextName == "." || contextName == "/" {
+				contextName = sName
+			}
+			sPtr.Build.Context = "./" + contextName
+		} else if composeOnly && mode == "git-images" && sPtr.Build != nil {
+			// If in git-images mode and has build, replace with GHCR image
+			remoteURL, err := git.GetRemoteURL(".", "origin")
+			if err == nil {
+				ownerRepo := ""
+				if strings.HasPrefix(remoteURL, "https://") {
+					parts := strings.Split(strings.TrimSuffix(remoteURL, ".git"), "/")
+					if len(parts) >= 2 {
+						ownerRepo = parts[len(parts)-2] + "/" + parts[len(parts)-1]
+					}
+				} else if strings.HasPrefix(remoteURL, "git@") {
+					parts := strings.Split(strings.TrimSuffix(remoteURL, ".git"), ":")
+					if len(parts) >= 2 {
+						ownerRepo = parts[1]
+					}
+				}
+
+				if ownerRepo != "" {
+					sPtr.Image = fmt.Sprintf("ghcr.io/%s/%s:latest", strings.ToLower(ownerRepo), sName)
+					sPtr.Build = nil // Remove build context
+				}
+			}
+		}
+
+		compose.Services[sName] = sPtr
+	}
+}
+
+// saveCompose writes the generated docker-compose.yml to compose/<env>.yml
+// and returns its content
+func saveCompose(envname string, compose *DockerComposeFile) ([]byte, error) {
+	updatedComposeData, err := yaml.Marshal(compose)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal updated compose file: %v", err)
+	}
+
+	localComposeFile := filepath.Join("compose", fmt.Sprintf("%s.yml", envname))
+	if err := os.MkdirAll("compose", 0755); err != nil {
+		return nil, fmt.Errorf("failed to create compose directory: %v", err)
+	}
+	if err := os.WriteFile(localComposeFile, updatedComposeData, 0644); err != nil {
+		return nil, fmt.Errorf("failed to save %s: %v", localComposeFile, err)
+	}
+
+	// Ensure .gitignore is up to date
+	EnsureGitignore(".")
+	return updatedComposeData, nil
+}
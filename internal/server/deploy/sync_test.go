@@ -0,0 +1,290 @@
+package deploy_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skssmd/graft/internal/server/deploy"
+	"github.com/skssmd/graft/internal/server/ssh"
+	"github.com/skssmd/graft/internal/server/ssh/sshtest"
+)
+
+const testCompose = `services:
+  api:
+    build:
+      context: ./api
+    labels:
+      - "graft.mode=serverbuild"
+      - "traefik.enable=true"
+  web:
+    image: nginx:1.27
+    labels:
+      - "graft.mode=image"
+      - "graft.hooks.pre-deploy=./migrate"
+`
+
+const remoteDir = "/opt/graft/projects/demo-prod"
+
+// previousCompose is the docker-compose.yml of the deploy before the test's
+const previousCompose = "services:\n  web:\n    image: nginx:1.26\n"
+
+// newTestProject starts a test server and a project directory holding a
+// graft-compose.yml with a serverbuild service (api) and an image service
+// (web) that has a pre-deploy hook. HOME is moved so nothing outside the
+// test is read or written.
+func newTestProject(t *testing.T) (*sshtest.Server, *ssh.Client) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+
+	files := map[string]string{
+		"graft-compose.yml": testCompose,
+		"api/Dockerfile":    "FROM scratch\nCOPY main /\n",
+		"api/main":          "binary\n",
+	}
+	for name, data := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv, err := sshtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	client, err := srv.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return srv, client
+}
+
+// ran reports whether the server was asked to run a command containing s
+func ran(cmds []string, s string) bool {
+	for _, cmd := range cmds {
+		if strings.Contains(cmd, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// failHook makes the pre-deploy hook of web fail with backups enabled. When
+// deployed is set, a previous deploy is on the server, so a backup is taken
+// and can be restored.
+func failHook(t *testing.T, srv *sshtest.Server, p *deploy.Project, deployed bool) {
+	t.Helper()
+	p.RollbackBackups = 1
+	srv.Respond("date +%Y%m%d%H%M%S", "20250101120000\n", 0)
+	srv.Respond("run --rm --no-deps -T web sh -c './migrate'", "", 1)
+	if !deployed {
+		return
+	}
+	// The backup's copy is made by `sudo cp`, which the test server only
+	// records, so it is written here
+	for _, name := range []string{remoteDir + "/docker-compose.yml", backupDir + "/compose/docker-compose.yml"} {
+		if err := srv.WriteFile(name, []byte(previousCompose)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	tests := []struct {
+		name     string
+		heave    bool
+		noCache  bool
+		hookFail bool
+		deployed bool
+		want     []string
+		notWant  []string
+		wantErr  string
+	}{
+		{
+			name: "full deploy",
+			want: []string{
+				"cd " + remoteDir + " && sudo docker compose build api",
+				"cd " + remoteDir + " && sudo docker compose pull web",
+				"run --rm --no-deps -T web sh -c './migrate'",
+				"cd " + remoteDir + " && sudo docker compose up -d --remove-orphans",
+				"sudo docker image prune -f",
+			},
+			notWant: []string{"docker builder prune", "--no-cache"},
+		},
+		{
+			name:     "failed pre-deploy hook rolls back",
+			hookFail: true,
+			deployed: true,
+			want: []string{
+				"sudo cp " + remoteDir + "/docker-compose.yml " + backupDir + "/compose/",
+				"sudo cp " + backupDir + "/compose/docker-compose.yml " + remoteDir + "/",
+				"cd " + remoteDir + " && sudo docker compose up -d --remove-orphans --pull never",
+			},
+			notWant: []string{"docker compose ps -q web"},
+			wantErr: "rolled back to backup 20250101120000",
+		},
+		{
+			name:     "failed pre-deploy hook of a first deploy",
+			hookFail: true,
+			notWant:  []string{"date +%Y%m%d%H%M%S", "/opt/graft/backup/", "docker compose up"},
+			wantErr:  "pre-deploy hook of web failed",
+		},
+		{
+			name:    "no cache",
+			noCache: true,
+			want: []string{
+				"sudo docker builder prune -f",
+				"cd " + remoteDir + " && sudo docker compose build --no-cache api",
+			},
+		},
+		{
+			name:    "heave uploads only",
+			heave:   true,
+			notWant: []string{"docker compose build", "docker compose pull", "docker compose up"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, client := newTestProject(t)
+			p := &deploy.Project{Name: "demo", Env: "prod"}
+			if tt.hookFail {
+				failHook(t, srv, p, tt.deployed)
+			}
+
+			err := deploy.Sync("prod", client, p, tt.noCache, tt.heave, false, "", "", 1, io.Discard, io.Discard)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Sync error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Sync: %v", err)
+			}
+
+			cmds := srv.Commands()
+			for _, s := range tt.want {
+				if !ran(cmds, s) {
+					t.Errorf("no command containing %q in %q", s, cmds)
+				}
+			}
+			for _, s := range tt.notWant {
+				if ran(cmds, s) {
+					t.Errorf("unexpected command containing %q in %q", s, cmds)
+				}
+			}
+
+			// The generated compose file and the api source are on the server
+			compose, err := srv.ReadFile(remoteDir + "/docker-compose.yml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range []string{"api:", "web:", "nginx:1.27"} {
+				if !strings.Contains(string(compose), s) {
+					t.Errorf("docker-compose.yml lacks %q:\n%s", s, compose)
+				}
+			}
+			if src, err := srv.ReadFile(remoteDir + "/api/main"); err != nil || string(src) != "binary\n" {
+				t.Errorf("api/main = %q, %v", src, err)
+			}
+
+			// The deploy is recorded in the project's history
+			if !ran(cmds, "cat >> /opt/graft/history/demo.jsonl") {
+				t.Errorf("deploy was not recorded in the history: %q", cmds)
+			}
+		})
+	}
+}
+
+func TestSyncService(t *testing.T) {
+	tests := []struct {
+		name     string
+		service  string
+		hookFail bool
+		want     []string
+		notWant  []string
+		wantErr  string
+	}{
+		{
+			name:    "serverbuild service",
+			service: "api",
+			want: []string{
+				"cd " + remoteDir + " && sudo docker compose build api",
+				"cd " + remoteDir + " && sudo docker compose up -d api",
+			},
+			notWant: []string{"docker compose pull"},
+		},
+		{
+			name:    "image service",
+			service: "web",
+			want: []string{
+				"cd " + remoteDir + " && sudo docker compose pull web",
+				"run --rm --no-deps -T web sh -c './migrate'",
+				"cd " + remoteDir + " && sudo docker compose up -d web",
+			},
+			notWant: []string{"docker compose build"},
+		},
+		{
+			name:     "failed pre-deploy hook rolls back the service",
+			service:  "web",
+			hookFail: true,
+			want: []string{
+				"sudo cp " + remoteDir + "/docker-compose.yml " + backupDir + "/compose/",
+				"cd " + remoteDir + " && sudo docker compose stop web",
+				"cd " + remoteDir + " && sudo docker compose up -d web --pull never",
+			},
+			notWant: []string{"docker compose ps -q web"},
+			wantErr: "rolled back to backup 20250101120000",
+		},
+		{
+			name:    "unknown service",
+			service: "worker",
+			wantErr: "service 'worker' not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, client := newTestProject(t)
+			p := &deploy.Project{Name: "demo", Env: "prod"}
+			if tt.hookFail {
+				t.Setenv("TMPDIR", t.TempDir())
+				failHook(t, srv, p, true)
+			}
+
+			err := deploy.SyncService("prod", client, p, tt.service, false, false, false, "", "", 0, io.Discard, io.Discard)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("SyncService error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("SyncService: %v", err)
+			}
+
+			cmds := srv.Commands()
+			for _, s := range tt.want {
+				if !ran(cmds, s) {
+					t.Errorf("no command containing %q in %q", s, cmds)
+				}
+			}
+			for _, s := range tt.notWant {
+				if ran(cmds, s) {
+					t.Errorf("unexpected command containing %q in %q", s, cmds)
+				}
+			}
+			if tt.wantErr != "" {
+				return
+			}
+			if _, err := srv.ReadFile(remoteDir + "/docker-compose.yml"); err != nil {
+				t.Errorf("docker-compose.yml was not uploaded: %v", err)
+			}
+		})
+	}
+}
@@ -7,8 +7,10 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
+	"github.com/skssmd/graft/internal/server/ignore"
 	"gopkg.in/yaml.v3"
 )
 
@@ -180,8 +182,8 @@ func ProcessServiceEnvironment(serviceName string, service *ComposeService, secr
 	return []string{mergedEnvPath}, nil
 }
 
-// Create a tarball of a directory
-func createTarball(sourceDir, tarballPath string) error {
+// Create a tarball of a directory, leaving out paths the matcher ignores
+func createTarball(sourceDir, tarballPath string, ignored *ignore.Matcher) error {
 	file, err := os.Create(tarballPath)
 	if err != nil {
 		return err
@@ -199,17 +201,20 @@ func createTarball(sourceDir, tarballPath string) error {
 			return err
 		}
 
-		// Skip .git, node_modules, etc.
-		if info.IsDir() && (info.Name() == ".git" || info.Name() == "node_modules" || info.Name() == ".next") {
-			return filepath.SkipDir
-		}
-
-		header, err := tar.FileInfoHeader(info, "")
+		relPath, err := filepath.Rel(sourceDir, path)
 		if err != nil {
 			return err
 		}
 
-		relPath, err := filepath.Rel(sourceDir, path)
+		// Skip .git, node_modules and everything the ignore files exclude
+		if relPath != "." && ignored.Match(filepath.ToSlash(relPath), info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
 		if err != nil {
 			return err
 		}
@@ -290,3 +295,51 @@ func ExtractTraefikHosts(labels []string) []string {
 
 	return hosts
 }
+
+// UploadContext lists the files a sync would upload from one build context
+type UploadContext struct {
+	Service string
+	Context string
+	Files   []string
+}
+
+// ListUploadFiles applies the ignore rules to the build context of every
+// serverbuild service in graft-compose.yml, or only serviceName when set
+func ListUploadFiles(serviceName string) ([]UploadContext, error) {
+	compose, err := ParseComposeFile("graft-compose.yml", "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse compose file: %v", err)
+	}
+	if serviceName != "" {
+		if _, ok := compose.Services[serviceName]; !ok {
+			return nil, fmt.Errorf("service '%s' not found in graft-compose.yml", serviceName)
+		}
+	}
+
+	var names []string
+	for name := range compose.Services {
+		if serviceName == "" || name == serviceName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var contexts []UploadContext
+	for _, name := range names {
+		service := compose.Services[name]
+		if getGraftMode(service.Labels) != "serverbuild" || service.Build == nil {
+			continue
+		}
+		contextPath := filepath.Clean(service.Build.Context)
+		ignored, err := ignore.New(".", contextPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ignore files: %v", err)
+		}
+		files, err := ignored.Files()
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %v", contextPath, err)
+		}
+		contexts = append(contexts, UploadContext{Service: name, Context: contextPath, Files: files})
+	}
+	return contexts, nil
+}
@@ -0,0 +1,105 @@
+package deploy
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/skssmd/graft/internal/config"
+)
+
+// webhookSecrets are the GitHub environment secrets the deploy workflow uses
+// to reach the server over SSH
+var webhookSecrets = []string{"GRAFT_SSH_HOST", "GRAFT_SSH_USER", "GRAFT_SSH_KEY", "GRAFT_SSH_KNOWN_HOSTS"}
+
+// webhookDeployStep is the deploy workflow step that takes the project's
+// deploy lock over SSH, sends the graft-hook request and appends a webhook
+// entry to the project's history, with the lock file and history format
+// graft sync uses
+const webhookDeployStep = `      - name: Deploy via graft-hook
+        env:
+          GRAFT_SSH_HOST: ${{ secrets.GRAFT_SSH_HOST }}
+          GRAFT_SSH_PORT: ${{ secrets.GRAFT_SSH_PORT }}
+          GRAFT_SSH_USER: ${{ secrets.GRAFT_SSH_USER }}
+          GRAFT_SSH_KEY: ${{ secrets.GRAFT_SSH_KEY }}
+          GRAFT_SSH_KNOWN_HOSTS: ${{ secrets.GRAFT_SSH_KNOWN_HOSTS }}
+          GIT_BRANCH: ${{ github.event.workflow_run.head_branch || github.ref_name }}
+          GIT_COMMIT: ${{ github.event.workflow_run.head_sha || github.sha }}
+        run: |
+          for name in SECRETS; do
+            if [ -z "$(printenv $name)" ]; then
+              echo "::error::Secret $name is missing from the ENV environment; graft needs SSH access to record the deploy"
+              exit 1
+            fi
+          done
+          mkdir -p ~/.ssh
+          printf '%s\n' "$GRAFT_SSH_KEY" > ~/.ssh/graft_key
+          chmod 600 ~/.ssh/graft_key
+          printf '%s\n' "$GRAFT_SSH_KNOWN_HOSTS" >> ~/.ssh/known_hosts
+          remote() { ssh -i ~/.ssh/graft_key -p "${GRAFT_SSH_PORT:-22}" -o BatchMode=yes "$GRAFT_SSH_USER@$GRAFT_SSH_HOST" "$@"; }
+
+          # 1. Take the deploy lock, so webhook and manual deploys never overlap
+          started=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+          lock_id="github-${{ github.run_id }}-${{ github.run_attempt }}"
+          lock=$(printf '{"id":"%s","owner":"%s","host":"github-actions","pid":0,"action":"ACTION","started":"%s"}' "$lock_id" "${{ github.actor }}" "$started")
+          take_lock() { echo "$lock" | remote "sudo mkdir -p LOCKS_DIR && sudo chown \$USER:\$USER LOCKS_DIR && (set -C; cat > LOCK_FILE) 2>/dev/null"; }
+          if ! take_lock; then
+            # A lock that has not been renewed in time is stale and taken over
+            if remote "[ \$(( \$(date +%s) - \$(stat -c %Y LOCK_FILE) )) -gt STALE_SECONDS ] && rm -f LOCK_FILE" && take_lock; then
+              echo "::warning::Took over a stale deploy lock"
+            else
+              echo "::error::Another deploy is running: $(remote cat LOCK_FILE 2>/dev/null)"
+              exit 1
+            fi
+          fi
+          (while sleep HEARTBEAT_SECONDS; do remote "touch -c LOCK_FILE"; done) &
+          heartbeat=$!
+          release_lock() {
+            kill "$heartbeat" 2>/dev/null
+            remote "grep -q '$lock_id' LOCK_FILE 2>/dev/null && rm -f LOCK_FILE; true"
+          }
+          trap release_lock EXIT
+
+          # 2. Send the deploy request
+          start=$(date +%s)
+          result=success
+          error=""
+          if ! curl -fsS -X POST HOOK_URL \
+            -H "Content-Type: application/json" \
+            -d '{
+              "project": "PROJECT_FULL",
+              "repository": "${{ github.event.repository.name }}",
+              "token": "${{ secrets.GITHUB_TOKEN }}",
+              "user": "${{ github.actor }}",
+              "type": "DEPLOY_TYPE",
+              "registry": "ghcr.io"
+            }'; then
+            result=failed
+            error="graft-hook request failed"
+          fi
+
+          # 3. Record the deploy in graft history
+          entry=$(printf '{"time":"%s","action":"ACTION","user":"%s","host":"github-actions","env":"ENV","git_branch":"%s","git_commit":"%s","services":[],"duration_seconds":%d,"result":"%s","error":"%s"}' \
+            "$started" "${{ github.actor }}" "$GIT_BRANCH" "$GIT_COMMIT" "$(( $(date +%s) - start ))" "$result" "$error")
+          echo "$entry" | remote "sudo mkdir -p HISTORY_DIR && sudo chown \$USER:\$USER HISTORY_DIR && cat >> HISTORY_FILE" \
+            || echo "::warning::Could not record the deploy in graft history"
+          [ "$result" = success ]
+`
+
+// webhookDeploySteps returns the steps of the deploy workflow of a project
+// and environment. projFull is the name graft-hook knows the project by.
+func webhookDeploySteps(project, env, projFull, hookURL, deployType string) string {
+	return strings.NewReplacer(
+		"SECRETS", strings.Join(webhookSecrets, " "),
+		"HOOK_URL", hookURL,
+		"PROJECT_FULL", projFull,
+		"DEPLOY_TYPE", deployType,
+		"ACTION", ActionWebhook,
+		"LOCKS_DIR", config.RemoteLocksDir,
+		"LOCK_FILE", lockPath(project, env),
+		"STALE_SECONDS", strconv.Itoa(int(staleLockAfter.Seconds())),
+		"HEARTBEAT_SECONDS", strconv.Itoa(int(lockHeartbeat.Seconds())),
+		"HISTORY_DIR", config.RemoteHistoryDir,
+		"HISTORY_FILE", historyPath(project),
+		"ENV", env,
+	).Replace(webhookDeployStep)
+}
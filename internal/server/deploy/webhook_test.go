@@ -0,0 +1,91 @@
+package deploy_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/skssmd/graft/internal/server/deploy"
+)
+
+func TestGenerateWorkflowsDeployStep(t *testing.T) {
+	tests := []struct {
+		mode string
+		want []string
+	}{
+		{
+			mode: "git-repo-serverbuild",
+			want: []string{
+				"curl -fsS -X POST https://hook.example.com/webhook",
+				`"project": "demo-prod"`,
+				`"type": "repo"`,
+				`"action":"webhook"`,
+				`"env":"prod"`,
+				"(set -C; cat > /opt/graft/locks/demo-prod.lock)",
+				"touch -c /opt/graft/locks/demo-prod.lock",
+				"trap release_lock EXIT",
+				"cat >> /opt/graft/history/demo.jsonl",
+			},
+		},
+		{
+			mode: "git-images",
+			want: []string{
+				`"type": "image"`,
+				"cat >> /opt/graft/history/demo.jsonl",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			t.Chdir(t.TempDir())
+			if err := os.WriteFile("graft-compose.yml", []byte(testCompose), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			p := &deploy.Project{Name: "demo"}
+			if err := deploy.GenerateWorkflows(p, "prod", "https://github.com/acme/demo.git", tt.mode, "https://hook.example.com"); err != nil {
+				t.Fatalf("GenerateWorkflows: %v", err)
+			}
+			data, err := os.ReadFile(filepath.Join(".github", "workflows", "deploy-prod.yml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The workflow stays valid YAML with the script as one step
+			var workflow struct {
+				Jobs map[string]struct {
+					Steps []struct {
+						Run string            `yaml:"run"`
+						Env map[string]string `yaml:"env"`
+					} `yaml:"steps"`
+				} `yaml:"jobs"`
+			}
+			if err := yaml.Unmarshal(data, &workflow); err != nil {
+				t.Fatalf("deploy workflow is not valid YAML: %v\n%s", err, data)
+			}
+			steps := workflow.Jobs["deploy"].Steps
+			if len(steps) != 1 {
+				t.Fatalf("deploy job has %d steps, want 1", len(steps))
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(steps[0].Run, s) {
+					t.Errorf("deploy step lacks %q:\n%s", s, steps[0].Run)
+				}
+			}
+			// The lock is held before graft-hook is asked to deploy
+			if lock, hook := strings.Index(steps[0].Run, "(set -C; cat > "), strings.Index(steps[0].Run, "curl "); lock < 0 || lock > hook {
+				t.Errorf("deploy step sends the request before taking the lock:\n%s", steps[0].Run)
+			}
+			for _, secret := range []string{"GRAFT_SSH_HOST", "GRAFT_SSH_USER", "GRAFT_SSH_KEY", "GRAFT_SSH_KNOWN_HOSTS"} {
+				if steps[0].Env[secret] != "${{ secrets."+secret+" }}" {
+					t.Errorf("step env %s = %q", secret, steps[0].Env[secret])
+				}
+			}
+		})
+	}
+}
@@ -11,7 +11,7 @@ import (
 	"github.com/skssmd/graft/internal/server/ssh"
 )
 
-func InitHost(client *ssh.Client, setupPostgres, setupRedis, exposePostgres, exposeRedis bool, pgUser, pgPass, pgDB string, stdout, stderr io.Writer) error {
+func InitHost(client ssh.Remote, setupPostgres, setupRedis, exposePostgres, exposeRedis bool, pgUser, pgPass, pgDB string, stdout, stderr io.Writer) error {
 	// Detect OS and set appropriate package manager commands
 	var dockerInstallCmd, composeInstallCmd string
 
@@ -94,7 +94,7 @@ sudo usermod -aG docker $USER`
 		},
 		{
 			name:  "Setup Traefik",
-			check: "sudo docker ps | grep graft-traefik",
+			check: "sudo docker ps | grep graft-traefik && grep -q providers.file.directory /opt/graft/gateway/docker-compose.yml",
 			cmd: `sudo tee /opt/graft/gateway/docker-compose.yml <<EOF
 version: '3.8'
 services:
@@ -109,6 +109,10 @@ services:
       - "--providers.docker=true"
       - "--providers.docker.exposedbydefault=false"
       
+      # File provider (blue-green routing)
+      - "--providers.file.directory=/etc/traefik/dynamic"
+      - "--providers.file.watch=true"
+      
       # Entrypoints
       - "--entrypoints.web.address=:80"
       - "--entrypoints.websecure.address=:443"
@@ -129,6 +133,7 @@ services:
     volumes:
       - "/var/run/docker.sock:/var/run/docker.sock:ro"
       - "/opt/graft/gateway/letsencrypt:/letsencrypt"
+      - "/opt/graft/gateway/dynamic:/etc/traefik/dynamic:ro"
     networks:
       - graft-public
     restart: unless-stopped
@@ -139,6 +144,7 @@ networks:
 EOF
 sudo mkdir -p /opt/graft/gateway/letsencrypt
 sudo chmod 600 /opt/graft/gateway/letsencrypt
+sudo mkdir -p /opt/graft/gateway/dynamic && sudo chown $USER:$USER /opt/graft/gateway/dynamic
 sudo docker compose -f /opt/graft/gateway/docker-compose.yml up -d`,
 			skipMsg: "Traefik gateway is already running.",
 		},
@@ -193,7 +199,7 @@ sudo docker compose -f /opt/graft/gateway/docker-compose.yml up -d`,
 	return nil
 }
 
-func SetupInfra(client *ssh.Client, setupPostgres, setupRedis bool, cfg config.InfraConfig, stdout, stderr io.Writer) error {
+func SetupInfra(client ssh.Remote, setupPostgres, setupRedis bool, cfg config.InfraConfig, stdout, stderr io.Writer) error {
 	var services string
 	if setupPostgres {
 		ports := ""
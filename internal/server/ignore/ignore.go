@@ -0,0 +1,367 @@
+// Package ignore decides which files of a build context are uploaded to the
+// server. It follows .gitignore rules, including negation, anchoring, "**"
+// and nested .gitignore files, and adds the project's .graftignore and the
+// build context's .dockerignore on top.
+//
+// Rules are applied in this order, the last matching rule winning:
+//
+//  1. built-in defaults (.git, .graft, node_modules, .next, *.log)
+//  2. .gitignore files from the project root down to the file's directory
+//  3. .dockerignore in the build context
+//  4. .graftignore in the project root
+//
+// As with git, a file inside an ignored directory cannot be re-included.
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Ignore file names
+const (
+	GitIgnore    = ".gitignore"
+	GraftIgnore  = ".graftignore"
+	DockerIgnore = ".dockerignore"
+)
+
+// Defaults are excluded unless a later rule re-includes them. .graft holds
+// the local secrets file and must never reach the server by accident.
+var Defaults = []string{
+	".git/",
+	".graft/",
+	"node_modules/",
+	".next/",
+	"*.log",
+}
+
+// Matcher answers whether a path inside a build context is ignored
+type Matcher struct {
+	context string // absolute, slash separated
+	root    string // absolute, slash separated
+
+	defaults rules
+	docker   rules
+	graft    rules
+
+	mu  sync.Mutex
+	git map[string]rules // directory -> rules of its .gitignore
+}
+
+// rules are the patterns of one ignore file, relative to base
+type rules struct {
+	base     string
+	patterns []pattern
+}
+
+type pattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// New builds the matcher for contextDir. projectRoot is the directory that
+// holds graft-compose.yml and .graftignore; .gitignore files between it and
+// contextDir apply as well. A context outside the project root only uses its
+// own ignore files.
+func New(projectRoot, contextDir string) (*Matcher, error) {
+	root, err := filepath.Abs(projectRoot)
+	if err != nil {
+		return nil, err
+	}
+	context, err := filepath.Abs(contextDir)
+	if err != nil {
+		return nil, err
+	}
+	m := &Matcher{
+		context: filepath.ToSlash(context),
+		root:    filepath.ToSlash(root),
+		git:     map[string]rules{},
+	}
+	if !within(m.root, m.context) {
+		m.root = m.context
+	}
+
+	m.defaults = compile(m.context, Defaults, false)
+	if m.docker, err = loadFile(m.context, filepath.Join(context, DockerIgnore), true); err != nil {
+		return nil, err
+	}
+	if m.graft, err = loadFile(m.root, filepath.Join(root, GraftIgnore), false); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Match reports whether rel, a slash separated path relative to the build
+// context, is ignored. dir tells whether rel is a directory.
+func (m *Matcher) Match(rel string, dir bool) bool {
+	if m == nil {
+		return false
+	}
+	rel = path.Clean(rel)
+	if rel == "." {
+		return false
+	}
+
+	// A file cannot be re-included once a parent directory is ignored
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if m.matchOne(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return m.matchOne(rel, dir)
+}
+
+// matchOne applies every rule to a single path, ignoring its parents
+func (m *Matcher) matchOne(rel string, dir bool) bool {
+	abs := path.Join(m.context, rel)
+
+	ignored := false
+	apply := func(r rules) {
+		sub, ok := relTo(r.base, abs)
+		if !ok {
+			return
+		}
+		for _, p := range r.patterns {
+			if p.dirOnly && !dir {
+				continue
+			}
+			if p.re.MatchString(sub) {
+				ignored = !p.negate
+			}
+		}
+	}
+
+	apply(m.defaults)
+	for _, r := range m.gitRules(path.Dir(abs)) {
+		apply(r)
+	}
+	apply(m.docker)
+	apply(m.graft)
+	return ignored
+}
+
+// gitRules returns the .gitignore rules that apply to entries of dir,
+// outermost first
+func (m *Matcher) gitRules(dir string) []rules {
+	var dirs []string
+	for d := dir; within(m.root, d); d = path.Dir(d) {
+		dirs = append(dirs, d)
+		if d == m.root {
+			break
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []rules
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		r, ok := m.git[d]
+		if !ok {
+			// Unreadable nested ignore files are treated as empty, like git does
+			r, _ = loadFile(d, filepath.Join(filepath.FromSlash(d), GitIgnore), false)
+			m.git[d] = r
+		}
+		if len(r.patterns) > 0 {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// Files walks the build context and returns the slash separated paths of
+// every file that would be uploaded, sorted
+func (m *Matcher) Files() ([]string, error) {
+	root := filepath.FromSlash(m.context)
+	files := []string{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if m.matchOne(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// loadFile reads an ignore file. A missing file yields no rules.
+func loadFile(base, file string, anchored bool) (rules, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return rules{base: base}, nil
+		}
+		return rules{base: base}, fmt.Errorf("unable to read %s: %v", file, err)
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return rules{base: base}, fmt.Errorf("unable to read %s: %v", file, err)
+	}
+	return compile(base, lines, anchored), nil
+}
+
+// compile parses ignore file lines. .dockerignore patterns are always
+// relative to the context root, so they are compiled anchored.
+func compile(base string, lines []string, anchored bool) rules {
+	r := rules{base: base}
+	for _, line := range lines {
+		if p, ok := parsePattern(line, anchored); ok {
+			r.patterns = append(r.patterns, p)
+		}
+	}
+	return r
+}
+
+func parsePattern(line string, anchored bool) (pattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	line = trimTrailingSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return pattern{}, false
+	}
+
+	var p pattern
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+
+	if anchored {
+		// Docker cleans patterns, so "dir/" and "./dir" mean "dir"
+		line = strings.TrimPrefix(path.Clean("/"+line), "/")
+		if line == "" {
+			return pattern{}, false
+		}
+	} else {
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		// A slash anywhere but the end anchors the pattern to its file
+		anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			return pattern{}, false
+		}
+	}
+
+	expr := translate(line)
+	if anchored {
+		expr = "^" + expr + "$"
+	} else {
+		expr = "^(?:.*/)?" + expr + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return pattern{}, false
+	}
+	p.re = re
+	return p, true
+}
+
+// translate turns a glob into a regular expression. "*", "?" and classes
+// never cross a slash; "**" does when it spans whole path segments.
+func translate(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				start := i == 0 || glob[i-1] == '/'
+				i++
+				for i+1 < len(glob) && glob[i+1] == '*' {
+					i++
+				}
+				switch {
+				case start && i+1 < len(glob) && glob[i+1] == '/':
+					// "**/" matches zero or more directories
+					b.WriteString("(?:.*/)?")
+					i++
+				case start && i+1 == len(glob):
+					// trailing "/**" matches everything inside
+					b.WriteString(".*")
+				default:
+					b.WriteString("[^/]*")
+				}
+				continue
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				c = glob[i]
+			}
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// trimTrailingSpace drops trailing spaces unless they are escaped
+func trimTrailingSpace(s string) string {
+	for strings.HasSuffix(s, " ") && !strings.HasSuffix(s, `\ `) {
+		s = s[:len(s)-1]
+	}
+	return strings.ReplaceAll(s, `\ `, " ")
+}
+
+// within reports whether p is dir or lies below it
+func within(dir, p string) bool {
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// relTo returns p relative to base when p lies below base
+func relTo(base, p string) (string, bool) {
+	if !within(base, p) || p == base {
+		return "", false
+	}
+	return strings.TrimPrefix(p, strings.TrimSuffix(base, "/")+"/"), true
+}
@@ -0,0 +1,148 @@
+package ignore_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/skssmd/graft/internal/server/ignore"
+)
+
+func TestMatcherFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		context string // build context below the project root
+		files   map[string]string
+		want    []string // uploaded files, relative to the context
+	}{
+		{
+			name:  "defaults",
+			files: map[string]string{".git/config": "", ".graft/secrets.env": "", "node_modules/x/index.js": "", "app.log": "", "main.go": ""},
+			want:  []string{"main.go"},
+		},
+		{
+			name:  "negation",
+			files: map[string]string{".gitignore": "*.txt\n!keep.txt\n", "a.txt": "", "keep.txt": ""},
+			want:  []string{".gitignore", "keep.txt"},
+		},
+		{
+			name:  "no re-include inside an ignored directory",
+			files: map[string]string{".gitignore": "build/\n!build/keep.txt\n", "build/keep.txt": "", "main.go": ""},
+			want:  []string{".gitignore", "main.go"},
+		},
+		{
+			name: "anchoring",
+			files: map[string]string{
+				".gitignore": "/out\ndocs/tmp\nsecret.env\n",
+				"out/a":      "", "src/out/b": "",
+				"docs/tmp/c": "", "src/docs/tmp/d": "",
+				"secret.env": "", "config/secret.env": "",
+			},
+			want: []string{".gitignore", "src/docs/tmp/d", "src/out/b"},
+		},
+		{
+			name:  "directory only patterns",
+			files: map[string]string{".gitignore": "cache/\n", "cache": "", "lib/cache/x": ""},
+			want:  []string{".gitignore", "cache"},
+		},
+		{
+			name: "double star",
+			files: map[string]string{
+				".gitignore": "**/gen/*.go\nlogs/**\na/**/z\n",
+				"gen/x.go":   "", "pkg/gen/y.go": "", "pkg/gen/sub/k.go": "",
+				"logs/a/b": "", "a/z": "", "a/b/c/z": "", "a/b/y": "",
+			},
+			want: []string{".gitignore", "a/b/y", "pkg/gen/sub/k.go"},
+		},
+		{
+			name: "nested gitignore",
+			files: map[string]string{
+				".gitignore":     "*.bak\n",
+				"sub/.gitignore": "*.tmp\n/local\n!keep.bak\n",
+				"x.tmp":          "", "x.bak": "", "sub/x.tmp": "", "sub/deep/y.tmp": "",
+				"sub/local": "", "sub/deep/local": "", "sub/keep.bak": "", "sub/old.bak": "",
+			},
+			want: []string{".gitignore", "sub/.gitignore", "sub/deep/local", "sub/keep.bak", "x.tmp"},
+		},
+		{
+			name:    "dockerignore is anchored to the context",
+			context: "api",
+			files: map[string]string{
+				"api/.dockerignore": "tmp\n*.md\n./dist/\n",
+				"api/tmp/a":         "", "api/src/tmp/b": "",
+				"api/README.md": "", "api/docs/x.md": "",
+				"api/dist/app.js": "", "api/src/dist/lib.js": "",
+			},
+			want: []string{".dockerignore", "docs/x.md", "src/dist/lib.js", "src/tmp/b"},
+		},
+		{
+			name:    "graftignore applies last",
+			context: "api",
+			files: map[string]string{
+				".gitignore":        "*.env\ndist/\n",
+				".graftignore":      "!dist/\nnotes.txt\n!debug.log\n",
+				"api/.dockerignore": "!keep.env\nnotes.txt\n!notes.txt\n",
+				"api/keep.env":      "", "api/other.env": "", "api/dist/app.js": "",
+				"api/notes.txt": "", "api/debug.log": "", "api/main.go": "",
+			},
+			want: []string{".dockerignore", "debug.log", "dist/app.js", "keep.env", "main.go"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			for name, data := range tt.files {
+				p := filepath.Join(root, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			m, err := ignore.New(root, filepath.Join(root, tt.context))
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			got, err := m.Files()
+			if err != nil {
+				t.Fatalf("Files: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Files = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatcherMatch(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, ignore.GitIgnore), []byte("build/\n!build/keep.txt\n*.o\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ignore.New(root, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rel  string
+		dir  bool
+		want bool
+	}{
+		{"build", true, true},
+		{"build", false, false},
+		{"build/keep.txt", false, true},
+		{"src/main.o", false, true},
+		{"src/main.c", false, false},
+		{".", true, false},
+	}
+	for _, tt := range tests {
+		if got := m.Match(tt.rel, tt.dir); got != tt.want {
+			t.Errorf("Match(%q, %v) = %v, want %v", tt.rel, tt.dir, got, tt.want)
+		}
+	}
+}
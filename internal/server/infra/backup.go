@@ -1,7 +1,6 @@
 package infra
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,63 +9,58 @@ import (
 	"strings"
 
 	"github.com/skssmd/graft/internal/config"
+	"github.com/skssmd/graft/internal/server/prompt"
 	"github.com/skssmd/graft/internal/server/ssh"
 )
 
-func SetupDBBackup(client *ssh.Client, stdout, stderr io.Writer) error {
-	reader := bufio.NewReader(os.Stdin)
-
+func SetupDBBackup(client ssh.Remote, in *prompt.Answers, stdout, stderr io.Writer) error {
 	fmt.Fprintln(stdout, "\n☁️  S3 Backup Configuration")
 	fmt.Fprintln(stdout, "----------------------------")
 
 	s3 := &config.S3Config{}
 
-	fmt.Fprint(stdout, "S3 Endpoint (leave empty for AWS): ")
-	endpoint, _ := reader.ReadString('\n')
-	s3.Endpoint = strings.TrimSpace(endpoint)
+	s3.Endpoint = in.AskDefault("backup.s3.endpoint", "S3 Endpoint (leave empty for AWS): ", "")
 
-	fmt.Fprint(stdout, "S3 Region (e.g., us-east-1): ")
-	region, _ := reader.ReadString('\n')
-	s3.Region = strings.TrimSpace(region)
+	var err error
+	s3.Region, err = in.Ask("backup.s3.region", "S3 Region (e.g., us-east-1): ")
+	if err != nil {
+		return err
+	}
 	if s3.Region == "" {
 		return fmt.Errorf("S3 region is required")
 	}
 
-	fmt.Fprint(stdout, "S3 Bucket Name: ")
-	bucket, _ := reader.ReadString('\n')
-	s3.Bucket = strings.TrimSpace(bucket)
+	s3.Bucket, err = in.Ask("backup.s3.bucket", "S3 Bucket Name: ")
+	if err != nil {
+		return err
+	}
 	if s3.Bucket == "" {
 		return fmt.Errorf("S3 bucket name is required")
 	}
 
-	fmt.Fprint(stdout, "S3 Access Key: ")
-	accessKey, _ := reader.ReadString('\n')
-	s3.AccessKey = strings.TrimSpace(accessKey)
+	s3.AccessKey, err = in.AskSecret("backup.s3.access_key", "S3 Access Key: ")
+	if err != nil {
+		return err
+	}
 	if s3.AccessKey == "" {
 		return fmt.Errorf("S3 access key is required")
 	}
 
-	fmt.Fprint(stdout, "S3 Secret Key: ")
-	secretKey, _ := reader.ReadString('\n')
-	s3.SecretKey = strings.TrimSpace(secretKey)
+	s3.SecretKey, err = in.AskSecret("backup.s3.secret_key", "S3 Secret Key: ")
+	if err != nil {
+		return err
+	}
 	if s3.SecretKey == "" {
 		return fmt.Errorf("S3 secret key is required")
 	}
 
-	fmt.Fprint(stdout, "❓ Setup a daily backup schedule (2 AM)? (y/n): ")
-	ansSchedule, _ := reader.ReadString('\n')
-	doSchedule := strings.ToLower(strings.TrimSpace(ansSchedule)) == "y"
-
-	fmt.Fprint(stdout, "❓ Save credentials on the server in /opt/graft/infra/.backup.env? (y/n): ")
-	ansRemote, _ := reader.ReadString('\n')
-	doRemote := strings.ToLower(strings.TrimSpace(ansRemote)) == "y"
+	doSchedule := in.Confirm("backup.schedule", "❓ Setup a daily backup schedule (2 AM)? (y/n): ", false)
+	doRemote := in.Confirm("backup.save_remote", "❓ Save credentials on the server in /opt/graft/infra/.backup.env? (y/n): ", false)
 
 	if !doRemote {
 		fmt.Fprintln(stdout, "⚠️  Notice: Storing credentials on the server is required for scheduled backups.")
 		if doSchedule {
-			fmt.Fprint(stdout, "Do you want to proceed with saving them? (y/n): ")
-			confirm, _ := reader.ReadString('\n')
-			if strings.ToLower(strings.TrimSpace(confirm)) == "y" {
+			if in.Confirm("backup.save_remote", "Do you want to proceed with saving them? (y/n): ", false) {
 				doRemote = true
 			} else {
 				fmt.Fprintln(stdout, "❌ Aborted: Credentials must be saved on server for scheduling.")
@@ -167,9 +161,7 @@ echo "✅ Backup complete: ${FILENAME}"
 		}
 	}
 
-	fmt.Fprint(stdout, "\n❓ Run a backup now? (y/n): ")
-	runNow, _ := reader.ReadString('\n')
-	if strings.ToLower(strings.TrimSpace(runNow)) == "y" {
+	if in.Confirm("backup.run_now", "\n❓ Run a backup now? (y/n): ", false) {
 		fmt.Fprintln(stdout, "🚀 Running backup...")
 		if err := client.RunCommand("/opt/graft/infra/backup.sh", stdout, stderr); err != nil {
 			return fmt.Errorf("failed to run backup: %v", err)
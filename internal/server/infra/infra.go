@@ -12,7 +12,7 @@ import (
 	"github.com/skssmd/graft/internal/server/ssh"
 )
 
-func InitPostgres(client *ssh.Client, name string, stdout, stderr io.Writer) (string, error) {
+func InitPostgres(client ssh.Remote, name string, stdout, stderr io.Writer) (string, error) {
 	fmt.Fprintf(stdout, "🐘 Creating isolated Postgres database: %s\n", name)
 
 	// If credentials missing, try to load from remote server
@@ -46,7 +46,7 @@ func InitPostgres(client *ssh.Client, name string, stdout, stderr io.Writer) (st
 	return url, nil
 }
 
-func InitRedis(client *ssh.Client, name string, stdout, stderr io.Writer) (string, error) {
+func InitRedis(client ssh.Remote, name string, stdout, stderr io.Writer) (string, error) {
 	fmt.Fprintf(stdout, "🍦 Mapping Redis database for: %s\n", name)
 
 	// Redis doesn't have "CREATE DATABASE" in the same way.
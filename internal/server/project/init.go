@@ -1,7 +1,6 @@
 package project
 
 import (
-	"bufio"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -20,15 +19,16 @@ import (
 )
 
 // CheckLocalDirectory checks if the current directory is already initialized with Graft
-func CheckLocalDirectory(reader *bufio.Reader) error {
+func CheckLocalDirectory(in *prompt.Answers) error {
 	configPath := filepath.Join(".graft", "config.json")
 	projectPath := filepath.Join(".graft", "project.json")
 	if _, err := os.Stat(configPath); err == nil {
 		if _, err := os.Stat(projectPath); err == nil {
-			fmt.Print("\n⚠️  This directory is already initialized with Graft. Do you want to proceed? (y/n): ")
-			input, _ := reader.ReadString('\n')
-			input = strings.ToLower(strings.TrimSpace(input))
-			if input != "y" && input != "yes" {
+			proceed, err := in.ConfirmRequired("project.reinit", "\n⚠️  This directory is already initialized with Graft. Do you want to proceed? (y/n): ")
+			if err != nil {
+				return err
+			}
+			if !proceed {
 				fmt.Println("❌ Init aborted.")
 				return errors.New("Init aborted.")
 			}
@@ -39,15 +39,32 @@ func CheckLocalDirectory(reader *bufio.Reader) error {
 }
 
 // SelectOrAddServer prompts the user to select an existing server or add a new one
-func SelectOrAddServer(reader *bufio.Reader, gCfg *config.GlobalConfig ) (*config.ServerConfig, error) {
+func SelectOrAddServer(in *prompt.Answers, gCfg *config.GlobalConfig ) (*config.ServerConfig, error) {
 	var host string
 	var port int
 	var user string
 	var keyPath string
 	var registryName string
 	var srv config.ServerConfig
+	var err error
 	
-	if gCfg != nil && len(gCfg.Servers) > 0 {
+	// A scripted server may name a registry entry directly
+	if gCfg != nil && in.Has("server") {
+		name := in.AskDefault("server", "Server: ", "")
+		if selected, ok := gCfg.Servers[name]; ok {
+			fmt.Printf("✅ Using server: %s\n", selected.RegistryName)
+			return &selected, nil
+		}
+		if name != "/new" && name != "new" {
+			return nil, fmt.Errorf("server '%s' not found in registry", name)
+		}
+		if host, port, user, keyPath, err = prompt.PromptNewServer(in); err != nil {
+			return nil, err
+		}
+		if registryName, err = in.Ask("server.name", "Registry Name (e.g. prod-us): "); err != nil {
+			return nil, err
+		}
+	} else if gCfg != nil && len(gCfg.Servers) > 0 {
 		fmt.Println("\n📋 Available servers in registry:")
 		var keys []string
 		i := 1
@@ -56,16 +73,18 @@ func SelectOrAddServer(reader *bufio.Reader, gCfg *config.GlobalConfig ) (*confi
 			keys = append(keys, name)
 			i++
 		}
-		fmt.Printf("\nSelect a server [1-%d] or type '/new' for a new connection: ", len(keys))
-
-		input, _ := reader.ReadString('\n')
-		input = strings.TrimSpace(input)
+		input, err := in.Ask("server", fmt.Sprintf("\nSelect a server [1-%d] or type '/new' for a new connection: ", len(keys)))
+		if err != nil {
+			return nil, err
+		}
 
 		if input == "/new" {
-			host, port, user, keyPath = prompt.PromptNewServer(reader)
-			fmt.Print("Registry Name (e.g. prod-us): ")
-			registryName, _ = reader.ReadString('\n')
-			registryName = strings.TrimSpace(registryName)
+			if host, port, user, keyPath, err = prompt.PromptNewServer(in); err != nil {
+				return nil, err
+			}
+			if registryName, err = in.Ask("server.name", "Registry Name (e.g. prod-us): "); err != nil {
+				return nil, err
+			}
 		} else {
 			idx, err := strconv.Atoi(input)
 			if err == nil && idx > 0 && idx <= len(keys) {
@@ -78,21 +97,34 @@ func SelectOrAddServer(reader *bufio.Reader, gCfg *config.GlobalConfig ) (*confi
 				fmt.Printf("✅ Using server: %s\n", registryName)
 			} else {
 				fmt.Println("Invalid selection, entering new server details...")
-				host, port, user, keyPath = prompt.PromptNewServer(reader)
-				fmt.Print("Registry Name (e.g. prod-us): ")
-				registryName, _ = reader.ReadString('\n')
-				registryName = strings.TrimSpace(registryName)
+				if host, port, user, keyPath, err = prompt.PromptNewServer(in); err != nil {
+					return nil, err
+				}
+				if registryName, err = in.Ask("server.name", "Registry Name (e.g. prod-us): "); err != nil {
+					return nil, err
+				}
 			}
 		}
 	} else {
 		fmt.Println("No servers found in registry. Enter new server details:")
-		host, port, user, keyPath = prompt.PromptNewServer(reader)
-		fmt.Print("Registry Name (e.g. prod-us): ")
-		registryName, _ = reader.ReadString('\n')
-		registryName = strings.TrimSpace(registryName)
+		if host, port, user, keyPath, err = prompt.PromptNewServer(in); err != nil {
+			return nil, err
+		}
+		if registryName, err = in.Ask("server.name", "Registry Name (e.g. prod-us): "); err != nil {
+			return nil, err
+		}
 	}
 
-	// Update and return the selected/new server
+	// Update and return the selected/new server, keeping settings such as
+	// the pinned host key unless the address changed
+	if gCfg != nil {
+		if existing, ok := gCfg.Servers[registryName]; ok {
+			srv = existing
+			if srv.Host != host || srv.Port != port {
+				srv.HostKey = ""
+			}
+		}
+	}
 	srv.RegistryName = registryName
 	srv.Host = host
 	srv.Port = port
@@ -110,14 +142,19 @@ func SelectOrAddServer(reader *bufio.Reader, gCfg *config.GlobalConfig ) (*confi
 }
 
 // DefineProjectName prompts the user for a project name and validates it
-func DefineProjectName(reader *bufio.Reader) string {
+func DefineProjectName(in *prompt.Answers) (string, error) {
 	var projName string
 	for {
-		fmt.Print("Project Name: ")
-		input, _ := reader.ReadString('\n')
+		input, err := in.Ask("project.name", "Project Name: ")
+		if err != nil {
+			return "", err
+		}
 		projName = config.NormalizeProjectName(input)
 
 		if projName == "" {
+			if in.Has("project.name") {
+				return "", fmt.Errorf("project name cannot be empty and must contain alphanumeric characters")
+			}
 			fmt.Println("❌ Project name cannot be empty and must contain alphanumeric characters")
 			continue
 		}
@@ -128,13 +165,16 @@ func DefineProjectName(reader *bufio.Reader) string {
 			}
 			break
 		}
+		if in.Has("project.name") {
+			return "", fmt.Errorf("invalid project name '%s'. Use only letters, numbers, and underscores", input)
+		}
 		fmt.Println("❌ Invalid project name. Use only letters, numbers, and underscores.")
 	}
-	return projName
+	return projName, nil
 }
 
 // LocalConfigCheck checks for local project conflicts
-func LocalConfigCheck(reader *bufio.Reader, gCfg *config.GlobalConfig, projName string, force bool) bool {
+func LocalConfigCheck(in *prompt.Answers, gCfg *config.GlobalConfig, projName string, force bool) (bool, error) {
 	if gCfg != nil && gCfg.Projects != nil {
 		if existingLocalPath, exists := gCfg.Projects[projName]; exists && !force {
 			fmt.Printf("\n⚠️  Project '%s' already exists in your local registry:\n", projName)
@@ -151,39 +191,31 @@ func LocalConfigCheck(reader *bufio.Reader, gCfg *config.GlobalConfig, projName
 				}
 			}
 
-			fmt.Print("\nDo you want to overwrite this local registration? (y/n): ")
-			confirm, _ := reader.ReadString('\n')
-			confirm = strings.ToLower(strings.TrimSpace(confirm))
-			if confirm != "y" && confirm != "yes" {
+			overwrite, err := in.ConfirmRequired("project.overwrite", "\nDo you want to overwrite this local registration? (y/n): ")
+			if err != nil {
+				return false, err
+			}
+			if !overwrite {
 				fmt.Println("❌ Init aborted.")
-				return false
+				return false, nil
 			}
 			fmt.Println("✅ Local overwrite confirmed.")
 		}
 	}
-	return true
+	return true, nil
 }
 
 // RemoteConflictCheck checks for remote project conflicts and initializes the host if needed
-func RemoteConflictCheck(reader *bufio.Reader, srv *config.ServerConfig, projFull string, force bool, client *ssh.Client) (map[string]interface{}, error) {
+func RemoteConflictCheck(in *prompt.Answers, srv *config.ServerConfig, projFull string, force bool, client *ssh.Client) (map[string]interface{}, error) {
 	remoteProjects := make(map[string]interface{})
 	fmt.Printf("🔍 Checking for conflicts on remote server '%s'...\n", srv.Host)
 
 	// Host Initialization Check
 	if err := client.RunCommand("ls -d /opt/graft", nil, nil); err != nil {
-		fmt.Print("\n⚠️  Host is not initialized. Do you want to initialize the host? (y/n): ")
-		input, _ := reader.ReadString('\n')
-		input = strings.ToLower(strings.TrimSpace(input))
-		if input == "y" || input == "yes" {
+		if in.Confirm("host.init", "\n⚠️  Host is not initialized. Do you want to initialize the host? (y/n): ", false) {
 			fmt.Println("🚀 Starting host initialization...")
-			setupPostgres := false
-			setupRedis := false
-			fmt.Print("  Setup shared Postgres? (y/n): ")
-			input, _ = reader.ReadString('\n')
-			setupPostgres = strings.ToLower(strings.TrimSpace(input)) == "y"
-			fmt.Print("  Setup shared Redis? (y/n): ")
-			input, _ = reader.ReadString('\n')
-			setupRedis = strings.ToLower(strings.TrimSpace(input)) == "y"
+			setupPostgres := in.Confirm("host.postgres", "  Setup shared Postgres? (y/n): ", false)
+			setupRedis := in.Confirm("host.redis", "  Setup shared Redis? (y/n): ", false)
 
 			pgUser := strings.ToLower("graft_admin_" + config.GenerateRandomString(4))
 			pgPass := config.GenerateRandomString(24)
@@ -266,18 +298,25 @@ func SetupRemoteProjectDirectory(client *ssh.Client, remoteProjPath string, depl
 	}
 }
 // InitProjectWorkflow handles the initial sequence of project setup steps
-func InitProjectWorkflow(reader *bufio.Reader, force bool, gCfg *config.GlobalConfig) (projName string, err error) {
+func InitProjectWorkflow(in *prompt.Answers, force bool, gCfg *config.GlobalConfig) (projName string, err error) {
 	// Directory Check
-	if err = CheckLocalDirectory(reader); err != nil {
+	if err = CheckLocalDirectory(in); err != nil {
 		return "", fmt.Errorf("directory check failed: %w", err)
 	}
 
 	
 
-	projName = DefineProjectName(reader)
+	projName, err = DefineProjectName(in)
+	if err != nil {
+		return "", err
+	}
 
 	// Local Conflict Check
-	if !LocalConfigCheck(reader, gCfg, projName, force) {
+	ok, err := LocalConfigCheck(in, gCfg, projName, force)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
 		return "", fmt.Errorf("local conflict check failed")
 	}
 
@@ -285,14 +324,17 @@ func InitProjectWorkflow(reader *bufio.Reader, force bool, gCfg *config.GlobalCo
 }
 
 // InitRemoteWorkflow handles checking remote conflicts and gathering configuration
-func InitRemoteWorkflow(reader *bufio.Reader, client *ssh.Client, srv *config.ServerConfig, projFull string, force bool) (remoteProjects map[string]interface{}, domain string, versionToKeep int, err error) {
-	remoteProjects, err = RemoteConflictCheck(reader, srv, projFull, force, client)
+func InitRemoteWorkflow(in *prompt.Answers, client *ssh.Client, srv *config.ServerConfig, projFull string, force bool) (remoteProjects map[string]interface{}, domain string, versionToKeep int, err error) {
+	remoteProjects, err = RemoteConflictCheck(in, srv, projFull, force, client)
 	if err != nil {
 		return nil, "", 0, err
 	}
 
-	domain = prompt.PromptDomain(reader, "")
-	versionToKeep = prompt.PromptRollback(reader)
+	domain, err = prompt.PromptDomain(in, "")
+	if err != nil {
+		return nil, "", 0, err
+	}
+	versionToKeep = prompt.PromptRollback(in)
 
 	// Update remote entry if available
 	if remoteProjects != nil && versionToKeep > 0 {
@@ -306,12 +348,15 @@ func InitRemoteWorkflow(reader *bufio.Reader, client *ssh.Client, srv *config.Se
 }
 
 // InitDeploymentWorkflow handles deployment mode selection and optional hook installation
-func InitDeploymentWorkflow(reader *bufio.Reader, client *ssh.Client, gCfg *config.GlobalConfig, srv *config.ServerConfig, currentHookURL string) (deploymentMode, gitBranch, hookURL string, err error) {
-	deploymentMode, gitBranch = prompt.SetupDeploymentMode(reader)
+func InitDeploymentWorkflow(in *prompt.Answers, client *ssh.Client, gCfg *config.GlobalConfig, srv *config.ServerConfig, currentHookURL string) (deploymentMode, gitBranch, hookURL string, err error) {
+	deploymentMode, gitBranch, err = prompt.SetupDeploymentMode(in)
+	if err != nil {
+		return "", "", "", err
+	}
 
 	// Graft-Hook detection and deployment for automated modes
 	if deploymentMode == "git-images" || deploymentMode == "git-repo-serverbuild" || deploymentMode == "git-manual" {
-		hookURL, err = webhook.InstallHook(client, gCfg, deploymentMode, reader, currentHookURL, srv)
+		hookURL, err = webhook.InstallHook(client, gCfg, deploymentMode, in, currentHookURL, srv)
 		if err != nil {
 			return "", "", "", fmt.Errorf("could not install hook: %w", err)
 		}
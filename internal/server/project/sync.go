@@ -1,15 +1,16 @@
 package project
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/skssmd/graft/internal/config"
 	"github.com/skssmd/graft/internal/server/deploy"
 	"github.com/skssmd/graft/internal/server/git"
+	"github.com/skssmd/graft/internal/server/prompt"
 	"github.com/skssmd/graft/internal/server/ssh"
 )
 
@@ -21,17 +22,24 @@ type SyncArgs struct {
 	UseGit      bool
 	GitBranch   string
 	GitCommit   string
+	ListFiles   bool
+	Strategy    string
+	Parallel    int // services uploaded and built at once; -1 if --parallel was invalid
+	Canary      int // percent of traffic sent to a canary; -1 if --canary was invalid
 }
 
-// ParseSyncArgs parses command line arguments for sync command
-func ParseSyncArgs(args []string) SyncArgs {
-	var sa SyncArgs
+// ParseSyncArgs parses command line arguments for sync command. A flag that
+// needs a value but ends the command line is an error.
+func ParseSyncArgs(args []string) (SyncArgs, error) {
+	sa := SyncArgs{Parallel: deploy.DefaultParallel}
 	for i := 0; i < len(args); i++ {
 		arg := args[i]
 		if arg == "--no-cache" {
 			sa.NoCache = true
 		} else if arg == "-h" || arg == "--heave" {
 			sa.Heave = true
+		} else if arg == "--list-files" {
+			sa.ListFiles = true
 		} else if arg == "--git" {
 			sa.UseGit = true
 		} else if arg == "--branch" && i+1 < len(args) {
@@ -40,11 +48,50 @@ func ParseSyncArgs(args []string) SyncArgs {
 		} else if arg == "--commit" && i+1 < len(args) {
 			sa.GitCommit = args[i+1]
 			i++ // Skip next arg
+		} else if arg == "--strategy" && i+1 < len(args) {
+			sa.Strategy = args[i+1]
+			i++ // Skip next arg
+		} else if strings.HasPrefix(arg, "--strategy=") {
+			sa.Strategy = strings.TrimPrefix(arg, "--strategy=")
+		} else if arg == "--parallel" && i+1 < len(args) {
+			sa.Parallel = parseParallel(args[i+1])
+			i++ // Skip next arg
+		} else if arg == "--parallel" {
+			return sa, fmt.Errorf("--parallel requires a number of services, such as --parallel 4")
+		} else if strings.HasPrefix(arg, "--parallel=") {
+			sa.Parallel = parseParallel(strings.TrimPrefix(arg, "--parallel="))
+		} else if arg == "--canary" && i+1 < len(args) {
+			sa.Canary = parseCanary(args[i+1])
+			i++ // Skip next arg
+		} else if arg == "--canary" {
+			return sa, fmt.Errorf("--canary requires a share of traffic, such as --canary 10%%")
+		} else if strings.HasPrefix(arg, "--canary=") {
+			sa.Canary = parseCanary(strings.TrimPrefix(arg, "--canary="))
 		} else if sa.ServiceName == "" {
 			sa.ServiceName = arg
 		}
 	}
-	return sa
+	return sa, nil
+}
+
+// parseParallel reads the --parallel value, returning -1 if it is not a
+// positive number
+func parseParallel(value string) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return -1
+	}
+	return n
+}
+
+// parseCanary reads the --canary share, returning -1 if it is not between 1%
+// and 99%
+func parseCanary(value string) int {
+	weight, err := deploy.ParseCanaryWeight(value)
+	if err != nil {
+		return -1
+	}
+	return weight
 }
 
 // SyncInitializeGitProject handles first-time git project initialization
@@ -89,17 +136,11 @@ func SyncInitializeGitProject(env string, client *ssh.Client, p *deploy.Project,
 }
 
 // SyncHandleInitializedProject handles sync for already initialized projects
-func SyncHandleInitializedProject(env string, client *ssh.Client, p *deploy.Project, reader *bufio.Reader) error {
+func SyncHandleInitializedProject(env string, client *ssh.Client, p *deploy.Project, in *prompt.Answers) error {
 	fmt.Printf("\n⚠️  Project '%s' is already initialized.\n", p.Name)
 
-	fmt.Print("❓ Do you want to re-generate and transfer the compose file? (y/n): ")
-	ansCompose, _ := reader.ReadString('\n')
-
-	fmt.Print("❓ Do you want to transfer the environment files (env/)? (y/n): ")
-	ansEnv, _ := reader.ReadString('\n')
-
-	doCompose := strings.TrimSpace(strings.ToLower(ansCompose)) == "y"
-	doEnv := strings.TrimSpace(strings.ToLower(ansEnv)) == "y"
+	doCompose := in.Confirm("sync.compose", "❓ Do you want to re-generate and transfer the compose file? (y/n): ", false)
+	doEnv := in.Confirm("sync.env", "❓ Do you want to transfer the environment files (env/)? (y/n): ", false)
 
 	if doCompose || doEnv {
 		if err := deploy.SyncComposeOnly(env, client, p, true, os.Stdout, os.Stderr, doCompose, doEnv); err != nil {
@@ -114,6 +155,26 @@ func SyncHandleInitializedProject(env string, client *ssh.Client, p *deploy.Proj
 
 // SyncPerformDeploy performs the actual sync/deploy operation
 func SyncPerformDeploy(env string, client *ssh.Client, p *deploy.Project, sa SyncArgs, stdout, stderr io.Writer) error {
+	if sa.Parallel < 0 {
+		return fmt.Errorf("--parallel must be a positive number")
+	}
+	if sa.Canary < 0 {
+		return fmt.Errorf("--canary must be a share of traffic from 1%% to 99%%, such as 10%%")
+	}
+	if sa.Canary > 0 && sa.ServiceName == "" {
+		return fmt.Errorf("--canary releases one service: graft sync <service> --canary 10%%")
+	}
+	if sa.Canary > 0 && sa.Heave {
+		return fmt.Errorf("--canary cannot be combined with --heave, which does not deploy")
+	}
+
+	// Remember the strategy so later syncs keep using it
+	if sa.Strategy != "" {
+		if err := deploy.SetSyncStrategy(env, sa.ServiceName, sa.Strategy); err != nil {
+			return err
+		}
+	}
+
 	if sa.ServiceName != "" {
 		fmt.Fprintf(stdout, "🎯 Syncing service: %s\n", sa.ServiceName)
 		if sa.UseGit {
@@ -125,7 +186,7 @@ func SyncPerformDeploy(env string, client *ssh.Client, p *deploy.Project, sa Syn
 		if sa.Heave {
 			fmt.Fprintln(stdout, "📦 Heave sync enabled (upload only)")
 		}
-		return deploy.SyncService(env, client, p, sa.ServiceName, sa.NoCache, sa.Heave, sa.UseGit, sa.GitBranch, sa.GitCommit, stdout, stderr)
+		return deploy.SyncService(env, client, p, sa.ServiceName, sa.NoCache, sa.Heave, sa.UseGit, sa.GitBranch, sa.GitCommit, sa.Canary, stdout, stderr)
 	}
 
 	if sa.UseGit {
@@ -137,7 +198,7 @@ func SyncPerformDeploy(env string, client *ssh.Client, p *deploy.Project, sa Syn
 	if sa.Heave {
 		fmt.Fprintln(stdout, "🚀 Heave sync enabled (upload only)")
 	}
-	err := deploy.Sync(env, client, p, sa.NoCache, sa.Heave, sa.UseGit, sa.GitBranch, sa.GitCommit, stdout, stderr)
+	err := deploy.Sync(env, client, p, sa.NoCache, sa.Heave, sa.UseGit, sa.GitBranch, sa.GitCommit, sa.Parallel, stdout, stderr)
 	if err != nil {
 		return fmt.Errorf("error during sync: %w", err)
 	}
@@ -0,0 +1,77 @@
+package project
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/skssmd/graft/internal/server/deploy"
+)
+
+func TestParseSyncArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    SyncArgs
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			want: SyncArgs{Parallel: deploy.DefaultParallel},
+		},
+		{
+			name: "service and flags",
+			args: []string{"api", "--no-cache", "--strategy=bluegreen"},
+			want: SyncArgs{ServiceName: "api", NoCache: true, Strategy: "bluegreen", Parallel: deploy.DefaultParallel},
+		},
+		{
+			name: "parallel",
+			args: []string{"--parallel", "8"},
+			want: SyncArgs{Parallel: 8},
+		},
+		{
+			name: "parallel with =",
+			args: []string{"--parallel=2", "api"},
+			want: SyncArgs{ServiceName: "api", Parallel: 2},
+		},
+		{
+			name: "invalid parallel",
+			args: []string{"--parallel", "0"},
+			want: SyncArgs{Parallel: -1},
+		},
+		{
+			name:    "parallel without a value",
+			args:    []string{"api", "--parallel"},
+			wantErr: true,
+		},
+		{
+			name: "canary",
+			args: []string{"api", "--canary", "10%"},
+			want: SyncArgs{ServiceName: "api", Parallel: deploy.DefaultParallel, Canary: 10},
+		},
+		{
+			name: "invalid canary",
+			args: []string{"api", "--canary=100%"},
+			want: SyncArgs{ServiceName: "api", Parallel: deploy.DefaultParallel, Canary: -1},
+		},
+		{
+			name:    "canary without a weight",
+			args:    []string{"api", "--canary"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSyncArgs(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSyncArgs error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSyncArgs = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
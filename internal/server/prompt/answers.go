@@ -0,0 +1,253 @@
+package prompt
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Answers is the single source of input for every interactive setup flow.
+// Values come from an answers file, from command line flags, or from stdin.
+// When NonInteractive is set, a missing required answer returns an error
+// instead of blocking on stdin.
+type Answers struct {
+	reader         *bufio.Reader
+	values         map[string]string
+	NonInteractive bool
+}
+
+// NewAnswers creates an answer source that reads from stdin
+func NewAnswers() *Answers {
+	return &Answers{
+		reader: bufio.NewReader(os.Stdin),
+		values: make(map[string]string),
+	}
+}
+
+// MissingAnswerError is returned in non-interactive mode when a required answer is not provided
+type MissingAnswerError struct {
+	Key string
+}
+
+func (e *MissingAnswerError) Error() string {
+	return fmt.Sprintf("missing answer for '%s' (set it in the answers file or pass --set %s=<value>)", e.Key, e.Key)
+}
+
+// LoadFile reads answers from a YAML or JSON file. Nested maps are flattened
+// into dotted keys, so `server: {host: 1.2.3.4}` becomes `server.host`.
+func (a *Answers) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read answers file: %v", err)
+	}
+
+	// YAML is a superset of JSON, so one parser handles both formats
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("could not parse answers file %s: %v", path, err)
+	}
+
+	flattenAnswers("", raw, a.values)
+	return nil
+}
+
+func flattenAnswers(prefix string, in map[string]interface{}, out map[string]string) {
+	for k, v := range in {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		switch val := v.(type) {
+		case map[string]interface{}:
+			flattenAnswers(key, val, out)
+		case nil:
+			out[key] = ""
+		case bool:
+			if val {
+				out[key] = "y"
+			} else {
+				out[key] = "n"
+			}
+		default:
+			out[key] = fmt.Sprintf("%v", val)
+		}
+	}
+}
+
+// Set records an answer for the given key, overriding any value from a file
+func (a *Answers) Set(key, value string) {
+	a.values[key] = value
+}
+
+// Has reports whether a scripted answer exists for key
+func (a *Answers) Has(key string) bool {
+	_, ok := a.values[key]
+	return ok
+}
+
+// Keys returns the scripted answer keys in sorted order
+func (a *Answers) Keys() []string {
+	var keys []string
+	for k := range a.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// answerFlags maps shorthand command line flags to answer keys
+var answerFlags = map[string]string{
+	"--name":          "project.name",
+	"--server":        "server",
+	"--host":          "server.host",
+	"--port":          "server.port",
+	"--user":          "server.user",
+	"--key":           "server.key",
+	"--registry-name": "server.name",
+	"--domain":        "domain",
+	"--rollback":      "rollback",
+	"--mode":          "mode",
+	"--branch":        "git.branch",
+	"--hook-domain":   "hook.domain",
+}
+
+// ParseFlags consumes answer related flags from args and returns the remaining arguments.
+// Supported flags: --answers <file>, --yes, --non-interactive, --set key=value and the
+// shorthands in answerFlags (e.g. --domain app.example.com).
+func (a *Answers) ParseFlags(args []string) ([]string, error) {
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--yes" || arg == "--non-interactive":
+			a.NonInteractive = true
+		case arg == "--answers":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--answers requires a file path")
+			}
+			if err := a.LoadFile(args[i+1]); err != nil {
+				return nil, err
+			}
+			i++
+		case arg == "--set":
+			if i+1 >= len(args) || !strings.Contains(args[i+1], "=") {
+				return nil, fmt.Errorf("--set requires a key=value argument")
+			}
+			kv := strings.SplitN(args[i+1], "=", 2)
+			a.Set(kv[0], kv[1])
+			i++
+		default:
+			key, ok := answerFlags[arg]
+			if !ok {
+				rest = append(rest, arg)
+				continue
+			}
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires a value", arg)
+			}
+			a.Set(key, args[i+1])
+			i++
+		}
+	}
+	return rest, nil
+}
+
+// lookup returns the scripted answer for key, echoing it after the prompt text
+func (a *Answers) lookup(key, text string, secret bool) (string, bool) {
+	val, ok := a.values[key]
+	if !ok {
+		return "", false
+	}
+	shown := val
+	if secret && val != "" {
+		shown = "********"
+	}
+	fmt.Printf("%s%s\n", text, shown)
+	return val, true
+}
+
+func (a *Answers) readLine() string {
+	input, _ := a.reader.ReadString('\n')
+	return strings.TrimSpace(input)
+}
+
+// Ask returns the answer for a required question. In non-interactive mode a
+// missing answer is an error.
+func (a *Answers) Ask(key, text string) (string, error) {
+	return a.ask(key, text, false)
+}
+
+// AskSecret behaves like Ask but never echoes the scripted value
+func (a *Answers) AskSecret(key, text string) (string, error) {
+	return a.ask(key, text, true)
+}
+
+func (a *Answers) ask(key, text string, secret bool) (string, error) {
+	if val, ok := a.lookup(key, text, secret); ok {
+		return strings.TrimSpace(val), nil
+	}
+	if a.NonInteractive {
+		fmt.Println(text)
+		return "", &MissingAnswerError{Key: key}
+	}
+	fmt.Print(text)
+	return a.readLine(), nil
+}
+
+// AskDefault returns the answer for an optional question, falling back to def
+// when the answer is empty or missing in non-interactive mode.
+func (a *Answers) AskDefault(key, text, def string) string {
+	if val, ok := a.lookup(key, text, false); ok {
+		val = strings.TrimSpace(val)
+		if val == "" {
+			return def
+		}
+		return val
+	}
+	if a.NonInteractive {
+		fmt.Printf("%s%s\n", text, def)
+		return def
+	}
+	fmt.Print(text)
+	if input := a.readLine(); input != "" {
+		return input
+	}
+	return def
+}
+
+// Confirm asks a yes/no question. A missing answer in non-interactive mode
+// takes the default.
+func (a *Answers) Confirm(key, text string, def bool) bool {
+	defStr := "n"
+	if def {
+		defStr = "y"
+	}
+	input := strings.ToLower(a.AskDefault(key, text, defStr))
+	return input == "y" || input == "yes" || input == "true"
+}
+
+// ConfirmRequired asks a yes/no question that has no safe default, such as a
+// destructive confirmation. In non-interactive mode the answer must be scripted.
+func (a *Answers) ConfirmRequired(key, text string) (bool, error) {
+	input, err := a.Ask(key, text)
+	if err != nil {
+		return false, err
+	}
+	input = strings.ToLower(input)
+	return input == "y" || input == "yes" || input == "true", nil
+}
+
+// AskInt asks for a number, falling back to def on empty input
+func (a *Answers) AskInt(key, text string, def int) (int, error) {
+	input := a.AskDefault(key, text, strconv.Itoa(def))
+	n, err := strconv.Atoi(input)
+	if err != nil {
+		return def, fmt.Errorf("invalid number for '%s': %s", key, input)
+	}
+	return n, nil
+}
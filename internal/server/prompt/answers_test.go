@@ -0,0 +1,214 @@
+package prompt_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/skssmd/graft/internal/server/prompt"
+)
+
+func TestParseFlags(t *testing.T) {
+	answersFile := filepath.Join(t.TempDir(), "answers.yml")
+	if err := os.WriteFile(answersFile, []byte("domain: file.example.com\nrollback: 3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		args           []string
+		wantRest       []string
+		wantValues     map[string]string
+		nonInteractive bool
+		wantErr        bool
+	}{
+		{
+			name:       "shorthands",
+			args:       []string{"--host", "203.0.113.7", "--port", "2222", "--domain", "app.example.com"},
+			wantValues: map[string]string{"server.host": "203.0.113.7", "server.port": "2222", "domain": "app.example.com"},
+		},
+		{
+			name:       "set keeps everything after the first =",
+			args:       []string{"--set", "env.DATABASE_URL=postgres://u:p@db/app?sslmode=disable"},
+			wantValues: map[string]string{"env.DATABASE_URL": "postgres://u:p@db/app?sslmode=disable"},
+		},
+		{
+			name:           "yes and non-interactive",
+			args:           []string{"--yes", "init", "--non-interactive"},
+			wantRest:       []string{"init"},
+			wantValues:     map[string]string{},
+			nonInteractive: true,
+		},
+		{
+			name:       "later flags override the answers file",
+			args:       []string{"--answers", answersFile, "--set", "domain=flag.example.com"},
+			wantValues: map[string]string{"domain": "flag.example.com", "rollback": "3"},
+		},
+		{
+			name:       "other arguments are kept in order",
+			args:       []string{"sync", "--domain", "app.example.com", "-f", "api"},
+			wantRest:   []string{"sync", "-f", "api"},
+			wantValues: map[string]string{"domain": "app.example.com"},
+		},
+		{name: "set without =", args: []string{"--set", "domain"}, wantErr: true},
+		{name: "set without value", args: []string{"--set"}, wantErr: true},
+		{name: "shorthand without value", args: []string{"--domain"}, wantErr: true},
+		{name: "answers without file", args: []string{"--answers"}, wantErr: true},
+		{name: "missing answers file", args: []string{"--answers", filepath.Join(t.TempDir(), "none.yml")}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := prompt.NewAnswers()
+			rest, err := a.ParseFlags(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFlags error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(rest, tt.wantRest) {
+				t.Errorf("rest = %q, want %q", rest, tt.wantRest)
+			}
+			if a.NonInteractive != tt.nonInteractive {
+				t.Errorf("NonInteractive = %v, want %v", a.NonInteractive, tt.nonInteractive)
+			}
+			if got := values(t, a); !reflect.DeepEqual(got, tt.wantValues) {
+				t.Errorf("answers = %v, want %v", got, tt.wantValues)
+			}
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	want := map[string]string{
+		"project.name": "demo",
+		"server.host":  "203.0.113.7",
+		"server.port":  "22",
+		"rollback":     "y",
+		"hook.domain":  "",
+	}
+	tests := []struct {
+		name string
+		file string
+		data string
+	}{
+		{
+			name: "yaml",
+			file: "answers.yml",
+			data: "project:\n  name: demo\nserver:\n  host: 203.0.113.7\n  port: 22\nrollback: true\nhook:\n  domain:\n",
+		},
+		{
+			name: "json",
+			file: "answers.json",
+			data: `{"project": {"name": "demo"}, "server": {"host": "203.0.113.7", "port": 22}, "rollback": true, "hook": {"domain": null}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			a := prompt.NewAnswers()
+			if err := a.LoadFile(path); err != nil {
+				t.Fatalf("LoadFile: %v", err)
+			}
+			if got := values(t, a); !reflect.DeepEqual(got, want) {
+				t.Errorf("answers = %v, want %v", got, want)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "answers.yml")
+		if err := os.WriteFile(path, []byte("server: [unclosed\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := prompt.NewAnswers().LoadFile(path); err == nil {
+			t.Error("LoadFile accepted an invalid file")
+		}
+	})
+}
+
+func TestNonInteractive(t *testing.T) {
+	newAnswers := func() *prompt.Answers {
+		a := prompt.NewAnswers()
+		a.NonInteractive = true
+		a.Set("domain", " app.example.com ")
+		a.Set("rollback", "yes")
+		a.Set("empty", "")
+		return a
+	}
+
+	t.Run("Ask", func(t *testing.T) {
+		a := newAnswers()
+		if got, err := a.Ask("domain", "Domain: "); err != nil || got != "app.example.com" {
+			t.Errorf("Ask(domain) = %q, %v", got, err)
+		}
+		_, err := a.Ask("server.host", "Host: ")
+		wantMissing(t, err, "server.host")
+		_, err = a.AskSecret("server.password", "Password: ")
+		wantMissing(t, err, "server.password")
+	})
+
+	t.Run("AskDefault", func(t *testing.T) {
+		a := newAnswers()
+		if got := a.AskDefault("domain", "Domain: ", "def"); got != "app.example.com" {
+			t.Errorf("AskDefault(domain) = %q", got)
+		}
+		if got := a.AskDefault("empty", "Empty: ", "def"); got != "def" {
+			t.Errorf("AskDefault(empty) = %q, want the default", got)
+		}
+		if got := a.AskDefault("missing", "Missing: ", "def"); got != "def" {
+			t.Errorf("AskDefault(missing) = %q, want the default", got)
+		}
+	})
+
+	t.Run("Confirm", func(t *testing.T) {
+		a := newAnswers()
+		if !a.Confirm("rollback", "Rollback? ", false) {
+			t.Error("Confirm(rollback) = false, want the scripted yes")
+		}
+		if a.Confirm("missing", "Continue? ", false) || !a.Confirm("missing", "Continue? ", true) {
+			t.Error("Confirm did not take the default for a missing answer")
+		}
+	})
+
+	t.Run("ConfirmRequired", func(t *testing.T) {
+		a := newAnswers()
+		if ok, err := a.ConfirmRequired("rollback", "Delete? "); err != nil || !ok {
+			t.Errorf("ConfirmRequired(rollback) = %v, %v", ok, err)
+		}
+		ok, err := a.ConfirmRequired("confirm.delete", "Delete? ")
+		if ok {
+			t.Error("ConfirmRequired confirmed a missing answer")
+		}
+		wantMissing(t, err, "confirm.delete")
+	})
+}
+
+// values returns every scripted answer of a, trimmed as Ask returns them
+func values(t *testing.T, a *prompt.Answers) map[string]string {
+	t.Helper()
+	out := map[string]string{}
+	for _, key := range a.Keys() {
+		v, err := a.Ask(key, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[key] = v
+	}
+	return out
+}
+
+func wantMissing(t *testing.T, err error, key string) {
+	t.Helper()
+	var missing *prompt.MissingAnswerError
+	if !errors.As(err, &missing) || missing.Key != key {
+		t.Errorf("error = %v, want a missing answer for %q", err, key)
+	}
+}
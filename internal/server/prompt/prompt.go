@@ -1,7 +1,6 @@
 package prompt
 
 import (
-	"bufio"
 	"fmt"
 	"os"
 	"os/exec"
@@ -10,34 +9,30 @@ import (
 	"strings"
 
 	"github.com/skssmd/graft/internal/config"
+	"github.com/skssmd/graft/internal/server/ssh"
 )
 
 // PromptDomain prompts the user for a domain name with an optional default value
-func PromptDomain(reader *bufio.Reader, defaultDomain string) string {
-	promptText := "Domain (e.g. app.example.com): "
+func PromptDomain(in *Answers, defaultDomain string) (string, error) {
 	if defaultDomain != "" {
-		promptText = fmt.Sprintf("Domain [%s]: ", defaultDomain)
+		return in.AskDefault("domain", fmt.Sprintf("Domain [%s]: ", defaultDomain), defaultDomain), nil
 	}
-	fmt.Print(promptText)
-	domain, _ := reader.ReadString('\n')
-	domain = strings.TrimSpace(domain)
-	if domain == "" && defaultDomain != "" {
-		return defaultDomain
-	}
-	return domain
+	return in.Ask("domain", "Domain (e.g. app.example.com): ")
 }
 
-// PromptRollback prompts the user for rollback configuration
-func PromptRollback(reader *bufio.Reader) int {
+// PromptRollback prompts the user for rollback configuration.
+// The scripted answer "rollback" is the number of versions to keep (0 disables rollbacks).
+func PromptRollback(in *Answers) int {
 	fmt.Println("\n🔄 Rollback configurations")
-	fmt.Print("Do you want to setup rollback configurations? (y/N): ")
-	input, _ := reader.ReadString('\n')
-	input = strings.ToLower(strings.TrimSpace(input))
-
-	if input == "y" || input == "yes" {
-		fmt.Print("Enter the number of versions to keep: ")
-		rollInput, _ := reader.ReadString('\n')
-		versionToKeep, err := strconv.Atoi(strings.TrimSpace(rollInput))
+
+	if in.Has("rollback") {
+		versionToKeep, err := in.AskInt("rollback", "Number of versions to keep: ", 0)
+		if err == nil && versionToKeep > 0 {
+			fmt.Printf("✅ Rollback configured to keep %d versions\n", versionToKeep)
+			return versionToKeep
+		}
+	} else if in.Confirm("rollback.enable", "Do you want to setup rollback configurations? (y/N): ", false) {
+		versionToKeep, err := in.AskInt("rollback", "Enter the number of versions to keep: ", 0)
 		if err == nil && versionToKeep > 0 {
 			fmt.Printf("✅ Rollback configured to keep %d versions\n", versionToKeep)
 			return versionToKeep
@@ -48,7 +43,7 @@ func PromptRollback(reader *bufio.Reader) int {
 }
 
 // PromptGitBranch prompts the user to select a git branch
-func PromptGitBranch(reader *bufio.Reader) (string, error) {
+func PromptGitBranch(in *Answers) (string, error) {
 	// Get remote origin
 	cmd := exec.Command("git", "remote", "get-url", "origin")
 	out, err := cmd.Output()
@@ -92,13 +87,20 @@ func PromptGitBranch(reader *bufio.Reader) (string, error) {
 		return "main", nil
 	}
 
+	// A scripted branch is taken by name
+	if in.Has("git.branch") {
+		branch := in.AskDefault("git.branch", "Branch: ", branches[0])
+		if !seen[branch] {
+			return "", fmt.Errorf("branch '%s' not found in this repository", branch)
+		}
+		return branch, nil
+	}
+
 	fmt.Println("\n🌿 Available branches:")
 	for i, b := range branches {
 		fmt.Printf("  [%d] %s\n", i+1, b)
 	}
-	fmt.Printf("\nSelect branch [1-%d] (default: 1): ", len(branches))
-	bInput, _ := reader.ReadString('\n')
-	bInput = strings.TrimSpace(bInput)
+	bInput := in.AskDefault("git.branch", fmt.Sprintf("\nSelect branch [1-%d] (default: 1): ", len(branches)), "")
 	if bInput == "" {
 		return branches[0], nil
 	}
@@ -113,35 +115,53 @@ func PromptGitBranch(reader *bufio.Reader) (string, error) {
 }
 
 // PromptNewServer prompts the user for new server connection details
-func PromptNewServer(reader *bufio.Reader) (string, int, string, string) {
-	fmt.Print("Host IP: ")
-	host, _ := reader.ReadString('\n')
-	host = strings.TrimSpace(host)
-
-	fmt.Print("Port (22): ")
-	portStr, _ := reader.ReadString('\n')
-	port, _ := strconv.Atoi(strings.TrimSpace(portStr))
-	if port == 0 {
-		port = 22
+func PromptNewServer(in *Answers) (string, int, string, string, error) {
+	host, err := in.Ask("server.host", "Host IP (or ~/.ssh/config alias): ")
+	if err != nil {
+		return "", 0, "", "", err
 	}
 
-	fmt.Print("User: ")
-	user, _ := reader.ReadString('\n')
-	user = strings.TrimSpace(user)
-
 	gDir := config.GetGlobalConfigDir()
 	defaultKeyPath := filepath.Join(gDir, "graftpem")
 
+	// An OpenSSH alias provides the defaults; HostName and ProxyJump are
+	// resolved again on every connection
+	defaultPort, defaultUser, keyLabel := "22", "", "graft pem"
+	if hc, ok := ssh.ResolveHost(host); ok {
+		fmt.Printf("🔗 Using ~/.ssh/config entry for '%s'\n", host)
+		if hc.Port != 0 {
+			defaultPort = strconv.Itoa(hc.Port)
+		}
+		defaultUser = hc.User
+		if hc.IdentityFile != "" {
+			defaultKeyPath = hc.IdentityFile
+			keyLabel = hc.IdentityFile
+		}
+		if hc.ProxyJump != "" {
+			fmt.Printf("   via %s\n", hc.ProxyJump)
+		}
+	}
+
+	port, _ := strconv.Atoi(in.AskDefault("server.port", fmt.Sprintf("Port (%s): ", defaultPort), defaultPort))
+	if port == 0 {
+		port = 22
+	}
+
+	var user string
+	if defaultUser != "" {
+		user = in.AskDefault("server.user", fmt.Sprintf("User (%s): ", defaultUser), defaultUser)
+	} else if user, err = in.Ask("server.user", "User: "); err != nil {
+		return "", 0, "", "", err
+	}
+
 	var keyPath string
 	for {
-		fmt.Printf("Key Path [graft pem]: ")
-		input, _ := reader.ReadString('\n')
-		input = strings.TrimSpace(input)
+		keyPath = in.AskDefault("server.key", fmt.Sprintf("Key Path [%s] (or 'agent'): ", keyLabel), defaultKeyPath)
 
-		if input == "" {
-			keyPath = defaultKeyPath
-		} else {
-			keyPath = input
+		// Authenticate with ssh-agent identities only
+		if keyPath == "agent" {
+			keyPath = ""
+			break
 		}
 
 		// Handle ./ prefix
@@ -156,14 +176,37 @@ func PromptNewServer(reader *bufio.Reader) (string, int, string, string) {
 		if _, err := os.Stat(keyPath); err == nil {
 			break
 		}
+		if in.NonInteractive || in.Has("server.key") {
+			return "", 0, "", "", fmt.Errorf("key not found at '%s'", keyPath)
+		}
 		fmt.Printf("❌ Error: Key not found at '%s'. Please recheck and enter.\n", keyPath)
 	}
 
-	return host, port, user, keyPath
+	return host, port, user, keyPath, nil
+}
+
+// deploymentModes lists the selectable deployment modes in menu order
+var deploymentModes = []string{"git-images", "git-repo-serverbuild", "git-manual", "direct-serverbuild", "direct-localbuild"}
+
+// ParseDeploymentMode accepts either a menu number or a mode name
+func ParseDeploymentMode(input string, max int) (string, bool) {
+	input = strings.TrimSpace(input)
+	if idx, err := strconv.Atoi(input); err == nil {
+		if idx >= 1 && idx <= max {
+			return deploymentModes[idx-1], true
+		}
+		return "", false
+	}
+	for _, m := range deploymentModes[:max] {
+		if m == input {
+			return m, true
+		}
+	}
+	return "", false
 }
 
 // SetupDeploymentMode prompts the user to select a deployment mode and git branch
-func SetupDeploymentMode(reader *bufio.Reader) (string, string) {
+func SetupDeploymentMode(in *Answers) (string, string, error) {
 	var gitBranch string
 	var deploymentMode string
 	for {
@@ -174,29 +217,30 @@ func SetupDeploymentMode(reader *bufio.Reader) (string, string) {
 		fmt.Println("    [3] git-manual (Git repo only, no CI/CD workflow provided)")
 		fmt.Println("\n  Direct deployment modes:")
 		fmt.Println("    [4] direct-serverbuild (upload source → build on server)")
-		fmt.Print("\nSelect deployment mode [1-4]: ")
-
-		modeInput, _ := reader.ReadString('\n')
-		modeInput = strings.TrimSpace(modeInput)
-
-		switch modeInput {
-		case "1":
-			deploymentMode = "git-images"
-		case "2":
-			deploymentMode = "git-repo-serverbuild"
-		case "3":
-			deploymentMode = "git-manual"
-		case "4":
-			deploymentMode = "direct-serverbuild"
-		default:
+
+		modeInput, err := in.Ask("mode", "\nSelect deployment mode [1-4]: ")
+		if err != nil {
+			return "", "", err
+		}
+
+		mode, ok := ParseDeploymentMode(modeInput, 4)
+		if !ok {
+			if in.Has("mode") {
+				return "", "", fmt.Errorf("invalid deployment mode '%s'", modeInput)
+			}
 			fmt.Println("Invalid selection, defaulting to direct-serverbuild")
-			deploymentMode = "direct-serverbuild"
+			mode = "direct-serverbuild"
 		}
+		deploymentMode = mode
 
 		// Git validation for git modes
 		if strings.HasPrefix(deploymentMode, "git") {
-			branch, err := PromptGitBranch(reader)
+			branch, err := PromptGitBranch(in)
 			if err != nil {
+				// A scripted answer will not change on retry
+				if in.NonInteractive || in.Has("mode") {
+					return "", "", err
+				}
 				fmt.Printf("\n❌ %v\n", err)
 				continue
 			}
@@ -222,5 +266,5 @@ func SetupDeploymentMode(reader *bufio.Reader) (string, string) {
 	case "direct-localbuild":
 		fmt.Println("\n✅ Direct local build mode selected")
 	}
-	return deploymentMode, gitBranch
+	return deploymentMode, gitBranch, nil
 }
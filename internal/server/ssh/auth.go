@@ -0,0 +1,248 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+)
+
+// Auth methods accepted in a server's "auth" list. "key:<path>" adds an
+// extra identity file. An empty list means the server key, then ssh-agent.
+const (
+	AuthAgent = "agent"
+	AuthKey   = "key"
+)
+
+// PassphraseEnv and AskpassEnv let scripts supply key passphrases. The askpass
+// helper is run with the key path as its only argument and prints the passphrase.
+const (
+	PassphraseEnv = "GRAFT_SSH_PASSPHRASE"
+	AskpassEnv    = "GRAFT_SSH_ASKPASS"
+)
+
+var (
+	passphraseMu    sync.Mutex
+	passphraseCache = map[string][]byte{}
+)
+
+// authSigners collects signers from the configured methods in order. The
+// returned closer releases the agent connection once authentication is done.
+// usedKey reports whether keyPath contributed a signer.
+func authSigners(keyPath string, methods []string) (signers []ssh.Signer, usedKey bool, closer func(), err error) {
+	if len(methods) == 0 {
+		methods = []string{AuthKey, AuthAgent}
+	}
+	closer = func() {}
+
+	var errs []error
+	for _, method := range methods {
+		switch {
+		case method == AuthAgent:
+			agentSigners, closeAgent, err := agentSigners()
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			signers = append(signers, agentSigners...)
+			closer = closeAgent
+		case method == AuthKey:
+			if keyPath == "" {
+				continue
+			}
+			signer, err := keySigner(keyPath)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			signers = append(signers, signer)
+			usedKey = true
+		case strings.HasPrefix(method, AuthKey+":"):
+			path, err := expandHome(strings.TrimPrefix(method, AuthKey+":"))
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			signer, err := keySigner(path)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			signers = append(signers, signer)
+		default:
+			errs = append(errs, fmt.Errorf("unknown auth method '%s' (use agent, key or key:<path>)", method))
+		}
+	}
+
+	if len(signers) == 0 {
+		closer()
+		if len(errs) == 0 {
+			return nil, false, nil, fmt.Errorf("no usable authentication method (set a key path or start ssh-agent)")
+		}
+		return nil, false, nil, errors.Join(errs...)
+	}
+	return signers, usedKey, closer, nil
+}
+
+// agentSigners loads the identities held by the ssh-agent at SSH_AUTH_SOCK
+func agentSigners() ([]ssh.Signer, func(), error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, nil, fmt.Errorf("ssh-agent not available: SSH_AUTH_SOCK is not set")
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to reach ssh-agent: %v", err)
+	}
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("unable to list ssh-agent keys: %v", err)
+	}
+	if len(signers) == 0 {
+		conn.Close()
+		return nil, nil, fmt.Errorf("ssh-agent has no keys loaded")
+	}
+	return signers, func() { conn.Close() }, nil
+}
+
+// keySigner parses a private key file. Passphrase-protected keys in the
+// OpenSSH format are decrypted lazily, only if the server accepts the key.
+func keySigner(path string) (ssh.Signer, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read private key: %v", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(key)
+	if err == nil {
+		return signer, nil
+	}
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return nil, fmt.Errorf("unable to parse private key: %v", err)
+	}
+
+	if missing.PublicKey != nil {
+		return &encryptedSigner{path: path, key: key, pub: missing.PublicKey}, nil
+	}
+	return decryptKey(path, key)
+}
+
+func decryptKey(path string, key []byte) (ssh.Signer, error) {
+	passphrase, err := keyPassphrase(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKeyWithPassphrase(key, passphrase)
+	if err != nil {
+		forgetPassphrase(path)
+		return nil, fmt.Errorf("unable to decrypt private key %s: %v", path, err)
+	}
+	return signer, nil
+}
+
+// keyPassphrase returns the passphrase for path from the environment, the
+// askpass helper or a terminal prompt, asking at most once per key
+func keyPassphrase(path string) ([]byte, error) {
+	passphraseMu.Lock()
+	defer passphraseMu.Unlock()
+
+	if p, ok := passphraseCache[path]; ok {
+		return p, nil
+	}
+
+	var passphrase []byte
+	if p := os.Getenv(PassphraseEnv); p != "" {
+		passphrase = []byte(p)
+	} else if helper := os.Getenv(AskpassEnv); helper != "" {
+		out, err := exec.Command(helper, path).Output()
+		if err != nil {
+			return nil, fmt.Errorf("passphrase helper %s failed: %v", helper, err)
+		}
+		passphrase = []byte(strings.TrimRight(string(out), "\r\n"))
+	} else {
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) {
+			return nil, fmt.Errorf("private key %s is passphrase-protected: set %s, %s or load it into ssh-agent", path, PassphraseEnv, AskpassEnv)
+		}
+		fmt.Fprintf(os.Stderr, "🔑 Enter passphrase for %s: ", path)
+		p, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read passphrase: %v", err)
+		}
+		passphrase = p
+	}
+
+	passphraseCache[path] = passphrase
+	return passphrase, nil
+}
+
+func forgetPassphrase(path string) {
+	passphraseMu.Lock()
+	defer passphraseMu.Unlock()
+	delete(passphraseCache, path)
+}
+
+// encryptedSigner offers the public half of an encrypted key and only asks
+// for the passphrase when the server wants a signature from it
+type encryptedSigner struct {
+	path   string
+	key    []byte
+	pub    ssh.PublicKey
+	once   sync.Once
+	signer ssh.Signer
+	err    error
+}
+
+func (s *encryptedSigner) PublicKey() ssh.PublicKey {
+	return s.pub
+}
+
+func (s *encryptedSigner) unlock() (ssh.Signer, error) {
+	s.once.Do(func() {
+		s.signer, s.err = decryptKey(s.path, s.key)
+	})
+	return s.signer, s.err
+}
+
+func (s *encryptedSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	signer, err := s.unlock()
+	if err != nil {
+		return nil, err
+	}
+	return signer.Sign(rand, data)
+}
+
+func (s *encryptedSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	signer, err := s.unlock()
+	if err != nil {
+		return nil, err
+	}
+	if as, ok := signer.(ssh.AlgorithmSigner); ok {
+		return as.SignWithAlgorithm(rand, data, algorithm)
+	}
+	return signer.Sign(rand, data)
+}
+
+// expandHome resolves a leading ~ in path
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to get home directory: %v", err)
+	}
+	return filepath.Join(home, strings.TrimPrefix(path[1:], "/")), nil
+}
@@ -1,20 +1,25 @@
 package ssh
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/term"
 )
 
+const dialTimeout = 10 * time.Second
+
 type Client struct {
 	client  *ssh.Client
 	sftp    *sftp.Client
@@ -22,33 +27,106 @@ type Client struct {
 	port    int
 	user    string
 	keyPath string
+
+	// jumps are the bastion connections the client is tunnelled through
+	// and jump the matching -J value for the external ssh client
+	jumps []*ssh.Client
+	jump  string
+
+	// hostKey is the key the server presented and that was verified
+	hostKey ssh.PublicKey
+
+	// dial opens a new connection; done is closed when the current one
+	// drops. See session.go for keepalives and reconnects.
+	dial   func() (*ssh.Client, []*ssh.Client, error)
+	done   chan struct{}
+	mu     sync.Mutex
+	closed bool
+
+	// reconnecting serializes reconnects of operations running in parallel
+	reconnecting sync.Mutex
+
+	// ctx is the parent of every remote operation and timeout bounds each
+	// remote command; see SetContext and SetCommandTimeout
+	ctx     context.Context
+	timeout time.Duration
+
+	// recorder is set in dry-run mode; see NewDryRunClient
+	recorder *Recorder
 }
 
-func NewClient(host string, port int, user, keyPath string) (*Client, error) {
-	// Expand tilde (~) if present in keyPath
-	actualKeyPath := keyPath
-	if strings.HasPrefix(keyPath, "~/") {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return nil, fmt.Errorf("unable to get home directory: %v", err)
-		}
-		actualKeyPath = filepath.Join(home, keyPath[2:])
-	} else if keyPath == "~" {
-		home, err := os.UserHomeDir()
+// NewClient connects to host. auth lists the authentication methods to try
+// in order (agent, key, key:<path>); by default the key at keyPath is tried
+// first, then any identities in ssh-agent.
+//
+// host may be an alias from ~/.ssh/config: its HostName, IdentityFile and
+// ProxyJump chain are used, and its Port and User fill in unset values.
+//
+// The host key is checked against ~/.ssh/known_hosts and recorded there on
+// first use; see NewPinnedClient to verify against a registry pin instead.
+func NewClient(host string, port int, user, keyPath string, auth ...string) (*Client, error) {
+	return newClient(host, port, user, keyPath, hostKeyPolicy{}, auth)
+}
+
+// NewPinnedClient connects like NewClient but only accepts the server if it
+// presents hostKey, a key in authorized_keys format. An empty hostKey falls
+// back to known_hosts; HostKey then returns the key to pin.
+func NewPinnedClient(host string, port int, user, keyPath, hostKey string, auth ...string) (*Client, error) {
+	var policy hostKeyPolicy
+	if hostKey != "" {
+		pinned, err := ParseHostKey(hostKey)
 		if err != nil {
-			return nil, fmt.Errorf("unable to get home directory: %v", err)
+			return nil, err
 		}
-		actualKeyPath = home
+		policy.pinned = pinned
 	}
+	return newClient(host, port, user, keyPath, policy, auth)
+}
 
-	key, err := os.ReadFile(actualKeyPath)
+// ScanHostKey logs in to the server, accepting whatever host key it presents,
+// and returns that key. It is only meant for re-pinning a server whose key
+// changed legitimately.
+func ScanHostKey(host string, port int, user, keyPath string, auth ...string) (string, error) {
+	client, err := newClient(host, port, user, keyPath, hostKeyPolicy{any: true}, auth)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read private key: %v", err)
+		return "", err
 	}
+	defer client.Close()
+	return client.HostKey(), nil
+}
 
-	signer, err := ssh.ParsePrivateKey(key)
+func newClient(host string, port int, user, keyPath string, policy hostKeyPolicy, auth []string) (*Client, error) {
+	// Resolve OpenSSH config aliases
+	var hops []jumpHop
+	if hc, ok := ResolveHost(host); ok {
+		if hc.HostName != "" {
+			host = hc.HostName
+		}
+		if port == 0 {
+			port = hc.Port
+		}
+		if user == "" {
+			user = hc.User
+		}
+		if keyPath == "" && hc.IdentityFile != "" {
+			keyPath = hc.IdentityFile
+		}
+		var err error
+		if hops, err = resolveJumps(hc.ProxyJump); err != nil {
+			return nil, err
+		}
+	}
+	if port == 0 {
+		port = 22
+	}
+	if user == "" {
+		user = localUser()
+	}
+
+	// Expand tilde (~) if present in keyPath
+	actualKeyPath, err := expandHome(keyPath)
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse private key: %v", err)
+		return nil, err
 	}
 
 	homeDir, err := os.UserHomeDir()
@@ -56,84 +134,202 @@ func NewClient(host string, port int, user, keyPath string) (*Client, error) {
 		return nil, fmt.Errorf("unable to get home directory: %v", err)
 	}
 
+	// Bastions are always checked against known_hosts; the target against
+	// its registry pin when there is one
 	knownHostsPath := filepath.Join(homeDir, ".ssh", "known_hosts")
-	hostKeyCallback := createHostKeyCallback(knownHostsPath, host)
-
-	config := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
-		HostKeyCallback: hostKeyCallback,
-		Timeout:         10 * time.Second,
+
+	c := &Client{
+		host:    host,
+		port:    port,
+		user:    user,
+		keyPath: actualKeyPath,
+		jump:    jumpSpec(hops),
+		ctx:     context.Background(),
 	}
 
-	addr := fmt.Sprintf("%s:%d", host, port)
-	client, err := ssh.Dial("tcp", addr, config)
-	if err != nil {
-		return nil, fmt.Errorf("unable to connect: %v", err)
+	// dial is kept for reconnects. Signers are loaded on every dial because
+	// the ssh-agent connection is released once authentication is done.
+	c.dial = func() (*ssh.Client, []*ssh.Client, error) {
+		signers, usedKey, closeAgent, err := authSigners(actualKeyPath, auth)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer closeAgent()
+		if !usedKey {
+			// The external ssh client falls back to the agent without -i
+			c.keyPath = ""
+		}
+
+		var hostKeyCallback ssh.HostKeyCallback
+		pinned := policy.pinned
+		if c.hostKey != nil {
+			// A reconnect must reach the same server as the first dial
+			pinned = c.hostKey
+		}
+		switch {
+		case pinned != nil:
+			hostKeyCallback = pinnedCallback(host, pinned)
+		case policy.any:
+			hostKeyCallback = ssh.InsecureIgnoreHostKey()
+		default:
+			hostKeyCallback = createHostKeyCallback(knownHostsPath, host)
+		}
+
+		config := &ssh.ClientConfig{
+			User: user,
+			Auth: []ssh.AuthMethod{
+				ssh.PublicKeys(signers...),
+			},
+			HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+				if err := hostKeyCallback(hostname, remote, key); err != nil {
+					return err
+				}
+				c.hostKey = key
+				return nil
+			},
+			Timeout: dialTimeout,
+		}
+		if pinned != nil {
+			config.HostKeyAlgorithms = hostKeyAlgorithms(pinned)
+		}
+
+		// Connect through the bastions first, if any
+		jumps, err := dialJumps(hops, signers, knownHostsPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		addr := net.JoinHostPort(host, strconv.Itoa(port))
+		client, err := dialVia(lastClient(jumps), addr, config)
+		if err != nil {
+			closeClients(jumps)
+			return nil, nil, fmt.Errorf("unable to connect: %w", err)
+		}
+		return client, jumps, nil
 	}
 
-	sftpClient, err := sftp.NewClient(client)
-	if err != nil {
-		client.Close()
-		return nil, fmt.Errorf("unable to start sftp: %v", err)
+	if err := c.connect(); err != nil {
+		return nil, err
 	}
+	return c, nil
+}
 
-	return &Client{
-		client:  client,
-		sftp:    sftpClient,
-		host:    host,
-		port:    port,
-		user:    user,
-		keyPath: actualKeyPath,
-	}, nil
+// HostKey returns the verified host key of the server in authorized_keys
+// format, or "" for an offline dry-run client
+func (c *Client) HostKey() string {
+	if c.hostKey == nil {
+		return ""
+	}
+	return MarshalHostKey(c.hostKey)
 }
 
+// RunCommand runs cmd on the server, bounded by the client's context and
+// command timeout. It is not repeated if the connection drops mid-way; see
+// RunIdempotent for steps that are safe to run again.
 func (c *Client) RunCommand(cmd string, stdout, stderr io.Writer) error {
-	session, err := c.client.NewSession()
+	ctx, cancel := c.commandContext()
+	defer cancel()
+	return c.RunCommandContext(ctx, cmd, stdout, stderr)
+}
+
+// AddAuthorizedKey appends pubKey to the login user's authorized_keys unless
+// it is already there, so running it twice is harmless
+func (c *Client) AddAuthorizedKey(pubKey string) error {
+	line, blob, err := authorizedKeyLine(pubKey)
 	if err != nil {
 		return err
 	}
-	defer session.Close()
+	// A file without a trailing newline would glue the new key to the last line
+	cmd := fmt.Sprintf(`f="$HOME/.ssh/authorized_keys"; mkdir -p "$HOME/.ssh" && chmod 700 "$HOME/.ssh" && touch "$f" && chmod 600 "$f" && `+
+		`(grep -qF '%s' "$f" || { [ -z "$(tail -c1 "$f")" ] || echo >> "$f"; echo '%s graft' >> "$f"; })`, blob, line)
+	return c.RunIdempotent(cmd, nil, nil)
+}
 
-	session.Stdout = stdout
-	session.Stderr = stderr
-	return session.Run(cmd)
+// RemoveAuthorizedKey deletes every authorized_keys line holding pubKey. The
+// file is rewritten in place so its owner and permissions are kept.
+func (c *Client) RemoveAuthorizedKey(pubKey string) error {
+	_, blob, err := authorizedKeyLine(pubKey)
+	if err != nil {
+		return err
+	}
+	cmd := fmt.Sprintf(`f="$HOME/.ssh/authorized_keys"; [ -f "$f" ] || exit 0; `+
+		`{ grep -vF '%s' "$f" > "$f.graft" || [ $? -eq 1 ]; } && cat "$f.graft" > "$f" && rm -f "$f.graft"`, blob)
+	return c.RunIdempotent(cmd, nil, nil)
 }
 
-func (c *Client) UpdateAuthorizedKey(oldPubKey, newPubKey string) error {
-	// Extract base64 part of the keys for more reliable matching
-	oldParts := strings.Fields(oldPubKey)
-	if len(oldParts) < 2 {
-		return fmt.Errorf("invalid old public key format")
+// SetLabeledAuthorizedKey adds pubKey to authorized_keys with label as its
+// comment, replacing any line that already carries the label. options are
+// authorized_keys options such as from="10.0.0.0/8"; empty means none.
+func (c *Client) SetLabeledAuthorizedKey(label, options, pubKey string) error {
+	line, _, err := authorizedKeyLine(pubKey)
+	if err != nil {
+		return err
 	}
-	oldBase64 := oldParts[1]
+	if strings.ContainsAny(label, " \t\n'") || strings.ContainsAny(options, "\n") {
+		return fmt.Errorf("invalid authorized_keys label or options")
+	}
+	if options != "" {
+		line = options + " " + line
+	}
+	line += " " + label
+	cmd := fmt.Sprintf(`f="$HOME/.ssh/authorized_keys"; mkdir -p "$HOME/.ssh" && chmod 700 "$HOME/.ssh" && touch "$f" && chmod 600 "$f" && `+
+		`awk -v l='%s' '$NF != l' "$f" > "$f.graft" && cat "$f.graft" > "$f" && rm -f "$f.graft" && `+
+		`{ [ -z "$(tail -c1 "$f")" ] || echo >> "$f"; echo %s >> "$f"; }`, label, shellQuote(line))
+	return c.RunIdempotent(cmd, nil, nil)
+}
 
-	// Use sed to replace the line containing the old base64 string with the new full public key
-	// We use | as delimiter to avoid issues with / in keys (though unusual)
-	cmd := fmt.Sprintf("sed -i '/%s/c\\%s' ~/.ssh/authorized_keys", oldBase64, strings.TrimSpace(newPubKey))
-	return c.RunCommand(cmd, nil, nil)
+// RemoveLabeledAuthorizedKey deletes the authorized_keys lines whose comment
+// is label
+func (c *Client) RemoveLabeledAuthorizedKey(label string) error {
+	if strings.ContainsAny(label, " \t\n'") {
+		return fmt.Errorf("invalid authorized_keys label")
+	}
+	cmd := fmt.Sprintf(`f="$HOME/.ssh/authorized_keys"; [ -f "$f" ] || exit 0; `+
+		`awk -v l='%s' '$NF != l' "$f" > "$f.graft" && cat "$f.graft" > "$f" && rm -f "$f.graft"`, label)
+	return c.RunIdempotent(cmd, nil, nil)
 }
 
-func (c *Client) GetCommandOutput(cmd string) (string, error) {
-	session, err := c.client.NewSession()
+// NormalizePublicKey validates a public key in authorized_keys format and
+// returns it without its comment. Keys carrying options are rejected.
+func NormalizePublicKey(pubKey string) (string, error) {
+	key, _, options, _, err := ssh.ParseAuthorizedKey([]byte(pubKey))
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("invalid public key: %v", err)
+	}
+	if len(options) > 0 {
+		return "", fmt.Errorf("the public key must not carry authorized_keys options")
 	}
-	defer session.Close()
+	return MarshalHostKey(key), nil
+}
 
-	out, err := session.Output(cmd)
+// authorizedKeyLine validates pubKey and returns it re-encoded without its
+// comment, along with its base64 blob, so neither can inject shell syntax
+func authorizedKeyLine(pubKey string) (string, string, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pubKey))
 	if err != nil {
-		return "", err
+		return "", "", fmt.Errorf("invalid public key: %v", err)
 	}
-	return string(out), nil
+	line := MarshalHostKey(key)
+	return line, strings.Fields(line)[1], nil
+}
+
+func (c *Client) GetCommandOutput(cmd string) (string, error) {
+	ctx, cancel := c.commandContext()
+	defer cancel()
+	return c.GetCommandOutputContext(ctx, cmd)
 }
 
 func (c *Client) InteractiveSession() error {
-	// Verify key exists
-	if _, err := os.Stat(c.keyPath); err != nil {
-		return fmt.Errorf("ssh key not found: %s", c.keyPath)
+	if c.recorder != nil {
+		c.recorder.record(Operation{Kind: OpShell, Host: c.host})
+		return nil
+	}
+
+	// Verify key exists (agent-only logins have no key file)
+	if c.keyPath != "" {
+		if _, err := os.Stat(c.keyPath); err != nil {
+			return fmt.Errorf("ssh key not found: %s", c.keyPath)
+		}
 	}
 
 	// Find best ssh command
@@ -148,21 +344,25 @@ func (c *Client) InteractiveSession() error {
 
 	args := []string{}
 	if isWSL {
-		wslKeyPath := "~/.ssh/graft_key.pem"
-		windowsKeyWSL := convertToUnixPath(c.keyPath, true)
-
-		// Copy key to WSL filesystem and set proper permissions
-		copyCmd := exec.Command("wsl", "bash", "-c",
-			fmt.Sprintf("mkdir -p ~/.ssh && cp '%s' %s && chmod 600 %s",
-				windowsKeyWSL, wslKeyPath, wslKeyPath))
-		if err := copyCmd.Run(); err != nil {
-			return fmt.Errorf("failed to copy SSH key to WSL: %v", err)
+		wslKeyPath, err := c.copyKeyToWSL()
+		if err != nil {
+			return err
 		}
 
-		args = []string{"ssh", "-i", wslKeyPath, "-p", fmt.Sprintf("%d", c.port), "-o", "StrictHostKeyChecking=no", fmt.Sprintf("%s@%s", c.user, c.host)}
+		args = append([]string{"ssh"}, identityArgs(wslKeyPath)...)
 	} else {
-		args = []string{"-i", c.keyPath, "-p", fmt.Sprintf("%d", c.port), "-o", "StrictHostKeyChecking=no", fmt.Sprintf("%s@%s", c.user, c.host)}
+		args = identityArgs(c.keyPath)
+	}
+	args = append(args, c.jumpArgs()...)
+
+	// Make the external client trust exactly the key verified on connect
+	hostArgs, cleanup, err := c.knownHostsFile(isWSL)
+	if err != nil {
+		return err
 	}
+	defer cleanup()
+	args = append(args, hostArgs...)
+	args = append(args, "-p", fmt.Sprintf("%d", c.port), fmt.Sprintf("%s@%s", c.user, c.host))
 
 	cmd := exec.Command(sshCmd, args...)
 	cmd.Stdin = os.Stdin
@@ -172,249 +372,63 @@ func (c *Client) InteractiveSession() error {
 	return cmd.Run()
 }
 
+// SimulatedSession starts a remote shell on a pseudo-terminal driven by the
+// built-in client, for systems without a usable ssh binary
 func (c *Client) SimulatedSession() error {
-	session, err := c.client.NewSession()
-	if err != nil {
-		return err
-	}
-	defer session.Close()
-
-	// Set up terminal modes
-	modes := ssh.TerminalModes{
-		ssh.ECHO:          1,     // enable echoing
-		ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
-		ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
-	}
-
-	// Get terminal size
-	fd := int(os.Stdin.Fd())
-	width, height, err := term.GetSize(fd)
-	if err != nil {
-		width, height = 80, 40 // Fallback
-	}
-
-	// Request pseudo terminal
-	if err := session.RequestPty("xterm-256color", height, width, modes); err != nil {
-		return fmt.Errorf("request for pseudo terminal failed: %v", err)
-	}
-
-	session.Stdin = os.Stdin
-	session.Stdout = os.Stdout
-	session.Stderr = os.Stderr
-
-	// Put local terminal into raw mode
-	oldState, err := term.MakeRaw(fd)
-	if err != nil {
-		return fmt.Errorf("failed to set raw mode: %v", err)
-	}
-	defer term.Restore(fd, oldState)
-
-	// Start shell on remote
-	if err := session.Shell(); err != nil {
-		return fmt.Errorf("failed to start shell: %v", err)
-	}
-
-	// Wait for session to finish
-	return session.Wait()
+	return c.ptySession(func(session *ssh.Session) error {
+		// Start shell on remote
+		if err := session.Shell(); err != nil {
+			return fmt.Errorf("failed to start shell: %v", err)
+		}
+		return nil
+	})
 }
 
 func (c *Client) UploadFile(local, remote string) error {
-	src, err := os.Open(local)
-	if err != nil {
-		return err
-	}
-	defer src.Close()
-
-	dst, err := c.sftp.Create(remote)
-	if err != nil {
-		return err
-	}
-	defer dst.Close()
-
-	_, err = io.Copy(dst, src)
-	return err
+	return c.UploadFileContext(c.ctx, local, remote)
 }
 
 func (c *Client) DownloadFile(remote, local string) error {
-	src, err := c.sftp.Open(remote)
-	if err != nil {
-		return err
-	}
-	defer src.Close()
-
-	dst, err := os.Create(local)
-	if err != nil {
-		return err
-	}
-	defer dst.Close()
-
-	_, err = io.Copy(dst, src)
-	return err
+	return c.DownloadFileContext(c.ctx, remote, local)
 }
 
-// RsyncDirectory syncs a local directory to a remote directory using rsync over SSH
-// This is much faster than creating tarballs as it only transfers changed files
-func (c *Client) RsyncDirectory(localDir, remoteDir string, stdout, stderr io.Writer) error {
-	// Find rsync executable
-	rsyncCmd, err := findRsync()
-	if err != nil {
-		return err
-	}
-
-	// Essential hardcoded exclusions (always excluded regardless of .gitignore)
-	essentialExcludes := []string{
-		//".git",
-		"node_modules",
-		".next",
-
-		"*.log",
+// copyKeyToWSL copies the key into the WSL filesystem, where it can get
+// proper permissions, and returns its WSL path ("" when using the agent)
+func (c *Client) copyKeyToWSL() (string, error) {
+	if c.keyPath == "" {
+		return "", nil
 	}
+	wslKeyPath := "~/.ssh/graft_key.pem"
+	windowsKeyWSL := convertToUnixPath(c.keyPath, true)
 
-	// Build base args
-	args := []string{
-		"-avz",
-		"--delete",
+	copyCmd := exec.Command("wsl", "bash", "-c",
+		fmt.Sprintf("mkdir -p ~/.ssh && cp '%s' %s && chmod 600 %s",
+			windowsKeyWSL, wslKeyPath, wslKeyPath))
+	if err := copyCmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to copy SSH key to WSL: %v", err)
 	}
-
-	// Add essential exclusions
-	for _, pattern := range essentialExcludes {
-		args = append(args, "--exclude="+pattern)
-	}
-
-	// Try to read .gitignore from the local directory
-	gitignorePath := filepath.Join(localDir, ".gitignore")
-	gitignorePatterns := parseGitignore(gitignorePath)
-
-	// Add gitignore patterns as exclusions
-	for _, pattern := range gitignorePatterns {
-		args = append(args, "--exclude="+pattern)
-	}
-
-	// Prepare paths based on rsync type
-	sshKeyPath := c.keyPath
-	localPath := localDir
-
-	// For Git Bash, Cygwin, and WSL, convert Windows paths to Unix format
-	if rsyncCmd != "rsync" {
-		useWSLFormat := (rsyncCmd == "wsl")
-
-		if useWSLFormat {
-			// For WSL, copy SSH key to WSL filesystem to fix permissions issue
-			// Windows filesystem doesn't support Unix permissions properly
-			wslKeyPath := "~/.ssh/graft_key.pem"
-
-			// Convert Windows path to WSL path for copying
-			windowsKeyWSL := convertToUnixPath(c.keyPath, true)
-
-			// Copy key to WSL filesystem and set proper permissions
-			copyCmd := exec.Command("wsl", "bash", "-c",
-				fmt.Sprintf("mkdir -p ~/.ssh && cp '%s' %s && chmod 600 %s",
-					windowsKeyWSL, wslKeyPath, wslKeyPath))
-			if err := copyCmd.Run(); err != nil {
-				return fmt.Errorf("failed to copy SSH key to WSL: %v", err)
-			}
-
-			sshKeyPath = wslKeyPath
-			localPath = convertToUnixPath(localDir, true)
-		} else {
-			sshKeyPath = convertToUnixPath(c.keyPath, false)
-			localPath = convertToUnixPath(localDir, false)
-		}
-	}
-
-	// Add SSH configuration and paths
-	// Quote the SSH key path to handle spaces and special characters
-	args = append(args,
-		"-e",
-		fmt.Sprintf("ssh -i \"%s\" -p %d -o StrictHostKeyChecking=no", sshKeyPath, c.port),
-		localPath+"/",
-		fmt.Sprintf("%s@%s:%s/", c.user, c.host, remoteDir),
-	)
-
-	// Execute rsync
-	var cmd *exec.Cmd
-	if rsyncCmd == "wsl" {
-		// For WSL, prepend rsync command
-		wslArgs := append([]string{"rsync"}, args...)
-		cmd = exec.Command("wsl", wslArgs...)
-	} else {
-		cmd = exec.Command(rsyncCmd, args...)
-	}
-
-	cmd.Stdout = stdout
-	cmd.Stderr = stderr
-
-	return cmd.Run()
+	return wslKeyPath, nil
 }
 
-// PullRsync syncs a remote directory to a local directory using rsync over SSH
-func (c *Client) PullRsync(remoteDir, localDir string, stdout, stderr io.Writer) error {
-	// Find rsync executable
-	rsyncCmd, err := findRsync()
-	if err != nil {
-		return err
-	}
-
-	// Build base args
-	args := []string{
-		"-avz",
-	}
-
-	// Prepare paths based on rsync type
-	sshKeyPath := c.keyPath
-	localPath := localDir
-
-	// For Git Bash, Cygwin, and WSL, convert Windows paths to Unix format
-	if rsyncCmd != "rsync" {
-		useWSLFormat := (rsyncCmd == "wsl")
-
-		if useWSLFormat {
-			wslKeyPath := "~/.ssh/graft_key.pem"
-			windowsKeyWSL := convertToUnixPath(c.keyPath, true)
-
-			copyCmd := exec.Command("wsl", "bash", "-c",
-				fmt.Sprintf("mkdir -p ~/.ssh && cp '%s' %s && chmod 600 %s",
-					windowsKeyWSL, wslKeyPath, wslKeyPath))
-			if err := copyCmd.Run(); err != nil {
-				return fmt.Errorf("failed to copy SSH key to WSL: %v", err)
-			}
-
-			sshKeyPath = wslKeyPath
-			localPath = convertToUnixPath(localDir, true)
-		} else {
-			sshKeyPath = convertToUnixPath(c.keyPath, false)
-			localPath = convertToUnixPath(localDir, false)
-		}
+// jumpArgs returns the -J flag for the external ssh client, if any
+func (c *Client) jumpArgs() []string {
+	if c.jump == "" {
+		return nil
 	}
+	return []string{"-J", c.jump}
+}
 
-	// Add SSH configuration and paths
-	args = append(args,
-		"-e",
-		fmt.Sprintf("ssh -i \"%s\" -p %d -o StrictHostKeyChecking=no", sshKeyPath, c.port),
-		fmt.Sprintf("%s@%s:%s/", c.user, c.host, remoteDir),
-		localPath+"/",
-	)
-
-	// Execute rsync
-	var cmd *exec.Cmd
-	if rsyncCmd == "wsl" {
-		wslArgs := append([]string{"rsync"}, args...)
-		cmd = exec.Command("wsl", wslArgs...)
-	} else {
-		cmd = exec.Command(rsyncCmd, args...)
+// identityArgs returns the -i flag for the external ssh client, if any
+func identityArgs(keyPath string) []string {
+	if keyPath == "" {
+		return nil
 	}
-
-	cmd.Stdout = stdout
-	cmd.Stderr = stderr
-
-	return cmd.Run()
+	return []string{"-i", keyPath}
 }
 
 func (c *Client) Close() {
-	if c.sftp != nil {
-		c.sftp.Close()
-	}
-	if c.client != nil {
-		c.client.Close()
-	}
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.closeConn()
 }
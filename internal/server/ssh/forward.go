@@ -0,0 +1,65 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+)
+
+// Forward accepts connections on listener and tunnels each one over the SSH
+// connection to addr, as seen from the server, until ctx ends
+func (c *Client) Forward(ctx context.Context, listener net.Listener, addr string) error {
+	if c.offline() {
+		return fmt.Errorf("not connected to %s", c.host)
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go c.tunnel(ctx, conn, addr)
+	}
+}
+
+// tunnel copies between a local connection and addr until either side
+// closes or ctx ends
+func (c *Client) tunnel(ctx context.Context, local net.Conn, addr string) {
+	defer local.Close()
+
+	c.mu.Lock()
+	client := c.client
+	c.mu.Unlock()
+
+	remote, err := client.Dial("tcp", addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️  Could not reach %s through %s: %v\n", addr, c.host, err)
+		return
+	}
+	defer remote.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, local)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(local, remote)
+		done <- struct{}{}
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,123 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyMismatchError is returned when a server presents a different host
+// key than the one pinned in the registry or recorded in known_hosts
+type HostKeyMismatchError struct {
+	Host string
+	Want string // fingerprint of the expected key
+	Got  string // fingerprint of the key the server presented
+	// Source is where the expected key came from
+	Source string
+	// Pinned is set when the expected key is the registry pin
+	Pinned bool
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!\n"+
+		"Host key for %s does not match the one in %s. This could indicate a man-in-the-middle attack.\n"+
+		"Expected: %s\nReceived: %s", e.Host, e.Source, e.Want, e.Got)
+}
+
+// hostKeyPolicy selects how NewClient verifies the target's host key
+type hostKeyPolicy struct {
+	// pinned is the registry pin; nil falls back to ~/.ssh/known_hosts
+	pinned ssh.PublicKey
+	// any accepts whatever key is presented (graft registry rekey)
+	any bool
+}
+
+// ParseHostKey parses a pin in authorized_keys format
+func ParseHostKey(hostKey string) (ssh.PublicKey, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pinned host key: %v", err)
+	}
+	return key, nil
+}
+
+// MarshalHostKey formats key as an authorized_keys style pin
+func MarshalHostKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+// Fingerprint returns the SHA256 fingerprint of a pin, as printed by ssh-keygen -l
+func Fingerprint(hostKey string) string {
+	key, err := ParseHostKey(hostKey)
+	if err != nil {
+		return "(invalid key)"
+	}
+	return ssh.FingerprintSHA256(key)
+}
+
+// pinnedCallback accepts only the pinned key
+func pinnedCallback(hostname string, pinned ssh.PublicKey) ssh.HostKeyCallback {
+	return func(_ string, _ net.Addr, key ssh.PublicKey) error {
+		if bytes.Equal(key.Marshal(), pinned.Marshal()) {
+			return nil
+		}
+		return &HostKeyMismatchError{
+			Host:   hostname,
+			Want:   ssh.FingerprintSHA256(pinned),
+			Got:    ssh.FingerprintSHA256(key),
+			Source: "the graft registry",
+			Pinned: true,
+		}
+	}
+}
+
+// hostKeyAlgorithms makes the server present the same kind of key as the pin,
+// so a host with several keys is not mistaken for a changed one
+func hostKeyAlgorithms(key ssh.PublicKey) []string {
+	if key.Type() == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{key.Type()}
+}
+
+// knownHostsFile writes the verified host key to a temporary known_hosts file
+// for the external ssh client and returns the arguments that make ssh use
+// only that file. The returned cleanup removes it.
+func (c *Client) knownHostsFile(wsl bool) ([]string, func(), error) {
+	if c.hostKey == nil {
+		return nil, nil, fmt.Errorf("no verified host key for %s", c.host)
+	}
+
+	f, err := os.CreateTemp("", "graft-known-hosts-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create known_hosts file: %v", err)
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+
+	addr := knownhosts.Normalize(net.JoinHostPort(c.host, strconv.Itoa(c.port)))
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{addr}, c.hostKey))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("could not write known_hosts file: %v", err)
+	}
+
+	path := f.Name()
+	if wsl {
+		path = convertToUnixPath(path, true)
+	}
+	args := []string{
+		"-o", `UserKnownHostsFile="` + path + `"`,
+		"-o", "GlobalKnownHostsFile=/dev/null",
+		"-o", "StrictHostKeyChecking=yes",
+	}
+	return args, cleanup, nil
+}
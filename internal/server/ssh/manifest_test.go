@@ -0,0 +1,81 @@
+package ssh
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestScript(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	if exec.Command("sh", "-c", "printf '' | sha256sum -z && find . -maxdepth 0 -printf ''").Run() != nil {
+		t.Skip("GNU find and sha256sum are not installed")
+	}
+
+	tests := []struct {
+		name       string
+		shims      map[string]string // commands replaced for the run
+		wantStatus int
+	}{
+		{name: "GNU tools"},
+		{
+			name:       "sha256sum without -z",
+			shims:      map[string]string{"sha256sum": `for a; do [ "$a" = -z ] && exit 1; done; exit 0`},
+			wantStatus: 3,
+		},
+		{
+			name:       "find without -printf",
+			shims:      map[string]string{"find": `for a; do [ "$a" = -printf ] && exit 1; done; exit 0`},
+			wantStatus: 3,
+		},
+		{
+			name:       "sha256sum missing",
+			shims:      map[string]string{"sha256sum": "exit 127"},
+			wantStatus: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			bin := t.TempDir()
+			for name, body := range tt.shims {
+				if err := os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cmd := exec.Command("sh", "-c", manifestScript(dir))
+			cmd.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+			out, err := cmd.Output()
+			status := 0
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				status = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.wantStatus {
+				t.Fatalf("exit status = %d, want %d", status, tt.wantStatus)
+			}
+			if status != 0 {
+				return
+			}
+
+			m, err := parseManifest(string(out))
+			if err != nil {
+				t.Fatalf("parseManifest: %v", err)
+			}
+			if e := m["main.go"]; e.kind != 'f' || len(e.hash) != 64 {
+				t.Errorf("main.go = %+v", e)
+			}
+		})
+	}
+}
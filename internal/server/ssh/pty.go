@@ -0,0 +1,103 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// RunInteractive runs cmd on a pseudo-terminal wired to the local terminal,
+// for commands such as `docker exec -it` that need one. The local terminal
+// is put in raw mode, so keys like Ctrl+C reach the remote program, and
+// window size changes are forwarded. The remote exit status is returned as
+// an *ssh.ExitError, like RunCommand.
+func (c *Client) RunInteractive(cmd string) error {
+	if c.recorder != nil {
+		c.recorder.record(Operation{Kind: OpRun, Host: c.host, Command: cmd})
+		return nil
+	}
+	return c.ptySession(func(session *ssh.Session) error {
+		return session.Start(cmd)
+	})
+}
+
+// ptySession opens a session with a pseudo-terminal, runs start on it and
+// waits for the remote side to finish. A dropped connection is replaced
+// before the session opens; a running session is never repeated.
+func (c *Client) ptySession(start func(*ssh.Session) error) error {
+	var session *ssh.Session
+	err := c.retry(c.ctx, false, func() error {
+		s, err := c.sshClient().NewSession()
+		if err != nil {
+			return &errNotStarted{err}
+		}
+		session = s
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	// Set up terminal modes
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,     // enable echoing
+		ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
+		ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
+	}
+
+	// Get terminal size
+	fd := int(os.Stdin.Fd())
+	interactive := term.IsTerminal(fd)
+	width, height, err := term.GetSize(fd)
+	if err != nil {
+		width, height = 80, 40 // Fallback
+	}
+
+	termType := os.Getenv("TERM")
+	if termType == "" {
+		termType = "xterm-256color"
+	}
+
+	// Request pseudo terminal
+	if err := session.RequestPty(termType, height, width, modes); err != nil {
+		return fmt.Errorf("request for pseudo terminal failed: %v", err)
+	}
+
+	session.Stdin = os.Stdin
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+
+	// Put local terminal into raw mode. Piped input is passed through as is.
+	if interactive {
+		oldState, err := term.MakeRaw(fd)
+		if err != nil {
+			return fmt.Errorf("failed to set raw mode: %v", err)
+		}
+		defer term.Restore(fd, oldState)
+
+		stop := watchResize(fd, session)
+		defer stop()
+	}
+
+	if err := start(session); err != nil {
+		return err
+	}
+
+	// Keys go to the remote program in raw mode, so only SIGTERM or a
+	// second interrupt cancel the context; hang up when they do
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-c.ctx.Done():
+			session.Close()
+		case <-finished:
+		}
+	}()
+
+	// Wait for session to finish
+	return session.Wait()
+}
@@ -0,0 +1,98 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Operation kinds captured by a Recorder
+const (
+	OpRun    = "run"
+	OpUpload = "upload"
+	OpSync   = "sync"
+	OpShell  = "shell"
+)
+
+// Operation is a single remote change that a dry run would have performed
+type Operation struct {
+	Kind    string `json:"kind" yaml:"kind"`
+	Host    string `json:"host" yaml:"host"`
+	Command string `json:"command,omitempty" yaml:"command,omitempty"`
+	Local   string `json:"local,omitempty" yaml:"local,omitempty"`
+	Remote  string `json:"remote,omitempty" yaml:"remote,omitempty"`
+}
+
+// Recorder collects the commands, uploads and directory syncs of a
+// dry run in the order they were issued instead of sending them to the server.
+type Recorder struct {
+	mu  sync.Mutex
+	ops []Operation
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) record(op Operation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = append(r.ops, op)
+}
+
+// Operations returns a copy of the recorded operations
+func (r *Recorder) Operations() []Operation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ops := make([]Operation, len(r.ops))
+	copy(ops, r.ops)
+	return ops
+}
+
+// Print writes the recorded plan in a human readable form
+func (r *Recorder) Print(w io.Writer) {
+	ops := r.Operations()
+	fmt.Fprintf(w, "\n📝 Dry run: %d remote operation(s) would be performed\n", len(ops))
+	for i, op := range ops {
+		switch op.Kind {
+		case OpRun:
+			fmt.Fprintf(w, "%3d. [%s] run     %s\n", i+1, op.Host, indentCommand(op.Command))
+		case OpUpload:
+			fmt.Fprintf(w, "%3d. [%s] upload  %s → %s\n", i+1, op.Host, op.Local, op.Remote)
+		case OpSync:
+			fmt.Fprintf(w, "%3d. [%s] sync    %s/ → %s/\n", i+1, op.Host, op.Local, op.Remote)
+		case OpShell:
+			fmt.Fprintf(w, "%3d. [%s] shell   interactive session\n", i+1, op.Host)
+		}
+	}
+	fmt.Fprintln(w, "ℹ️  No changes were made on the server.")
+}
+
+// indentCommand keeps multi-line scripts aligned under their list entry
+func indentCommand(cmd string) string {
+	return strings.ReplaceAll(strings.TrimSpace(cmd), "\n", "\n                 ")
+}
+
+// NewDryRunClient returns a client whose mutating operations are recorded
+// into rec. When the server is reachable the connection is kept for
+// read-only lookups (GetCommandOutput, DownloadFile, PullDirectory) so the plan
+// reflects the real server state; otherwise those lookups return nothing.
+// hostKey is verified as in NewPinnedClient.
+func NewDryRunClient(host string, port int, user, keyPath, hostKey string, rec *Recorder, auth ...string) (*Client, error) {
+	client, err := NewPinnedClient(host, port, user, keyPath, hostKey, auth...)
+	if err != nil {
+		client = &Client{host: host, port: port, user: user, keyPath: keyPath}
+	}
+	client.recorder = rec
+	return client, err
+}
+
+// DryRun reports whether the client records operations instead of running them
+func (c *Client) DryRun() bool {
+	return c.recorder != nil
+}
+
+func (c *Client) offline() bool {
+	return c.client == nil
+}
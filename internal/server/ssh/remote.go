@@ -0,0 +1,22 @@
+package ssh
+
+import (
+	"io"
+
+	"github.com/skssmd/graft/internal/server/ignore"
+)
+
+// Remote is the set of server operations used by the deploy, rollback,
+// hostinit, infra and webhook packages. *Client implements it; tests can
+// connect a Client to an sshtest.Server instead of a real host.
+type Remote interface {
+	RunCommand(cmd string, stdout, stderr io.Writer) error
+	RunIdempotent(cmd string, stdout, stderr io.Writer) error
+	GetCommandOutput(cmd string) (string, error)
+	StreamCommand(cmd string, in io.Reader, stdout, stderr io.Writer) error
+	UploadFile(local, remote string) error
+	DownloadFile(remote, local string) error
+	SyncDirectory(localDir, remoteDir string, ignored *ignore.Matcher, stdout, stderr io.Writer) error
+}
+
+var _ Remote = (*Client)(nil)
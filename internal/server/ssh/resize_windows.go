@@ -0,0 +1,37 @@
+//go:build windows
+
+package ssh
+
+import (
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// watchResize forwards terminal size changes to the session until the
+// returned stop function is called. Windows has no SIGWINCH, so the size
+// is polled.
+func watchResize(fd int, session *ssh.Session) func() {
+	done := make(chan struct{})
+	width, height, _ := term.GetSize(fd)
+
+	go func() {
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				w, h, err := term.GetSize(fd)
+				if err == nil && (w != width || h != height) {
+					width, height = w, h
+					session.WindowChange(height, width)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,411 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Connection health and recovery tuning
+const (
+	// keepaliveInterval is how often an idle connection is probed and
+	// keepaliveTimeout how long a probe may go unanswered before the
+	// connection is considered dead
+	keepaliveInterval = 15 * time.Second
+	keepaliveTimeout  = 45 * time.Second
+
+	// reconnectAttempts bounds how often a step is retried after the
+	// connection drops
+	reconnectAttempts = 3
+
+	// interruptGrace is how long a remote command gets to exit after
+	// SIGINT before it is killed
+	interruptGrace = 5 * time.Second
+)
+
+// errNotStarted marks failures that happened before a remote command ran,
+// which makes them safe to retry for any command
+type errNotStarted struct{ err error }
+
+func (e *errNotStarted) Error() string { return e.err.Error() }
+func (e *errNotStarted) Unwrap() error { return e.err }
+
+// SetContext makes ctx the parent of every remote operation. Cancelling it,
+// for example on Ctrl+C, interrupts the running command.
+func (c *Client) SetContext(ctx context.Context) {
+	if ctx != nil {
+		c.ctx = ctx
+	}
+}
+
+// SetCommandTimeout limits how long each remote command may run. Zero means
+// no limit. Syncs and file transfers are not affected.
+func (c *Client) SetCommandTimeout(d time.Duration) {
+	c.timeout = d
+}
+
+// commandContext derives the context for a single remote command
+func (c *Client) commandContext() (context.Context, context.CancelFunc) {
+	if c.timeout > 0 {
+		return context.WithTimeout(c.ctx, c.timeout)
+	}
+	return context.WithCancel(c.ctx)
+}
+
+// RunCommandContext runs cmd until it exits or ctx ends. When ctx ends the
+// remote process is sent SIGINT and, if it is still running after a grace
+// period, SIGKILL.
+func (c *Client) RunCommandContext(ctx context.Context, cmd string, stdout, stderr io.Writer) error {
+	if c.recorder != nil {
+		c.recorder.record(Operation{Kind: OpRun, Host: c.host, Command: cmd})
+		return nil
+	}
+	return c.retry(ctx, false, func() error {
+		return c.run(ctx, cmd, nil, stdout, stderr)
+	})
+}
+
+// StreamCommand runs cmd with stdin connected to in, for example to pipe an
+// image into `docker load`. Like file transfers it is not bound by the
+// command timeout, and since in cannot be rewound it is never repeated
+// after a reconnect.
+func (c *Client) StreamCommand(cmd string, in io.Reader, stdout, stderr io.Writer) error {
+	if c.recorder != nil {
+		c.recorder.record(Operation{Kind: OpRun, Host: c.host, Command: cmd + " < (stream)"})
+		return nil
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+	return c.retry(ctx, false, func() error {
+		return c.run(ctx, cmd, contextReader{ctx, in}, stdout, stderr)
+	})
+}
+
+// RunIdempotent runs cmd like RunCommand, but if the connection drops while
+// it runs, graft reconnects and runs it again. Only use it for steps that
+// are safe to repeat, such as builds, pulls and `up -d`.
+func (c *Client) RunIdempotent(cmd string, stdout, stderr io.Writer) error {
+	ctx, cancel := c.commandContext()
+	defer cancel()
+	return c.RunIdempotentContext(ctx, cmd, stdout, stderr)
+}
+
+// RunIdempotentContext is RunIdempotent with an explicit context
+func (c *Client) RunIdempotentContext(ctx context.Context, cmd string, stdout, stderr io.Writer) error {
+	if c.recorder != nil {
+		c.recorder.record(Operation{Kind: OpRun, Host: c.host, Command: cmd})
+		return nil
+	}
+	return c.retry(ctx, true, func() error {
+		return c.run(ctx, cmd, nil, stdout, stderr)
+	})
+}
+
+// GetCommandOutputContext returns the stdout of cmd
+func (c *Client) GetCommandOutputContext(ctx context.Context, cmd string) (string, error) {
+	if c.offline() {
+		return "", nil
+	}
+	var out bytes.Buffer
+	err := c.retry(ctx, false, func() error {
+		out.Reset()
+		return c.run(ctx, cmd, nil, &out, nil)
+	})
+	if err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// UploadFileContext copies local to remote, starting over after a reconnect
+func (c *Client) UploadFileContext(ctx context.Context, local, remote string) error {
+	if c.recorder != nil {
+		c.recorder.record(Operation{Kind: OpUpload, Host: c.host, Local: local, Remote: remote})
+		return nil
+	}
+	return c.retry(ctx, true, func() error {
+		src, err := os.Open(local)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		dst, err := c.sftpClient().Create(remote)
+		if err != nil {
+			return err
+		}
+		defer dst.Close()
+
+		_, err = io.Copy(dst, contextReader{ctx, src})
+		return err
+	})
+}
+
+// DownloadFileContext copies remote to local, starting over after a reconnect
+func (c *Client) DownloadFileContext(ctx context.Context, remote, local string) error {
+	if c.offline() {
+		return fmt.Errorf("not connected to %s", c.host)
+	}
+	return c.retry(ctx, true, func() error {
+		src, err := c.sftpClient().Open(remote)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		dst, err := os.Create(local)
+		if err != nil {
+			return err
+		}
+		defer dst.Close()
+
+		_, err = io.Copy(dst, contextReader{ctx, src})
+		return err
+	})
+}
+
+// run executes cmd in a new session and interrupts it when ctx ends
+func (c *Client) run(ctx context.Context, cmd string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return c.interrupted(err)
+	}
+	session, err := c.sshClient().NewSession()
+	if err != nil {
+		return &errNotStarted{err}
+	}
+	defer session.Close()
+
+	if stdin != nil {
+		session.Stdin = stdin
+	}
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if err := session.Start(cmd); err != nil {
+		return &errNotStarted{err}
+	}
+
+	wait := make(chan error, 1)
+	go func() { wait <- session.Wait() }()
+
+	select {
+	case err := <-wait:
+		return err
+	case <-ctx.Done():
+		// Servers without signal support ignore the request; closing the
+		// channel then at least hangs up on the command
+		session.Signal(ssh.SIGINT)
+		select {
+		case <-wait:
+		case <-time.After(interruptGrace):
+			session.Signal(ssh.SIGKILL)
+			session.Close()
+		}
+		return c.interrupted(ctx.Err())
+	}
+}
+
+// interrupted describes why a command was stopped
+func (c *Client) interrupted(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) && c.timeout > 0 {
+		return fmt.Errorf("remote command timed out after %s: %w", c.timeout, err)
+	}
+	return fmt.Errorf("remote command interrupted: %w", err)
+}
+
+// retry runs op, reconnecting when the connection drops. op is repeated
+// after a reconnect if it never started or if idempotent is set.
+func (c *Client) retry(ctx context.Context, idempotent bool, op func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || ctx.Err() != nil || !c.connectionLost(err) {
+			var notStarted *errNotStarted
+			if errors.As(err, &notStarted) {
+				return notStarted.err
+			}
+			return err
+		}
+
+		var notStarted *errNotStarted
+		if !idempotent && !errors.As(err, &notStarted) {
+			return fmt.Errorf("connection to %s lost while the command was running: %w", c.host, err)
+		}
+		if attempt > reconnectAttempts {
+			return fmt.Errorf("connection to %s lost: %w", c.host, err)
+		}
+		if err := c.reconnect(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// connectionLost reports whether err was caused by the connection dropping
+// rather than by the remote command or file operation itself
+func (c *Client) connectionLost(err error) bool {
+	if c.dial == nil {
+		return false
+	}
+	var exitErr *ssh.ExitError
+	var statusErr *sftp.StatusError
+	if errors.As(err, &exitErr) || errors.As(err, &statusErr) || errors.Is(err, os.ErrNotExist) {
+		return false
+	}
+	c.mu.Lock()
+	done := c.done
+	c.mu.Unlock()
+	// The session can fail slightly before the connection reports its end
+	select {
+	case <-done:
+		return true
+	case <-time.After(500 * time.Millisecond):
+		return false
+	}
+}
+
+// reconnect replaces a dropped connection, backing off between attempts.
+// Operations running in parallel that lose the connection together wait for
+// a single reconnect.
+func (c *Client) reconnect(ctx context.Context) error {
+	c.reconnecting.Lock()
+	defer c.reconnecting.Unlock()
+	c.mu.Lock()
+	done := c.done
+	c.mu.Unlock()
+	select {
+	case <-done:
+	default:
+		return nil // another operation already reconnected
+	}
+
+	var err error
+	for attempt := 1; attempt <= reconnectAttempts; attempt++ {
+		delay := time.Duration(1<<(attempt-1)) * time.Second
+		fmt.Fprintf(os.Stderr, "⚠️  Connection to %s lost, reconnecting in %s (attempt %d/%d)...\n", c.host, delay, attempt, reconnectAttempts)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return c.interrupted(ctx.Err())
+		}
+
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			return fmt.Errorf("connection to %s is closed", c.host)
+		}
+
+		c.closeConn()
+		if err = c.connect(); err == nil {
+			fmt.Fprintf(os.Stderr, "🔌 Reconnected to %s\n", c.host)
+			return nil
+		}
+	}
+	return fmt.Errorf("unable to reconnect to %s: %v", c.host, err)
+}
+
+// connect dials the server, opens the SFTP session and starts keepalives
+func (c *Client) connect() error {
+	client, jumps, err := c.dial()
+	if err != nil {
+		return err
+	}
+
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		client.Close()
+		closeClients(jumps)
+		return fmt.Errorf("unable to start sftp: %v", err)
+	}
+
+	done := make(chan struct{})
+	c.mu.Lock()
+	c.client, c.sftp, c.jumps, c.done = client, sftpClient, jumps, done
+	c.mu.Unlock()
+
+	go func() {
+		client.Wait()
+		close(done)
+	}()
+	go keepalive(client, done)
+	return nil
+}
+
+// sshClient returns the current connection
+func (c *Client) sshClient() *ssh.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.client
+}
+
+// sftpClient returns the SFTP session of the current connection
+func (c *Client) sftpClient() *sftp.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sftp
+}
+
+// closeConn closes the current connection, if any
+func (c *Client) closeConn() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sftp != nil {
+		c.sftp.Close()
+	}
+	if c.client != nil {
+		c.client.Close()
+	}
+	closeClients(c.jumps)
+	c.jumps = nil
+}
+
+// keepalive probes the server while the connection is open and closes it
+// when the server stops answering, so blocked operations fail instead of
+// hanging on a dead link
+func keepalive(client *ssh.Client, done <-chan struct{}) {
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		reply := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+		select {
+		case err := <-reply:
+			if err != nil {
+				client.Close()
+				return
+			}
+		case <-time.After(keepaliveTimeout):
+			client.Close()
+			return
+		case <-done:
+			return
+		}
+	}
+}
+
+// contextReader stops a copy once its context ends
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
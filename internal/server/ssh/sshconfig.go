@@ -0,0 +1,217 @@
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kevinburke/ssh_config"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHConfigEnv overrides the OpenSSH client config that host aliases are read from
+const SSHConfigEnv = "GRAFT_SSH_CONFIG"
+
+// HostConfig holds what the OpenSSH client config says about a host alias
+type HostConfig struct {
+	HostName     string
+	Port         int
+	User         string
+	IdentityFile string
+	ProxyJump    string
+}
+
+// ResolveHost reads the settings for alias from ~/.ssh/config. ok is false
+// when no Host block sets anything for it.
+func ResolveHost(alias string) (HostConfig, bool) {
+	var hc HostConfig
+	cfg := loadSSHConfig()
+	if cfg == nil || alias == "" {
+		return hc, false
+	}
+
+	get := func(key string) string {
+		val, _ := cfg.Get(alias, key)
+		return strings.TrimSpace(val)
+	}
+	hc.HostName = strings.ReplaceAll(get("HostName"), "%h", alias)
+	hc.Port, _ = strconv.Atoi(get("Port"))
+	hc.User = get("User")
+	if identity := get("IdentityFile"); identity != "" {
+		hc.IdentityFile, _ = expandHome(identity)
+	}
+	if jump := get("ProxyJump"); !strings.EqualFold(jump, "none") {
+		hc.ProxyJump = jump
+	}
+
+	return hc, hc != HostConfig{}
+}
+
+func loadSSHConfig() *ssh_config.Config {
+	path := os.Getenv(SSHConfigEnv)
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		path = filepath.Join(home, ".ssh", "config")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	cfg, err := ssh_config.Decode(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️  Ignoring %s: %v\n", path, err)
+		return nil
+	}
+	return cfg
+}
+
+// jumpHop is one bastion in a ProxyJump chain
+type jumpHop struct {
+	user    string
+	host    string
+	port    int
+	keyPath string
+}
+
+func (h jumpHop) String() string {
+	return fmt.Sprintf("%s@%s", h.user, net.JoinHostPort(h.host, strconv.Itoa(h.port)))
+}
+
+// resolveJumps parses a ProxyJump value ("[user@]host[:port],...") and
+// resolves each hop through the ssh config, like OpenSSH does
+func resolveJumps(proxyJump string) ([]jumpHop, error) {
+	if proxyJump == "" {
+		return nil, nil
+	}
+
+	var hops []jumpHop
+	for _, spec := range strings.Split(proxyJump, ",") {
+		spec = strings.TrimPrefix(strings.TrimSpace(spec), "ssh://")
+		if spec == "" {
+			continue
+		}
+
+		var hop jumpHop
+		if i := strings.LastIndex(spec, "@"); i >= 0 {
+			hop.user, spec = spec[:i], spec[i+1:]
+		}
+		hop.host = spec
+		if h, p, err := net.SplitHostPort(spec); err == nil {
+			port, err := strconv.Atoi(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ProxyJump port in '%s'", spec)
+			}
+			hop.host, hop.port = h, port
+		}
+
+		if hc, ok := ResolveHost(hop.host); ok {
+			if hc.HostName != "" {
+				hop.host = hc.HostName
+			}
+			if hop.port == 0 {
+				hop.port = hc.Port
+			}
+			if hop.user == "" {
+				hop.user = hc.User
+			}
+			hop.keyPath = hc.IdentityFile
+		}
+		if hop.port == 0 {
+			hop.port = 22
+		}
+		if hop.user == "" {
+			hop.user = localUser()
+		}
+		hops = append(hops, hop)
+	}
+	return hops, nil
+}
+
+// jumpSpec formats hops for the -J flag of the external ssh client
+func jumpSpec(hops []jumpHop) string {
+	specs := make([]string, len(hops))
+	for i, hop := range hops {
+		specs[i] = hop.String()
+	}
+	return strings.Join(specs, ",")
+}
+
+func localUser() string {
+	if u, err := user.Current(); err == nil {
+		// Windows returns DOMAIN\user
+		name := u.Username
+		if i := strings.LastIndex(name, `\`); i >= 0 {
+			name = name[i+1:]
+		}
+		return name
+	}
+	return os.Getenv("USER")
+}
+
+// dialJumps connects through each hop in turn and returns the clients, the
+// last of which tunnels the connection to the target
+func dialJumps(hops []jumpHop, signers []ssh.Signer, knownHostsPath string) ([]*ssh.Client, error) {
+	var jumps []*ssh.Client
+	for _, hop := range hops {
+		hopSigners := signers
+		if hop.keyPath != "" {
+			if signer, err := keySigner(hop.keyPath); err == nil {
+				hopSigners = append([]ssh.Signer{signer}, signers...)
+			}
+		}
+		config := &ssh.ClientConfig{
+			User:            hop.user,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(hopSigners...)},
+			HostKeyCallback: createHostKeyCallback(knownHostsPath, hop.host),
+			Timeout:         dialTimeout,
+		}
+
+		addr := net.JoinHostPort(hop.host, strconv.Itoa(hop.port))
+		client, err := dialVia(lastClient(jumps), addr, config)
+		if err != nil {
+			closeClients(jumps)
+			return nil, fmt.Errorf("unable to connect to jump host %s: %w", hop, err)
+		}
+		jumps = append(jumps, client)
+	}
+	return jumps, nil
+}
+
+// dialVia opens an SSH connection to addr, tunnelled through via when set
+func dialVia(via *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if via == nil {
+		return ssh.Dial("tcp", addr, config)
+	}
+	conn, err := via.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+func lastClient(clients []*ssh.Client) *ssh.Client {
+	if len(clients) == 0 {
+		return nil
+	}
+	return clients[len(clients)-1]
+}
+
+func closeClients(clients []*ssh.Client) {
+	for i := len(clients) - 1; i >= 0; i-- {
+		clients[i].Close()
+	}
+}
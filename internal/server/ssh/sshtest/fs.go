@@ -0,0 +1,131 @@
+package sshtest
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+)
+
+// rootFS serves SFTP requests from a local directory. Remote commands are
+// only recorded, so a `mkdir -p` before an upload never happens; parent
+// directories are therefore created on write.
+type rootFS struct {
+	root string
+}
+
+func rootHandlers(root string) sftp.Handlers {
+	fs := &rootFS{root: root}
+	return sftp.Handlers{FileGet: fs, FilePut: fs, FileCmd: fs, FileList: fs}
+}
+
+func (fs *rootFS) path(p string) string {
+	return filepath.Join(fs.root, filepath.FromSlash(p))
+}
+
+func (fs *rootFS) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	return os.Open(fs.path(r.Filepath))
+}
+
+func (fs *rootFS) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	p := fs.path(r.Filepath)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, err
+	}
+
+	pflags := r.Pflags()
+	flags := os.O_WRONLY
+	if pflags.Read {
+		flags = os.O_RDWR
+	}
+	if pflags.Creat {
+		flags |= os.O_CREATE
+	}
+	if pflags.Trunc {
+		flags |= os.O_TRUNC
+	}
+	if pflags.Excl {
+		flags |= os.O_EXCL
+	}
+	return os.OpenFile(p, flags, 0644)
+}
+
+func (fs *rootFS) Filecmd(r *sftp.Request) error {
+	p := fs.path(r.Filepath)
+	switch r.Method {
+	case "Setstat":
+		attrs, flags := r.Attributes(), r.AttrFlags()
+		if flags.Size {
+			if err := os.Truncate(p, int64(attrs.Size)); err != nil {
+				return err
+			}
+		}
+		if flags.Permissions {
+			if err := os.Chmod(p, attrs.FileMode().Perm()); err != nil {
+				return err
+			}
+		}
+		if flags.Acmodtime {
+			return os.Chtimes(p, attrs.AccessTime(), attrs.ModTime())
+		}
+		return nil
+	case "Rename", "PosixRename":
+		return os.Rename(p, fs.path(r.Target))
+	case "Rmdir", "Remove":
+		return os.Remove(p)
+	case "Mkdir":
+		return os.Mkdir(p, 0755)
+	case "Link":
+		return os.Link(fs.path(r.Target), p)
+	case "Symlink":
+		return os.Symlink(r.Target, p)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (fs *rootFS) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	p := fs.path(r.Filepath)
+	switch r.Method {
+	case "List":
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		infos := make([]os.FileInfo, 0, len(entries))
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, info)
+		}
+		return listerAt(infos), nil
+	case "Stat":
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt{info}, nil
+	case "Lstat":
+		info, err := os.Lstat(p)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt{info}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
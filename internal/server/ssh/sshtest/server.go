@@ -0,0 +1,404 @@
+// Package sshtest provides an in-process SSH/SFTP server for exercising code
+// that talks to a graft host without a real machine.
+//
+// The server accepts a generated client key, records every command it is
+// asked to run instead of executing it, and serves SFTP from a temporary
+// directory that stands in for the remote filesystem. Plain `ls <path>`
+// checks fail for paths missing from that directory:
+//
+//	srv, err := sshtest.NewServer()
+//	defer srv.Close()
+//	srv.Respond("date +%Y%m%d%H%M%S", "20250101120000\n", 0)
+//	client, err := srv.Client()
+//	err = deploy.Sync("prod", client, p, false, false, false, "", "", 1, os.Stdout, os.Stderr)
+//	cmds := srv.Commands()
+//	data, err := srv.ReadFile("/opt/graft/projects/demo-prod/docker-compose.yml")
+package sshtest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	graftssh "github.com/skssmd/graft/internal/server/ssh"
+)
+
+// User is the login name the server expects
+const User = "graft"
+
+type Server struct {
+	Host string
+	Port int
+
+	// Root is the directory remote absolute paths are mapped into
+	Root string
+
+	// KeyPath is a private key file the server accepts, for ssh.NewClient
+	KeyPath string
+
+	// HostKey is the server's host key in authorized_keys format, for
+	// ssh.NewPinnedClient and registry entries
+	HostKey string
+
+	dir       string
+	listener  net.Listener
+	config    *ssh.ServerConfig
+	clientKey ssh.PublicKey
+	extraKeys []ssh.PublicKey
+	wg        sync.WaitGroup
+
+	mu        sync.Mutex
+	conns     map[net.Conn]struct{}
+	commands  []string
+	responses []response
+}
+
+type response struct {
+	match  string
+	stdout string
+	status int
+}
+
+// NewServer starts a server listening on a random local port
+func NewServer() (*Server, error) {
+	dir, err := os.MkdirTemp("", "graft-sshtest-")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Root:    filepath.Join(dir, "root"),
+		KeyPath: filepath.Join(dir, "id_ed25519"),
+		dir:     dir,
+		conns:   map[net.Conn]struct{}{},
+	}
+	if err := s.setup(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func (s *Server) setup() error {
+	if err := os.MkdirAll(s.Root, 0755); err != nil {
+		return err
+	}
+
+	// 1. Client key, written to disk so the real client can load it
+	clientPub, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.KeyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		return err
+	}
+	if s.clientKey, err = ssh.NewPublicKey(clientPub); err != nil {
+		return err
+	}
+
+	// 2. Host key and auth
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		return err
+	}
+	s.config = &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == User && s.authorized(key) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown public key for %s", conn.User())
+		},
+	}
+	s.config.AddHostKey(hostSigner)
+	s.HostKey = graftssh.MarshalHostKey(hostSigner.PublicKey())
+
+	// 3. Listener
+	s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	addr := s.listener.Addr().(*net.TCPAddr)
+	s.Host = addr.IP.String()
+	s.Port = addr.Port
+	return nil
+}
+
+// Client connects a graft client to the server. The client is pinned to the
+// server's host key, so it never reads or writes ~/.ssh/known_hosts.
+func (s *Server) Client() (*graftssh.Client, error) {
+	return graftssh.NewPinnedClient(s.Host, s.Port, User, s.KeyPath, s.HostKey)
+}
+
+// Close stops the server, drops open connections and removes its
+// temporary filesystem
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	os.RemoveAll(s.dir)
+	return err
+}
+
+// DropConnections closes every open connection while the server keeps
+// listening, like a network drop the client can recover from
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// AuthorizeKey makes the server accept another client key, given in
+// authorized_keys format. Commands that edit authorized_keys are only
+// recorded, so tests call this to stand in for them.
+func (s *Server) AuthorizeKey(pubKey string) error {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pubKey))
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.extraKeys = append(s.extraKeys, key)
+	return nil
+}
+
+func (s *Server) authorized(key ssh.PublicKey) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range append([]ssh.PublicKey{s.clientKey}, s.extraKeys...) {
+		if string(k.Marshal()) == string(key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Respond makes commands containing match print stdout and exit with status.
+// Later registrations take precedence; unmatched commands succeed silently.
+func (s *Server) Respond(match, stdout string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses = append(s.responses, response{match: match, stdout: stdout, status: status})
+}
+
+// Commands returns every command received so far, in order
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cmds := make([]string, len(s.commands))
+	copy(cmds, s.commands)
+	return cmds
+}
+
+// Path maps a remote absolute path into Root
+func (s *Server) Path(remote string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(path.Clean("/"+remote)))
+}
+
+// WriteFile seeds a file on the fake remote filesystem
+func (s *Server) WriteFile(remote string, data []byte) error {
+	p := s.Path(remote)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0644)
+}
+
+// ReadFile reads a file from the fake remote filesystem
+func (s *Server) ReadFile(remote string) ([]byte, error) {
+	return os.ReadFile(s.Path(remote))
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConn(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	var wg sync.WaitGroup
+	for newCh := range chans {
+		if newCh.ChannelType() == "direct-tcpip" {
+			// Lets the server act as a ProxyJump bastion
+			wg.Add(1)
+			go func(newCh ssh.NewChannel) {
+				defer wg.Done()
+				s.forward(newCh)
+			}(newCh)
+			continue
+		}
+		if newCh.ChannelType() != "session" {
+			newCh.Reject(ssh.UnknownChannelType, "only session and direct-tcpip channels are supported")
+			continue
+		}
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handleSession(ch, chReqs)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *Server) handleSession(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range reqs {
+		switch req.Type {
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			status := s.exec(payload.Command, ch)
+			ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+			return
+		case "subsystem":
+			var payload struct{ Name string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil || payload.Name != "sftp" {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			server := sftp.NewRequestServer(ch, rootHandlers(s.Root))
+			server.Serve()
+			server.Close()
+			return
+		case "env", "pty-req":
+			req.Reply(true, nil)
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// forward connects a direct-tcpip channel to its destination
+func (s *Server) forward(newCh ssh.NewChannel) {
+	var payload struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(newCh.ExtraData(), &payload); err != nil {
+		newCh.Reject(ssh.ConnectionFailed, "invalid direct-tcpip request")
+		return
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(payload.Host, fmt.Sprint(payload.Port)))
+	if err != nil {
+		newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer conn.Close()
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(conn, ch)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(ch, conn)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// exec records cmd and answers it from the registered responses, or from
+// Root for the manifest script and plain `ls <path>` checks
+func (s *Server) exec(cmd string, ch ssh.Channel) int {
+	s.mu.Lock()
+	s.commands = append(s.commands, cmd)
+	var match *response
+	for i := len(s.responses) - 1; i >= 0; i-- {
+		if strings.Contains(cmd, s.responses[i].match) {
+			r := s.responses[i]
+			match = &r
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if match != nil {
+		io.WriteString(ch, match.stdout)
+		return match.status
+	}
+	if dir, ok := graftssh.ParseManifestCommand(cmd); ok {
+		// Answer SyncDirectory's manifest script from Root
+		if err := graftssh.WriteManifest(ch, s.Path(dir)); err != nil {
+			fmt.Fprintf(ch.Stderr(), "sshtest: %v\n", err)
+			return 1
+		}
+	}
+	if target, ok := strings.CutPrefix(cmd, "ls "); ok && path.IsAbs(target) && !strings.ContainsAny(target, " \t;&|<>*") {
+		// A plain existence check such as `ls <dir>/docker-compose.yml`
+		// is answered from Root
+		if _, err := os.Stat(s.Path(target)); err != nil {
+			fmt.Fprintf(ch.Stderr(), "ls: cannot access '%s': No such file or directory\n", target)
+			return 2
+		}
+		fmt.Fprintln(ch, target)
+	}
+	return 0
+}
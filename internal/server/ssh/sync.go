@@ -0,0 +1,455 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/skssmd/graft/internal/server/ignore"
+)
+
+// ErrNoRemoteManifest is returned by SyncDirectory when the server lacks the
+// tools (GNU find with -printf, sha256sum with -z) to hash its copy, so
+// callers can fall back to a full upload.
+var ErrNoRemoteManifest = errors.New("server cannot build a file manifest (GNU find/sha256sum missing)")
+
+// manifestMarker starts the remote manifest script so test servers can
+// recognise it
+const manifestMarker = ": graft-manifest"
+
+// manifestSeparator ends the entry records and starts the sha256sum output
+const manifestSeparator = ":hashes:"
+
+// fileEntry describes one path in a directory manifest
+type fileEntry struct {
+	kind byte // 'f' file, 'd' directory, 'l' symlink
+	mode os.FileMode
+	size int64
+	hash string
+	link string
+}
+
+// manifest maps slash-separated relative paths to their entries
+type manifest map[string]fileEntry
+
+// SyncDirectory makes remoteDir an exact copy of localDir over the SFTP
+// session. Both sides are hashed and only changed files are sent; remote
+// files that no longer exist locally are deleted unless they are ignored.
+// A nil matcher uploads everything. If the connection drops, the sync is
+// started over after reconnecting and only sends what is still missing.
+func (c *Client) SyncDirectory(localDir, remoteDir string, ignored *ignore.Matcher, stdout, stderr io.Writer) error {
+	if c.recorder != nil {
+		c.recorder.record(Operation{Kind: OpSync, Host: c.host, Local: localDir, Remote: remoteDir})
+		return nil
+	}
+	return c.retry(c.ctx, true, func() error {
+		return c.syncDirectory(c.ctx, localDir, remoteDir, ignored, stdout)
+	})
+}
+
+func (c *Client) syncDirectory(ctx context.Context, localDir, remoteDir string, ignored *ignore.Matcher, stdout io.Writer) error {
+	// 1. Hash both sides
+	local, err := localManifest(localDir, ignored.Match)
+	if err != nil {
+		return fmt.Errorf("failed to scan %s: %v", localDir, err)
+	}
+	remote, err := c.remoteManifest(ctx, remoteDir)
+	if err != nil {
+		return err
+	}
+
+	// 2. Work out what changed
+	var mkdirs, uploads, links, chmods, deletes []string
+	var uploadBytes int64
+	for _, rel := range sortedPaths(local) {
+		l := local[rel]
+		r, exists := remote[rel]
+		if exists && r.kind != l.kind {
+			deletes = append(deletes, rel)
+			exists = false
+		}
+		switch l.kind {
+		case 'd':
+			if !exists {
+				mkdirs = append(mkdirs, rel)
+			} else if r.mode != l.mode {
+				chmods = append(chmods, rel)
+			}
+		case 'l':
+			if !exists || r.link != l.link {
+				links = append(links, rel)
+			}
+		case 'f':
+			if !exists || r.hash != l.hash {
+				uploads = append(uploads, rel)
+				uploadBytes += l.size
+			} else if r.mode != l.mode {
+				chmods = append(chmods, rel)
+			}
+		}
+	}
+	for _, rel := range sortedPaths(remote) {
+		if _, ok := local[rel]; !ok && !ignored.Match(rel, remote[rel].kind == 'd') && !coveredBy(deletes, rel) {
+			deletes = append(deletes, rel)
+		}
+	}
+
+	unchanged := len(local) - len(mkdirs) - len(uploads) - len(links) - len(chmods)
+	fmt.Fprintf(stdout, "🔍 %d paths scanned: %d to upload (%s), %d to delete, %d unchanged\n",
+		len(local), len(uploads), formatBytes(uploadBytes), len(deletes), unchanged)
+
+	// 3. Deletions first, deepest paths first, so type changes can be recreated
+	sftpClient := c.sftpClient()
+	sort.Sort(sort.Reverse(sort.StringSlice(deletes)))
+	for _, rel := range deletes {
+		fmt.Fprintf(stdout, "  🗑️  %s\n", rel)
+		if err := sftpClient.RemoveAll(path.Join(remoteDir, rel)); err != nil {
+			return fmt.Errorf("failed to delete %s: %v", rel, err)
+		}
+	}
+
+	// 4. Directories, files, symlinks and permission changes
+	if err := sftpClient.MkdirAll(remoteDir); err != nil {
+		return fmt.Errorf("failed to create %s: %v", remoteDir, err)
+	}
+	for _, rel := range mkdirs {
+		target := path.Join(remoteDir, rel)
+		if err := sftpClient.MkdirAll(target); err != nil {
+			return fmt.Errorf("failed to create %s: %v", rel, err)
+		}
+		sftpClient.Chmod(target, local[rel].mode)
+	}
+	for i, rel := range uploads {
+		fmt.Fprintf(stdout, "  📤 [%d/%d] %s (%s)\n", i+1, len(uploads), rel, formatBytes(local[rel].size))
+		if err := c.uploadEntry(ctx, filepath.Join(localDir, filepath.FromSlash(rel)), path.Join(remoteDir, rel), local[rel].mode); err != nil {
+			return fmt.Errorf("failed to upload %s: %w", rel, err)
+		}
+	}
+	for _, rel := range links {
+		target := path.Join(remoteDir, rel)
+		sftpClient.Remove(target)
+		if err := sftpClient.Symlink(local[rel].link, target); err != nil {
+			return fmt.Errorf("failed to link %s: %v", rel, err)
+		}
+	}
+	for _, rel := range chmods {
+		if err := sftpClient.Chmod(path.Join(remoteDir, rel), local[rel].mode); err != nil {
+			return fmt.Errorf("failed to chmod %s: %v", rel, err)
+		}
+	}
+
+	fmt.Fprintf(stdout, "✅ Sync complete: %d uploaded, %d deleted, %d unchanged\n", len(uploads), len(deletes), unchanged)
+	return nil
+}
+
+// PullDirectory copies remoteDir into localDir, downloading only files
+// whose content differs. Local files are never deleted.
+func (c *Client) PullDirectory(remoteDir, localDir string, stdout, stderr io.Writer) error {
+	if c.offline() {
+		return fmt.Errorf("not connected to %s", c.host)
+	}
+	return c.retry(c.ctx, true, func() error {
+		return c.pullDirectory(c.ctx, remoteDir, localDir, stdout, stderr)
+	})
+}
+
+func (c *Client) pullDirectory(ctx context.Context, remoteDir, localDir string, stdout, stderr io.Writer) error {
+	remote, err := c.remoteManifest(ctx, remoteDir)
+	if err != nil {
+		return err
+	}
+	local, err := localManifest(localDir, nil)
+	if err != nil {
+		return fmt.Errorf("failed to scan %s: %v", localDir, err)
+	}
+
+	var downloads []string
+	for _, rel := range sortedPaths(remote) {
+		r := remote[rel]
+		target := filepath.Join(localDir, filepath.FromSlash(rel))
+		switch r.kind {
+		case 'd':
+			if err := os.MkdirAll(target, r.mode|0700); err != nil {
+				return err
+			}
+		case 'l':
+			if l, ok := local[rel]; !ok || l.link != r.link {
+				os.Remove(target)
+				if err := os.Symlink(r.link, target); err != nil {
+					fmt.Fprintf(stderr, "  ⚠️  Could not create symlink %s: %v\n", rel, err)
+				}
+			}
+		case 'f':
+			if l, ok := local[rel]; !ok || l.hash != r.hash {
+				downloads = append(downloads, rel)
+			}
+		}
+	}
+
+	fmt.Fprintf(stdout, "🔍 %d remote paths: %d to download\n", len(remote), len(downloads))
+	for i, rel := range downloads {
+		fmt.Fprintf(stdout, "  📥 [%d/%d] %s\n", i+1, len(downloads), rel)
+		target := filepath.Join(localDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := c.DownloadFileContext(ctx, path.Join(remoteDir, rel), target); err != nil {
+			return fmt.Errorf("failed to download %s: %w", rel, err)
+		}
+		os.Chmod(target, remote[rel].mode)
+	}
+	return nil
+}
+
+// uploadEntry writes one file through a temporary name and renames it into
+// place, so a running build never sees a half-written file
+func (c *Client) uploadEntry(ctx context.Context, local, remote string, mode os.FileMode) error {
+	src, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	sftpClient := c.sftpClient()
+	tmp := path.Join(path.Dir(remote), ".graft-tmp-"+path.Base(remote))
+	dst, err := sftpClient.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, contextReader{ctx, src}); err != nil {
+		dst.Close()
+		sftpClient.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		sftpClient.Remove(tmp)
+		return err
+	}
+	sftpClient.Chmod(tmp, mode)
+	if err := sftpClient.PosixRename(tmp, remote); err != nil {
+		// Servers without the posix-rename extension
+		sftpClient.Remove(remote)
+		if err := sftpClient.Rename(tmp, remote); err != nil {
+			sftpClient.Remove(tmp)
+			return err
+		}
+	}
+	return nil
+}
+
+// remoteManifest hashes remoteDir on the server. A missing directory yields
+// an empty manifest.
+func (c *Client) remoteManifest(ctx context.Context, remoteDir string) (manifest, error) {
+	out, err := c.GetCommandOutputContext(ctx, manifestScript(remoteDir))
+	if err != nil {
+		if status, ok := ExitStatus(err); ok && status == 3 {
+			return nil, ErrNoRemoteManifest
+		}
+		return nil, fmt.Errorf("failed to read remote manifest: %w", err)
+	}
+	return parseManifest(out)
+}
+
+// manifestScript lists every entry under dir with its mode, then the
+// sha256 of every file, NUL separated. Exit status 3 means the server's find
+// or sha256sum, such as BusyBox's, lacks the options the script needs.
+func manifestScript(dir string) string {
+	return fmt.Sprintf("%s %s; cd %s 2>/dev/null || exit 0; "+
+		"printf '' | sha256sum -z >/dev/null 2>&1 || exit 3; "+
+		"find . -maxdepth 0 -printf '' >/dev/null 2>&1 || exit 3; "+
+		"find . -mindepth 1 \\( -type d -printf 'd %%m %%P\\0' \\) -o \\( -type l -printf 'l %%m %%P\\0%%l\\0' \\) -o \\( -type f -printf 'f %%m %%P\\0' \\) || exit 1; "+
+		"printf '%s\\0'; find . -type f -print0 | xargs -0 -r sha256sum -z",
+		manifestMarker, shellQuote(dir), shellQuote(dir), manifestSeparator)
+}
+
+// parseManifest reads the output of the remote manifest script
+func parseManifest(out string) (manifest, error) {
+	m := manifest{}
+	fields := strings.Split(out, "\x00")
+	i := 0
+	for ; i < len(fields) && fields[i] != manifestSeparator; i++ {
+		rec := fields[i]
+		if rec == "" {
+			continue
+		}
+		parts := strings.SplitN(rec, " ", 3)
+		if len(parts) != 3 || len(parts[0]) != 1 {
+			return nil, fmt.Errorf("malformed manifest entry %q", rec)
+		}
+		mode, err := strconv.ParseUint(parts[1], 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("malformed manifest mode %q", rec)
+		}
+		e := fileEntry{kind: parts[0][0], mode: os.FileMode(mode).Perm()}
+		if e.kind == 'l' {
+			i++
+			if i < len(fields) {
+				e.link = fields[i]
+			}
+		}
+		m[parts[2]] = e
+	}
+	for i++; i < len(fields); i++ {
+		line := fields[i]
+		if len(line) < 67 {
+			continue
+		}
+		// "<sha256>  ./<path>"
+		rel := strings.TrimPrefix(line[66:], "./")
+		if e, ok := m[rel]; ok && e.kind == 'f' {
+			e.hash = line[:64]
+			m[rel] = e
+		}
+	}
+	return m, nil
+}
+
+// WriteManifest writes a manifest of dir in the remote script's format. It
+// lets test servers answer manifest commands without a shell.
+func WriteManifest(w io.Writer, dir string) error {
+	m, err := localManifest(dir, nil)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	paths := sortedPaths(m)
+	bw := bufio.NewWriter(w)
+	for _, rel := range paths {
+		e := m[rel]
+		fmt.Fprintf(bw, "%c %o %s\x00", e.kind, e.mode, rel)
+		if e.kind == 'l' {
+			fmt.Fprintf(bw, "%s\x00", e.link)
+		}
+	}
+	fmt.Fprintf(bw, "%s\x00", manifestSeparator)
+	for _, rel := range paths {
+		if e := m[rel]; e.kind == 'f' {
+			fmt.Fprintf(bw, "%s  ./%s\x00", e.hash, rel)
+		}
+	}
+	return bw.Flush()
+}
+
+// ParseManifestCommand returns the directory a manifest script was issued
+// for, or false if cmd is not a manifest script
+func ParseManifestCommand(cmd string) (string, bool) {
+	if !strings.HasPrefix(cmd, manifestMarker+" '") {
+		return "", false
+	}
+	rest := cmd[len(manifestMarker)+1:]
+	end := strings.Index(rest, "; cd ")
+	if end < 0 {
+		return "", false
+	}
+	return shellUnquote(rest[:end]), true
+}
+
+// localManifest walks root and hashes every file not excluded
+func localManifest(root string, exclude func(rel string, dir bool) bool) (manifest, error) {
+	m := manifest{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if exclude != nil && exclude(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		e := fileEntry{mode: info.Mode().Perm(), size: info.Size()}
+		switch {
+		case d.IsDir():
+			e.kind = 'd'
+		case info.Mode()&os.ModeSymlink != 0:
+			e.kind = 'l'
+			if e.link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			e.kind = 'f'
+			if e.hash, err = hashFile(p); err != nil {
+				return err
+			}
+		default:
+			return nil // sockets, devices
+		}
+		m[rel] = e
+		return nil
+	})
+	return m, err
+}
+
+func hashFile(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// coveredBy reports whether rel lies inside a path that is already deleted
+func coveredBy(deletes []string, rel string) bool {
+	for _, d := range deletes {
+		if strings.HasPrefix(rel, d+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedPaths(m manifest) []string {
+	paths := make([]string, 0, len(m))
+	for p := range m {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func shellUnquote(s string) string {
+	s = strings.TrimPrefix(strings.TrimSuffix(s, "'"), "'")
+	return strings.ReplaceAll(s, `'\''`, "'")
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,104 @@
+package ssh_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skssmd/graft/internal/server/ssh/sshtest"
+)
+
+func TestSyncDirectory(t *testing.T) {
+	tests := []struct {
+		name    string
+		local   map[string]string
+		remote  map[string]string
+		want    map[string]string
+		deleted []string
+	}{
+		{
+			name:  "empty remote",
+			local: map[string]string{"main.go": "package main\n", "web/index.html": "<h1>hi</h1>\n"},
+			want:  map[string]string{"main.go": "package main\n", "web/index.html": "<h1>hi</h1>\n"},
+		},
+		{
+			name:   "changed file",
+			local:  map[string]string{"main.go": "package main // v2\n", "go.mod": "module demo\n"},
+			remote: map[string]string{"main.go": "package main\n", "go.mod": "module demo\n"},
+			want:   map[string]string{"main.go": "package main // v2\n", "go.mod": "module demo\n"},
+		},
+		{
+			name:    "stale files are deleted",
+			local:   map[string]string{"main.go": "package main\n"},
+			remote:  map[string]string{"main.go": "package main\n", "old.go": "package old\n", "old/dir/file.txt": "x\n"},
+			want:    map[string]string{"main.go": "package main\n"},
+			deleted: []string{"old.go", "old/dir/file.txt", "old"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := sshtest.NewServer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { srv.Close() })
+
+			const remoteDir = "/opt/graft/projects/demo-prod/api"
+			for rel, data := range tt.remote {
+				if err := srv.WriteFile(remoteDir+"/"+rel, []byte(data)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			localDir := t.TempDir()
+			for rel, data := range tt.local {
+				p := filepath.Join(localDir, filepath.FromSlash(rel))
+				if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			client, err := srv.Client()
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { client.Close() })
+
+			if err := client.SyncDirectory(localDir, remoteDir, nil, io.Discard, io.Discard); err != nil {
+				t.Fatalf("SyncDirectory: %v", err)
+			}
+
+			for rel, want := range tt.want {
+				got, err := srv.ReadFile(remoteDir + "/" + rel)
+				if err != nil {
+					t.Errorf("%s: %v", rel, err)
+					continue
+				}
+				if string(got) != want {
+					t.Errorf("%s = %q, want %q", rel, got, want)
+				}
+			}
+			for _, rel := range tt.deleted {
+				if _, err := os.Stat(srv.Path(remoteDir + "/" + rel)); !os.IsNotExist(err) {
+					t.Errorf("%s still exists on the server", rel)
+				}
+			}
+
+			// The remote side is hashed with a single manifest command
+			var manifests int
+			for _, cmd := range srv.Commands() {
+				if strings.Contains(cmd, ": graft-manifest") {
+					manifests++
+				}
+			}
+			if manifests != 1 {
+				t.Errorf("ran %d manifest commands, want 1: %q", manifests, srv.Commands())
+			}
+		})
+	}
+}
@@ -1,7 +1,7 @@
 package ssh
 
 import (
-	"bufio"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -41,7 +41,12 @@ func createHostKeyCallback(knownHostsPath, hostname string) ssh.HostKeyCallback
 			var keyErr *knownhosts.KeyError
 			if errors.As(err, &keyErr) && len(keyErr.Want) > 0 {
 				// Host exists but key doesn't match - SECURITY WARNING
-				return fmt.Errorf("WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!\nHost key for %s has changed. This could indicate a man-in-the-middle attack.\nTo fix: ssh-keygen -R %s", hostname, hostname)
+				return &HostKeyMismatchError{
+					Host:   hostname,
+					Want:   ssh.FingerprintSHA256(keyErr.Want[0].Key),
+					Got:    ssh.FingerprintSHA256(key),
+					Source: knownHostsPath,
+				}
 			}
 			// Host not found, will add below
 		}
@@ -84,72 +89,6 @@ func findSSH() (string, bool) {
 	return "ssh", false
 }
 
-// parseGitignore reads a .gitignore file and returns rsync-compatible exclude patterns
-func parseGitignore(gitignorePath string) []string {
-	var excludes []string
-
-	file, err := os.Open(gitignorePath)
-	if err != nil {
-		// If .gitignore doesn't exist, return empty list
-		return excludes
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		// Remove leading slash for rsync compatibility
-		pattern := strings.TrimPrefix(line, "/")
-
-		// Skip negation patterns (rsync handles them differently)
-		if strings.HasPrefix(pattern, "!") {
-			continue
-		}
-
-		excludes = append(excludes, pattern)
-	}
-
-	return excludes
-}
-
-// findRsync tries to find rsync executable, checking common Windows locations
-func findRsync() (string, error) {
-	// On Windows, check specific locations first to properly identify the rsync type
-	windowsPaths := []string{
-		"C:\\Program Files\\Git\\usr\\bin\\rsync.exe", // Git Bash
-		"C:\\cygwin64\\bin\\rsync.exe",                // Cygwin 64-bit
-		"C:\\cygwin\\bin\\rsync.exe",                  // Cygwin 32-bit
-	}
-
-	for _, path := range windowsPaths {
-		if _, err := os.Stat(path); err == nil {
-			return path, nil
-		}
-	}
-
-	// Check if WSL is available
-	if _, err := exec.LookPath("wsl"); err == nil {
-		// Verify WSL has rsync
-		cmd := exec.Command("wsl", "which", "rsync")
-		if err := cmd.Run(); err == nil {
-			return "wsl", nil
-		}
-	}
-
-	// Try standard rsync (Linux/Mac or rsync in PATH)
-	if _, err := exec.LookPath("rsync"); err == nil {
-		return "rsync", nil
-	}
-
-	return "", fmt.Errorf("rsync not found - please install rsync via WSL, Git for Windows, or Cygwin")
-}
-
 // convertToUnixPath converts Windows paths to Unix-style paths
 // For WSL: C:\Users\Name\file.pem -> /mnt/c/Users/Name/file.pem
 // For Git Bash/Cygwin: C:\Users\Name\file.pem -> /c/Users/Name/file.pem
@@ -175,7 +114,13 @@ func convertToUnixPath(windowsPath string, useWSLFormat bool) string {
 	return unixPath
 }
 
-// GenerateSSHKey generates a new RSA SSH key pair and saves it to the specified paths
+// Key types accepted by GenerateKeyPair
+const (
+	KeyTypeEd25519 = "ed25519"
+	KeyTypeRSA     = "rsa"
+)
+
+// GenerateSSHKey generates a new Ed25519 SSH key pair and saves it to the specified paths
 func GenerateSSHKey(privateKeyPath, publicKeyPath string) error {
 	privPEM, pubSSH, err := GenerateSSHKeyPairStrings()
 	if err != nil {
@@ -200,8 +145,47 @@ func GenerateSSHKey(privateKeyPath, publicKeyPath string) error {
 	return nil
 }
 
-// GenerateSSHKeyPairStrings generates a new RSA SSH key pair and returns them as strings
+// GenerateSSHKeyPairStrings generates a new Ed25519 SSH key pair and returns them as strings
 func GenerateSSHKeyPairStrings() (string, string, error) {
+	return GenerateKeyPair(KeyTypeEd25519)
+}
+
+// GenerateKeyPair generates a key pair of the given type and returns the
+// private key in PEM format and the public key in authorized_keys format.
+// RSA keys are 4096 bits, for servers that do not accept Ed25519.
+func GenerateKeyPair(keyType string) (string, string, error) {
+	switch keyType {
+	case KeyTypeEd25519, "":
+		return generateEd25519KeyPair()
+	case KeyTypeRSA:
+		return generateRSAKeyPair()
+	}
+	return "", "", fmt.Errorf("unknown key type '%s' (use %s or %s)", keyType, KeyTypeEd25519, KeyTypeRSA)
+}
+
+func generateEd25519KeyPair() (string, string, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Ed25519 keys only have the OpenSSH private key format
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		return "", "", err
+	}
+	privPEM := string(pem.EncodeToMemory(block))
+
+	pubKey, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return "", "", err
+	}
+	pubSSH := string(ssh.MarshalAuthorizedKey(pubKey))
+
+	return privPEM, pubSSH, nil
+}
+
+func generateRSAKeyPair() (string, string, error) {
 	// Generate private key
 	privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
 	if err != nil {
@@ -224,3 +208,17 @@ func GenerateSSHKeyPairStrings() (string, string, error) {
 
 	return privPEM, pubSSH, nil
 }
+
+// ExitStatus extracts the exit status of a remote or local command from err.
+// It understands both SSH session errors and errors from the external ssh binary.
+func ExitStatus(err error) (int, bool) {
+	var sshErr *ssh.ExitError
+	if errors.As(err, &sshErr) {
+		return sshErr.ExitStatus(), true
+	}
+	var execErr *exec.ExitError
+	if errors.As(err, &execErr) {
+		return execErr.ExitCode(), true
+	}
+	return 0, false
+}
@@ -1,28 +1,25 @@
 package webhook
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/skssmd/graft/internal/config"
+	"github.com/skssmd/graft/internal/server/prompt"
 	"github.com/skssmd/graft/internal/server/ssh"
 )
 
 // InstallHook installs or restarts the graft-hook webhook service
-func InstallHook(client *ssh.Client, gCfg *config.GlobalConfig, deploymentMode string, reader *bufio.Reader, currentHookURL string, srv *config.ServerConfig) (string, error) {
+func InstallHook(client ssh.Remote, gCfg *config.GlobalConfig, deploymentMode string, in *prompt.Answers, currentHookURL string, srv *config.ServerConfig) (string, error) {
 	if client == nil {
 		return "", errors.New("client is nil")
 	}
 	
 	installHook := false
 	if deploymentMode == "git-manual" {
-		fmt.Print("\n❓ Do you want to install graft-hook for CI/CD automation? (y/n): ")
-		input, _ := reader.ReadString('\n')
-		installHook = strings.ToLower(strings.TrimSpace(input)) == "y"
+		installHook = in.Confirm("hook.install", "\n❓ Do you want to install graft-hook for CI/CD automation? (y/n): ", false)
 	} else {
 		// Check if already installed and restart if it exists
 		if err := client.RunCommand("cd /opt/graft/webhook && sudo docker compose down", nil, nil); err != nil {
@@ -41,9 +38,7 @@ func InstallHook(client *ssh.Client, gCfg *config.GlobalConfig, deploymentMode s
 					}
 					if currentHookURL == "" {
 						fmt.Println("⚠️  Warning: graft-hook URL not found in registry.")
-						fmt.Print("Enter the graft-hook domain (e.g. graft-hook.example.com): ")
-						hookDomain, _ := reader.ReadString('\n')
-						hookDomain = strings.TrimSpace(hookDomain)
+						hookDomain := in.AskDefault("hook.domain", "Enter the graft-hook domain (e.g. graft-hook.example.com): ", "")
 						if hookDomain != "" {
 							currentHookURL = fmt.Sprintf("https://%s", hookDomain)
 							// Save to global registry
@@ -66,9 +61,10 @@ func InstallHook(client *ssh.Client, gCfg *config.GlobalConfig, deploymentMode s
 	}
 
 	if installHook {
-		fmt.Print("Enter domain for graft-hook (e.g. graft-hook.example.com): ")
-		hookDomain, _ := reader.ReadString('\n')
-		hookDomain = strings.TrimSpace(hookDomain)
+		hookDomain, err := in.Ask("hook.domain", "Enter domain for graft-hook (e.g. graft-hook.example.com): ")
+		if err != nil {
+			return "", err
+		}
 
 		fmt.Println("🚀 Deploying graft-hook...")
 		hookCompose := fmt.Sprintf(`version: '3.8'